@@ -32,7 +32,11 @@ import (
 	"github.com/ngaut/unistore/config"
 	"github.com/ngaut/unistore/pd"
 	"github.com/ngaut/unistore/server"
+	"github.com/ngaut/unistore/tikv"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
 	"github.com/pingcap/kvproto/pkg/deadlock"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/pingcap/log"
 	"github.com/zhangjinpeng1987/raft"
@@ -147,10 +151,13 @@ func main() {
 	listenAddr := conf.Server.StoreAddr[strings.IndexByte(conf.Server.StoreAddr, ':'):]
 	l, err := net.Listen("tcp", listenAddr)
 	deadlock.RegisterDeadlockServer(grpcServer, tikvServer)
+	import_sstpb.RegisterImportSSTServer(grpcServer, tikvServer)
+	cdcpb.RegisterChangeDataServer(grpcServer, tikvServer)
+	backup.RegisterBackupServer(grpcServer, tikvServer)
 	if err != nil {
 		log.S().Fatal(err)
 	}
-	handleSignal(grpcServer)
+	handleSignal(grpcServer, tikvServer, conf.Server.GracefulShutdownTimeout)
 	go func() {
 		log.S().Infof("listening on %v", conf.Server.StatusAddr)
 		http.HandleFunc("/status", func(writer http.ResponseWriter, request *http.Request) {
@@ -194,7 +201,11 @@ func loadConfig() *config.Config {
 	return &conf
 }
 
-func handleSignal(grpcServer *grpc.Server) {
+func handleSignal(grpcServer *grpc.Server, tikvServer *tikv.Server, gracefulShutdownTimeout string) {
+	timeout, err := time.ParseDuration(gracefulShutdownTimeout)
+	if err != nil {
+		log.S().Fatal(err)
+	}
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh,
 		syscall.SIGHUP,
@@ -204,6 +215,8 @@ func handleSignal(grpcServer *grpc.Server) {
 	go func() {
 		sig := <-sigCh
 		log.S().Infof("Got signal [%s] to exit.", sig)
+		// The gRPC server keeps serving the raft messages while the leaders are moved away.
+		tikvServer.PrepareStop(timeout)
 		grpcServer.Stop()
 	}()
 }
@@ -26,6 +26,9 @@ type Config struct {
 	RaftStore      RaftStore      `toml:"raftstore"`       // RaftStore configs
 	Coprocessor    Coprocessor    `toml:"coprocessor"`     // Coprocessor options
 	PessimisticTxn PessimisticTxn `toml:"pessimistic-txn"` // Pessimistic txn related
+	ReadPool       ReadPool       `toml:"read-pool"`       // Read pool options
+	LoadSplit      LoadSplit      `toml:"load-split"`      // Load-based split options
+	FlowControl    FlowControl    `toml:"flow-control"`    // Write flow control options
 }
 
 type Server struct {
@@ -37,6 +40,11 @@ type Server struct {
 	MaxProcs    int    `toml:"max-procs"`   // Max CPU cores to use, set 0 to use all CPU cores in the machine.
 	Raft        bool   `toml:"raft"`        // Enable raft.
 	LogfilePath string `toml:"log-file"`    // Log file path for unistore server
+	// The root directory of the local stand-in for S3, the files of a backup to S3 are stored in
+	// <s3-dir>/<bucket>/<prefix>.
+	S3Dir string `toml:"s3-dir"`
+	// The deadline to move the leaders away and drain the applies and lock waiters on shutdown.
+	GracefulShutdownTimeout string `toml:"graceful-shutdown-timeout"`
 }
 
 type RaftStore struct {
@@ -46,6 +54,8 @@ type RaftStore struct {
 	RaftHeartbeatTicks       int    `toml:"raft-heartbeat-ticks"`        // raft-heartbeat-ticks times
 	RaftElectionTimeoutTicks int    `toml:"raft-election-timeout-ticks"` // raft-election-timeout-ticks times
 	CustomRaftLog            bool   `toml:"custom-raft-log"`
+	HibernateRegions         bool   `toml:"hibernate-regions"`    // stop ticking idle regions on every base tick
+	RaftHibernateTicks       int    `toml:"raft-hibernate-ticks"` // raft-hibernate-ticks times
 }
 
 type Coprocessor struct {
@@ -88,6 +98,38 @@ type PessimisticTxn struct {
 	WakeUpDelayDuration int64 `toml:"wake-up-delay-duration"`
 }
 
+type ReadPool struct {
+	// The max number of the KV reads and the coprocessor requests running at the same time.
+	StorageConcurrency     int `toml:"storage-concurrency"`
+	CoprocessorConcurrency int `toml:"coprocessor-concurrency"`
+
+	// The max number of the requests of a priority waiting in a pool per concurrency, a request is rejected with
+	// ServerIsBusy once the queue is full.
+	MaxTasksPerWorker int `toml:"max-tasks-per-worker"`
+}
+
+type LoadSplit struct {
+	// A region is hot if it serves more reads and writes per second than the threshold, 0 disables the
+	// load-based split.
+	QPSThreshold int `toml:"qps-threshold"`
+	// The number of the seconds in a row a region is hot before it's split.
+	DetectTimes int `toml:"detect-times"`
+	// A split key is picked only if |left - right| / (left + right) of the sampled keys on its two sides is not
+	// greater than the score.
+	SplitBalanceScore float64 `toml:"split-balance-score"`
+}
+
+type FlowControl struct {
+	Enable bool `toml:"enable"`
+	// The writes are throttled once the number of the L0 tables reaches the soft limit, and all rejected before
+	// num-L0-tables-stall of the engine is reached.
+	SoftL0Tables int `toml:"soft-l0-tables"`
+	// The writes are throttled once the estimated bytes to compact reach the soft limit, and all rejected at the
+	// hard limit.
+	SoftPendingCompactionBytes int64 `toml:"soft-pending-compaction-bytes"`
+	HardPendingCompactionBytes int64 `toml:"hard-pending-compaction-bytes"`
+}
+
 func ParseCompression(s string) options.CompressionType {
 	switch s {
 	case "snappy":
@@ -99,7 +141,10 @@ func ParseCompression(s string) options.CompressionType {
 	}
 }
 
-const MB = 1024 * 1024
+const (
+	MB = 1024 * 1024
+	GB = 1024 * MB
+)
 
 var DefaultConf = Config{
 	Server: Server{
@@ -111,6 +156,8 @@ var DefaultConf = Config{
 		MaxProcs:    0,
 		Raft:        true,
 		LogfilePath: "",
+
+		GracefulShutdownTimeout: "30s",
 	},
 	RaftStore: RaftStore{
 		PdHeartbeatTickInterval:  "20s",
@@ -119,6 +166,8 @@ var DefaultConf = Config{
 		RaftHeartbeatTicks:       2,
 		RaftElectionTimeoutTicks: 10,
 		CustomRaftLog:            true,
+		HibernateRegions:         false,
+		RaftHibernateTicks:       10,
 	},
 	Engine: Engine{
 		DBPath:             "/tmp/badger",
@@ -145,6 +194,22 @@ var DefaultConf = Config{
 		WaitForLockTimeout:  1000, // 1000ms same with tikv default value
 		WakeUpDelayDuration: 100,  // 100ms same with tikv default value
 	},
+	ReadPool: ReadPool{
+		StorageConcurrency:     8,
+		CoprocessorConcurrency: 8,
+		MaxTasksPerWorker:      2000,
+	},
+	LoadSplit: LoadSplit{
+		QPSThreshold:      3000,
+		DetectTimes:       10,
+		SplitBalanceScore: 0.25,
+	},
+	FlowControl: FlowControl{
+		Enable:                     true,
+		SoftL0Tables:               6,
+		SoftPendingCompactionBytes: 64 * GB,
+		HardPendingCompactionBytes: 256 * GB,
+	},
 }
 
 // parseDuration parses duration argument string.
@@ -23,6 +23,8 @@ import (
 const (
 	namespace = "unistore"
 	raft      = "raft"
+	gc        = "gc"
+	server    = "server"
 )
 
 var (
@@ -97,6 +99,73 @@ var (
 			Name:      "batch_size",
 			Buckets:   prometheus.ExponentialBuckets(1, 1.5, 20),
 		})
+	GCDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: gc,
+			Name:      "region_duration",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 20),
+		})
+	GCKeys = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: gc,
+			Name:      "keys",
+		}, []string{"type"})
+	ConsistencyCheckFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: raft,
+			Name:      "consistency_check_failures",
+		})
+	LocalReadRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: raft,
+			Name:      "local_read_requests",
+		}, []string{"type"})
+	ReadPoolPendingTasks = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "read_pool_pending_tasks",
+		}, []string{"pool", "priority"})
+	ReadPoolRunningTasks = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "read_pool_running_tasks",
+		}, []string{"pool"})
+	ReadPoolRejectedTasks = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "read_pool_rejected_tasks",
+		}, []string{"pool", "priority"})
+	EngineL0Tables = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "engine_l0_tables",
+		})
+	EnginePendingCompactionBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "engine_pending_compaction_bytes",
+		})
+	FlowControlThrottleRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "flow_control_throttle_ratio",
+		})
+	FlowControlThrottledWrites = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: server,
+			Name:      "flow_control_throttled_writes",
+		}, []string{"type"})
 )
 
 func init() {
@@ -110,5 +179,16 @@ func init() {
 	prometheus.MustRegister(LockUpdate)
 	prometheus.MustRegister(RaftBatchSize)
 	prometheus.MustRegister(LatchWait)
+	prometheus.MustRegister(GCDuration)
+	prometheus.MustRegister(GCKeys)
+	prometheus.MustRegister(ConsistencyCheckFailures)
+	prometheus.MustRegister(LocalReadRequests)
+	prometheus.MustRegister(ReadPoolPendingTasks)
+	prometheus.MustRegister(ReadPoolRunningTasks)
+	prometheus.MustRegister(ReadPoolRejectedTasks)
+	prometheus.MustRegister(EngineL0Tables)
+	prometheus.MustRegister(EnginePendingCompactionBytes)
+	prometheus.MustRegister(FlowControlThrottleRatio)
+	prometheus.MustRegister(FlowControlThrottledWrites)
 	http.Handle("/metrics", promhttp.Handler())
 }
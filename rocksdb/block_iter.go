@@ -13,10 +13,14 @@
 
 package rocksdb
 
+import "sort"
+
 type blockIterator struct {
-	data    []byte
-	cursor  int
-	invalid bool
+	data       []byte
+	restarts   []byte
+	cursor     int
+	currOffset int
+	invalid    bool
 
 	keyBuf   []byte
 	valueBuf []byte
@@ -42,6 +46,7 @@ func (it *blockIterator) Next() {
 		it.invalid = true
 		return
 	}
+	it.currOffset = it.cursor
 
 	var prefixLen, keyLen, valueLen uint32
 	var n int
@@ -89,7 +94,9 @@ func (it *blockIterator) Reset(block []byte) {
 	data := block[:len(block)-restartsSz]
 
 	it.data = data
+	it.restarts = block[len(data) : len(block)-4]
 	it.cursor = 0
+	it.currOffset = 0
 	it.invalid = false
 	it.keyBuf = it.keyBuf[:0]
 	it.valueBuf = it.valueBuf[:0]
@@ -102,3 +109,72 @@ func (it *blockIterator) currData() []byte {
 func (it *blockIterator) end() bool {
 	return it.cursor == len(it.data)
 }
+
+func (it *blockIterator) numRestarts() int {
+	return len(it.restarts) / 4
+}
+
+func (it *blockIterator) restartOffset(i int) int {
+	return int(rocksEndian.Uint32(it.restarts[i*4:]))
+}
+
+// seekToRestart moves the cursor to the i-th restart point, the key of the entry at a restart point is not
+// prefix compressed.
+func (it *blockIterator) seekToRestart(i int) {
+	it.cursor = it.restartOffset(i)
+	it.invalid = false
+	it.keyBuf = it.keyBuf[:0]
+}
+
+// Seek moves to the first entry whose key is not less than key, the iterator is invalid if there is no
+// such entry.
+func (it *blockIterator) Seek(key []byte, cmp func(key1, key2 []byte) int) {
+	// Find the last restart point whose key is less than key.
+	left, right := 0, it.numRestarts()-1
+	for left < right {
+		mid := (left + right + 1) / 2
+		it.seekToRestart(mid)
+		it.Next()
+		if it.Valid() && cmp(it.Key(), key) < 0 {
+			left = mid
+		} else {
+			right = mid - 1
+		}
+	}
+	it.seekToRestart(left)
+	for !it.end() {
+		it.Next()
+		if !it.Valid() || cmp(it.Key(), key) >= 0 {
+			return
+		}
+	}
+	it.invalid = true
+}
+
+// SeekToLast moves to the last entry, the iterator is invalid if the block is empty.
+func (it *blockIterator) SeekToLast() {
+	it.seekToRestart(it.numRestarts() - 1)
+	if it.end() {
+		it.invalid = true
+		return
+	}
+	for !it.end() && it.Valid() {
+		it.Next()
+	}
+}
+
+// Prev moves to the previous entry, the iterator is invalid if the current entry is the first one.
+func (it *blockIterator) Prev() {
+	target := it.currOffset
+	if target == 0 {
+		it.invalid = true
+		return
+	}
+	i := sort.Search(it.numRestarts(), func(i int) bool {
+		return it.restartOffset(i) >= target
+	})
+	it.seekToRestart(i - 1)
+	for it.cursor < target && it.Valid() {
+		it.Next()
+	}
+}
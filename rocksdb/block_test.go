@@ -14,6 +14,7 @@
 package rocksdb
 
 import (
+	"bytes"
 	"sort"
 	"strconv"
 	"testing"
@@ -63,3 +64,36 @@ func sortedNumbers(size int) []string {
 	})
 	return nums
 }
+
+func TestBlockSeekAndPrev(t *testing.T) {
+	nums := sortedNumbers(1000)
+
+	builder := newBlockBuilder(16)
+	for _, num := range nums {
+		builder.Add(encodeKey(num), []byte(num))
+	}
+	iter := newBlockIterator(builder.Finish())
+	cmp := Comparator(bytes.Compare).CompareInternalKey
+
+	for i, num := range nums {
+		iter.Seek(encodeKey(num), cmp)
+		require.True(t, iter.Valid())
+		require.Equal(t, num, decodeKey(iter.Key()))
+		iter.Prev()
+		if i == 0 {
+			require.False(t, iter.Valid())
+			continue
+		}
+		require.True(t, iter.Valid())
+		require.Equal(t, nums[i-1], decodeKey(iter.Key()))
+	}
+	iter.Seek(encodeKey("a"), cmp)
+	require.False(t, iter.Valid())
+
+	i := len(nums) - 1
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		require.Equal(t, nums[i], decodeKey(iter.Key()))
+		i--
+	}
+	require.Equal(t, -1, i)
+}
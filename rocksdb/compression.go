@@ -25,34 +25,64 @@ package rocksdb
 import (
 	"math"
 
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4"
 	"github.com/pingcap/errors"
 )
 
 var ErrDecompress = errors.New("Error during decompress")
 
-func lz4Compress(input, dst []byte) []byte {
-	rawLen := len(input)
-	if rawLen > math.MaxUint32 {
-		return nil
-	}
+// The zstd encoder and decoder are safe for concurrent use of EncodeAll and DecodeAll, creating them
+// without options never fails.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
 
+// The compressed blocks are framed the same way as RocksDB with format_version 2, a snappy block is the
+// raw snappy format which has the decompressed size in its header, a lz4 or zstd block is prefixed by
+// the varint32 decompressed size.
+
+func putDecompressedSize(input, dst []byte, bound int) ([]byte, int) {
 	var varintBuf [5]byte
-	decompressedSize := encodeVarint32(varintBuf[:], uint32(rawLen))
-	outputBound := lz4.CompressBlockBound(rawLen)
-	size := len(decompressedSize) + outputBound
+	decompressedSize := encodeVarint32(varintBuf[:], uint32(len(input)))
+	size := len(decompressedSize) + bound
 	if cap(dst) < size {
 		dst = make([]byte, size)
 	} else {
 		dst = dst[:size]
 	}
 	copy(dst, decompressedSize)
+	return dst, len(decompressedSize)
+}
+
+func lz4Compress(input, dst []byte) []byte {
+	if len(input) > math.MaxUint32 {
+		return nil
+	}
+	dst, n := putDecompressedSize(input, dst, lz4.CompressBlockBound(len(input)))
 	var ht [1 << 16]int
-	n, err := lz4.CompressBlock(input, dst[len(decompressedSize):], ht[:])
-	if err != nil || n == 0 {
+	m, err := lz4.CompressBlock(input, dst[n:], ht[:])
+	if err != nil || m == 0 {
+		return nil
+	}
+	return dst[:n+m]
+}
+
+func snappyCompress(input, dst []byte) []byte {
+	if len(input) > math.MaxUint32 {
+		return nil
+	}
+	return snappy.Encode(dst[:cap(dst)], input)
+}
+
+func zstdCompress(input, dst []byte) []byte {
+	if len(input) > math.MaxUint32 {
 		return nil
 	}
-	return dst[:len(decompressedSize)+n]
+	dst, n := putDecompressedSize(input, dst, 0)
+	return zstdEncoder.EncodeAll(input, dst[:n])
 }
 
 func isGoodCompressionRatio(compressed, input []byte) bool {
@@ -68,9 +98,9 @@ func CompressBlock(tp CompressionType, input, dst []byte) ([]byte, bool) {
 	case CompressionNone:
 		return input, false
 	case CompressionSnappy:
-		panic("unsupported")
+		compressed = snappyCompress(input, dst)
 	case CompressionZstd:
-		panic("unsupported")
+		compressed = zstdCompress(input, dst)
 	}
 	if compressed == nil || !isGoodCompressionRatio(compressed, input) {
 		return input, false
@@ -94,6 +124,29 @@ func lz4Decompress(input, dst []byte) ([]byte, error) {
 	return dst, err
 }
 
+func snappyDecompress(input, dst []byte) ([]byte, error) {
+	out, err := snappy.Decode(dst[:cap(dst)], input)
+	if err != nil {
+		return input, ErrDecompress
+	}
+	return out, nil
+}
+
+func zstdDecompress(input, dst []byte) ([]byte, error) {
+	size, n := decodeVarint32(input)
+	if n <= 0 {
+		return input, ErrDecompress
+	}
+	if uint32(cap(dst)) < size {
+		dst = make([]byte, 0, size)
+	}
+	out, err := zstdDecoder.DecodeAll(input[n:], dst[:0])
+	if err != nil || uint32(len(out)) != size {
+		return input, ErrDecompress
+	}
+	return out, nil
+}
+
 func DecompressBlock(tp CompressionType, input, dst []byte) ([]byte, error) {
 	switch tp {
 	case CompressionLz4:
@@ -101,10 +154,10 @@ func DecompressBlock(tp CompressionType, input, dst []byte) ([]byte, error) {
 	case CompressionNone:
 		return input, nil
 	case CompressionSnappy:
-		panic("unsupported")
+		return snappyDecompress(input, dst)
 	case CompressionZstd:
-		panic("unsupported")
+		return zstdDecompress(input, dst)
 	default:
-		panic("unreachable branch")
+		return input, errors.Errorf("unsupported compression type %d", tp)
 	}
 }
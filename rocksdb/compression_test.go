@@ -0,0 +1,63 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressBlock(t *testing.T) {
+	input := bytes.Repeat([]byte("unistore compress block "), 100)
+	for _, tp := range []CompressionType{CompressionSnappy, CompressionLz4, CompressionZstd} {
+		compressed, ok := CompressBlock(tp, input, nil)
+		require.True(t, ok, tp.String())
+		output, err := DecompressBlock(tp, compressed, nil)
+		require.Nil(t, err, tp.String())
+		require.Equal(t, input, output, tp.String())
+	}
+	for _, tp := range []CompressionType{CompressionSnappy, CompressionZstd} {
+		compressed, _ := CompressBlock(tp, input, nil)
+		_, err := DecompressBlock(tp, compressed[:len(compressed)/2], nil)
+		require.NotNil(t, err, tp.String())
+	}
+	_, err := DecompressBlock(CompressionType(0xff), input, nil)
+	require.NotNil(t, err)
+}
+
+func TestDecompressSnappyFixture(t *testing.T) {
+	// The decompressed size 20, a literal "a" and two copies of offset 1 with the length 11 and 8.
+	block := []byte{0x14, 0x00, 'a', 0x1d, 0x01, 0x11, 0x01}
+	output, err := DecompressBlock(CompressionSnappy, block, nil)
+	require.Nil(t, err)
+	require.Equal(t, bytes.Repeat([]byte("a"), 20), output)
+}
+
+func TestDecompressZstdFixture(t *testing.T) {
+	// zstd_block.zst is compressed from zstd_block.txt by the zstd command line tool, a RocksDB zstd block
+	// is the frame prefixed by the varint32 decompressed size.
+	expected, err := ioutil.ReadFile(filepath.Join("testdata", "zstd_block.txt"))
+	require.Nil(t, err)
+	frame, err := ioutil.ReadFile(filepath.Join("testdata", "zstd_block.zst"))
+	require.Nil(t, err)
+	var buf [5]byte
+	block := append(encodeVarint32(buf[:], uint32(len(expected))), frame...)
+	output, err := DecompressBlock(CompressionZstd, block, nil)
+	require.Nil(t, err)
+	require.Equal(t, expected, output)
+}
@@ -25,20 +25,25 @@ var (
 	errEnd                 = errors.New("reach end of block")
 )
 
+// blockReader reads the footer and the blocks of an SST file.
+type blockReader struct {
+	f            *os.File
+	readBuf      []byte
+	checksumType ChecksumType
+}
+
 type SstFileIterator struct {
-	f              *os.File
+	blockReader
 	indexBlockIter *blockIterator
 	dataBlockIter  *blockIterator
-	readBuf        []byte
 	dataBuf        []byte
 	invalid        bool
 	err            error
-	checksumType   ChecksumType
 }
 
 func NewSstFileIterator(f *os.File) (*SstFileIterator, error) {
 	it := &SstFileIterator{
-		f:             f,
+		blockReader:   blockReader{f: f},
 		dataBlockIter: new(blockIterator),
 	}
 
@@ -99,11 +104,7 @@ func (it *SstFileIterator) loadNextDataBlk() error {
 	var handle blockHandle
 	handle.Decode(it.indexBlockIter.Value())
 
-	it.checkReadBufSize(handle.Size + blockTrailerSize)
-	if _, err = it.f.ReadAt(it.readBuf, int64(handle.Offset)); err != nil {
-		return err
-	}
-	if it.dataBuf, err = it.decompressBlock(it.dataBuf, it.readBuf); err != nil {
+	if it.dataBuf, err = it.readBlock(handle, it.dataBuf); err != nil {
 		return err
 	}
 	it.dataBlockIter.Reset(it.dataBuf)
@@ -111,21 +112,31 @@ func (it *SstFileIterator) loadNextDataBlk() error {
 	return nil
 }
 
-func (it *SstFileIterator) checkReadBufSize(sz uint64) {
-	if uint64(cap(it.readBuf)) < sz {
-		it.readBuf = make([]byte, sz)
+// readBlock reads the block of the handle and decompresses it to dst, the returned block may refer to
+// the read buffer which is reused by the next read.
+func (r *blockReader) readBlock(handle blockHandle, dst []byte) ([]byte, error) {
+	r.checkReadBufSize(handle.Size + blockTrailerSize)
+	if _, err := r.f.ReadAt(r.readBuf, int64(handle.Offset)); err != nil {
+		return nil, err
+	}
+	return r.decompressBlock(dst, r.readBuf)
+}
+
+func (r *blockReader) checkReadBufSize(sz uint64) {
+	if uint64(cap(r.readBuf)) < sz {
+		r.readBuf = make([]byte, sz)
 		return
 	}
-	it.readBuf = it.readBuf[:sz]
+	r.readBuf = r.readBuf[:sz]
 }
 
-func (it *SstFileIterator) decompressBlock(dst, raw []byte) ([]byte, error) {
+func (r *blockReader) decompressBlock(dst, raw []byte) ([]byte, error) {
 	trailerPos := len(raw) - blockTrailerSize
 
 	blkData := raw[:trailerPos]
 	compressTp := CompressionType(raw[trailerPos])
 
-	switch it.checksumType {
+	switch r.checksumType {
 	case ChecksumCRC32:
 		crc := newCrc32()
 		crc.Write(raw[:trailerPos+1])
@@ -142,40 +153,35 @@ func (it *SstFileIterator) decompressBlock(dst, raw []byte) ([]byte, error) {
 }
 
 func (it *SstFileIterator) getIndexBlockHandle() (blockHandle, error) {
-	var handle blockHandle
-
-	footer, err := it.loadFooter()
-	if err != nil {
-		return handle, err
-	}
-
-	// Skip meta index handle
-	n := handle.Decode(footer[1:])
-	handle.Decode(footer[1+n:])
-	return handle, nil
+	_, handle, err := it.loadFooter()
+	return handle, err
 }
 
-func (it *SstFileIterator) loadFooter() ([]byte, error) {
-	fi, err := it.f.Stat()
+// loadFooter loads the footer and returns the meta index handle and the index handle.
+func (r *blockReader) loadFooter() (metaIndexHandle, indexHandle blockHandle, err error) {
+	fi, err := r.f.Stat()
 	if err != nil {
-		return nil, err
+		return
 	}
 
 	off := fi.Size() - footerEncodedLength
 	var footerBuf [footerEncodedLength]byte
-	if _, err = it.f.ReadAt(footerBuf[:], off); err != nil {
-		return nil, err
+	if _, err = r.f.ReadAt(footerBuf[:], off); err != nil {
+		return
 	}
 
-	if !it.checkMagicNumber(footerBuf[:]) {
-		return nil, ErrMagicNumberMismatch
+	if !r.checkMagicNumber(footerBuf[:]) {
+		err = ErrMagicNumberMismatch
+		return
 	}
-	it.checksumType = ChecksumType(footerBuf[0])
+	r.checksumType = ChecksumType(footerBuf[0])
 
-	return footerBuf[:], nil
+	n := metaIndexHandle.Decode(footerBuf[1:])
+	indexHandle.Decode(footerBuf[1+n:])
+	return
 }
 
-func (it *SstFileIterator) checkMagicNumber(footer []byte) bool {
+func (r *blockReader) checkMagicNumber(footer []byte) bool {
 	pos := footerEncodedLength - 8
 	if rocksEndian.Uint32(footer[pos:]) != blockBasedTableMagicNumber&0xffffffff {
 		return false
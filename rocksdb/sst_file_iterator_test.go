@@ -17,6 +17,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -49,6 +50,52 @@ func TestLz4Compression(t *testing.T) {
 	})
 }
 
+func TestSnappyCompression(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	opts.CompressionType = CompressionSnappy
+
+	t.Run("small", func(t *testing.T) {
+		testSstReadWrite(t, smallTestSize, opts)
+	})
+	t.Run("large", func(t *testing.T) {
+		testSstReadWrite(t, largeTestSize, opts)
+	})
+}
+
+func TestZstdCompression(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	opts.CompressionType = CompressionZstd
+
+	t.Run("small", func(t *testing.T) {
+		testSstReadWrite(t, smallTestSize, opts)
+	})
+	t.Run("large", func(t *testing.T) {
+		testSstReadWrite(t, largeTestSize, opts)
+	})
+}
+
+func TestCompressionFixtures(t *testing.T) {
+	keys, values := loadFixtureEntries(t)
+	for _, name := range []string{"snappy.sst", "zstd.sst"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name))
+			require.Nil(t, err)
+			defer f.Close()
+			it, err := NewSstFileIterator(f)
+			require.Nil(t, err)
+			var i int
+			for it.SeekToFirst(); it.Valid(); it.Next() {
+				require.Equal(t, keys[i], string(it.Key().UserKey))
+				require.Equal(t, TypeValue, it.Key().ValueType)
+				require.Equal(t, values[i], string(it.Value()))
+				i++
+			}
+			require.Equal(t, len(keys), i)
+			require.Nil(t, it.Err())
+		})
+	}
+}
+
 func TestBlockAlign(t *testing.T) {
 	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
 	opts.CompressionType = CompressionLz4
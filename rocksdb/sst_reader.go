@@ -0,0 +1,428 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"math"
+	"os"
+	"sort"
+
+	"github.com/coocood/badger/y"
+	"github.com/pingcap/errors"
+)
+
+var ErrNotFound = errors.New("Key not found")
+
+// SstReader reads an SST file by random access. The index, properties and filter blocks are loaded when
+// the reader is created, a data block is read when an iterator or a Get reaches it.
+// The comparator, prefix extractor and whole key filtering of the options must be the same as the writer.
+type SstReader struct {
+	blockReader
+	comparator        Comparator
+	prefixExtractor   SliceTransform
+	wholeKeyFiltering bool
+
+	props  TableProperties
+	index  []indexEntry
+	filter *fullFilterBitsReader
+}
+
+type indexEntry struct {
+	// lastKey is not less than the last internal key in the data block and less than the first internal key in
+	// the next block, RocksDB writes a shortened separator between the blocks instead of the last key.
+	lastKey []byte
+	handle  blockHandle
+}
+
+func NewSstReader(f *os.File, opts *BlockBasedTableOptions) (*SstReader, error) {
+	r := &SstReader{
+		blockReader:       blockReader{f: f},
+		comparator:        opts.Comparator,
+		prefixExtractor:   opts.PrefixExtractor,
+		wholeKeyFiltering: opts.WholeKeyFiltering,
+	}
+	metaIndexHandle, indexHandle, err := r.loadFooter()
+	if err != nil {
+		return nil, err
+	}
+	metaIndex, err := r.readBlock(metaIndexHandle, nil)
+	if err != nil {
+		return nil, err
+	}
+	var propsHandle, filterHandle *blockHandle
+	for it := newBlockIterator(y.Copy(metaIndex)); !it.end(); {
+		it.Next()
+		handle := new(blockHandle)
+		handle.Decode(it.Value())
+		switch string(it.Key()) {
+		case propsBlockHandleKey:
+			propsHandle = handle
+		case bloomBlockHandleKey:
+			filterHandle = handle
+		}
+	}
+	if propsHandle != nil {
+		if err = r.loadProperties(*propsHandle); err != nil {
+			return nil, err
+		}
+	}
+	if filterHandle != nil {
+		filter, err := r.readBlock(*filterHandle, nil)
+		if err != nil {
+			return nil, err
+		}
+		r.filter = newFullFilterBitsReader(y.Copy(filter))
+	}
+	if err = r.loadIndex(indexHandle); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *SstReader) loadProperties(handle blockHandle) error {
+	block, err := r.readBlock(handle, nil)
+	if err != nil {
+		return err
+	}
+	p := &r.props
+	uint64Props := map[string]*uint64{
+		propColumnFamilyId: &p.ColumnFamilyID,
+		propCreationTime:   &p.CreationTime,
+		propDataSize:       &p.DataSize,
+		propFilterSize:     &p.FilterSize,
+		propIndexSize:      &p.IndexSize,
+		propNumDataBlocks:  &p.NumDataBlocks,
+		propNumEntries:     &p.NumEntries,
+		propOldestKeyTime:  &p.OldestKeyTime,
+		propRawKeySize:     &p.RawKeySize,
+		propRawValueSize:   &p.RawValueSize,
+	}
+	stringProps := map[string]*string{
+		propCompression:         &p.CompressionName,
+		propFilterPolicy:        &p.FilterPolicyName,
+		propPrefixExtractorName: &p.PrefixExtractorName,
+	}
+	for it := newBlockIterator(block); !it.end(); {
+		it.Next()
+		name := string(it.Key())
+		if v, ok := uint64Props[name]; ok {
+			*v, _ = decodeVarint64(it.Value())
+		} else if v, ok := stringProps[name]; ok {
+			*v = string(it.Value())
+		}
+	}
+	return nil
+}
+
+func (r *SstReader) loadIndex(handle blockHandle) error {
+	if r.props.NumEntries == 0 {
+		return nil
+	}
+	block, err := r.readBlock(handle, nil)
+	if err != nil {
+		return err
+	}
+	for it := newBlockIterator(block); !it.end(); {
+		it.Next()
+		entry := indexEntry{lastKey: y.Copy(it.Key())}
+		entry.handle.Decode(it.Value())
+		r.index = append(r.index, entry)
+	}
+	return nil
+}
+
+// Properties returns the table properties of the file.
+func (r *SstReader) Properties() *TableProperties {
+	return &r.props
+}
+
+// KeyMayMatch returns false if the filter block tells the key is not in the file.
+func (r *SstReader) KeyMayMatch(key []byte) bool {
+	if r.filter == nil {
+		return true
+	}
+	if r.wholeKeyFiltering {
+		return r.filter.MayMatch(key)
+	}
+	if r.prefixExtractor != nil && r.prefixExtractor.InDomain(key) {
+		return r.filter.MayMatch(r.prefixExtractor.Transform(key))
+	}
+	return true
+}
+
+// Get returns the value of the newest entry of the key, ErrNotFound is returned if the key is not in the
+// file or the newest entry is a deletion.
+func (r *SstReader) Get(key []byte) ([]byte, error) {
+	if !r.KeyMayMatch(key) {
+		return nil, ErrNotFound
+	}
+	it := r.NewIterator(nil, nil)
+	it.Seek(key)
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	if !it.Valid() || r.comparator(it.userKey(), key) != 0 || it.valueType() != TypeValue {
+		return nil, ErrNotFound
+	}
+	return it.Value(), nil
+}
+
+// seekKey returns the smallest internal key of the user key.
+func seekKey(key []byte) []byte {
+	ikey := InternalKey{UserKey: key, SequenceNumber: math.MaxUint64 >> 8, ValueType: ValueType(0xff)}
+	return ikey.Encode()
+}
+
+// seekForPrevKey returns the largest internal key of the user key.
+func seekForPrevKey(key []byte) []byte {
+	ikey := InternalKey{UserKey: key}
+	return ikey.Encode()
+}
+
+// NewIterator returns an iterator over the entries whose user keys are in [lowerBound, upperBound), a nil
+// bound means the range is not bounded on that side.
+func (r *SstReader) NewIterator(lowerBound, upperBound []byte) *SstReaderIterator {
+	return &SstReaderIterator{
+		r:             r,
+		lowerBound:    lowerBound,
+		upperBound:    upperBound,
+		dataBlockIter: new(blockIterator),
+		blockIdx:      -1,
+		invalid:       true,
+	}
+}
+
+// SstReaderIterator iterates the entries of an SstReader in both directions.
+type SstReaderIterator struct {
+	r             *SstReader
+	lowerBound    []byte
+	upperBound    []byte
+	dataBlockIter *blockIterator
+	dataBuf       []byte
+	blockIdx      int
+	invalid       bool
+	err           error
+}
+
+func (it *SstReaderIterator) Valid() bool {
+	return !it.invalid
+}
+
+func (it *SstReaderIterator) Err() error {
+	return it.err
+}
+
+func (it *SstReaderIterator) Key() InternalKey {
+	var ikey InternalKey
+	ikey.Decode(it.dataBlockIter.Key())
+	return ikey
+}
+
+func (it *SstReaderIterator) Value() []byte {
+	return it.dataBlockIter.Value()
+}
+
+func (it *SstReaderIterator) userKey() []byte {
+	return extractUserKey(it.dataBlockIter.Key())
+}
+
+func (it *SstReaderIterator) valueType() ValueType {
+	key := it.dataBlockIter.Key()
+	return ValueType(key[len(key)-8])
+}
+
+// Seek moves to the first entry whose user key is not less than key.
+func (it *SstReaderIterator) Seek(key []byte) {
+	if it.lowerBound != nil && it.r.comparator(key, it.lowerBound) < 0 {
+		key = it.lowerBound
+	}
+	it.seek(seekKey(key))
+	it.checkUpperBound()
+}
+
+// SeekForPrev moves to the last entry whose user key is not greater than key.
+func (it *SstReaderIterator) SeekForPrev(key []byte) {
+	if it.upperBound != nil && it.r.comparator(key, it.upperBound) >= 0 {
+		it.seekBefore(seekKey(it.upperBound))
+	} else {
+		it.seekBefore(seekForPrevKey(key))
+	}
+	it.checkLowerBound()
+}
+
+func (it *SstReaderIterator) SeekToFirst() {
+	if it.lowerBound != nil {
+		it.Seek(it.lowerBound)
+		return
+	}
+	it.loadBlock(0)
+	if it.Valid() {
+		it.dataBlockIter.SeekToFirst()
+		it.invalid = !it.dataBlockIter.Valid()
+	}
+	it.checkUpperBound()
+}
+
+func (it *SstReaderIterator) SeekToLast() {
+	if it.upperBound != nil {
+		it.seekBefore(seekKey(it.upperBound))
+	} else {
+		it.seekToLast()
+	}
+	it.checkLowerBound()
+}
+
+func (it *SstReaderIterator) Next() {
+	if it.dataBlockIter.end() {
+		it.loadBlock(it.blockIdx + 1)
+	}
+	if it.Valid() {
+		it.dataBlockIter.Next()
+		it.invalid = !it.dataBlockIter.Valid()
+	}
+	it.checkUpperBound()
+}
+
+func (it *SstReaderIterator) Prev() {
+	it.prev()
+	it.checkLowerBound()
+}
+
+// seek moves to the first entry not less than the internal key.
+func (it *SstReaderIterator) seek(ikey []byte) {
+	cmp := it.r.comparator.CompareInternalKey
+	idx := sort.Search(len(it.r.index), func(i int) bool {
+		return cmp(it.r.index[i].lastKey, ikey) >= 0
+	})
+	it.loadBlock(idx)
+	if !it.Valid() {
+		return
+	}
+	it.dataBlockIter.Seek(ikey, cmp)
+	if !it.dataBlockIter.Valid() {
+		// The key is between the last key of the block and the separator, the entry is the first one of the next block.
+		it.loadBlock(idx + 1)
+		if !it.Valid() {
+			return
+		}
+		it.dataBlockIter.SeekToFirst()
+	}
+	it.invalid = !it.dataBlockIter.Valid()
+}
+
+// seekBefore moves to the last entry not greater than the internal key.
+func (it *SstReaderIterator) seekBefore(ikey []byte) {
+	it.seek(ikey)
+	if it.err != nil {
+		return
+	}
+	if !it.Valid() {
+		it.seekToLast()
+	} else if it.r.comparator.CompareInternalKey(it.dataBlockIter.Key(), ikey) > 0 {
+		it.prev()
+	}
+}
+
+func (it *SstReaderIterator) seekToLast() {
+	it.loadBlock(len(it.r.index) - 1)
+	if it.Valid() {
+		it.dataBlockIter.SeekToLast()
+		it.invalid = !it.dataBlockIter.Valid()
+	}
+}
+
+func (it *SstReaderIterator) prev() {
+	if it.dataBlockIter.currOffset == 0 {
+		it.loadBlock(it.blockIdx - 1)
+		if it.Valid() {
+			it.dataBlockIter.SeekToLast()
+			it.invalid = !it.dataBlockIter.Valid()
+		}
+		return
+	}
+	it.dataBlockIter.Prev()
+	it.invalid = !it.dataBlockIter.Valid()
+}
+
+// loadBlock loads the idx-th data block, the iterator is invalid if idx is out of range.
+func (it *SstReaderIterator) loadBlock(idx int) {
+	if idx < 0 || idx >= len(it.r.index) {
+		it.invalid = true
+		return
+	}
+	if idx != it.blockIdx {
+		var err error
+		it.dataBuf, err = it.r.readBlock(it.r.index[idx].handle, it.dataBuf)
+		if err != nil {
+			it.err = err
+			it.invalid = true
+			return
+		}
+		// The block may refer to the read buffer of the reader which is shared by the iterators.
+		it.dataBuf = y.SafeCopy(nil, it.dataBuf)
+		it.blockIdx = idx
+	}
+	it.dataBlockIter.Reset(it.dataBuf)
+	it.invalid = false
+}
+
+func (it *SstReaderIterator) checkUpperBound() {
+	if it.Valid() && it.upperBound != nil && it.r.comparator(it.userKey(), it.upperBound) >= 0 {
+		it.invalid = true
+	}
+}
+
+func (it *SstReaderIterator) checkLowerBound() {
+	if it.Valid() && it.lowerBound != nil && it.r.comparator(it.userKey(), it.lowerBound) < 0 {
+		it.invalid = true
+	}
+}
+
+// fullFilterBitsReader checks a key against the full filter built by fullFilterBitsBuilder.
+type fullFilterBitsReader struct {
+	data      []byte
+	numProbes int
+	numLines  uint32
+}
+
+func newFullFilterBitsReader(data []byte) *fullFilterBitsReader {
+	r := &fullFilterBitsReader{data: data}
+	if len(data) >= 5 {
+		r.numProbes = int(data[len(data)-5])
+		r.numLines = rocksEndian.Uint32(data[len(data)-4:])
+	}
+	return r
+}
+
+// MayMatch returns false if the key is definitely not added to the filter.
+func (r *fullFilterBitsReader) MayMatch(key []byte) bool {
+	if r.numProbes == 0 || r.numLines == 0 {
+		return true
+	}
+	totalBits := uint32(len(r.data)-5) * 8
+	if r.numLines*cacheLineSize*8 != totalBits {
+		return true
+	}
+	hash := bloomHash(key)
+	delta := (hash >> 17) | (hash << 15)
+	base := (hash % r.numLines) * (cacheLineSize * 8)
+	for i := 0; i < r.numProbes; i++ {
+		bitpos := base + (hash % (cacheLineSize * 8))
+		if r.data[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		hash += delta
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocksdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSstReaderGet(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	opts.CompressionType = CompressionLz4
+	nums := sortedNumbers(largeTestSize)
+	r, f := newTestSstReader(t, opts, nums, func(w *SstFileWriter) {
+		require.Nil(t, w.Delete([]byte("deleted")))
+	})
+	defer removeTestSst(f)
+
+	require.Equal(t, uint64(len(nums)+1), r.Properties().NumEntries)
+	for _, num := range nums {
+		val, err := r.Get([]byte(num))
+		require.Nil(t, err)
+		require.Equal(t, num, string(val))
+	}
+	_, err := r.Get([]byte("deleted"))
+	require.Equal(t, ErrNotFound, err)
+
+	var filtered int
+	for i := 0; i < 1000; i++ {
+		key := []byte("absent" + nums[i])
+		if !r.KeyMayMatch(key) {
+			filtered++
+		}
+		_, err := r.Get(key)
+		require.Equal(t, ErrNotFound, err)
+	}
+	// The filter uses 10 bits per key, the false positive rate is about 1%.
+	require.True(t, filtered > 900)
+}
+
+func TestSstReaderIterate(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	nums := sortedNumbers(largeTestSize)
+	r, f := newTestSstReader(t, opts, nums, nil)
+	defer removeTestSst(f)
+
+	it := r.NewIterator(nil, nil)
+	var i int
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		require.Equal(t, nums[i], string(it.Key().UserKey))
+		require.Equal(t, nums[i], string(it.Value()))
+		i++
+	}
+	require.Equal(t, len(nums), i)
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		i--
+		require.Equal(t, nums[i], string(it.Key().UserKey))
+	}
+	require.Equal(t, 0, i)
+	require.Nil(t, it.Err())
+
+	it.Seek([]byte("100"))
+	require.Equal(t, "100", string(it.Key().UserKey))
+	it.Seek([]byte("10000a"))
+	require.Equal(t, "10001", string(it.Key().UserKey))
+	it.SeekForPrev([]byte("10000a"))
+	require.Equal(t, "10000", string(it.Key().UserKey))
+	it.SeekForPrev([]byte("10000"))
+	require.Equal(t, "10000", string(it.Key().UserKey))
+	it.Seek([]byte("a"))
+	require.False(t, it.Valid())
+	it.SeekForPrev([]byte("a"))
+	require.Equal(t, nums[len(nums)-1], string(it.Key().UserKey))
+	it.SeekForPrev([]byte(""))
+	require.False(t, it.Valid())
+}
+
+func TestSstReaderBounds(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	nums := sortedNumbers(largeTestSize)
+	r, f := newTestSstReader(t, opts, nums, nil)
+	defer removeTestSst(f)
+
+	lower, upper := 1000, 30000
+	it := r.NewIterator([]byte(nums[lower]), []byte(nums[upper]))
+	i := lower
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		require.Equal(t, nums[i], string(it.Key().UserKey))
+		i++
+	}
+	require.Equal(t, upper, i)
+	for it.SeekToLast(); it.Valid(); it.Prev() {
+		i--
+		require.Equal(t, nums[i], string(it.Key().UserKey))
+	}
+	require.Equal(t, lower, i)
+
+	it.Seek([]byte(nums[0]))
+	require.Equal(t, nums[lower], string(it.Key().UserKey))
+	it.Seek([]byte(nums[upper]))
+	require.False(t, it.Valid())
+	it.SeekForPrev([]byte(nums[upper]))
+	require.Equal(t, nums[upper-1], string(it.Key().UserKey))
+	it.SeekForPrev([]byte(nums[lower-1]))
+	require.False(t, it.Valid())
+}
+
+func TestSstReaderPrefixFilter(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	opts.WholeKeyFiltering = false
+	opts.PrefixExtractorName = "FixedPrefix.3"
+	opts.PrefixExtractor = NewFixedPrefixSliceTransform(3)
+	nums := sortedNumbers(smallTestSize * 100)
+	r, f := newTestSstReader(t, opts, nums, nil)
+	defer removeTestSst(f)
+
+	for _, num := range nums {
+		require.True(t, r.KeyMayMatch([]byte(num)))
+	}
+	require.False(t, r.KeyMayMatch([]byte("abc")))
+	// Keys out of the domain of the prefix extractor are never filtered.
+	require.True(t, r.KeyMayMatch([]byte("a")))
+}
+
+func TestSstReaderCompression(t *testing.T) {
+	nums := sortedNumbers(1000)
+	for _, tp := range []CompressionType{CompressionSnappy, CompressionZstd} {
+		t.Run(tp.String(), func(t *testing.T) {
+			opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+			opts.CompressionType = tp
+			r, f := newTestSstReader(t, opts, nums, nil)
+			defer removeTestSst(f)
+			for _, num := range nums {
+				val, err := r.Get([]byte(num))
+				require.Nil(t, err)
+				require.Equal(t, num, string(val))
+			}
+		})
+	}
+}
+
+func TestSstReaderFixtures(t *testing.T) {
+	keys, values := loadFixtureEntries(t)
+	for _, name := range []string{"snappy.sst", "zstd.sst"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name))
+			require.Nil(t, err)
+			defer f.Close()
+			r, err := NewSstReader(f, NewDefaultBlockBasedTableOptions(bytes.Compare))
+			require.Nil(t, err)
+			// The range deletions are counted in the entries but stored in their own meta block.
+			require.True(t, r.Properties().NumEntries > uint64(len(keys)))
+			for i, key := range keys {
+				val, err := r.Get([]byte(key))
+				require.Nil(t, err)
+				require.Equal(t, values[i], string(val))
+				_, err = r.Get([]byte(key + "\x00"))
+				require.Equal(t, ErrNotFound, err)
+			}
+			it := r.NewIterator(nil, nil)
+			i := len(keys) - 1
+			for it.SeekToLast(); it.Valid(); it.Prev() {
+				require.Equal(t, keys[i], string(it.Key().UserKey))
+				require.Equal(t, values[i], string(it.Value()))
+				i--
+			}
+			require.Equal(t, -1, i)
+			require.Nil(t, it.Err())
+		})
+	}
+}
+
+// loadFixtureEntries returns the keys and values of the fixture SSTs in testdata. The fixtures are copied from
+// the sstable testdata of github.com/cockroachdb/pebble, they are written by the SstFileWriter of RocksDB with
+// make-table.cc from the word counts of hamlet.txt, the word is the key and the count is the value.
+func loadFixtureEntries(t *testing.T) (keys, values []string) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "hamlet.txt"))
+	require.Nil(t, err)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		require.Len(t, fields, 2)
+		keys = append(keys, fields[1])
+		values = append(values, fields[0])
+	}
+	return keys, values
+}
+
+// The index of the fixtures is written with the kShortenSeparatorsAndSuccessor index shortening of RocksDB.
+func TestSstReaderShortenedSeparators(t *testing.T) {
+	keys, _ := loadFixtureEntries(t)
+	f, err := os.Open(filepath.Join("testdata", "snappy.sst"))
+	require.Nil(t, err)
+	defer f.Close()
+	r, err := NewSstReader(f, NewDefaultBlockBasedTableOptions(bytes.Compare))
+	require.Nil(t, err)
+
+	require.True(t, len(r.index) > 1)
+	it := r.NewIterator(nil, nil)
+	var shortened int
+	for _, entry := range r.index {
+		separator := extractUserKey(entry.lastKey)
+		idx := sort.SearchStrings(keys, string(separator))
+		if idx < len(keys) && keys[idx] == string(separator) {
+			// The last key of the block can't be shortened.
+			continue
+		}
+		shortened++
+		// The separator is greater than the last key of its block and less than the first key of the next block.
+		it.Seek(separator)
+		if idx == len(keys) {
+			require.False(t, it.Valid())
+		} else {
+			require.True(t, it.Valid())
+			require.Equal(t, keys[idx], string(it.Key().UserKey))
+		}
+		it.SeekForPrev(separator)
+		require.True(t, it.Valid())
+		require.Equal(t, keys[idx-1], string(it.Key().UserKey))
+		_, err = r.Get(separator)
+		require.Equal(t, ErrNotFound, err)
+	}
+	require.True(t, shortened > len(r.index)/2)
+	for i, key := range keys {
+		// The key between two keys may be greater than the last key of a block and less than its separator.
+		between := []byte(key + "\x00")
+		it.Seek(between)
+		if i == len(keys)-1 {
+			require.False(t, it.Valid())
+		} else {
+			require.True(t, it.Valid())
+			require.Equal(t, keys[i+1], string(it.Key().UserKey))
+		}
+		it.SeekForPrev(between)
+		require.True(t, it.Valid())
+		require.Equal(t, key, string(it.Key().UserKey))
+	}
+	require.Nil(t, it.Err())
+}
+
+func TestSstReaderEmpty(t *testing.T) {
+	opts := NewDefaultBlockBasedTableOptions(bytes.Compare)
+	r, f := newTestSstReader(t, opts, nil, nil)
+	defer removeTestSst(f)
+
+	_, err := r.Get([]byte("a"))
+	require.Equal(t, ErrNotFound, err)
+	it := r.NewIterator(nil, nil)
+	it.SeekToFirst()
+	require.False(t, it.Valid())
+	it.SeekToLast()
+	require.False(t, it.Valid())
+}
+
+func newTestSstReader(t *testing.T, opts *BlockBasedTableOptions, nums []string, extra func(w *SstFileWriter)) (*SstReader, *os.File) {
+	f, err := ioutil.TempFile("", "unistore-test.*.sst")
+	require.Nil(t, err)
+	w := NewSstFileWriter(f, opts)
+	for _, num := range nums {
+		require.Nil(t, w.Put([]byte(num), []byte(num)))
+	}
+	if extra != nil {
+		extra(w)
+	}
+	require.Nil(t, w.Finish())
+	r, err := NewSstReader(f, opts)
+	require.Nil(t, err)
+	return r, f
+}
+
+func removeTestSst(f *os.File) {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
@@ -90,6 +90,7 @@ func setupRaftServer(bundle *mvcc.DBBundle, safePoint *tikv.SafePoint, pdClient
 	kvPath := filepath.Join(dbPath, "kv")
 	raftPath := filepath.Join(dbPath, "raft")
 	snapPath := filepath.Join(dbPath, "snap")
+	importPath := filepath.Join(dbPath, "import")
 
 	os.MkdirAll(kvPath, os.ModePerm)
 	os.MkdirAll(raftPath, os.ModePerm)
@@ -97,6 +98,7 @@ func setupRaftServer(bundle *mvcc.DBBundle, safePoint *tikv.SafePoint, pdClient
 
 	raftConf := raftstore.NewDefaultConfig()
 	raftConf.SnapPath = snapPath
+	raftConf.ImportPath = importPath
 	setupRaftStoreConf(raftConf, conf)
 
 	raftDB, err := createDB(subPathRaft, nil, &conf.Engine)
@@ -159,6 +161,8 @@ func setupRaftStoreConf(raftConf *raftstore.Config, conf *config.Config) {
 	raftConf.RaftBaseTickInterval = config.ParseDuration(conf.RaftStore.RaftBaseTickInterval)
 	raftConf.RaftHeartbeatTicks = conf.RaftStore.RaftHeartbeatTicks
 	raftConf.RaftElectionTimeoutTicks = conf.RaftStore.RaftElectionTimeoutTicks
+	raftConf.HibernateRegions = conf.RaftStore.HibernateRegions
+	raftConf.RaftHibernateTicks = conf.RaftStore.RaftHibernateTicks
 
 	// coprocessor block
 	raftConf.SplitCheck.RegionMaxKeys = uint64(conf.Coprocessor.RegionMaxKeys)
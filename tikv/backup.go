@@ -0,0 +1,380 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/coocood/badger"
+	"github.com/juju/errors"
+	"github.com/ngaut/unistore/config"
+	"github.com/ngaut/unistore/rocksdb"
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/tikv/raftstore"
+	"github.com/pingcap/kvproto/pkg/backup"
+)
+
+// A backup file is an SST file in the import format, every key is encoded by raftstore.EncodeImportSSTKey with
+// its commit ts, so the file is restored by downloading it for ingestion. A file of a full backup holds the
+// latest version at or below the backup ts of every key in the backed up range of a region. The files written
+// by a store for a backup request are listed in a manifest stored with them.
+//
+// An incremental backup holds every version committed in (start version, end version] and the rollback and op
+// lock records written in the range of ts, in the replay files which keep the user meta of the entries. The
+// versions of a key are written to the layers of files in asc order of ts, so a file holds at most one version
+// of a key and the versions of a key are ingested in the order they were committed, a read finds the first
+// version at or below its ts in the newest memtable that holds the key. The increments are restored in the
+// order of their versions after the full backup they are based on. The files of a manifest are listed in the
+// order they must be restored, a replayed version is rejected if the key has a version as new in the store.
+
+const (
+	backupFileSuffix     = ".sst"
+	backupManifestSuffix = ".backupmeta"
+	backupTmpSuffix      = ".tmp"
+)
+
+var errS3NotConfigured = errors.New("the s3 storage backend is not configured")
+
+// backupStorageDir returns the directory of the storage backend, S3 is stood in for by a local directory.
+func backupStorageDir(conf *config.Config, backend *backup.StorageBackend) (string, error) {
+	switch x := backend.GetBackend().(type) {
+	case *backup.StorageBackend_Local:
+		return x.Local.Path, nil
+	case *backup.StorageBackend_S3:
+		if conf.Server.S3Dir == "" {
+			return "", errS3NotConfigured
+		}
+		return filepath.Join(conf.Server.S3Dir, x.S3.Bucket, x.S3.Prefix), nil
+	}
+	return "", errors.Errorf("unsupported storage backend %v", backend)
+}
+
+// backupFileName names a backup file by the store, the region, the start key of the range, the backup ts and
+// the kind of the file.
+func backupFileName(storeID, regionID uint64, startKey []byte, backupTS uint64, kind string) string {
+	hash := sha256.Sum256(startKey)
+	return fmt.Sprintf("%d_%d_%x_%d_%s%s", storeID, regionID, hash[:8], backupTS, kind, backupFileSuffix)
+}
+
+// backupManifestName names the manifest of a backup request by the store and the range and versions of the
+// request.
+func backupManifestName(storeID uint64, req *backup.BackupRequest) string {
+	h := sha256.New()
+	h.Write(req.StartKey)
+	h.Write(req.EndKey)
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[:], req.StartVersion)
+	binary.LittleEndian.PutUint64(buf[8:], req.EndVersion)
+	h.Write(buf[:])
+	return fmt.Sprintf("%d_%x%s", storeID, h.Sum(nil)[:8], backupManifestSuffix)
+}
+
+// backupFileWriter writes the entries of a backup file and computes the checksums of the file.
+type backupFileWriter struct {
+	dir     string
+	tmpPath string
+	f       *os.File
+	w       *rocksdb.SstFileWriter
+	file    *backup.File
+	buf     []byte
+}
+
+func newBackupFileWriter(dir string, file *backup.File) (*backupFileWriter, error) {
+	tmpPath := filepath.Join(dir, file.Name+backupTmpSuffix)
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &backupFileWriter{
+		dir:     dir,
+		tmpPath: tmpPath,
+		f:       f,
+		w:       rocksdb.NewSstFileWriter(f, rocksdb.NewDefaultBlockBasedTableOptions(bytes.Compare)),
+		file:    file,
+	}, nil
+}
+
+func (w *backupFileWriter) add(key []byte, ts uint64, value []byte) error {
+	if err := w.w.Put(raftstore.EncodeImportSSTKey(key, ts), value); err != nil {
+		return errors.Trace(err)
+	}
+	w.buf = append(append(w.buf[:0], key...), value...)
+	w.file.Crc64Xor ^= crc64.Checksum(w.buf, checksumTable)
+	w.file.TotalKvs++
+	w.file.TotalBytes += uint64(len(key) + len(value))
+	return nil
+}
+
+// finish finishes the file and moves it to its name, the sha256 and the size of the file are computed.
+func (w *backupFileWriter) finish() (*backup.File, error) {
+	if err := w.w.Finish(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := w.f.Sync(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := ioutil.ReadFile(w.tmpPath)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	hash := sha256.Sum256(data)
+	w.file.Sha256 = hash[:]
+	w.file.Size_ = uint64(len(data))
+	return w.file, errors.Trace(os.Rename(w.tmpPath, filepath.Join(w.dir, w.file.Name)))
+}
+
+// close removes the temporary file if the file is not finished.
+func (w *backupFileWriter) close() {
+	w.w.Close()
+	os.Remove(w.tmpPath)
+}
+
+// backupRange writes the latest versions at or below backupTS of the keys in [startKey, endKey) into the file
+// named name in dir, nil is returned if there is no key in the range.
+func (store *MVCCStore) backupRange(reader *dbreader.DBReader, dir, name string, startKey, endKey []byte,
+	backupTS uint64) (*backup.File, error) {
+	w, err := newBackupFileWriter(dir, &backup.File{
+		Name:       name,
+		StartKey:   startKey,
+		EndKey:     endKey,
+		EndVersion: backupTS,
+		Cf:         "default",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+	reader.GetTxn().SetReadTS(backupTS)
+	it := reader.GetIter()
+	for it.Seek(startKey); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if exceedEndKey(key, endKey) {
+			break
+		}
+		if item.IsEmpty() {
+			continue
+		}
+		val, err := item.Value()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = w.add(key, item.Version(), val); err != nil {
+			return nil, err
+		}
+	}
+	if w.file.TotalKvs == 0 {
+		return nil, nil
+	}
+	return w.finish()
+}
+
+// backupIncrementalRange writes the versions in (startTS, endTS] of the keys in [startKey, endKey) into the
+// layers of replay files, and the txn status records of the keys in the range of ts into a txn status file.
+func (store *MVCCStore) backupIncrementalRange(reader *dbreader.DBReader, dir string,
+	name func(kind string) string, startKey, endKey []byte, startTS, endTS uint64) ([]*backup.File, error) {
+	var writers []*backupFileWriter
+	defer func() {
+		for _, w := range writers {
+			w.close()
+		}
+	}()
+	newWriter := func(kind, cf string) (*backupFileWriter, error) {
+		w, err := newBackupFileWriter(dir, &backup.File{
+			Name:         name(kind),
+			StartKey:     startKey,
+			EndKey:       endKey,
+			StartVersion: startTS,
+			EndVersion:   endTS,
+			Cf:           cf,
+		})
+		if err == nil {
+			writers = append(writers, w)
+		}
+		return w, err
+	}
+	txn := reader.GetTxn()
+	txn.SetReadTS(endTS)
+
+	var (
+		layers   []*backupFileWriter
+		lastKey  []byte
+		versions []replayVersion
+	)
+	// flushVersions writes the versions of the last key, which are iterated in desc order of ts, to the layers.
+	flushVersions := func() error {
+		for layer := range versions {
+			if layer == len(layers) {
+				w, err := newWriter(fmt.Sprintf("%s%d", raftstore.ReplayCF, layer), raftstore.ReplayCF)
+				if err != nil {
+					return err
+				}
+				layers = append(layers, w)
+			}
+			v := versions[len(versions)-1-layer]
+			if err := layers[layer].add(lastKey, v.ts, v.value); err != nil {
+				return err
+			}
+		}
+		versions = versions[:0]
+		return nil
+	}
+	it := dbreader.NewIterator(txn, false, startKey, endKey)
+	defer it.Close()
+	it.SetAllVersions(true)
+	for it.Seek(startKey); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if exceedEndKey(key, endKey) {
+			break
+		}
+		if key[0] != metaPrefix && key[0] != tablePrefix {
+			continue
+		}
+		if !bytes.Equal(key, lastKey) {
+			if err := flushVersions(); err != nil {
+				return nil, err
+			}
+			lastKey = append(lastKey[:0], key...)
+		}
+		if item.IsDeleted() || item.Version() <= startTS || item.Version() > endTS {
+			continue
+		}
+		val, err := item.Value()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		versions = append(versions, replayVersion{
+			ts:    item.Version(),
+			value: raftstore.EncodeReplaySSTValue(item.UserMeta(), val),
+		})
+	}
+	if err := flushVersions(); err != nil {
+		return nil, err
+	}
+
+	var statusWriter *backupFileWriter
+	extraStartKey, extraEndKey := extraTxnStatusRange(startKey, endKey)
+	extraIt := dbreader.NewIterator(txn, false, extraStartKey, extraEndKey)
+	defer extraIt.Close()
+	for extraIt.Seek(extraStartKey); extraIt.Valid(); extraIt.Next() {
+		item := extraIt.Item()
+		key := item.Key()
+		if exceedEndKey(key, extraEndKey) {
+			break
+		}
+		if key[0] != metaExtraPrefix && key[0] != tableExtraPrefix {
+			continue
+		}
+		if rawKey := mvcc.DecodeExtraTxnStatusKey(key); rawKey == nil || bytes.Compare(rawKey, startKey) < 0 ||
+			exceedEndKey(rawKey, endKey) {
+			continue
+		}
+		if item.IsDeleted() || item.Version() <= startTS || item.Version() > endTS {
+			continue
+		}
+		if statusWriter == nil {
+			w, err := newWriter(raftstore.ReplayTxnStatusCF, raftstore.ReplayTxnStatusCF)
+			if err != nil {
+				return nil, err
+			}
+			statusWriter = w
+		}
+		if err := addReplayEntry(statusWriter, item); err != nil {
+			return nil, err
+		}
+	}
+
+	files := make([]*backup.File, 0, len(writers))
+	for _, w := range writers {
+		file, err := w.finish()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// replayVersion is a version of a key encoded as the value of a replay file entry.
+type replayVersion struct {
+	ts    uint64
+	value []byte
+}
+
+func addReplayEntry(w *backupFileWriter, item *badger.Item) error {
+	val, err := item.Value()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return w.add(item.Key(), item.Version(), raftstore.EncodeReplaySSTValue(item.UserMeta(), val))
+}
+
+// extraTxnStatusRange returns the range of the extra txn status keys of the keys in [startKey, endKey).
+func extraTxnStatusRange(startKey, endKey []byte) (extraStartKey, extraEndKey []byte) {
+	if len(startKey) > 0 {
+		extraStartKey = mvcc.EncodeExtraTxnStatusKey(startKey, math.MaxUint64)
+	}
+	extraEndKey = InternalKeyPrefix
+	if len(endKey) > 0 && endKey[0] < InternalKeyPrefix[0] {
+		extraEndKey = mvcc.EncodeExtraTxnStatusKey(endKey, 0)
+	}
+	return
+}
+
+// writeBackupManifest writes the manifest of the files into dir.
+func writeBackupManifest(dir, name string, meta *backup.BackupMeta) error {
+	data, err := meta.Marshal()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmpPath := filepath.Join(dir, name+backupTmpSuffix)
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpPath, filepath.Join(dir, name)))
+}
+
+// findBackupFile returns the file named name in the manifests in dir.
+func findBackupFile(dir, name string) (*backup.File, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*"+backupManifestSuffix))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, manifest := range manifests {
+		data, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		meta := new(backup.BackupMeta)
+		if err = meta.Unmarshal(data); err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, file := range meta.Files {
+			if file.Name == name {
+				return file, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("backup file %s is not in any manifest", name)
+}
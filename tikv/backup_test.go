@@ -0,0 +1,292 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/tikv/raftstore"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"google.golang.org/grpc"
+)
+
+type mockBackupStream struct {
+	grpc.ServerStream
+	resps []*backup.BackupResponse
+}
+
+func (s *mockBackupStream) Send(resp *backup.BackupResponse) error {
+	s.resps = append(s.resps, resp)
+	return nil
+}
+
+func (s *testMvccSuite) TestBackupRestore(c *C) {
+	store, err := NewTestStore("TestBackup", "TestBackup", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	bundle := &mvcc.DBBundle{DB: store.MvccStore.db, LockStore: store.MvccStore.lockStore}
+	rm, err := NewMockRegionManager(bundle, 1, RegionOptions{})
+	c.Assert(err, IsNil)
+	region := &metapb.Region{Id: 1, RegionEpoch: &metapb.RegionEpoch{}, Peers: []*metapb.Peer{{Id: 2, StoreId: 1}}}
+	c.Assert(rm.Bootstrap([]*metapb.Store{{Id: 1}}, region), IsNil)
+	store.Svr.regionManager = rm
+
+	MustPrewritePut([]byte("ta"), []byte("ta"), []byte("va5"), 5, store)
+	MustCommit([]byte("ta"), 5, 6, store)
+	MustPrewritePut([]byte("tb"), []byte("tb"), []byte("vb10"), 10, store)
+	MustCommit([]byte("tb"), 10, 11, store)
+	MustPrewritePut([]byte("tb"), []byte("tb"), []byte("vb30"), 30, store)
+	MustCommit([]byte("tb"), 30, 31, store)
+	MustPrewritePut([]byte("tc"), []byte("tc"), []byte("vc5"), 5, store)
+	MustCommit([]byte("tc"), 5, 6, store)
+	MustPrewriteDelete([]byte("tc"), []byte("tc"), 12, store)
+	MustCommit([]byte("tc"), 12, 13, store)
+
+	dir := filepath.Join(store.DBPath, "backup")
+	req := &backup.BackupRequest{
+		ClusterId:      1,
+		StartKey:       []byte("t"),
+		EndKey:         []byte("u"),
+		EndVersion:     20,
+		StorageBackend: &backup.StorageBackend{Backend: &backup.StorageBackend_Local{Local: &backup.Local{Path: dir}}},
+	}
+	// The locks in the range below the backup ts must be resolved first.
+	MustPrewritePut([]byte("td"), []byte("td"), []byte("vd15"), 15, store)
+	stream := new(mockBackupStream)
+	c.Assert(store.Svr.Backup(req, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 1)
+	c.Assert(stream.resps[0].Error.GetKvError().GetLocked(), NotNil)
+	MustRollbackKey([]byte("td"), 15, store)
+
+	stream = new(mockBackupStream)
+	c.Assert(store.Svr.Backup(req, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 1)
+	c.Assert(stream.resps[0].Error, IsNil)
+	c.Assert(stream.resps[0].Files, HasLen, 1)
+	file := stream.resps[0].Files[0]
+	c.Assert(file.TotalKvs, Equals, uint64(2))
+	c.Assert(file.EndVersion, Equals, uint64(20))
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestName(1, req)))
+	c.Assert(err, IsNil)
+	meta := new(backup.BackupMeta)
+	c.Assert(meta.Unmarshal(data), IsNil)
+	c.Assert(meta.Files, HasLen, 1)
+	c.Assert(meta.Files[0].Name, Equals, file.Name)
+
+	// Restore the file into a fresh store with the prefix rewritten.
+	restoreStore, err := NewTestStore("TestRestore", "TestRestore", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(restoreStore)
+	dlReq := &import_sstpb.DownloadRequest{
+		Sst: import_sstpb.SSTMeta{
+			Uuid:     []byte("restore1"),
+			Range:    &import_sstpb.Range{Start: []byte("t"), End: []byte("u")},
+			RegionId: 1,
+		},
+		Name:           file.Name,
+		RewriteRule:    import_sstpb.RewriteRule{OldKeyPrefix: []byte("ta"), NewKeyPrefix: []byte("tx")},
+		StorageBackend: req.StorageBackend,
+	}
+	dlResp, err := restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error.GetMessage(), Matches, ".*rewrite prefix.*")
+	dlReq.RewriteRule = import_sstpb.RewriteRule{OldKeyPrefix: []byte("t"), NewKeyPrefix: []byte("t")}
+	dlResp, err = restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error, IsNil)
+	c.Assert(dlResp.IsEmpty, IsFalse)
+	c.Assert(dlResp.Range.Start, BytesEquals, []byte("ta"))
+	c.Assert(dlResp.Range.End, BytesEquals, []byte("tb"))
+	sstMeta := dlReq.Sst
+	sstMeta.Range = &dlResp.Range
+	sstMeta.Crc32 = dlResp.Crc32
+	sstMeta.Length = dlResp.Length
+	reqCtx := restoreStore.newImportReqCtx()
+	c.Assert(restoreStore.MvccStore.IngestSST(reqCtx, &sstMeta), IsNil)
+	reqCtx.finish()
+
+	MustGetNone([]byte("ta"), 5, restoreStore)
+	MustGetVal([]byte("ta"), []byte("va5"), 6, restoreStore)
+	MustGetVal([]byte("tb"), []byte("vb10"), 40, restoreStore)
+	MustGetNone([]byte("tc"), 40, restoreStore)
+
+	// The keys out of the range are skipped.
+	dlReq.Sst.Uuid = []byte("restore2")
+	dlReq.Sst.Range = &import_sstpb.Range{Start: []byte("tc"), End: []byte("tz")}
+	dlResp, err = restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error, IsNil)
+	c.Assert(dlResp.IsEmpty, IsTrue)
+	dlReq.Name = "../" + file.Name
+	dlResp, err = restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error, NotNil)
+
+	// The file is rejected if it doesn't match the checksums in the manifest.
+	dlReq.Name = file.Name
+	dlReq.Sst.Uuid = []byte("restore3")
+	meta.Files[0].Crc64Xor++
+	c.Assert(writeBackupManifest(dir, backupManifestName(1, req), meta), IsNil)
+	dlResp, err = restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error.GetMessage(), Matches, ".*crc64xor mismatch.*")
+	meta.Files[0].Crc64Xor--
+	meta.Files[0].Sha256[0]++
+	c.Assert(writeBackupManifest(dir, backupManifestName(1, req), meta), IsNil)
+	dlResp, err = restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error.GetMessage(), Matches, ".*sha256 mismatch.*")
+	dlReq.Name = "unknown.sst"
+	dlResp, err = restoreStore.Svr.Download(context.Background(), dlReq)
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error.GetMessage(), Matches, ".*not in any manifest.*")
+}
+
+// MustRestoreBackupFile downloads the backup file and ingests it into the store.
+func MustRestoreBackupFile(file *backup.File, backend *backup.StorageBackend, store *TestStore) {
+	c := store.c
+	c.Assert(restoreBackupFile(file, backend, store), IsNil)
+}
+
+// restoreBackupFile downloads the backup file and returns the error of the ingestion.
+func restoreBackupFile(file *backup.File, backend *backup.StorageBackend, store *TestStore) error {
+	c := store.c
+	dlResp, err := store.Svr.Download(context.Background(), &import_sstpb.DownloadRequest{
+		Sst: import_sstpb.SSTMeta{
+			Uuid:     []byte(file.Name),
+			Range:    &import_sstpb.Range{Start: []byte("t"), End: []byte("u")},
+			CfName:   file.Cf,
+			RegionId: 1,
+		},
+		Name:           file.Name,
+		StorageBackend: backend,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(dlResp.Error, IsNil)
+	c.Assert(dlResp.IsEmpty, IsFalse)
+	meta := &import_sstpb.SSTMeta{
+		Uuid:     []byte(file.Name),
+		Range:    &dlResp.Range,
+		Crc32:    dlResp.Crc32,
+		Length:   dlResp.Length,
+		CfName:   file.Cf,
+		RegionId: 1,
+	}
+	reqCtx := store.newImportReqCtx()
+	defer reqCtx.finish()
+	return store.MvccStore.IngestSST(reqCtx, meta)
+}
+
+func (s *testMvccSuite) TestIncrementalBackup(c *C) {
+	store, err := NewTestStore("TestIncrementalBackup", "TestIncrementalBackup", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	bundle := &mvcc.DBBundle{DB: store.MvccStore.db, LockStore: store.MvccStore.lockStore}
+	rm, err := NewMockRegionManager(bundle, 1, RegionOptions{})
+	c.Assert(err, IsNil)
+	region := &metapb.Region{Id: 1, RegionEpoch: &metapb.RegionEpoch{}, Peers: []*metapb.Peer{{Id: 2, StoreId: 1}}}
+	c.Assert(rm.Bootstrap([]*metapb.Store{{Id: 1}}, region), IsNil)
+	store.Svr.regionManager = rm
+
+	ta, tb, tc, td := []byte("ta"), []byte("tb"), []byte("tc"), []byte("td")
+	MustPrewritePut(ta, ta, []byte("va6"), 5, store)
+	MustCommit(ta, 5, 6, store)
+	MustRollbackKey(tc, 8, store)
+
+	dir := filepath.Join(store.DBPath, "backup")
+	backend := &backup.StorageBackend{Backend: &backup.StorageBackend_Local{Local: &backup.Local{Path: dir}}}
+	fullReq := &backup.BackupRequest{StartKey: ta, EndKey: []byte("u"), EndVersion: 10, StorageBackend: backend}
+	stream := new(mockBackupStream)
+	c.Assert(store.Svr.Backup(fullReq, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 1)
+	c.Assert(stream.resps[0].Files, HasLen, 1)
+	fullFile := stream.resps[0].Files[0]
+
+	MustPrewritePut(ta, ta, []byte("va12"), 11, store)
+	MustCommit(ta, 11, 12, store)
+	MustPrewritePut(tb, tb, []byte("vb12"), 11, store)
+	MustCommit(tb, 11, 12, store)
+	MustPrewritePut(ta, ta, []byte("va14"), 13, store)
+	MustCommit(ta, 13, 14, store)
+	MustPrewriteDelete(ta, ta, 15, store)
+	MustCommit(ta, 15, 16, store)
+	MustRollbackKey(tc, 17, store)
+	MustPrewriteLock(td, td, 18, store)
+	MustCommit(td, 18, 19, store)
+	MustPrewritePut(tb, tb, []byte("vb32"), 31, store)
+	MustCommit(tb, 31, 32, store)
+
+	incReq := &backup.BackupRequest{
+		StartKey: ta, EndKey: []byte("u"), StartVersion: 10, EndVersion: 20, StorageBackend: backend,
+	}
+	stream = new(mockBackupStream)
+	c.Assert(store.Svr.Backup(incReq, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 1)
+	c.Assert(stream.resps[0].Error, IsNil)
+	// The 3 versions of ta are in 3 layers in asc order of ts, and the rollback of tc and the op lock of td are in
+	// the txn status file.
+	files := stream.resps[0].Files
+	c.Assert(files, HasLen, 4)
+	kvs := []uint64{2, 1, 1, 2}
+	cfs := []string{raftstore.ReplayCF, raftstore.ReplayCF, raftstore.ReplayCF, raftstore.ReplayTxnStatusCF}
+	for i, file := range files {
+		c.Assert(file.TotalKvs, Equals, kvs[i])
+		c.Assert(file.Cf, Equals, cfs[i])
+		c.Assert(file.StartVersion, Equals, uint64(10))
+		c.Assert(file.EndVersion, Equals, uint64(20))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupManifestName(1, incReq)))
+	c.Assert(err, IsNil)
+	meta := new(backup.BackupMeta)
+	c.Assert(meta.Unmarshal(data), IsNil)
+	c.Assert(meta.StartVersion, Equals, uint64(10))
+	c.Assert(meta.Files, HasLen, 4)
+
+	restoreStore, err := NewTestStore("TestIncrementalRestore", "TestIncrementalRestore", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(restoreStore)
+	MustRestoreBackupFile(fullFile, backend, restoreStore)
+	for _, file := range meta.Files {
+		MustRestoreBackupFile(file, backend, restoreStore)
+	}
+	MustGetVal(ta, []byte("va6"), 11, restoreStore)
+	MustGetVal(ta, []byte("va12"), 13, restoreStore)
+	MustGetVal(ta, []byte("va14"), 15, restoreStore)
+	MustGetNone(ta, 16, restoreStore)
+	MustGetVal(tb, []byte("vb12"), 40, restoreStore)
+	MustGetRollback(tc, 17, restoreStore)
+	status := restoreStore.MvccStore.checkExtraTxnStatus(restoreStore.newReqCtx(), td, 18)
+	c.Assert(status.commitTS, Equals, uint64(19))
+	// The rollback before the start version is not in the increment.
+	status = restoreStore.MvccStore.checkExtraTxnStatus(restoreStore.newReqCtx(), tc, 8)
+	c.Assert(status.isRollback, IsFalse)
+	// A layer restored out of order is rejected.
+	err = restoreBackupFile(meta.Files[0], backend, restoreStore)
+	c.Assert(err, ErrorMatches, ".*must be restored in order.*")
+
+	// The increment since a version below the gc safe point is rejected.
+	store.MvccStore.safePoint.UpdateTS(15)
+	stream = new(mockBackupStream)
+	c.Assert(store.Svr.Backup(incReq, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 1)
+	c.Assert(stream.resps[0].Error.GetMsg(), Matches, ".*less than the gc safe point.*")
+}
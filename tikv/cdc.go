@@ -0,0 +1,435 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/coocood/badger/y"
+	"github.com/juju/errors"
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	cdcResolveInterval = time.Second
+	cdcEventChanSize   = 1024
+	cdcScanBatchSize   = 64
+	cdcSendBatchSize   = 128
+)
+
+var errCDCFeedOverflow = errors.New("cdc event feed overflow, the consumer is too slow")
+
+// cdcHub captures the transactional changes of the store and dispatches them to the subscriptions of the
+// event feeds.
+// A subscription scans the changes committed after its checkpoint ts and the locks in its range first, the
+// changes captured during the scan are buffered and sent after the scan, so a change may be sent twice around
+// the INITIALIZED row. The resolved ts of a subscription is advanced after it is initialized.
+type cdcHub struct {
+	store           *MVCCStore
+	regionManager   RegionManager
+	getTS           func() (uint64, error)
+	resolveInterval time.Duration
+
+	mu        sync.RWMutex
+	subs      map[*cdcSubscription]struct{}
+	startOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func newCDCHub(store *MVCCStore, rm RegionManager) *cdcHub {
+	h := &cdcHub{
+		store:           store,
+		regionManager:   rm,
+		resolveInterval: cdcResolveInterval,
+		subs:            make(map[*cdcSubscription]struct{}),
+		closeCh:         make(chan struct{}),
+	}
+	h.getTS = store.getPDTS
+	store.changeObserver.SetHandler(h.observe)
+	return h
+}
+
+func (h *cdcHub) close() {
+	close(h.closeCh)
+}
+
+// cdcSubscription is the subscription of a region in an event feed.
+type cdcSubscription struct {
+	feed         *cdcFeed
+	ctx          *kvrpcpb.Context
+	requestID    uint64
+	startKey     []byte
+	endKey       []byte
+	checkpointTS uint64
+
+	mu          sync.Mutex
+	initialized bool
+	pending     []*cdcpb.Event_Row
+	resolvedTS  uint64
+}
+
+func (sub *cdcSubscription) contains(key []byte) bool {
+	return bytes.Compare(key, sub.startKey) >= 0 && !exceedEndKey(key, sub.endKey)
+}
+
+func (sub *cdcSubscription) newEvent() *cdcpb.Event {
+	return &cdcpb.Event{RegionId: sub.ctx.RegionId, RequestId: sub.requestID}
+}
+
+func (sub *cdcSubscription) newEntriesEvent(rows []*cdcpb.Event_Row) *cdcpb.Event {
+	event := sub.newEvent()
+	event.Event = &cdcpb.Event_Entries_{Entries: &cdcpb.Event_Entries{Entries: rows}}
+	return event
+}
+
+func (sub *cdcSubscription) appendRows(rows []*cdcpb.Event_Row) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.initialized {
+		sub.pending = append(sub.pending, rows...)
+		return
+	}
+	sub.feed.send(sub.newEntriesEvent(rows))
+}
+
+func (sub *cdcSubscription) advance(resolvedTS uint64) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if resolvedTS <= sub.resolvedTS {
+		return
+	}
+	sub.resolvedTS = resolvedTS
+	event := sub.newEvent()
+	event.Event = &cdcpb.Event_ResolvedTs{ResolvedTs: resolvedTS}
+	sub.feed.send(event)
+}
+
+// cdcFeed is an event feed stream, the events of its subscriptions are sent in order.
+type cdcFeed struct {
+	eventCh   chan *cdcpb.Event
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	err       error
+
+	mu   sync.Mutex
+	subs map[uint64]*cdcSubscription
+}
+
+func newCDCFeed() *cdcFeed {
+	return &cdcFeed{
+		eventCh: make(chan *cdcpb.Event, cdcEventChanSize),
+		closeCh: make(chan struct{}),
+		subs:    make(map[uint64]*cdcSubscription),
+	}
+}
+
+// send sends the event without blocking, the feed fails if the consumer is too slow, so the writes are not
+// blocked by the consumer.
+func (f *cdcFeed) send(event *cdcpb.Event) {
+	select {
+	case f.eventCh <- event:
+	default:
+		f.fail(errCDCFeedOverflow)
+	}
+}
+
+// sendWait sends the event, it blocks until the event is sent or the feed is closed.
+func (f *cdcFeed) sendWait(event *cdcpb.Event) error {
+	select {
+	case f.eventCh <- event:
+		return nil
+	case <-f.closeCh:
+		return f.err
+	}
+}
+
+func (f *cdcFeed) fail(err error) {
+	f.closeOnce.Do(func() {
+		f.err = err
+		close(f.closeCh)
+	})
+}
+
+// register adds the subscription and starts its initial scan.
+func (h *cdcHub) register(sub *cdcSubscription) *cdcpb.Error {
+	feed := sub.feed
+	feed.mu.Lock()
+	if _, ok := feed.subs[sub.ctx.RegionId]; ok {
+		feed.mu.Unlock()
+		return &cdcpb.Error{DuplicateRequest: &cdcpb.Error_DuplicateRequest{RegionId: sub.ctx.RegionId}}
+	}
+	feed.subs[sub.ctx.RegionId] = sub
+	feed.mu.Unlock()
+	sub.resolvedTS = sub.checkpointTS
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	h.startOnce.Do(func() {
+		go h.runResolver()
+	})
+	go h.initialize(sub)
+	return nil
+}
+
+func (h *cdcHub) deregister(sub *cdcSubscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+	sub.feed.mu.Lock()
+	if sub.feed.subs[sub.ctx.RegionId] == sub {
+		delete(sub.feed.subs, sub.ctx.RegionId)
+	}
+	sub.feed.mu.Unlock()
+}
+
+// unregisterFeed removes all the subscriptions of the feed.
+func (h *cdcHub) unregisterFeed(feed *cdcFeed) {
+	feed.mu.Lock()
+	subs := make([]*cdcSubscription, 0, len(feed.subs))
+	for _, sub := range feed.subs {
+		subs = append(subs, sub)
+	}
+	feed.mu.Unlock()
+	for _, sub := range subs {
+		h.deregister(sub)
+	}
+	feed.fail(errors.New("cdc event feed is closed"))
+}
+
+// initialize sends the committed changes after the checkpoint ts and the locks in the range of the
+// subscription, followed by an INITIALIZED row and the changes captured during the scan.
+func (h *cdcHub) initialize(sub *cdcSubscription) {
+	err := h.scanCommitted(sub)
+	if err == nil {
+		err = h.scanLocks(sub)
+	}
+	if err == nil {
+		err = sub.feed.sendWait(sub.newEntriesEvent([]*cdcpb.Event_Row{{Type: cdcpb.Event_INITIALIZED}}))
+	}
+	if err != nil {
+		log.Warn("cdc initial scan failed", zap.Uint64("region", sub.ctx.RegionId), zap.Error(err))
+		h.deregister(sub)
+		sub.feed.fail(err)
+		return
+	}
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.initialized = true
+	if len(sub.pending) > 0 {
+		sub.feed.send(sub.newEntriesEvent(sub.pending))
+		sub.pending = nil
+	}
+}
+
+func (h *cdcHub) scanCommitted(sub *cdcSubscription) error {
+	txn := h.store.db.NewTransaction(false)
+	defer txn.Discard()
+	it := dbreader.NewIterator(txn, false, sub.startKey, sub.endKey)
+	defer it.Close()
+	it.SetAllVersions(true)
+	rows := make([]*cdcpb.Event_Row, 0, cdcScanBatchSize)
+	for it.Seek(sub.startKey); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if exceedEndKey(key, sub.endKey) {
+			break
+		}
+		if key[0] != metaPrefix && key[0] != tablePrefix {
+			continue
+		}
+		if item.IsDeleted() || item.Version() <= sub.checkpointTS {
+			continue
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		row := &cdcpb.Event_Row{
+			StartTs:  mvcc.DBUserMeta(item.UserMeta()).StartTS(),
+			CommitTs: item.Version(),
+			Type:     cdcpb.Event_COMMITTED,
+			OpType:   cdcpb.Event_Row_PUT,
+			Key:      y.Copy(key),
+			Value:    val,
+		}
+		if item.IsEmpty() {
+			row.OpType = cdcpb.Event_Row_DELETE
+		}
+		rows = append(rows, row)
+		if len(rows) == cdcScanBatchSize {
+			if err = sub.feed.sendWait(sub.newEntriesEvent(rows)); err != nil {
+				return err
+			}
+			rows = make([]*cdcpb.Event_Row, 0, cdcScanBatchSize)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return sub.feed.sendWait(sub.newEntriesEvent(rows))
+}
+
+func (h *cdcHub) scanLocks(sub *cdcSubscription) error {
+	var rows []*cdcpb.Event_Row
+	it := h.store.lockStore.NewIterator()
+	for it.Seek(sub.startKey); it.Valid(); it.Next() {
+		if exceedEndKey(it.Key(), sub.endKey) {
+			break
+		}
+		lock := mvcc.DecodeLock(it.Value())
+		row := newCDCRow(&mvcc.ChangeEvent{
+			Type: mvcc.ChangePrewrite, Key: it.Key(), Op: lock.Op, Value: lock.Value, StartTS: lock.StartTS,
+		})
+		if row != nil {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return sub.feed.sendWait(sub.newEntriesEvent(rows))
+}
+
+// observe is the handler of the change observer.
+func (h *cdcHub) observe(events []mvcc.ChangeEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		var rows []*cdcpb.Event_Row
+		for i := range events {
+			if !sub.contains(events[i].Key) {
+				continue
+			}
+			if row := newCDCRow(&events[i]); row != nil {
+				rows = append(rows, row)
+			}
+		}
+		if len(rows) > 0 {
+			sub.appendRows(rows)
+		}
+	}
+}
+
+// newCDCRow converts a change to a row, nil is returned if the change doesn't change the value of the key.
+func newCDCRow(event *mvcc.ChangeEvent) *cdcpb.Event_Row {
+	row := &cdcpb.Event_Row{StartTs: event.StartTS}
+	switch event.Type {
+	case mvcc.ChangeRollback:
+		row.Type = cdcpb.Event_ROLLBACK
+		row.Key = y.Copy(event.Key)
+		return row
+	case mvcc.ChangePrewrite:
+		row.Type = cdcpb.Event_PREWRITE
+	case mvcc.ChangeCommit:
+		row.Type = cdcpb.Event_COMMIT
+		row.CommitTs = event.CommitTS
+	}
+	// An empty value is a delete in the DB, it is sent as a delete like the committed rows of the scan.
+	switch kvrpcpb.Op(event.Op) {
+	case kvrpcpb.Op_Put, kvrpcpb.Op_Insert:
+		row.OpType = cdcpb.Event_Row_PUT
+		if len(event.Value) == 0 {
+			row.OpType = cdcpb.Event_Row_DELETE
+		}
+	case kvrpcpb.Op_Del:
+		row.OpType = cdcpb.Event_Row_DELETE
+	default:
+		return nil
+	}
+	row.Key = y.Copy(event.Key)
+	if event.Type == mvcc.ChangePrewrite {
+		row.Value = y.Copy(event.Value)
+	}
+	return row
+}
+
+func (h *cdcHub) runResolver() {
+	ticker := time.NewTicker(h.resolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.closeCh:
+			return
+		case <-ticker.C:
+			h.resolve()
+		}
+	}
+}
+
+// resolve advances the resolved ts of the initialized subscriptions to the min start ts of the locks in
+// their ranges, or the current ts if there is no lock.
+func (h *cdcHub) resolve() {
+	h.mu.RLock()
+	subs := make([]*cdcSubscription, 0, len(h.subs))
+	for sub := range h.subs {
+		subs = append(subs, sub)
+	}
+	h.mu.RUnlock()
+	if len(subs) == 0 {
+		return
+	}
+	ts, err := h.getTS()
+	if err != nil {
+		log.Warn("cdc get ts failed", zap.Error(err))
+		return
+	}
+	for _, sub := range subs {
+		sub.mu.Lock()
+		initialized := sub.initialized
+		sub.mu.Unlock()
+		if !initialized {
+			continue
+		}
+		if cdcErr := h.checkRegion(sub); cdcErr != nil {
+			h.deregister(sub)
+			event := sub.newEvent()
+			event.Event = &cdcpb.Event_Error{Error: cdcErr}
+			sub.feed.send(event)
+			continue
+		}
+		sub.advance(h.store.minLockTS(sub.startKey, sub.endKey, ts))
+	}
+}
+
+// checkRegion checks if the subscribed region is still led by the store with the same epoch.
+func (h *cdcHub) checkRegion(sub *cdcSubscription) *cdcpb.Error {
+	if h.regionManager == nil {
+		return nil
+	}
+	if _, regErr := h.regionManager.GetRegionFromCtx(sub.ctx); regErr != nil {
+		return convertToCDCError(regErr, sub.ctx.RegionId)
+	}
+	return nil
+}
+
+func convertToCDCError(regErr *errorpb.Error, regionID uint64) *cdcpb.Error {
+	cdcErr := &cdcpb.Error{
+		NotLeader:      regErr.NotLeader,
+		RegionNotFound: regErr.RegionNotFound,
+		EpochNotMatch:  regErr.EpochNotMatch,
+	}
+	if cdcErr.NotLeader == nil && cdcErr.EpochNotMatch == nil && cdcErr.RegionNotFound == nil {
+		cdcErr.RegionNotFound = &errorpb.RegionNotFound{RegionId: regionID}
+	}
+	return cdcErr
+}
@@ -0,0 +1,131 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+type cdcRow struct {
+	tp       cdcpb.Event_LogType
+	op       cdcpb.Event_Row_OpType
+	key      string
+	value    string
+	startTS  uint64
+	commitTS uint64
+}
+
+// MustRecvCDCRows receives the next n rows of the feed.
+func MustRecvCDCRows(c *C, feed *cdcFeed, n int) []cdcRow {
+	var rows []cdcRow
+	for len(rows) < n {
+		event := mustRecvCDCEvent(c, feed)
+		entries := event.GetEntries()
+		c.Assert(entries, NotNil, Commentf("%v", event))
+		for _, row := range entries.Entries {
+			rows = append(rows, cdcRow{
+				tp:       row.Type,
+				op:       row.OpType,
+				key:      string(row.Key),
+				value:    string(row.Value),
+				startTS:  row.StartTs,
+				commitTS: row.CommitTs,
+			})
+		}
+	}
+	c.Assert(rows, HasLen, n)
+	return rows
+}
+
+func mustRecvCDCEvent(c *C, feed *cdcFeed) *cdcpb.Event {
+	select {
+	case event := <-feed.eventCh:
+		c.Assert(event.RegionId, Equals, uint64(1))
+		c.Assert(event.RequestId, Equals, uint64(7))
+		return event
+	case <-time.After(5 * time.Second):
+		c.Fatal("no cdc event is received")
+	}
+	return nil
+}
+
+func (s *testMvccSuite) TestCDC(c *C) {
+	store, err := NewTestStore("TestCDC", "TestCDC", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k1, k2, k3, k4 := []byte("t1"), []byte("t2"), []byte("t3"), []byte("t4")
+	MustPrewritePut(k1, k1, []byte("v1"), 1, store)
+	MustCommit(k1, 1, 2, store)
+	MustPrewritePut(k2, k2, []byte("v2"), 3, store)
+	MustCommit(k2, 3, 4, store)
+	MustPrewritePut(k3, k3, []byte("v3"), 5, store)
+
+	hub := store.Svr.cdc
+	hub.resolveInterval = time.Hour
+	hub.getTS = func() (uint64, error) { return 100, nil }
+	feed := newCDCFeed()
+	sub := &cdcSubscription{
+		feed:         feed,
+		ctx:          &kvrpcpb.Context{RegionId: 1},
+		requestID:    7,
+		startKey:     []byte("t"),
+		endKey:       []byte("u"),
+		checkpointTS: 2,
+	}
+	c.Assert(hub.register(sub), IsNil)
+	// The version committed at the checkpoint ts is not sent.
+	c.Assert(MustRecvCDCRows(c, feed, 3), DeepEquals, []cdcRow{
+		{tp: cdcpb.Event_COMMITTED, op: cdcpb.Event_Row_PUT, key: "t2", value: "v2", startTS: 3, commitTS: 4},
+		{tp: cdcpb.Event_PREWRITE, op: cdcpb.Event_Row_PUT, key: "t3", value: "v3", startTS: 5},
+		{tp: cdcpb.Event_INITIALIZED},
+	})
+	dup := &cdcSubscription{feed: feed, ctx: &kvrpcpb.Context{RegionId: 1}}
+	c.Assert(hub.register(dup).DuplicateRequest, NotNil)
+
+	// The resolved ts is blocked by the lock of k3.
+	hub.resolve()
+	c.Assert(mustRecvCDCEvent(c, feed).GetResolvedTs(), Equals, uint64(5))
+	MustCommit(k3, 5, 6, store)
+	MustPrewriteDelete(k1, k1, 7, store)
+	MustCommit(k1, 7, 8, store)
+	MustPrewritePut(k4, k4, []byte("v4"), 9, store)
+	MustRollbackKey(k4, 9, store)
+	c.Assert(MustRecvCDCRows(c, feed, 5), DeepEquals, []cdcRow{
+		{tp: cdcpb.Event_COMMIT, op: cdcpb.Event_Row_PUT, key: "t3", startTS: 5, commitTS: 6},
+		{tp: cdcpb.Event_PREWRITE, op: cdcpb.Event_Row_DELETE, key: "t1", startTS: 7},
+		{tp: cdcpb.Event_COMMIT, op: cdcpb.Event_Row_DELETE, key: "t1", startTS: 7, commitTS: 8},
+		{tp: cdcpb.Event_PREWRITE, op: cdcpb.Event_Row_PUT, key: "t4", value: "v4", startTS: 9},
+		{tp: cdcpb.Event_ROLLBACK, key: "t4", startTS: 9},
+	})
+	hub.resolve()
+	c.Assert(mustRecvCDCEvent(c, feed).GetResolvedTs(), Equals, uint64(100))
+	// The resolved ts doesn't go back.
+	hub.getTS = func() (uint64, error) { return 50, nil }
+	hub.resolve()
+	select {
+	case event := <-feed.eventCh:
+		c.Fatalf("unexpected event %v", event)
+	default:
+	}
+
+	hub.unregisterFeed(feed)
+	c.Assert(hub.subs, HasLen, 0)
+	MustPrewritePut(k4, k4, []byte("v4"), 10, store)
+	c.Assert(feed.eventCh, HasLen, 0)
+}
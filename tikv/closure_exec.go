@@ -14,13 +14,14 @@
 package tikv
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
 	"math"
 	"sort"
 
 	"github.com/juju/errors"
 	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/pingcap/kvproto/pkg/coprocessor"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/parser/terror"
@@ -47,9 +48,56 @@ const (
 	pkColIsUnsigned
 )
 
-// buildClosureExecutor build a closureExecutor for the DAGRequest.
-// Currently the composition of executors are:
+// dagExecutor executes a DAGRequest and returns the encoded rows.
+type dagExecutor interface {
+	execute() ([]tipb.Chunk, error)
+	executeStream(batchRows int, send streamSender) error
+	// rangeCounts returns the number of rows scanned in each range if the request collects them.
+	rangeCounts() []int64
+}
+
+// buildDAGExecutor builds a closureExecutor if the executors can be flattened to a closure, otherwise it builds
+// a pipelineExecutor.
+func (svr *Server) buildDAGExecutor(dagCtx *dagContext, dagReq *tipb.DAGRequest) (dagExecutor, error) {
+	if isClosureSupported(dagReq.Executors) {
+		ce, err := svr.buildClosureExecutor(dagCtx, dagReq)
+		if err != nil {
+			return nil, err
+		}
+		return ce, nil
+	}
+	pe, err := svr.buildPipelineExecutor(dagCtx, dagReq)
+	if err != nil {
+		return nil, err
+	}
+	return pe, nil
+}
+
+// isClosureSupported returns whether the executors can be flattened to a closure, the composition of the
+// executors must be:
 // 	tableScan|indexScan [selection] [topN | limit | agg]
+func isClosureSupported(executors []*tipb.Executor) bool {
+	switch len(executors) {
+	case 1:
+		return true
+	case 2:
+		return executors[1].Tp == tipb.ExecType_TypeSelection || isClosureLastExecutor(executors[1])
+	case 3:
+		return executors[1].Tp == tipb.ExecType_TypeSelection && isClosureLastExecutor(executors[2])
+	}
+	return false
+}
+
+func isClosureLastExecutor(exec *tipb.Executor) bool {
+	switch exec.Tp {
+	case tipb.ExecType_TypeLimit, tipb.ExecType_TypeTopN, tipb.ExecType_TypeAggregation, tipb.ExecType_TypeStreamAgg:
+		return true
+	}
+	return false
+}
+
+// buildClosureExecutor build a closureExecutor for the DAGRequest, the executors must be supported by
+// isClosureSupported.
 func (svr *Server) buildClosureExecutor(dagCtx *dagContext, dagReq *tipb.DAGRequest) (*closureExecutor, error) {
 	ce, err := svr.newClosureExecutor(dagCtx, dagReq)
 	if err != nil {
@@ -85,7 +133,7 @@ func (svr *Server) buildClosureExecutor(dagCtx *dagContext, dagReq *tipb.DAGRequ
 	case tipb.ExecType_TypeSelection:
 		ce.processor = &selectionProcessor{closureExecutor: ce}
 	default:
-		panic("unknown executor type " + lastExecutor.Tp.String())
+		return nil, errors.Errorf("unsupported executor type %s", lastExecutor.Tp)
 	}
 	if err != nil {
 		return nil, err
@@ -112,6 +160,7 @@ func (svr *Server) newClosureExecutor(dagCtx *dagContext, dagReq *tipb.DAGReques
 		outputOff:   dagReq.OutputOffsets,
 		mvccStore:   svr.mvccStore,
 		startTS:     dagCtx.startTS,
+		ignoreLock:  dagCtx.reqCtx.staleRead,
 		limit:       math.MaxInt64,
 	}
 	seCtx := mockpkg.NewContext()
@@ -130,7 +179,7 @@ func (svr *Server) newClosureExecutor(dagCtx *dagContext, dagReq *tipb.DAGReques
 		e.scanCtx.desc = idxScan.Desc
 		e.initIdxScanCtx()
 	default:
-		panic(fmt.Sprintf("unknown first executor type %s", executors[0].Tp))
+		return nil, errors.Errorf("unsupported first executor type %s", executors[0].Tp)
 	}
 	ranges, err := svr.extractKVRanges(dagCtx.reqCtx.regCtx, dagCtx.keyRanges, e.scanCtx.desc)
 	if err != nil {
@@ -337,6 +386,10 @@ type topNCtx struct {
 	sortRow      *sortRow
 }
 
+func (e *closureExecutor) rangeCounts() []int64 {
+	return e.counts
+}
+
 func (e *closureExecutor) execute() ([]tipb.Chunk, error) {
 	err := e.checkRangeLock()
 	if err != nil {
@@ -344,35 +397,9 @@ func (e *closureExecutor) execute() ([]tipb.Chunk, error) {
 	}
 	dbReader := e.reqCtx.getDBReader()
 	for i, ran := range e.kvRanges {
-		if e.unique && ran.IsPoint() {
-			val, err := dbReader.Get(ran.StartKey, e.startTS)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-			if len(val) == 0 {
-				continue
-			}
-			if e.counts != nil {
-				e.counts[i]++
-			}
-			err = e.processor.Process(ran.StartKey, val)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-		} else {
-			oldCnt := e.rowCount
-			if e.scanCtx.desc {
-				err = dbReader.ReverseScan(ran.StartKey, ran.EndKey, math.MaxInt64, e.startTS, e.processor)
-			} else {
-				err = dbReader.Scan(ran.StartKey, ran.EndKey, math.MaxInt64, e.startTS, e.processor)
-			}
-			delta := int64(e.rowCount - oldCnt)
-			if e.counts != nil {
-				e.counts[i] += delta
-			}
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
+		err = e.processRange(dbReader, i, ran, e.processor)
+		if err != nil {
+			return nil, err
 		}
 		if e.rowCount == e.limit {
 			break
@@ -382,6 +409,156 @@ func (e *closureExecutor) execute() ([]tipb.Chunk, error) {
 	return e.oldChunks, err
 }
 
+// processRange feeds the key/value pairs in the i-th range to the processor.
+func (e *closureExecutor) processRange(dbReader *dbreader.DBReader, i int, ran kv.KeyRange, processor dbreader.ScanProcessor) error {
+	if e.unique && ran.IsPoint() {
+		val, err := dbReader.Get(ran.StartKey, e.startTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(val) == 0 {
+			return nil
+		}
+		if e.counts != nil {
+			e.counts[i]++
+		}
+		// ScanBreak stops the point get like the scan, the caller resumes from the next range.
+		if err = processor.Process(ran.StartKey, val); err == dbreader.ScanBreak {
+			err = nil
+		}
+		return errors.Trace(err)
+	}
+	var err error
+	oldCnt := e.rowCount
+	if e.scanCtx.desc {
+		err = dbReader.ReverseScan(ran.StartKey, ran.EndKey, math.MaxInt64, e.startTS, processor)
+	} else {
+		err = dbReader.Scan(ran.StartKey, ran.EndKey, math.MaxInt64, e.startTS, processor)
+	}
+	delta := int64(e.rowCount - oldCnt)
+	if e.counts != nil {
+		e.counts[i] += delta
+	}
+	return errors.Trace(err)
+}
+
+// streamSender sends a batch of chunks and the key range they cover.
+type streamSender func(chunks []tipb.Chunk, counts []int64, ran *coprocessor.KeyRange) error
+
+// executeStream executes the request and sends the result in batches of at most batchRows scanned rows.
+// The range of each batch is sent along so the client can resume the scan from where it stopped.
+// Aggregation and TopN need all the rows to produce the result, so they are sent in a single batch.
+func (e *closureExecutor) executeStream(batchRows int, send streamSender) error {
+	streamable := false
+	switch e.processor.(type) {
+	case *tableScanProcessor, *indexScanProcessor, *selectionProcessor:
+		streamable = len(e.kvRanges) > 0
+	}
+	if !streamable {
+		chunks, err := e.execute()
+		if err != nil {
+			return err
+		}
+		var ran *coprocessor.KeyRange
+		if len(e.kvRanges) > 0 {
+			ran = e.coveredRange(e.kvRanges[0], e.kvRanges[len(e.kvRanges)-1])
+		}
+		return send(chunks, e.counts, ran)
+	}
+	err := e.checkRangeLock()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dbReader := e.reqCtx.getDBReader()
+	processor := &streamProcessor{closureProcessor: e.processor, batchRows: batchRows}
+	batchFirst, i := e.kvRanges[0], 0
+	sent := false
+	for i < len(e.kvRanges) && e.rowCount < e.limit {
+		ran := e.kvRanges[i]
+		err = e.processRange(dbReader, i, ran, processor)
+		if err != nil {
+			return err
+		}
+		if processor.scanned < batchRows {
+			i++
+			continue
+		}
+		// The batch is full, cut the current range at the last scanned key.
+		var covered *coprocessor.KeyRange
+		if e.scanCtx.desc {
+			ran.EndKey = append([]byte{}, processor.lastKey...)
+			covered = &coprocessor.KeyRange{Start: ran.EndKey, End: batchFirst.EndKey}
+		} else {
+			ran.StartKey = kv.Key(processor.lastKey).Next()
+			covered = &coprocessor.KeyRange{Start: batchFirst.StartKey, End: ran.StartKey}
+		}
+		err = e.flushStream(send, covered)
+		if err != nil {
+			return err
+		}
+		sent = true
+		processor.scanned = 0
+		if (e.unique && e.kvRanges[i].IsPoint()) || bytes.Compare(ran.StartKey, ran.EndKey) >= 0 {
+			i++
+			if i < len(e.kvRanges) {
+				batchFirst = e.kvRanges[i]
+			}
+			continue
+		}
+		e.kvRanges[i] = ran
+		batchFirst = ran
+	}
+	if sent && processor.scanned == 0 {
+		return nil
+	}
+	if i == len(e.kvRanges) {
+		i--
+	}
+	return e.flushStream(send, e.coveredRange(batchFirst, e.kvRanges[i]))
+}
+
+func (e *closureExecutor) flushStream(send streamSender, ran *coprocessor.KeyRange) error {
+	err := e.processor.Finish()
+	if err != nil {
+		return err
+	}
+	err = send(e.oldChunks, e.counts, ran)
+	e.oldChunks = nil
+	for i := range e.counts {
+		e.counts[i] = 0
+	}
+	return err
+}
+
+// coveredRange returns the key range from the first to the last scanned range.
+func (e *closureExecutor) coveredRange(first, last kv.KeyRange) *coprocessor.KeyRange {
+	if e.scanCtx.desc {
+		return &coprocessor.KeyRange{Start: last.StartKey, End: first.EndKey}
+	}
+	return &coprocessor.KeyRange{Start: first.StartKey, End: last.EndKey}
+}
+
+// streamProcessor breaks the scan when a batch of rows has been scanned.
+type streamProcessor struct {
+	closureProcessor
+	batchRows int
+	scanned   int
+	lastKey   []byte
+}
+
+func (p *streamProcessor) Process(key, value []byte) error {
+	err := p.closureProcessor.Process(key, value)
+	if err != nil {
+		return err
+	}
+	p.scanned++
+	p.lastKey = append(p.lastKey[:0], key...)
+	if p.scanned >= p.batchRows {
+		return dbreader.ScanBreak
+	}
+	return nil
+}
+
 func (e *closureExecutor) checkRangeLock() error {
 	if !e.ignoreLock && !e.lockChecked {
 		for _, ran := range e.kvRanges {
@@ -494,38 +671,45 @@ func (e *closureExecutor) hasSelection() bool {
 func (e *closureExecutor) processSelection() (gotRow bool, err error) {
 	chk := e.scanCtx.chk
 	row := chk.GetRow(chk.NumRows() - 1)
-	gotRow = true
-	for _, expr := range e.selectionCtx.conditions {
-		wc := e.sc.WarningCount()
+	wc := e.sc.WarningCount()
+	gotRow, err = evalConditions(e.sc, e.selectionCtx.conditions, row)
+	if err != nil {
+		return false, err
+	}
+	if !gotRow {
+		if e.sc.WarningCount() > wc {
+			// Deep-copy error object here, because the data it referenced is going to be truncated.
+			warns := e.sc.TruncateWarnings(int(wc))
+			for i, warn := range warns {
+				warns[i].Err = e.copyError(warn.Err)
+			}
+			e.sc.AppendWarnings(warns)
+		}
+		chk.TruncateTo(chk.NumRows() - 1)
+	}
+	return
+}
+
+// evalConditions returns whether the row satisfies all the conditions.
+func evalConditions(sc *stmtctx.StatementContext, conditions []expression.Expression, row chunk.Row) (bool, error) {
+	for _, expr := range conditions {
 		d, err := expr.Eval(row)
 		if err != nil {
 			return false, errors.Trace(err)
 		}
-
 		if d.IsNull() {
-			gotRow = false
-		} else {
-			isBool, err := d.ToBool(e.sc)
-			isBool, err = expression.HandleOverflowOnSelection(e.sc, isBool, err)
-			if err != nil {
-				return false, errors.Trace(err)
-			}
-			gotRow = isBool != 0
-		}
-		if !gotRow {
-			if e.sc.WarningCount() > wc {
-				// Deep-copy error object here, because the data it referenced is going to be truncated.
-				warns := e.sc.TruncateWarnings(int(wc))
-				for i, warn := range warns {
-					warns[i].Err = e.copyError(warn.Err)
-				}
-				e.sc.AppendWarnings(warns)
-			}
-			chk.TruncateTo(chk.NumRows() - 1)
-			break
+			return false, nil
+		}
+		isBool, err := d.ToBool(sc)
+		isBool, err = expression.HandleOverflowOnSelection(sc, isBool, err)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if isBool == 0 {
+			return false, nil
 		}
 	}
-	return
+	return true, nil
 }
 
 func (e *closureExecutor) copyError(err error) error {
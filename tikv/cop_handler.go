@@ -16,6 +16,8 @@ package tikv
 import (
 	"bytes"
 	"fmt"
+	"hash/crc64"
+	"math"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -48,19 +50,92 @@ type dagContext struct {
 	startTS   uint64
 }
 
+// checksumTable is the crc64 table used by TiKV to checksum a table, it is the ECMA polynomial.
+var checksumTable = crc64.MakeTable(crc64.ECMA)
+
+// handleCopChecksumRequest checksums the visible key/value pairs in the ranges at the start ts the same way
+// as TiKV, the checksum of the ranges is the xor of the crc64 digests of the pairs. If the request has a
+// rewrite rule, the new prefix of the keys is replaced by the old prefix before they are digested.
 func (svr *Server) handleCopChecksumRequest(reqCtx *requestCtx, req *coprocessor.Request) *coprocessor.Response {
-	resp := &tipb.ChecksumResponse{
-		Checksum:   1,
-		TotalKvs:   1,
-		TotalBytes: 1,
+	checksumReq := new(tipb.ChecksumRequest)
+	err := proto.Unmarshal(req.Data, checksumReq)
+	if err != nil {
+		return &coprocessor.Response{OtherError: err.Error()}
+	}
+	if checksumReq.Algorithm != tipb.ChecksumAlgorithm_Crc64_Xor {
+		return &coprocessor.Response{OtherError: fmt.Sprintf("unsupported checksum algorithm %v", checksumReq.Algorithm)}
+	}
+	ranges, err := svr.extractKVRanges(reqCtx.regCtx, req.Ranges, false)
+	if err != nil {
+		return &coprocessor.Response{OtherError: err.Error()}
+	}
+	proc := &checksumProcessor{}
+	if rule := checksumReq.Rule; rule != nil {
+		proc.oldPrefix, proc.newPrefix = rule.OldPrefix, rule.NewPrefix
 	}
-	data, err := resp.Marshal()
+	err = svr.checksumRanges(reqCtx, ranges, req.StartTs, proc)
+	if err != nil {
+		resp := &coprocessor.Response{}
+		if locked, ok := errors.Cause(err).(*ErrLocked); ok {
+			resp.Locked = &kvrpcpb.LockInfo{
+				Key:         locked.Key,
+				PrimaryLock: locked.Primary,
+				LockVersion: locked.StartTS,
+				LockTtl:     locked.TTL,
+			}
+		} else {
+			resp.OtherError = err.Error()
+		}
+		return resp
+	}
+	data, err := proc.resp.Marshal()
 	if err != nil {
 		return &coprocessor.Response{OtherError: fmt.Sprintf("marshal checksum response error: %v", err)}
 	}
 	return &coprocessor.Response{Data: data}
 }
 
+func (svr *Server) checksumRanges(reqCtx *requestCtx, ranges []kv.KeyRange, startTS uint64, proc *checksumProcessor) error {
+	if !reqCtx.staleRead {
+		for _, ran := range ranges {
+			err := svr.mvccStore.CheckRangeLock(startTS, ran.StartKey, ran.EndKey)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	dbReader := reqCtx.getDBReader()
+	for _, ran := range ranges {
+		err := dbReader.Scan(ran.StartKey, ran.EndKey, math.MaxInt64, startTS, proc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type checksumProcessor struct {
+	skipVal
+	oldPrefix []byte
+	newPrefix []byte
+	buf       []byte
+	resp      tipb.ChecksumResponse
+}
+
+func (p *checksumProcessor) Process(key, value []byte) error {
+	if !bytes.HasPrefix(key, p.newPrefix) {
+		return errors.Errorf("key %q does not have the rewrite prefix %q", key, p.newPrefix)
+	}
+	p.buf = append(p.buf[:0], p.oldPrefix...)
+	p.buf = append(p.buf, key[len(p.newPrefix):]...)
+	p.buf = append(p.buf, value...)
+	p.resp.Checksum ^= crc64.Checksum(p.buf, checksumTable)
+	p.resp.TotalKvs++
+	// The bytes are counted with the old prefix, like the checksum.
+	p.resp.TotalBytes += uint64(len(p.buf))
+	return nil
+}
+
 func (svr *Server) handleCopDAGRequest(reqCtx *requestCtx, req *coprocessor.Request) *coprocessor.Response {
 	startTime := time.Now()
 	resp := &coprocessor.Response{}
@@ -69,12 +144,44 @@ func (svr *Server) handleCopDAGRequest(reqCtx *requestCtx, req *coprocessor.Requ
 		resp.OtherError = err.Error()
 		return resp
 	}
-	closureExec, err := svr.buildClosureExecutor(dagCtx, dagReq)
+	exec, err := svr.buildDAGExecutor(dagCtx, dagReq)
 	if err != nil {
 		return buildResp(nil, nil, err, dagCtx.evalCtx.sc.GetWarnings(), time.Since(startTime))
 	}
-	chunks, err := closureExec.execute()
-	return buildResp(chunks, closureExec.counts, err, dagCtx.evalCtx.sc.GetWarnings(), time.Since(startTime))
+	chunks, err := exec.execute()
+	return buildResp(chunks, exec.rangeCounts(), err, dagCtx.evalCtx.sc.GetWarnings(), time.Since(startTime))
+}
+
+// copStreamBatchRows is the max number of rows scanned for one response of a coprocessor stream.
+const copStreamBatchRows = 1024
+
+func (svr *Server) handleCopStreamRequest(reqCtx *requestCtx, req *coprocessor.Request, send func(*coprocessor.Response) error) error {
+	startTime := time.Now()
+	dagCtx, dagReq, err := svr.buildDAG(reqCtx, req)
+	if err != nil {
+		return send(&coprocessor.Response{OtherError: err.Error()})
+	}
+	sc := dagCtx.evalCtx.sc
+	exec, err := svr.buildDAGExecutor(dagCtx, dagReq)
+	if err != nil {
+		return send(buildResp(nil, nil, err, sc.GetWarnings(), time.Since(startTime)))
+	}
+	var sendErr error
+	err = exec.executeStream(copStreamBatchRows, func(chunks []tipb.Chunk, counts []int64, ran *coprocessor.KeyRange) error {
+		resp := buildResp(chunks, counts, nil, sc.GetWarnings(), time.Since(startTime))
+		resp.Range = ran
+		sc.SetWarnings(nil)
+		startTime = time.Now()
+		sendErr = send(resp)
+		return sendErr
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return send(buildResp(nil, nil, err, sc.GetWarnings(), time.Since(startTime)))
+	}
+	return nil
 }
 
 func (svr *Server) buildDAG(reqCtx *requestCtx, req *coprocessor.Request) (*dagContext, *tipb.DAGRequest, error) {
@@ -90,6 +197,9 @@ func (svr *Server) buildDAG(reqCtx *requestCtx, req *coprocessor.Request) (*dagC
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
+	if err = checkDAGExecutors(dagReq.Executors); err != nil {
+		return nil, nil, err
+	}
 	sc := flagsToStatementContext(dagReq.Flags)
 	sc.TimeZone = time.FixedZone("UTC", int(dagReq.TimeZoneOffset))
 	ctx := &dagContext{
@@ -108,6 +218,27 @@ func (svr *Server) buildDAG(reqCtx *requestCtx, req *coprocessor.Request) (*dagC
 	return ctx, dagReq, err
 }
 
+// checkDAGExecutors checks the executors start with a scan and the rest can be executed.
+func checkDAGExecutors(executors []*tipb.Executor) error {
+	if len(executors) == 0 {
+		return errors.New("no executor in the DAG request")
+	}
+	switch executors[0].Tp {
+	case tipb.ExecType_TypeTableScan, tipb.ExecType_TypeIndexScan:
+	default:
+		return errors.Errorf("unsupported first executor type %s", executors[0].Tp)
+	}
+	for _, exec := range executors[1:] {
+		switch exec.Tp {
+		case tipb.ExecType_TypeSelection, tipb.ExecType_TypeLimit, tipb.ExecType_TypeTopN,
+			tipb.ExecType_TypeAggregation, tipb.ExecType_TypeStreamAgg:
+		default:
+			return errors.Errorf("unsupported executor type %s", exec.Tp)
+		}
+	}
+	return nil
+}
+
 func (svr *Server) getAggInfo(ctx *dagContext, pbAgg *tipb.Aggregation) ([]aggregation.Aggregation, []expression.Expression, error) {
 	length := len(pbAgg.AggFunc)
 	aggs := make([]aggregation.Aggregation, 0, length)
@@ -270,6 +270,31 @@ func (dagBuilder *dagBuilder) addLimit(limit uint64) *dagBuilder {
 	return dagBuilder
 }
 
+func (dagBuilder *dagBuilder) addTopN(colID int64, desc bool, limit uint64) *dagBuilder {
+	dagBuilder.executors = append(dagBuilder.executors, &tipb.Executor{
+		Tp: tipb.ExecType_TypeTopN,
+		TopN: &tipb.TopN{
+			OrderBy: []*tipb.ByItem{{Expr: buildColumnRefExpr(colID), Desc: desc}},
+			Limit:   limit,
+		},
+	})
+	return dagBuilder
+}
+
+func (dagBuilder *dagBuilder) addCountAgg(colID int64) *dagBuilder {
+	dagBuilder.executors = append(dagBuilder.executors, &tipb.Executor{
+		Tp: tipb.ExecType_TypeAggregation,
+		Aggregation: &tipb.Aggregation{
+			AggFunc: []*tipb.Expr{{
+				Tp:        tipb.ExprType_Count,
+				Children:  []*tipb.Expr{buildColumnRefExpr(colID)},
+				FieldType: expression.ToPBFieldType(types.NewFieldType(mysql.TypeLonglong)),
+			}},
+		},
+	})
+	return dagBuilder
+}
+
 func (dagBuilder *dagBuilder) build() *tipb.DAGRequest {
 	return &tipb.DAGRequest{
 		Executors:     dagBuilder.executors,
@@ -366,17 +391,86 @@ func TestClosureExecutor(t *testing.T) {
 	require.Equal(t, rowCount, 0)
 }
 
+func TestClosureExecutorStream(t *testing.T) {
+	data := prepareTestTableData(t, keyNumber, TableId)
+	store, err := NewTestStore("cop_handler_test_db", "cop_handler_test_log", nil)
+	defer CleanTestStore(store)
+	require.Nil(t, err)
+	errors := initTestData(store, data.encodedTestKVDatas)
+	require.Nil(t, errors)
+
+	dagRequest := newDagBuilder().
+		setStartTs(DagRequestStartTs).
+		addTableScan(data.colInfos, TableId).
+		setOutputOffsets([]uint32{0, 1}).
+		build()
+	fullRange := kv.KeyRange{
+		StartKey: tablecodec.EncodeRowKeyWithHandle(TableId, kv.IntHandle(0)),
+		EndKey:   tablecodec.EncodeRowKeyWithHandle(TableId, kv.IntHandle(keyNumber)),
+	}
+	dagCtx := newDagContext(store, []kv.KeyRange{fullRange}, dagRequest, DagRequestStartTs)
+	closureExec, err := store.Svr.buildClosureExecutor(dagCtx, dagRequest)
+	require.Nil(t, err)
+
+	var rowCounts []int
+	var ranges []*coprocessor.KeyRange
+	err = closureExec.executeStream(2, func(chunks []tipb.Chunk, counts []int64, ran *coprocessor.KeyRange) error {
+		rowCnt := 0
+		for _, chk := range chunks {
+			row, err := codec.Decode(chk.RowsData, 2)
+			require.Nil(t, err)
+			rowCnt += len(row) / 2
+		}
+		rowCounts = append(rowCounts, rowCnt)
+		ranges = append(ranges, ran)
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, []int{2, 1}, rowCounts)
+	// The ranges of the batches are contiguous so the scan can be resumed from the end of each batch.
+	require.Equal(t, []byte(fullRange.StartKey), ranges[0].Start)
+	require.Equal(t, []byte(kv.Key(data.encodedTestKVDatas[1].encodedRowKey).Next()), ranges[0].End)
+	require.Equal(t, ranges[0].End, ranges[1].Start)
+	require.Equal(t, []byte(fullRange.EndKey), ranges[1].End)
+
+	// A full batch of point gets is resumed from the next range.
+	pointRanges := make([]kv.KeyRange, keyNumber)
+	for i := range pointRanges {
+		pointRanges[i] = getTestPointRange(TableId, int64(i))
+	}
+	dagCtx = newDagContext(store, pointRanges, dagRequest, DagRequestStartTs)
+	closureExec, err = store.Svr.buildClosureExecutor(dagCtx, dagRequest)
+	require.Nil(t, err)
+	rowCounts = rowCounts[:0]
+	err = closureExec.executeStream(2, func(chunks []tipb.Chunk, counts []int64, ran *coprocessor.KeyRange) error {
+		rowCnt := 0
+		for _, chk := range chunks {
+			row, err := codec.Decode(chk.RowsData, 2)
+			require.Nil(t, err)
+			rowCnt += len(row) / 2
+		}
+		rowCounts = append(rowCounts, rowCnt)
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, []int{2, 1}, rowCounts)
+}
+
+func buildColumnRefExpr(colID int64) *tipb.Expr {
+	return &tipb.Expr{
+		Tp:        tipb.ExprType_ColumnRef,
+		Val:       codec.EncodeInt(nil, colID),
+		FieldType: expression.ToPBFieldType(types.NewFieldType(mysql.TypeLonglong)),
+	}
+}
+
 func buildEQIntExpr(colID, val int64) *tipb.Expr {
 	return &tipb.Expr{
 		Tp:        tipb.ExprType_ScalarFunc,
 		Sig:       tipb.ScalarFuncSig_EQInt,
 		FieldType: expression.ToPBFieldType(types.NewFieldType(mysql.TypeLonglong)),
 		Children: []*tipb.Expr{
-			{
-				Tp:        tipb.ExprType_ColumnRef,
-				Val:       codec.EncodeInt(nil, colID),
-				FieldType: expression.ToPBFieldType(types.NewFieldType(mysql.TypeLonglong)),
-			},
+			buildColumnRefExpr(colID),
 			{
 				Tp:        tipb.ExprType_Int64,
 				Val:       codec.EncodeInt(nil, val),
@@ -385,3 +479,122 @@ func buildEQIntExpr(colID, val int64) *tipb.Expr {
 		},
 	}
 }
+
+// executeDAG builds the executor of the dagRequest and returns the first column of the result rows.
+func executeDAG(t *testing.T, store *TestStore, dagRequest *tipb.DAGRequest, ranges []kv.KeyRange) ([]int64, error) {
+	return executeDAGAt(t, store, dagRequest, ranges, DagRequestStartTs)
+}
+
+func executeDAGAt(t *testing.T, store *TestStore, dagRequest *tipb.DAGRequest, ranges []kv.KeyRange,
+	startTS uint64) ([]int64, error) {
+	dagCtx := newDagContext(store, ranges, dagRequest, startTS)
+	exec, err := store.Svr.buildDAGExecutor(dagCtx, dagRequest)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := exec.execute()
+	if err != nil {
+		return nil, err
+	}
+	var vals []int64
+	for _, chk := range chunks {
+		row, err := codec.Decode(chk.RowsData, 1)
+		require.Nil(t, err)
+		for _, d := range row {
+			vals = append(vals, d.GetInt64())
+		}
+	}
+	return vals, nil
+}
+
+func TestPipelineExecutor(t *testing.T) {
+	data := prepareTestTableData(t, keyNumber, TableId)
+	store, err := NewTestStore("cop_handler_test_db", "cop_handler_test_log", nil)
+	defer CleanTestStore(store)
+	require.Nil(t, err)
+	errors := initTestData(store, data.encodedTestKVDatas)
+	require.Nil(t, errors)
+	fullRange := kv.KeyRange{
+		StartKey: tablecodec.EncodeRowKeyWithHandle(TableId, kv.IntHandle(0)),
+		EndKey:   tablecodec.EncodeRowKeyWithHandle(TableId, kv.IntHandle(keyNumber)),
+	}
+
+	// The selection after the limit can not be flattened to a closure.
+	dagRequest := newDagBuilder().
+		setStartTs(DagRequestStartTs).
+		addTableScan(data.colInfos, TableId).
+		addLimit(2).
+		addSelection(buildEQIntExpr(0, 1)).
+		setOutputOffsets([]uint32{0}).
+		build()
+	require.False(t, isClosureSupported(dagRequest.Executors))
+	vals, err := executeDAG(t, store, dagRequest, []kv.KeyRange{fullRange})
+	require.Nil(t, err)
+	require.Equal(t, []int64{1}, vals)
+
+	dagRequest = newDagBuilder().
+		setStartTs(DagRequestStartTs).
+		addTableScan(data.colInfos, TableId).
+		addLimit(1).
+		addSelection(buildEQIntExpr(0, 1)).
+		setOutputOffsets([]uint32{0}).
+		build()
+	vals, err = executeDAG(t, store, dagRequest, []kv.KeyRange{fullRange})
+	require.Nil(t, err)
+	require.Len(t, vals, 0)
+
+	dagRequest = newDagBuilder().
+		setStartTs(DagRequestStartTs).
+		addTableScan(data.colInfos, TableId).
+		addLimit(keyNumber).
+		addTopN(0, true, 2).
+		setOutputOffsets([]uint32{0}).
+		build()
+	vals, err = executeDAG(t, store, dagRequest, []kv.KeyRange{fullRange})
+	require.Nil(t, err)
+	require.Equal(t, []int64{2, 1}, vals)
+
+	dagRequest = newDagBuilder().
+		setStartTs(DagRequestStartTs).
+		addTableScan(data.colInfos, TableId).
+		addLimit(2).
+		addCountAgg(0).
+		setOutputOffsets([]uint32{0}).
+		build()
+	vals, err = executeDAG(t, store, dagRequest, []kv.KeyRange{fullRange})
+	require.Nil(t, err)
+	require.Equal(t, []int64{2}, vals)
+
+	// The output offset must be in the columns of the last executor.
+	dagRequest.OutputOffsets = []uint32{1}
+	_, err = executeDAG(t, store, dagRequest, []kv.KeyRange{fullRange})
+	require.NotNil(t, err)
+
+	// The scan goes on with the next point range when a chunk is full.
+	pointTableID := int64(1)
+	pointData := prepareTestTableData(t, chunkMaxRows+2, pointTableID)
+	require.Nil(t, initTestData(store, pointData.encodedTestKVDatas))
+	pointRanges := make([]kv.KeyRange, chunkMaxRows+2)
+	for i := range pointRanges {
+		pointRanges[i] = getTestPointRange(pointTableID, int64(i))
+	}
+	dagRequest = newDagBuilder().
+		addTableScan(pointData.colInfos, pointTableID).
+		addLimit(chunkMaxRows * 2).
+		addCountAgg(0).
+		setOutputOffsets([]uint32{0}).
+		build()
+	// The rows are committed at increasing ts by initTestData.
+	vals, err = executeDAGAt(t, store, dagRequest, pointRanges, StartTs+2*(chunkMaxRows+2))
+	require.Nil(t, err)
+	require.Equal(t, []int64{chunkMaxRows + 2}, vals)
+}
+
+func TestCheckDAGExecutors(t *testing.T) {
+	scan := &tipb.Executor{Tp: tipb.ExecType_TypeTableScan, TblScan: &tipb.TableScan{}}
+	limit := &tipb.Executor{Tp: tipb.ExecType_TypeLimit, Limit: &tipb.Limit{Limit: 1}}
+	require.Nil(t, checkDAGExecutors([]*tipb.Executor{scan, limit, limit}))
+	require.NotNil(t, checkDAGExecutors(nil))
+	require.NotNil(t, checkDAGExecutors([]*tipb.Executor{limit}))
+	require.NotNil(t, checkDAGExecutors([]*tipb.Executor{scan, {Tp: tipb.ExecType(100)}}))
+}
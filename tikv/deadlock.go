@@ -16,6 +16,7 @@ package tikv
 import (
 	"context"
 	"errors"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -39,26 +40,50 @@ type DetectorServer struct {
 	role     int32
 }
 
+// detectTask is a request to the detector, the key of the entry is only sent to the local detector since the
+// DeadlockRequest has no key.
+type detectTask struct {
+	req *deadlockPb.DeadlockRequest
+	key []byte
+}
+
 func (ds *DetectorServer) Detect(req *deadlockPb.DeadlockRequest) *deadlockPb.DeadlockResponse {
+	resp, _ := ds.detect(req, nil)
+	return resp
+}
+
+// detect handles the request, the wait chain is returned if a deadlock is detected.
+func (ds *DetectorServer) detect(req *deadlockPb.DeadlockRequest,
+	key []byte) (*deadlockPb.DeadlockResponse, []*lockwaiter.WaitChainEntry) {
 	switch req.Tp {
 	case deadlockPb.DeadlockRequestType_Detect:
-		err := ds.Detector.Detect(req.Entry.Txn, req.Entry.WaitForTxn, req.Entry.KeyHash)
+		err := ds.Detector.Detect(req.Entry.Txn, req.Entry.WaitForTxn, req.Entry.KeyHash, key)
 		if err != nil {
+			log.Warn("deadlock detected", zap.Uint64("txn", req.Entry.Txn),
+				zap.String("wait chain", formatWaitChain(err.WaitChain)))
 			resp := convertErrToResp(err, req.Entry.Txn, req.Entry.WaitForTxn, req.Entry.KeyHash)
-			return resp
+			return resp, err.WaitChain
 		}
 	case deadlockPb.DeadlockRequestType_CleanUpWaitFor:
 		ds.Detector.CleanUpWaitFor(req.Entry.Txn, req.Entry.WaitForTxn, req.Entry.KeyHash)
 	case deadlockPb.DeadlockRequestType_CleanUp:
 		ds.Detector.CleanUp(req.Entry.Txn)
 	}
-	return nil
+	return nil, nil
+}
+
+func formatWaitChain(waitChain []*lockwaiter.WaitChainEntry) string {
+	strs := make([]string, len(waitChain))
+	for i, e := range waitChain {
+		strs[i] = e.String()
+	}
+	return strings.Join(strs, ", ")
 }
 
 // DetectorClient is a util used for distributed deadlock detection
 type DetectorClient struct {
 	pdClient     pd.Client
-	sendCh       chan *deadlockPb.DeadlockRequest
+	sendCh       chan detectTask
 	waitMgr      *lockwaiter.Manager
 	streamCli    deadlockPb.Deadlock_DetectClient
 	streamCancel context.CancelFunc
@@ -121,7 +146,7 @@ func (dt *DetectorClient) rebuildStreamClient() error {
 func NewDetectorClient(waiterMgr *lockwaiter.Manager, pdClient pd.Client) *DetectorClient {
 	chSize := 10000
 	newDetector := &DetectorClient{
-		sendCh:   make(chan *deadlockPb.DeadlockRequest, chSize),
+		sendCh:   make(chan detectTask, chSize),
 		waitMgr:  waiterMgr,
 		pdClient: pdClient,
 	}
@@ -134,7 +159,7 @@ func (dt *DetectorClient) sendReqLoop() {
 	var (
 		err        error
 		rebuildErr error
-		req        *deadlockPb.DeadlockRequest
+		task       detectTask
 	)
 	for {
 		if dt.streamCli == nil {
@@ -145,8 +170,8 @@ func (dt *DetectorClient) sendReqLoop() {
 				continue
 			}
 		}
-		req = <-dt.sendCh
-		err = dt.streamCli.Send(req)
+		task = <-dt.sendCh
+		err = dt.streamCli.Send(task.req)
 		if err != nil {
 			log.Warn("send failed, invalid current stream and try to rebuild connection", zap.Error(err))
 			dt.streamCancel()
@@ -168,35 +193,35 @@ func (dt *DetectorClient) recvLoop(streamCli deadlockPb.Deadlock_DetectClient) {
 			break
 		}
 		// here only detection request will get response from leader
-		dt.waitMgr.WakeUpForDeadlock(resp)
+		dt.waitMgr.WakeUpForDeadlock(resp, nil)
 	}
 }
 
 func (dt *DetectorClient) handleRemoteTask(requestType deadlockPb.DeadlockRequestType,
-	txnTs uint64, waitForTxnTs uint64, keyHash uint64) {
+	txnTs uint64, waitForTxnTs uint64, keyHash uint64, key []byte) {
 	detectReq := &deadlockPb.DeadlockRequest{}
 	detectReq.Tp = requestType
 	detectReq.Entry.Txn = txnTs
 	detectReq.Entry.WaitForTxn = waitForTxnTs
 	detectReq.Entry.KeyHash = keyHash
-	dt.sendCh <- detectReq
+	dt.sendCh <- detectTask{req: detectReq, key: key}
 }
 
 // user interfaces
 // Cleanup processes cleaup task on local detector
 func (dt *DetectorClient) CleanUp(startTs uint64) {
-	dt.handleRemoteTask(deadlockPb.DeadlockRequestType_CleanUp, startTs, 0, 0)
+	dt.handleRemoteTask(deadlockPb.DeadlockRequestType_CleanUp, startTs, 0, 0, nil)
 }
 
 // CleanUpWaitFor cleans up the specific wait edge in detector's wait map
 func (dt *DetectorClient) CleanUpWaitFor(txnTs, waitForTxn, keyHash uint64) {
-	dt.handleRemoteTask(deadlockPb.DeadlockRequestType_CleanUpWaitFor, txnTs, waitForTxn, keyHash)
+	dt.handleRemoteTask(deadlockPb.DeadlockRequestType_CleanUpWaitFor, txnTs, waitForTxn, keyHash, nil)
 }
 
 // DetectRemote post the detection request to local deadlock detector or remote first region leader,
 // the caller should use `waiter.ch` to receive possible deadlock response
-func (dt *DetectorClient) Detect(txnTs uint64, waitForTxnTs uint64, keyHash uint64) {
-	dt.handleRemoteTask(deadlockPb.DeadlockRequestType_Detect, txnTs, waitForTxnTs, keyHash)
+func (dt *DetectorClient) Detect(txnTs uint64, waitForTxnTs uint64, keyHash uint64, key []byte) {
+	dt.handleRemoteTask(deadlockPb.DeadlockRequestType_Detect, txnTs, waitForTxnTs, keyHash, key)
 }
 
 // convertErrToResp converts `ErrDeadlock` to `DeadlockResponse` proto type
@@ -30,6 +30,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ngaut/unistore/util/lockwaiter"
+	deadlockPb "github.com/pingcap/kvproto/pkg/deadlock"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 )
@@ -53,6 +55,7 @@ type txnList struct {
 type txnKeyHashPair struct {
 	txn          uint64
 	keyHash      uint64
+	key          []byte
 	registerTime time.Time
 }
 
@@ -74,14 +77,19 @@ func NewDetector(ttl time.Duration, urgentSize uint64, expireInterval time.Durat
 	}
 }
 
-// Detect detects deadlock for the sourceTxn on a locked key.
-func (d *Detector) Detect(sourceTxn, waitForTxn, keyHash uint64) *ErrDeadlock {
+// Detect detects deadlock for the sourceTxn on a locked key, the key is nil if it is unknown. The wait chain
+// of the deadlock starts from the waitForTxn and ends with the edge of the sourceTxn.
+func (d *Detector) Detect(sourceTxn, waitForTxn, keyHash uint64, key []byte) *ErrDeadlock {
 	d.lock.Lock()
 	nowTime := time.Now()
 	d.activeExpire(nowTime)
 	err := d.doDetect(nowTime, sourceTxn, waitForTxn)
 	if err == nil {
-		d.register(sourceTxn, waitForTxn, keyHash)
+		d.register(sourceTxn, waitForTxn, keyHash, key)
+	} else {
+		err.WaitChain = append(err.WaitChain, &lockwaiter.WaitChainEntry{
+			Txn: sourceTxn, WaitForTxn: waitForTxn, KeyHash: keyHash, Key: key,
+		})
 	}
 	d.lock.Unlock()
 	return err
@@ -102,10 +110,14 @@ func (d *Detector) doDetect(nowTime time.Time, sourceTxn, waitForTxn uint64) *Er
 			d.totalSize--
 			continue
 		}
+		edge := &lockwaiter.WaitChainEntry{
+			Txn: waitForTxn, WaitForTxn: keyHashPair.txn, KeyHash: keyHashPair.keyHash, Key: keyHashPair.key,
+		}
 		if keyHashPair.txn == sourceTxn {
-			return &ErrDeadlock{DeadlockKeyHash: keyHashPair.keyHash}
+			return &ErrDeadlock{DeadlockKeyHash: keyHashPair.keyHash, WaitChain: []*lockwaiter.WaitChainEntry{edge}}
 		}
 		if err := d.doDetect(nowTime, sourceTxn, keyHashPair.txn); err != nil {
+			err.WaitChain = append([]*lockwaiter.WaitChainEntry{edge}, err.WaitChain...)
 			return err
 		}
 	}
@@ -115,9 +127,9 @@ func (d *Detector) doDetect(nowTime time.Time, sourceTxn, waitForTxn uint64) *Er
 	return nil
 }
 
-func (d *Detector) register(sourceTxn, waitForTxn, keyHash uint64) {
+func (d *Detector) register(sourceTxn, waitForTxn, keyHash uint64, key []byte) {
 	val := d.waitForMap[sourceTxn]
-	pair := txnKeyHashPair{txn: waitForTxn, keyHash: keyHash, registerTime: time.Now()}
+	pair := txnKeyHashPair{txn: waitForTxn, keyHash: keyHash, key: key, registerTime: time.Now()}
 	if val == nil {
 		newList := &txnList{txns: list.New()}
 		newList.txns.PushBack(&pair)
@@ -168,6 +180,28 @@ func (d *Detector) CleanUpWaitFor(txn, waitForTxn, keyHash uint64) {
 
 }
 
+// GetWaitForEntries returns the unexpired wait for entries.
+func (d *Detector) GetWaitForEntries() []deadlockPb.WaitForEntry {
+	d.lock.Lock()
+	nowTime := time.Now()
+	entries := make([]deadlockPb.WaitForEntry, 0, d.totalSize)
+	for txn, l := range d.waitForMap {
+		for cur := l.txns.Front(); cur != nil; cur = cur.Next() {
+			valuePair := cur.Value.(*txnKeyHashPair)
+			if valuePair.isExpired(d.entryTTL, nowTime) {
+				continue
+			}
+			entries = append(entries, deadlockPb.WaitForEntry{
+				Txn:        txn,
+				WaitForTxn: valuePair.txn,
+				KeyHash:    valuePair.keyHash,
+			})
+		}
+	}
+	d.lock.Unlock()
+	return entries
+}
+
 // activeExpire removes expired entries, should be called under d.lock protection
 func (d *Detector) activeExpire(nowTime time.Time) {
 	if nowTime.Sub(d.lastActiveExpire) > d.expireInterval &&
@@ -27,10 +27,13 @@ package tikv
 
 import (
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/ngaut/unistore/util/lockwaiter"
 	. "github.com/pingcap/check"
+	deadlockPb "github.com/pingcap/kvproto/pkg/deadlock"
 )
 
 func TestT(t *testing.T) {
@@ -46,13 +49,13 @@ func (s *testDeadlockSuite) TestDeadlock(c *C) {
 	expireInterval := time.Duration(100 * time.Millisecond)
 	urgentSize := uint64(1)
 	detector := NewDetector(ttl, urgentSize, expireInterval)
-	err := detector.Detect(1, 2, 100)
+	err := detector.Detect(1, 2, 100, nil)
 	c.Assert(err, IsNil)
 	c.Assert(detector.totalSize, Equals, uint64(1))
-	err = detector.Detect(2, 3, 200)
+	err = detector.Detect(2, 3, 200, nil)
 	c.Assert(err, IsNil)
 	c.Assert(detector.totalSize, Equals, uint64(2))
-	err = detector.Detect(3, 1, 300)
+	err = detector.Detect(3, 1, 300, nil)
 	c.Assert(err, NotNil)
 	c.Assert(err.Error(), Equals, fmt.Sprintf("deadlock"))
 	c.Assert(detector.totalSize, Equals, uint64(2))
@@ -62,20 +65,20 @@ func (s *testDeadlockSuite) TestDeadlock(c *C) {
 	c.Assert(detector.totalSize, Equals, uint64(1))
 
 	// After cycle is broken, no deadlock now.
-	err = detector.Detect(3, 1, 300)
+	err = detector.Detect(3, 1, 300, nil)
 	c.Assert(err, IsNil)
 	list3 := detector.waitForMap[3]
 	c.Assert(list3.txns.Len(), Equals, 1)
 	c.Assert(detector.totalSize, Equals, uint64(2))
 
 	// Different keyHash grows the list.
-	err = detector.Detect(3, 1, 400)
+	err = detector.Detect(3, 1, 400, nil)
 	c.Assert(err, IsNil)
 	c.Assert(list3.txns.Len(), Equals, 2)
 	c.Assert(detector.totalSize, Equals, uint64(3))
 
 	// Same waitFor and key hash doesn't grow the list.
-	err = detector.Detect(3, 1, 400)
+	err = detector.Detect(3, 1, 400, nil)
 	c.Assert(err, IsNil)
 	c.Assert(list3.txns.Len(), Equals, 2)
 	c.Assert(detector.totalSize, Equals, uint64(3))
@@ -90,7 +93,7 @@ func (s *testDeadlockSuite) TestDeadlock(c *C) {
 
 	// after 100ms, all entries expired, detect non exist edges
 	time.Sleep(100 * time.Millisecond)
-	err = detector.Detect(100, 200, 100)
+	err = detector.Detect(100, 200, 100, nil)
 	c.Assert(err, IsNil)
 	c.Assert(detector.totalSize, Equals, uint64(1))
 	c.Assert(len(detector.waitForMap), Equals, 1)
@@ -98,8 +101,31 @@ func (s *testDeadlockSuite) TestDeadlock(c *C) {
 	// expired entry should not report deadlock, detect will remove this entry
 	// not dependent on expire check interval
 	time.Sleep(60 * time.Millisecond)
-	err = detector.Detect(200, 100, 200)
+	err = detector.Detect(200, 100, 200, nil)
 	c.Assert(err, IsNil)
 	c.Assert(detector.totalSize, Equals, uint64(1))
 	c.Assert(len(detector.waitForMap), Equals, 1)
 }
+
+func (s *testDeadlockSuite) TestWaitChain(c *C) {
+	detector := NewDetector(time.Minute, 100, time.Minute)
+	c.Assert(detector.Detect(1, 2, 100, []byte("k1")), IsNil)
+	c.Assert(detector.Detect(2, 3, 200, []byte("k2")), IsNil)
+	c.Assert(detector.Detect(2, 4, 300, nil), IsNil)
+	entries := detector.GetWaitForEntries()
+	c.Assert(entries, HasLen, 3)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].KeyHash < entries[j].KeyHash })
+	c.Assert(entries[0], DeepEquals, deadlockPb.WaitForEntry{Txn: 1, WaitForTxn: 2, KeyHash: 100})
+	c.Assert(entries[1], DeepEquals, deadlockPb.WaitForEntry{Txn: 2, WaitForTxn: 3, KeyHash: 200})
+	c.Assert(entries[2], DeepEquals, deadlockPb.WaitForEntry{Txn: 2, WaitForTxn: 4, KeyHash: 300})
+
+	err := detector.Detect(3, 1, 400, []byte("k4"))
+	c.Assert(err, NotNil)
+	c.Assert(err.DeadlockKeyHash, Equals, uint64(200))
+	c.Assert(err.WaitChain, DeepEquals, []*lockwaiter.WaitChainEntry{
+		{Txn: 1, WaitForTxn: 2, KeyHash: 100, Key: []byte("k1")},
+		{Txn: 2, WaitForTxn: 3, KeyHash: 200, Key: []byte("k2")},
+		{Txn: 3, WaitForTxn: 1, KeyHash: 400, Key: []byte("k4")},
+	})
+	c.Assert(detector.GetWaitForEntries(), HasLen, 3)
+}
@@ -16,6 +16,7 @@ package tikv
 import (
 	"fmt"
 
+	"github.com/ngaut/unistore/util/lockwaiter"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 )
 
@@ -88,6 +89,9 @@ type ErrDeadlock struct {
 	LockKey         []byte
 	LockTS          uint64
 	DeadlockKeyHash uint64
+	// WaitChain is the cycle of the deadlock, it is not sent to the client since the Deadlock error of the
+	// protocol has no wait chain.
+	WaitChain []*lockwaiter.WaitChainEntry
 }
 
 func (e ErrDeadlock) Error() string {
@@ -0,0 +1,167 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/coocood/badger"
+	"github.com/ngaut/unistore/config"
+	"github.com/ngaut/unistore/metrics"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	flowControlInterval = 100 * time.Millisecond
+	// flowControlMaxBackoff is the backoff hint of a write rejected at the hard limits.
+	flowControlMaxBackoff = time.Second
+	// levelSizeMultiplier is the LevelSizeMultiplier of badger.DefaultOptions, which is not configurable.
+	levelSizeMultiplier = 10
+)
+
+// engineStats is the pressure of the engine. The memtables waiting for flush are not counted because badger doesn't
+// expose them, a backlog of them shows up as the L0 tables only once flushed.
+type engineStats struct {
+	l0Tables int
+	// pendingCompactionBytes is not reported by badger, it's estimated by the number of the tables in each level
+	// assuming every table is full. The L0 tables are counted once the L0 compaction is triggered, and the tables
+	// of a lower level are counted beyond its target size.
+	pendingCompactionBytes int64
+}
+
+func estimateEngineStats(tables []badger.TableInfo, conf *config.Engine) engineStats {
+	var levelTables []int
+	for _, t := range tables {
+		for len(levelTables) <= t.Level {
+			levelTables = append(levelTables, 0)
+		}
+		levelTables[t.Level]++
+	}
+	var stats engineStats
+	targetSize := conf.L1Size
+	for level, n := range levelTables {
+		if level == 0 {
+			stats.l0Tables = n
+			if n >= conf.NumL0Tables {
+				stats.pendingCompactionBytes += int64(n) * conf.MaxMemTableSize
+			}
+			continue
+		}
+		if size := int64(n) * conf.MaxTableSize; size > targetSize {
+			stats.pendingCompactionBytes += size - targetSize
+		}
+		targetSize *= levelSizeMultiplier
+	}
+	return stats
+}
+
+// flowController throttles the writes when the compaction of the engine falls behind. Once a soft limit is
+// reached, a write is rejected with ServerIsBusy by the probability of the throttle ratio, which grows to 1
+// at the hard limits, so the writes back off before badger stalls the whole write path.
+//
+// Only the client writes are throttled before they are proposed. The applier is not, the committed raft logs
+// must be applied on every peer, and the writes of the followers are throttled by their leaders.
+type flowController struct {
+	db       *badger.DB
+	conf     config.FlowControl
+	engine   *config.Engine
+	interval time.Duration
+	closeCh  chan struct{}
+
+	// ratio is the bits of the throttle ratio in [0, 1].
+	ratio uint64
+}
+
+func newFlowController(db *badger.DB, conf *config.Config) *flowController {
+	return &flowController{
+		db:       db,
+		conf:     conf.FlowControl,
+		engine:   &conf.Engine,
+		interval: flowControlInterval,
+		closeCh:  make(chan struct{}),
+	}
+}
+
+func (fc *flowController) run() {
+	ticker := time.NewTicker(fc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fc.closeCh:
+			return
+		case <-ticker.C:
+			fc.update(estimateEngineStats(fc.db.Tables(), fc.engine))
+		}
+	}
+}
+
+func (fc *flowController) update(stats engineStats) {
+	metrics.EngineL0Tables.Set(float64(stats.l0Tables))
+	metrics.EnginePendingCompactionBytes.Set(float64(stats.pendingCompactionBytes))
+	ratio := fc.throttleRatio(stats)
+	metrics.FlowControlThrottleRatio.Set(ratio)
+	oldRatio := fc.loadRatio()
+	atomic.StoreUint64(&fc.ratio, math.Float64bits(ratio))
+	if oldRatio == 0 && ratio > 0 {
+		log.Warn("start throttling writes", zap.Int("L0 tables", stats.l0Tables),
+			zap.Int64("pending compaction bytes", stats.pendingCompactionBytes), zap.Float64("ratio", ratio))
+	} else if oldRatio > 0 && ratio == 0 {
+		log.Info("stop throttling writes")
+	}
+}
+
+func (fc *flowController) throttleRatio(stats engineStats) float64 {
+	var ratio float64
+	if soft := fc.conf.SoftL0Tables; stats.l0Tables >= soft {
+		// All the writes are rejected at NumL0TablesStall - 1 L0 tables.
+		ratio = float64(stats.l0Tables-soft+1) / math.Max(float64(fc.engine.NumL0TablesStall-soft), 1)
+	}
+	if soft := fc.conf.SoftPendingCompactionBytes; stats.pendingCompactionBytes >= soft {
+		hard := fc.conf.HardPendingCompactionBytes
+		ratio = math.Max(ratio, float64(stats.pendingCompactionBytes-soft+1)/math.Max(float64(hard-soft), 1))
+	}
+	return math.Min(ratio, 1)
+}
+
+func (fc *flowController) loadRatio() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&fc.ratio))
+}
+
+// throttle returns a ServerIsBusy error with a backoff hint if the write of the method is throttled. A critical
+// write, e.g. a commit releasing the locks of a prewritten transaction, is rejected at the hard limits only.
+func (fc *flowController) throttle(method string, critical bool) *errorpb.Error {
+	ratio := fc.loadRatio()
+	if ratio == 0 || ratio < 1 && (critical || rand.Float64() >= ratio) {
+		return nil
+	}
+	metrics.FlowControlThrottledWrites.WithLabelValues(method).Inc()
+	backoff := time.Duration(ratio * float64(flowControlMaxBackoff))
+	if backoff < fc.interval {
+		backoff = fc.interval
+	}
+	reason := "engine compaction falls behind"
+	return &errorpb.Error{
+		Message:      reason,
+		ServerIsBusy: &errorpb.ServerIsBusy{Reason: reason, BackoffMs: uint64(backoff / time.Millisecond)},
+	}
+}
+
+func (fc *flowController) close() {
+	close(fc.closeCh)
+}
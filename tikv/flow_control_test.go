@@ -0,0 +1,133 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	"github.com/coocood/badger"
+	"github.com/ngaut/unistore/config"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+var _ = Suite(&testFlowControlSuite{})
+
+type testFlowControlSuite struct{}
+
+func (s *testFlowControlSuite) TestEstimateEngineStats(c *C) {
+	conf := config.DefaultConf.Engine
+	conf.MaxMemTableSize = 10
+	conf.MaxTableSize = 10
+	conf.L1Size = 100
+	var tables []badger.TableInfo
+	addTables := func(level, n int) {
+		for i := 0; i < n; i++ {
+			tables = append(tables, badger.TableInfo{Level: level})
+		}
+	}
+	addTables(0, conf.NumL0Tables-1)
+	addTables(1, 10)
+	addTables(2, 100)
+	c.Assert(estimateEngineStats(tables, &conf), Equals, engineStats{l0Tables: conf.NumL0Tables - 1})
+
+	// The L0 compaction is triggered, and the levels go beyond their target sizes.
+	addTables(0, 1)
+	addTables(1, 2)
+	addTables(2, 5)
+	stats := estimateEngineStats(tables, &conf)
+	c.Assert(stats.l0Tables, Equals, conf.NumL0Tables)
+	c.Assert(stats.pendingCompactionBytes, Equals, int64(conf.NumL0Tables*10+20+50))
+}
+
+func (s *testFlowControlSuite) TestThrottle(c *C) {
+	conf := config.DefaultConf
+	conf.FlowControl.SoftPendingCompactionBytes = 100
+	conf.FlowControl.HardPendingCompactionBytes = 200
+	fc := newFlowController(nil, &conf)
+	soft := conf.FlowControl.SoftL0Tables
+	c.Assert(fc.throttleRatio(engineStats{l0Tables: soft - 1, pendingCompactionBytes: 99}), Equals, 0.0)
+	c.Assert(fc.throttleRatio(engineStats{l0Tables: soft}), Equals, 0.5)
+	c.Assert(fc.throttleRatio(engineStats{l0Tables: conf.Engine.NumL0TablesStall - 1}), Equals, 1.0)
+	c.Assert(fc.throttleRatio(engineStats{pendingCompactionBytes: 149}), Equals, 0.5)
+	c.Assert(fc.throttleRatio(engineStats{pendingCompactionBytes: 300}), Equals, 1.0)
+
+	fc.update(engineStats{l0Tables: soft - 1})
+	c.Assert(fc.throttle("KvPrewrite", false), IsNil)
+
+	// The critical writes are rejected at the hard limits only.
+	fc.update(engineStats{l0Tables: soft})
+	c.Assert(fc.throttle("KvCommit", true), IsNil)
+	var rejected int
+	for i := 0; i < 1000; i++ {
+		if regErr := fc.throttle("KvPrewrite", false); regErr != nil {
+			c.Assert(regErr.GetServerIsBusy().GetBackoffMs(), Equals, uint64(500))
+			rejected++
+		}
+	}
+	c.Assert(rejected > 0 && rejected < 1000, IsTrue)
+
+	fc.update(engineStats{l0Tables: conf.Engine.NumL0TablesStall - 1})
+	regErr := fc.throttle("KvCommit", true)
+	c.Assert(regErr.GetServerIsBusy().GetBackoffMs(), Equals, uint64(1000))
+}
+
+func (s *testFlowControlSuite) TestThrottledRequests(c *C) {
+	store, err := NewTestStore("TestThrottledRequests", "TestThrottledRequests", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+	bundle := &mvcc.DBBundle{DB: store.MvccStore.db, LockStore: store.MvccStore.lockStore}
+	rm, err := NewMockRegionManager(bundle, 1, RegionOptions{})
+	c.Assert(err, IsNil)
+	region := &metapb.Region{Id: 1, RegionEpoch: &metapb.RegionEpoch{}, Peers: []*metapb.Peer{{Id: 2, StoreId: 1}}}
+	c.Assert(rm.Bootstrap([]*metapb.Store{{Id: 1}}, region), IsNil)
+	svr := store.Svr
+	svr.regionManager = rm
+	svr.flowControl = newFlowController(nil, &config.DefaultConf)
+	svr.flowControl.update(engineStats{l0Tables: config.DefaultConf.Engine.NumL0TablesStall})
+
+	ctx := context.Background()
+	rpcCtx := &kvrpcpb.Context{RegionId: 1, RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peer: &metapb.Peer{Id: 2, StoreId: 1}}
+	lockResp, err := svr.KvPessimisticLock(ctx, &kvrpcpb.PessimisticLockRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(lockResp.RegionError.GetServerIsBusy(), NotNil)
+	importResp, err := svr.KvImport(ctx, &kvrpcpb.ImportRequest{
+		Mutations:     []*kvrpcpb.Mutation{{Op: kvrpcpb.Op_Put, Key: []byte("ta"), Value: []byte("va")}},
+		CommitVersion: 10,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(importResp.RegionError.GetServerIsBusy(), NotNil)
+	rawDeleteRangeResp, err := svr.RawDeleteRange(ctx, &kvrpcpb.RawDeleteRangeRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(rawDeleteRangeResp.RegionError.GetServerIsBusy(), NotNil)
+	deleteRangeResp, err := svr.KvDeleteRange(ctx, &kvrpcpb.DeleteRangeRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(deleteRangeResp.RegionError.GetServerIsBusy(), NotNil)
+	verDeleteRangeResp, err := svr.VerDeleteRange(ctx, &kvrpcpb.VerDeleteRangeRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(verDeleteRangeResp.RegionError.GetServerIsBusy(), NotNil)
+	verMutResp, err := svr.VerMut(ctx, &kvrpcpb.VerMutRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(verMutResp.RegionError.GetServerIsBusy(), NotNil)
+	verBatchMutResp, err := svr.VerBatchMut(ctx, &kvrpcpb.VerBatchMutRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(verBatchMutResp.RegionError.GetServerIsBusy(), NotNil)
+	ingestResp, err := svr.Ingest(ctx, &import_sstpb.IngestRequest{Context: rpcCtx})
+	c.Assert(err, IsNil)
+	c.Assert(ingestResp.Error.GetServerIsBusy(), NotNil)
+}
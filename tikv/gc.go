@@ -0,0 +1,296 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"math"
+	"time"
+
+	"github.com/coocood/badger"
+	"github.com/ngaut/unistore/metrics"
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	gcBatchSize    = 256
+	gcTaskChanSize = 16
+)
+
+// GCStats is the progress of a GC task.
+type GCStats struct {
+	ScannedKeys     int
+	DeletedVersions int
+	// ObsoleteVersions is the number of versions below a newer version before the safe point, they are
+	// dropped by the compaction.
+	ObsoleteVersions int
+	DeletedRollbacks int
+	DeletedOpLocks   int
+}
+
+type gcTask struct {
+	reqCtx    *requestCtx
+	safePoint uint64
+	stats     GCStats
+	err       error
+	done      chan struct{}
+}
+
+// GC deletes the obsolete data in the region of the request before the safe point.
+// The tasks are executed one by one by the GC worker, so GC doesn't take too much resource from
+// the foreground requests. The safe point of the store is updated first, so the compaction drops the
+// obsolete versions that are not deleted by the task.
+func (store *MVCCStore) GC(reqCtx *requestCtx, safePoint uint64) (GCStats, error) {
+	store.UpdateSafePoint(safePoint)
+	task := &gcTask{
+		reqCtx:    reqCtx,
+		safePoint: safePoint,
+		done:      make(chan struct{}),
+	}
+	select {
+	case store.gcTaskCh <- task:
+	default:
+		return GCStats{}, ErrRetryable("gc worker is busy")
+	}
+	select {
+	case <-task.done:
+		return task.stats, task.err
+	case <-store.closeCh:
+		return GCStats{}, ErrRetryable("server is closed")
+	}
+}
+
+func (store *MVCCStore) runGCWorker() {
+	for {
+		select {
+		case task := <-store.gcTaskCh:
+			task.stats, task.err = store.gcRegion(task.reqCtx, task.safePoint)
+			close(task.done)
+		case <-store.closeCh:
+			return
+		}
+	}
+}
+
+func (store *MVCCStore) gcRegion(reqCtx *requestCtx, safePoint uint64) (GCStats, error) {
+	start := time.Now()
+	gc := &gcContext{
+		store:     store,
+		reqCtx:    reqCtx,
+		safePoint: safePoint,
+	}
+	txn := store.db.NewTransaction(false)
+	defer txn.Discard()
+	txn.SetReadTS(math.MaxUint64)
+
+	// Only the keys with meta or table prefix have versions, the extra keys of them are stored with the prefix + 1.
+	lower, upper := reqCtx.regCtx.startKey, reqCtx.regCtx.endKey
+	if bytes.Compare(lower, []byte{metaPrefix}) < 0 {
+		lower = []byte{metaPrefix}
+	}
+	if len(upper) == 0 || bytes.Compare(upper, []byte{tableExtraPrefix}) > 0 {
+		upper = []byte{tableExtraPrefix}
+	}
+	if bytes.Compare(lower, upper) < 0 {
+		if err := gc.gcKeys(txn, lower, upper); err != nil {
+			return gc.stats, err
+		}
+		extraLower, extraUpper := safeCopy(lower), safeCopy(upper)
+		extraLower[0]++
+		extraUpper[0]++
+		if err := gc.gcExtraKeys(txn, extraLower, extraUpper); err != nil {
+			return gc.stats, err
+		}
+		if err := gc.flush(); err != nil {
+			return gc.stats, err
+		}
+	}
+	metrics.GCDuration.Observe(time.Since(start).Seconds())
+	log.Info("gc region done", zap.Uint64("region", reqCtx.rpcCtx.RegionId), zap.Uint64("safePoint", safePoint),
+		zap.Int("scanned", gc.stats.ScannedKeys), zap.Int("versions", gc.stats.DeletedVersions),
+		zap.Int("obsolete", gc.stats.ObsoleteVersions),
+		zap.Int("rollbacks", gc.stats.DeletedRollbacks), zap.Int("opLocks", gc.stats.DeletedOpLocks),
+		zap.Duration("takes", time.Since(start)))
+	return gc.stats, nil
+}
+
+type gcContext struct {
+	store     *MVCCStore
+	reqCtx    *requestCtx
+	safePoint uint64
+	keys      []gcKey
+	stats     GCStats
+}
+
+// gcKey is a key to delete at version, the version must still be the latest version of the key when the
+// delete is written.
+type gcKey struct {
+	key     []byte
+	version uint64
+}
+
+// gcKeys deletes the keys whose latest version is a delete before the safe point, the older versions are
+// hidden by the delete.
+// Badger serves a read by the newest write of a key, so a delete written at an old version would shadow
+// the newer versions. The versions below the latest version before the safe point are obsolete, they are
+// dropped by the compaction once the safe ts of the DB is updated, so they are only counted here.
+func (gc *gcContext) gcKeys(txn *badger.Txn, lower, upper []byte) error {
+	it := dbreader.NewIterator(txn, false, lower, upper)
+	defer it.Close()
+	it.SetAllVersions(true)
+	var curKey []byte
+	// obsolete is set after the latest version before the safe point is visited, hidden is set if the
+	// latest version of the key is a delete before the safe point.
+	var obsolete, hidden, done bool
+	for it.Seek(lower); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if exceedEndKey(key, upper) {
+			break
+		}
+		if key[0] != metaPrefix && key[0] != tablePrefix {
+			continue
+		}
+		first := !bytes.Equal(key, curKey)
+		if first {
+			curKey = append(curKey[:0], key...)
+			obsolete, hidden, done = false, false, false
+			gc.stats.ScannedKeys++
+			metrics.GCKeys.WithLabelValues("scanned").Inc()
+		}
+		if done {
+			continue
+		}
+		if item.IsDeleted() {
+			// The older versions are already deleted.
+			done = true
+			continue
+		}
+		if hidden {
+			gc.stats.DeletedVersions++
+			metrics.GCKeys.WithLabelValues("version").Inc()
+			continue
+		}
+		if obsolete {
+			gc.stats.ObsoleteVersions++
+			metrics.GCKeys.WithLabelValues("obsolete").Inc()
+			continue
+		}
+		if item.Version() > gc.safePoint {
+			continue
+		}
+		obsolete = true
+		if !item.IsEmpty() {
+			continue
+		}
+		if !first {
+			// The delete is turned into a tombstone by the compaction filter.
+			gc.stats.ObsoleteVersions++
+			metrics.GCKeys.WithLabelValues("obsolete").Inc()
+			continue
+		}
+		hidden = true
+		gc.stats.DeletedVersions++
+		metrics.GCKeys.WithLabelValues("version").Inc()
+		if err := gc.deleteVersion(key, item.Version()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcExtraKeys deletes the rollback and op lock records before the safe point.
+func (gc *gcContext) gcExtraKeys(txn *badger.Txn, lower, upper []byte) error {
+	it := dbreader.NewIterator(txn, false, lower, upper)
+	defer it.Close()
+	for it.Seek(lower); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if exceedEndKey(key, upper) {
+			break
+		}
+		if key[0] != metaExtraPrefix && key[0] != tableExtraPrefix {
+			continue
+		}
+		gc.stats.ScannedKeys++
+		metrics.GCKeys.WithLabelValues("scanned").Inc()
+		if item.Version() > gc.safePoint {
+			continue
+		}
+		if mvcc.DBUserMeta(item.UserMeta()).CommitTS() == 0 {
+			gc.stats.DeletedRollbacks++
+			metrics.GCKeys.WithLabelValues("rollback").Inc()
+		} else {
+			gc.stats.DeletedOpLocks++
+			metrics.GCKeys.WithLabelValues("op_lock").Inc()
+		}
+		if err := gc.deleteVersion(key, item.Version()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gc *gcContext) deleteVersion(key []byte, version uint64) error {
+	gc.keys = append(gc.keys, gcKey{key: safeCopy(key), version: version})
+	if len(gc.keys) >= gcBatchSize {
+		return gc.flush()
+	}
+	return nil
+}
+
+// flush writes the deletes of the collected keys. The latches are held to make sure no new version is
+// written to the keys concurrently, a key is skipped if it has been changed after scan.
+func (gc *gcContext) flush() error {
+	if len(gc.keys) == 0 {
+		return nil
+	}
+	keys := make([][]byte, len(gc.keys))
+	for i, k := range gc.keys {
+		keys[i] = k.key
+	}
+	hashVals := keysToHashVals(keys...)
+	regCtx := gc.reqCtx.regCtx
+	regCtx.AcquireLatches(hashVals)
+	defer regCtx.ReleaseLatches(hashVals)
+
+	txn := gc.store.db.NewTransaction(false)
+	defer txn.Discard()
+	txn.SetReadTS(math.MaxUint64)
+	it := dbreader.NewIterator(txn, false, nil, nil)
+	defer it.Close()
+	it.SetAllVersions(true)
+	batch := gc.store.dbWriter.NewWriteBatch(0, 0, gc.reqCtx.rpcCtx)
+	var cnt int
+	for _, k := range gc.keys {
+		it.Seek(k.key)
+		if !it.Valid() {
+			continue
+		}
+		item := it.Item()
+		if !bytes.Equal(item.Key(), k.key) || item.IsDeleted() || item.Version() != k.version {
+			continue
+		}
+		batch.DeleteVersion(k.key, k.version)
+		cnt++
+	}
+	gc.keys = gc.keys[:0]
+	if cnt == 0 {
+		return nil
+	}
+	return gc.store.dbWriter.Write(batch)
+}
@@ -0,0 +1,135 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/coocood/badger"
+	"github.com/coocood/badger/y"
+	"github.com/juju/errors"
+	"github.com/ngaut/unistore/tikv/raftstore"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// Bulk load writes committed versions without locks, the imported keys are not checked against the locks
+// and the existing versions, so the range should not be accessed by transactions while it is being imported.
+
+var (
+	errZeroCommitTS   = errors.New("commit ts must be greater than 0")
+	errUnsortedImport = errors.New("import keys must be sorted and unique")
+)
+
+func checkImportMutations(muts []*kvrpcpb.Mutation, commitTS uint64) error {
+	if commitTS == 0 {
+		return errZeroCommitTS
+	}
+	for i, mut := range muts {
+		if mut.Op != kvrpcpb.Op_Put && mut.Op != kvrpcpb.Op_Del {
+			return errors.Errorf("unsupported import op %s", mut.Op)
+		}
+		if i > 0 && bytes.Compare(muts[i-1].Key, mut.Key) >= 0 {
+			return errUnsortedImport
+		}
+	}
+	return nil
+}
+
+// Import writes the mutations as committed versions at commitTS, the keys must be sorted and in the region
+// of the request.
+func (store *MVCCStore) Import(reqCtx *requestCtx, muts []*kvrpcpb.Mutation, commitTS uint64) error {
+	if err := checkImportMutations(muts, commitTS); err != nil {
+		return err
+	}
+	if len(muts) == 0 {
+		return nil
+	}
+	regCtx := reqCtx.regCtx
+	keys := make([][]byte, len(muts))
+	for i, mut := range muts {
+		keys[i] = mut.Key
+	}
+	if err := checkKeysInRegion(reqCtx, keys...); err != nil {
+		return err
+	}
+	hashVals := keysToHashVals(keys...)
+	regCtx.AcquireLatches(hashVals)
+	defer regCtx.ReleaseLatches(hashVals)
+
+	batch := store.dbWriter.NewWriteBatch(commitTS, commitTS, reqCtx.rpcCtx)
+	for _, mut := range muts {
+		if mut.Op == kvrpcpb.Op_Del {
+			batch.ImportDelete(mut.Key, commitTS)
+		} else {
+			batch.ImportPut(mut.Key, mut.Value, commitTS)
+		}
+	}
+	return store.dbWriter.Write(batch)
+}
+
+// IngestSST ingests the uploaded SST file of the meta into the region of the request, the file is deleted
+// after it is ingested.
+func (store *MVCCStore) IngestSST(reqCtx *requestCtx, meta *import_sstpb.SSTMeta) error {
+	regCtx := reqCtx.regCtx
+	if meta.GetRegionId() != regCtx.meta.Id {
+		return errors.Errorf("sst of region %d can not be ingested to region %d", meta.GetRegionId(), regCtx.meta.Id)
+	}
+	regionEpoch := regCtx.getRegionEpoch()
+	if epoch := meta.GetRegionEpoch(); epoch != nil &&
+		(epoch.ConfVer != regionEpoch.ConfVer || epoch.Version != regionEpoch.Version) {
+		return errors.Errorf("sst epoch %s does not match region epoch %s", epoch, regionEpoch)
+	}
+	if err := raftstore.CheckImportSSTRange(meta, regCtx.startKey, regCtx.endKey); err != nil {
+		return err
+	}
+	path, err := store.importer.Path(meta)
+	if err != nil {
+		return err
+	}
+	// Check all the keys before the file is ingested, so a bad file is not ingested partially.
+	var txn *badger.Txn
+	if meta.GetCfName() == raftstore.ReplayCF {
+		txn = store.db.NewTransaction(false)
+		defer txn.Discard()
+		txn.SetReadTS(math.MaxUint64)
+	}
+	err = raftstore.IterateImportSST(path, meta, func(key y.Key, value, userMeta []byte) error {
+		if txn == nil {
+			return nil
+		}
+		// The layers of an incremental backup are restored in the order of the manifest, a read would find a
+		// replayed version before the newer versions of the key ingested out of order.
+		item, err := txn.Get(key.UserKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if item.Version() >= key.Version {
+			return errors.Errorf("replay version %d of key %q is not newer than the version %d in the store, "+
+				"the files of an incremental backup must be restored in order", key.Version, key.UserKey, item.Version())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = store.dbWriter.IngestSST(reqCtx.rpcCtx, meta, path, regCtx); err != nil {
+		return err
+	}
+	return store.importer.Delete(meta)
+}
@@ -0,0 +1,158 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ngaut/unistore/rocksdb"
+	"github.com/ngaut/unistore/tikv/raftstore"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+type importSSTEntry struct {
+	key      string
+	value    string
+	commitTS uint64
+}
+
+// MustUploadSST writes the entries into an SST file and uploads it, an entry with an empty value is a delete.
+func MustUploadSST(uuid string, entries []importSSTEntry, store *TestStore) *import_sstpb.SSTMeta {
+	f, err := ioutil.TempFile(store.DBPath, "sst")
+	store.c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+	w := rocksdb.NewSstFileWriter(f, rocksdb.NewDefaultBlockBasedTableOptions(bytes.Compare))
+	for _, e := range entries {
+		key := raftstore.EncodeImportSSTKey([]byte(e.key), e.commitTS)
+		if len(e.value) == 0 {
+			err = w.Delete(key)
+		} else {
+			err = w.Put(key, []byte(e.value))
+		}
+		store.c.Assert(err, IsNil)
+	}
+	store.c.Assert(w.Finish(), IsNil)
+	store.c.Assert(w.Close(), IsNil)
+	data, err := ioutil.ReadFile(f.Name())
+	store.c.Assert(err, IsNil)
+
+	meta := &import_sstpb.SSTMeta{
+		Uuid:     []byte(uuid),
+		Range:    &import_sstpb.Range{Start: []byte(entries[0].key), End: []byte(entries[len(entries)-1].key)},
+		Crc32:    crc32.ChecksumIEEE(data),
+		Length:   uint64(len(data)),
+		RegionId: 1,
+	}
+	file, err := store.MvccStore.importer.Create(meta)
+	store.c.Assert(err, IsNil)
+	defer file.Cleanup()
+	store.c.Assert(file.Append(data[:len(data)/2]), IsNil)
+	store.c.Assert(file.Append(data[len(data)/2:]), IsNil)
+	store.c.Assert(file.Finish(), IsNil)
+	return meta
+}
+
+func (store *TestStore) newImportReqCtx() *requestCtx {
+	reqCtx := store.newReqCtx()
+	reqCtx.regCtx.meta = &metapb.Region{Id: 1}
+	return reqCtx
+}
+
+func (s *testMvccSuite) TestImport(c *C) {
+	store, err := NewTestStore("TestImport", "TestImport", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	MustPrewritePut([]byte("tb"), []byte("tb"), []byte("v5"), 5, store)
+	MustCommit([]byte("tb"), 5, 6, store)
+
+	reqCtx := store.newReqCtx()
+	muts := []*kvrpcpb.Mutation{
+		newMutation(kvrpcpb.Op_Put, []byte("ta"), []byte("va10")),
+		newMutation(kvrpcpb.Op_Del, []byte("tb"), nil),
+	}
+	c.Assert(store.MvccStore.Import(reqCtx, muts, 10), IsNil)
+	// The keys must be sorted and in the region.
+	c.Assert(store.MvccStore.Import(reqCtx, []*kvrpcpb.Mutation{muts[1], muts[0]}, 11), NotNil)
+	c.Assert(store.MvccStore.Import(reqCtx, []*kvrpcpb.Mutation{
+		newMutation(kvrpcpb.Op_Put, []byte("x"), []byte("v")),
+	}, 11), NotNil)
+	c.Assert(store.MvccStore.Import(reqCtx, muts, 0), NotNil)
+	reqCtx.finish()
+
+	MustGetNone([]byte("ta"), 9, store)
+	MustGetVal([]byte("ta"), []byte("va10"), 10, store)
+	MustGetVal([]byte("tb"), []byte("v5"), 9, store)
+	MustGetNone([]byte("tb"), 10, store)
+}
+
+func (s *testMvccSuite) TestIngestSST(c *C) {
+	store, err := NewTestStore("TestIngestSST", "TestIngestSST", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	MustPrewritePut([]byte("tc"), []byte("tc"), []byte("v5"), 5, store)
+	MustCommit([]byte("tc"), 5, 6, store)
+
+	meta := MustUploadSST("sst1", []importSSTEntry{
+		{key: "ta", value: "va10", commitTS: 10},
+		{key: "tb", value: "vb20", commitTS: 20},
+		{key: "tc", commitTS: 10},
+	}, store)
+	// A file can not be uploaded twice.
+	_, err = store.MvccStore.importer.Create(meta)
+	c.Assert(err, NotNil)
+
+	reqCtx := store.newImportReqCtx()
+	c.Assert(store.MvccStore.IngestSST(reqCtx, meta), IsNil)
+	reqCtx.finish()
+	path, err := store.MvccStore.importer.Path(meta)
+	c.Assert(err, IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), IsTrue)
+
+	MustGetVal([]byte("ta"), []byte("va10"), 10, store)
+	MustGetNone([]byte("tb"), 19, store)
+	MustGetVal([]byte("tb"), []byte("vb20"), 20, store)
+	MustGetVal([]byte("tc"), []byte("v5"), 9, store)
+	MustGetNone([]byte("tc"), 10, store)
+
+	// The range of the file must be in the region.
+	meta = MustUploadSST("sst2", []importSSTEntry{
+		{key: "ta", value: "va30", commitTS: 30},
+		{key: "x", value: "vx30", commitTS: 30},
+	}, store)
+	reqCtx = store.newImportReqCtx()
+	c.Assert(store.MvccStore.IngestSST(reqCtx, meta), NotNil)
+	reqCtx.finish()
+	MustGetVal([]byte("ta"), []byte("va10"), 30, store)
+
+	// A file with duplicated keys is rejected.
+	meta = MustUploadSST("sst3", []importSSTEntry{
+		{key: "ta", value: "va40", commitTS: 40},
+		{key: "ta", value: "va35", commitTS: 35},
+	}, store)
+	reqCtx = store.newImportReqCtx()
+	c.Assert(store.MvccStore.IngestSST(reqCtx, meta), NotNil)
+	reqCtx.finish()
+	MustGetVal([]byte("ta"), []byte("va10"), 40, store)
+	c.Assert(filepath.Dir(path), Equals, filepath.Join(store.DBPath, "import"))
+}
@@ -14,6 +14,8 @@
 package tikv
 
 import (
+	"context"
+
 	"github.com/ngaut/unistore/pd"
 	"github.com/ngaut/unistore/tikv/mvcc"
 	"github.com/pingcap/kvproto/pkg/tikvpb"
@@ -23,6 +25,8 @@ type InnerServer interface {
 	Setup(pdClient pd.Client)
 	Start(pdClient pd.Client) error
 	Stop() error
+	// EvictLeaders moves the leaders of the store away and waits for the committed logs to be applied.
+	EvictLeaders(ctx context.Context) error
 	Raft(stream tikvpb.Tikv_RaftServer) error
 	BatchRaft(stream tikvpb.Tikv_BatchRaftServer) error
 	Snapshot(stream tikvpb.Tikv_SnapshotServer) error
@@ -56,6 +60,10 @@ func (is *StandAlongInnerServer) Start(pdClient pd.Client) error {
 	return nil
 }
 
+func (is *StandAlongInnerServer) EvictLeaders(ctx context.Context) error {
+	return nil
+}
+
 func (is *StandAlongInnerServer) Stop() error {
 	return is.bundle.DB.Close()
 }
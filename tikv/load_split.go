@@ -0,0 +1,179 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ngaut/unistore/config"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	loadSplitInterval = time.Second
+	// loadSampleSize is the max number of the request keys sampled in a region.
+	loadSampleSize = 128
+)
+
+// regionLoad records the requests served by a region since the last collection, the keys of the requests are
+// sampled by reservoir sampling to pick a split key.
+type regionLoad struct {
+	mu      sync.Mutex
+	reads   int64
+	writes  int64
+	offered int
+	samples [][]byte
+
+	// hotTimes is the number of the intervals in a row the region is hot, it's only accessed by the worker.
+	hotTimes int
+}
+
+func (l *regionLoad) record(isWrite bool, keys ...[]byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if isWrite {
+		l.writes++
+	} else {
+		l.reads++
+	}
+	for _, key := range keys {
+		l.offered++
+		if len(l.samples) < loadSampleSize {
+			l.samples = append(l.samples, safeCopy(key))
+		} else if i := rand.Intn(l.offered); i < loadSampleSize {
+			l.samples[i] = safeCopy(key)
+		}
+	}
+}
+
+// collect returns the number of the reads and writes since the last collection and resets them.
+func (l *regionLoad) collect() (reads, writes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reads, writes = l.reads, l.writes
+	l.reads, l.writes = 0, 0
+	return
+}
+
+func (l *regionLoad) resetSamples() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.offered = 0
+	l.samples = l.samples[:0]
+}
+
+// splitKey returns the sampled key in the region which balances the sampled keys on its two sides best, nil is
+// returned if |left - right| / (left + right) is greater than balanceScore, e.g. the requests are on a single key.
+func (l *regionLoad) splitKey(regCtx *regionCtx, balanceScore float64) []byte {
+	l.mu.Lock()
+	samples := make([][]byte, 0, len(l.samples))
+	for _, key := range l.samples {
+		if !regCtx.lessThanStartKey(key) && !regCtx.greaterEqualEndKey(key) {
+			samples = append(samples, key)
+		}
+	}
+	l.mu.Unlock()
+	sort.Slice(samples, func(i, j int) bool {
+		return bytes.Compare(samples[i], samples[j]) < 0
+	})
+	var splitKey []byte
+	bestDiff := len(samples)
+	for i := 1; i < len(samples); i++ {
+		if bytes.Equal(samples[i], samples[i-1]) {
+			continue
+		}
+		// The keys less than samples[i] go to the left region.
+		diff := len(samples) - 2*i
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < bestDiff {
+			splitKey, bestDiff = samples[i], diff
+		}
+	}
+	if splitKey == nil || float64(bestDiff) > float64(len(samples))*balanceScore {
+		return nil
+	}
+	return splitKey
+}
+
+// loadSplitWorker splits the hot regions, a region is split once it has been serving more requests per second
+// than the threshold for DetectTimes intervals in a row.
+type loadSplitWorker struct {
+	regionManager RegionManager
+	conf          config.LoadSplit
+	interval      time.Duration
+	closeCh       chan struct{}
+}
+
+func newLoadSplitWorker(rm RegionManager, conf config.LoadSplit) *loadSplitWorker {
+	return &loadSplitWorker{
+		regionManager: rm,
+		conf:          conf,
+		interval:      loadSplitInterval,
+		closeCh:       make(chan struct{}),
+	}
+}
+
+func (w *loadSplitWorker) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func (w *loadSplitWorker) check() {
+	for _, regCtx := range w.regionManager.GetRegionsInRange(nil, nil) {
+		load := &regCtx.load
+		reads, writes := load.collect()
+		qps := float64(reads+writes) / w.interval.Seconds()
+		if qps < float64(w.conf.QPSThreshold) {
+			// The split key is picked from the keys sampled in the hot intervals only.
+			load.hotTimes = 0
+			load.resetSamples()
+			continue
+		}
+		load.hotTimes++
+		if load.hotTimes < w.conf.DetectTimes {
+			continue
+		}
+		splitKey := load.splitKey(regCtx, w.conf.SplitBalanceScore)
+		load.hotTimes = 0
+		load.resetSamples()
+		if splitKey == nil {
+			log.Debug("no balanced split key for hot region", zap.Uint64("region", regCtx.meta.Id))
+			continue
+		}
+		log.Info("split hot region", zap.Uint64("region", regCtx.meta.Id), zap.Float64("qps", qps),
+			zap.Int64("reads", reads), zap.Int64("writes", writes), zap.Binary("split key", splitKey))
+		if err := w.regionManager.SplitHotRegion(regCtx, splitKey); err != nil {
+			log.Warn("split hot region failed", zap.Uint64("region", regCtx.meta.Id), zap.Error(err))
+		}
+	}
+}
+
+func (w *loadSplitWorker) close() {
+	close(w.closeCh)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ngaut/unistore/config"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+func (s *testMvccSuite) TestLoadSplitKey(c *C) {
+	regCtx := newRegionCtx(&metapb.Region{Id: 1}, nil, nil)
+	load := &regCtx.load
+	for i := 0; i < 10; i++ {
+		load.record(false, []byte(fmt.Sprintf("k%d", i)))
+	}
+	c.Assert(load.splitKey(regCtx, 0.25), BytesEquals, []byte("k5"))
+	reads, writes := load.collect()
+	c.Assert(reads, Equals, int64(10))
+	c.Assert(writes, Equals, int64(0))
+
+	// The requests on a single key can't be balanced.
+	load.resetSamples()
+	for i := 0; i < 10; i++ {
+		load.record(true, []byte("k1"))
+	}
+	load.record(true, []byte("k2"))
+	c.Assert(load.splitKey(regCtx, 0.25), IsNil)
+
+	// The samples are bounded.
+	for i := 0; i < loadSampleSize*2; i++ {
+		load.record(false, []byte("k3"))
+	}
+	c.Assert(load.samples, HasLen, loadSampleSize)
+}
+
+func (s *testMvccSuite) TestLoadSplitWorker(c *C) {
+	store, err := NewTestStore("TestLoadSplitWorker", "TestLoadSplitWorker", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	bundle := &mvcc.DBBundle{DB: store.MvccStore.db, LockStore: store.MvccStore.lockStore}
+	rm, err := NewMockRegionManager(bundle, 1, RegionOptions{})
+	c.Assert(err, IsNil)
+	// The ids are allocated by the region manager to not conflict with the ids of the split regions.
+	ids := rm.AllocIDs(2)
+	peer := &metapb.Peer{Id: ids[1], StoreId: 1}
+	region := &metapb.Region{Id: ids[0], RegionEpoch: &metapb.RegionEpoch{}, Peers: []*metapb.Peer{peer}}
+	c.Assert(rm.Bootstrap([]*metapb.Store{{Id: 1}}, region), IsNil)
+	svr := store.Svr
+	svr.regionManager = rm
+	w := newLoadSplitWorker(rm, config.LoadSplit{QPSThreshold: 20, DetectTimes: 2, SplitBalanceScore: 0.25})
+	svr.loadSplit = w
+	rpcCtx := &kvrpcpb.Context{RegionId: region.Id, RegionEpoch: region.RegionEpoch, Peer: peer}
+	get := func(n int) {
+		for i := 0; i < n; i++ {
+			key := []byte(fmt.Sprintf("k%d", i%10))
+			resp, err := svr.KvGet(context.Background(), &kvrpcpb.GetRequest{Context: rpcCtx, Key: key, Version: 1})
+			c.Assert(err, IsNil)
+			c.Assert(resp.RegionError, IsNil)
+		}
+	}
+
+	// The region is not hot in the second interval.
+	get(20)
+	w.check()
+	get(10)
+	w.check()
+	get(20)
+	w.check()
+	c.Assert(rm.GetRegionsInRange(nil, nil), HasLen, 1)
+	get(20)
+	w.check()
+	regions := rm.GetRegionsInRange(nil, nil)
+	c.Assert(regions, HasLen, 2)
+	c.Assert(regions[0].endKey, BytesEquals, []byte("k5"))
+	c.Assert(regions[1].startKey, BytesEquals, []byte("k5"))
+}
@@ -271,6 +271,13 @@ func (rm *MockRegionManager) SplitKeys(start, end kv.Key, count int) {
 	}
 }
 
+func (rm *MockRegionManager) ReadIndex(req *kvrpcpb.ReadIndexRequest) *kvrpcpb.ReadIndexResponse {
+	if _, err := rm.GetRegionFromCtx(req.Context); err != nil {
+		return &kvrpcpb.ReadIndexResponse{RegionError: err}
+	}
+	return &kvrpcpb.ReadIndexResponse{}
+}
+
 func (rm *MockRegionManager) SplitRegion(req *kvrpcpb.SplitRegionRequest) *kvrpcpb.SplitRegionResponse {
 	if _, err := rm.GetRegionFromCtx(req.Context); err != nil {
 		return &kvrpcpb.SplitRegionResponse{RegionError: err}
@@ -295,6 +302,17 @@ func (rm *MockRegionManager) SplitRegion(req *kvrpcpb.SplitRegionRequest) *kvrpc
 	return &kvrpcpb.SplitRegionResponse{Regions: ret}
 }
 
+func (rm *MockRegionManager) SplitHotRegion(regCtx *regionCtx, splitKey []byte) error {
+	resp := rm.SplitRegion(&kvrpcpb.SplitRegionRequest{
+		Context:   &kvrpcpb.Context{RegionId: regCtx.meta.Id, RegionEpoch: regCtx.getRegionEpoch()},
+		SplitKeys: [][]byte{splitKey},
+	})
+	if resp.RegionError != nil {
+		return errors.New(resp.RegionError.Message)
+	}
+	return nil
+}
+
 func (rm *MockRegionManager) calculateSplitKeys(start, end []byte, count int) [][]byte {
 	var keys [][]byte
 	txn := rm.bundle.DB.NewTransaction(false)
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"sort"
 	"sync/atomic"
 	"time"
@@ -34,6 +35,7 @@ import (
 	"github.com/ngaut/unistore/pd"
 	"github.com/ngaut/unistore/tikv/dbreader"
 	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/tikv/raftstore"
 	"github.com/ngaut/unistore/util/lockwaiter"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/log"
@@ -47,13 +49,19 @@ import (
 
 // MVCCStore is a wrapper of badger.DB to provide MVCC functions.
 type MVCCStore struct {
-	dir       string
-	db        *badger.DB
-	lockStore *lockstore.MemStore
-	dbWriter  mvcc.DBWriter
-	safePoint *SafePoint
-	pdClient  pd.Client
-	closeCh   chan bool
+	dir          string
+	db           *badger.DB
+	lockStore    *lockstore.MemStore
+	lockObserver *mvcc.LockObserver
+	dbWriter     mvcc.DBWriter
+	importer     *raftstore.SSTImporter
+	safePoint    *SafePoint
+	pdClient     pd.Client
+	closeCh      chan bool
+	gcTaskCh     chan *gcTask
+
+	// changeObserver passes the changes written by the dbWriter to the CDC hub.
+	changeObserver *mvcc.ChangeObserver
 
 	conf *config.Config
 
@@ -61,6 +69,9 @@ type MVCCStore struct {
 	lockWaiterManager *lockwaiter.Manager
 	DeadlockDetectCli *DetectorClient
 	DeadlockDetectSvr *DetectorServer
+
+	// rawVersion is the last version of the raw writes allocated without PD.
+	rawVersion uint64
 }
 
 // NewMVCCStore creates a new MVCCStore
@@ -70,16 +81,21 @@ func NewMVCCStore(conf *config.Config, bundle *mvcc.DBBundle, dataDir string, sa
 		db:                bundle.DB,
 		dir:               dataDir,
 		lockStore:         bundle.LockStore,
+		lockObserver:      &bundle.LockObserver,
+		changeObserver:    &bundle.ChangeObserver,
 		safePoint:         safePoint,
 		pdClient:          pdClient,
 		closeCh:           make(chan bool),
+		gcTaskCh:          make(chan *gcTask, gcTaskChanSize),
 		dbWriter:          writer,
+		importer:          raftstore.NewSSTImporter(filepath.Join(dataDir, "import")),
 		conf:              conf,
 		lockWaiterManager: lockwaiter.NewManager(conf),
 	}
 	store.DeadlockDetectSvr = NewDetectorServer()
 	store.DeadlockDetectCli = NewDetectorClient(store.lockWaiterManager, pdClient)
 	writer.Open()
+	go store.runGCWorker()
 	if pdClient != nil {
 		// pdClient is nil in unit test.
 		go store.runUpdateSafePointLoop()
@@ -103,6 +119,26 @@ func (store *MVCCStore) getLatestTS() uint64 {
 	return atomic.LoadUint64(&store.latestTS)
 }
 
+func (store *MVCCStore) getPDTS() (uint64, error) {
+	if store.pdClient == nil {
+		return 0, errors.New("pd client is not set")
+	}
+	physical, logical, err := store.pdClient.GetTS(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	return uint64(physical)<<18 + uint64(logical), nil
+}
+
+// allocRawVersion allocates the version of a raw write from PD, so the versions of a key keep increasing when the
+// leader of its region changes. The version is allocated locally without PD in unit tests.
+func (store *MVCCStore) allocRawVersion() (uint64, error) {
+	if store.pdClient == nil {
+		return atomic.AddUint64(&store.rawVersion, 1), nil
+	}
+	return store.getPDTS()
+}
+
 func (store *MVCCStore) Close() error {
 	store.dbWriter.Close()
 	close(store.closeCh)
@@ -454,7 +490,7 @@ func (store *MVCCStore) handleCheckPessimisticErr(startTS uint64, err error, isF
 			log.S().Debugf("%d blocked by %d on key %d", startTS, lock.StartTS, keyHash)
 			waiter := store.lockWaiterManager.NewWaiter(startTS, lock.StartTS, keyHash, waitTimeDuration)
 			if !isFirstLock {
-				store.DeadlockDetectCli.Detect(startTS, lock.StartTS, keyHash)
+				store.DeadlockDetectCli.Detect(startTS, lock.StartTS, keyHash, lock.Key)
 			}
 			return waiter, err
 		}
@@ -949,6 +985,26 @@ func (store *MVCCStore) CheckRangeLock(startTS uint64, startKey, endKey []byte)
 	return nil
 }
 
+// minLockTS returns the min start ts of the locks in the range, or maxTS if there is no older lock. A pessimistic
+// lock is ignored since it doesn't block a read.
+func (store *MVCCStore) minLockTS(startKey, endKey []byte, maxTS uint64) uint64 {
+	minTS := maxTS
+	it := store.lockStore.NewIterator()
+	for it.Seek(startKey); it.Valid(); it.Next() {
+		if exceedEndKey(it.Key(), endKey) {
+			break
+		}
+		lock := mvcc.DecodeLock(it.Value())
+		if lock.Op == uint8(kvrpcpb.Op_PessimisticLock) {
+			continue
+		}
+		if lock.StartTS < minTS {
+			minTS = lock.StartTS
+		}
+	}
+	return minTS
+}
+
 func (store *MVCCStore) Cleanup(reqCtx *requestCtx, key []byte, startTS, currentTs uint64) error {
 	hashVals := keysToHashVals(key)
 	regCtx := reqCtx.regCtx
@@ -1004,18 +1060,38 @@ func (store *MVCCStore) ScanLock(reqCtx *requestCtx, maxTS uint64, limit int) ([
 	return locks, nil
 }
 
+// PhysicalScanLock scans the locks of all the regions on the store whose startTS is not greater than maxTS,
+// a zero limit means no limit. The locks written during the scan are collected by the lock observer.
 func (store *MVCCStore) PhysicalScanLock(startKey []byte, maxTS uint64, limit int) []*kvrpcpb.LockInfo {
 	var locks []*kvrpcpb.LockInfo
 	it := store.lockStore.NewIterator()
 	for it.Seek(startKey); it.Valid(); it.Next() {
-		if len(locks) == limit {
+		if limit > 0 && len(locks) == limit {
 			break
 		}
-		locks = store.appendScannedLock(locks, it, maxTS)
+		lock := mvcc.DecodeLock(it.Value())
+		if lock.StartTS <= maxTS {
+			locks = append(locks, lock.ToLockInfo(it.Key()))
+		}
 	}
 	return locks
 }
 
+// RegisterLockObserver starts collecting the locks whose startTS is not greater than maxTS.
+func (store *MVCCStore) RegisterLockObserver(maxTS uint64) error {
+	return store.lockObserver.Register(maxTS)
+}
+
+// CheckLockObserver returns the locks collected for maxTS, isClean is false if some locks are missing.
+func (store *MVCCStore) CheckLockObserver(maxTS uint64) (isClean bool, locks []*kvrpcpb.LockInfo, err error) {
+	return store.lockObserver.Check(maxTS)
+}
+
+// RemoveLockObserver stops collecting locks for maxTS.
+func (store *MVCCStore) RemoveLockObserver(maxTS uint64) error {
+	return store.lockObserver.Remove(maxTS)
+}
+
 func (store *MVCCStore) ResolveLock(reqCtx *requestCtx, lockKeys [][]byte, startTS, commitTS uint64) error {
 	regCtx := reqCtx.regCtx
 	if len(lockKeys) == 0 {
@@ -1083,10 +1159,10 @@ func (store *MVCCStore) StartDeadlockDetection(isRaft bool) {
 	go func() {
 		for {
 			select {
-			case req := <-store.DeadlockDetectCli.sendCh:
-				resp := store.DeadlockDetectSvr.Detect(req)
+			case task := <-store.DeadlockDetectCli.sendCh:
+				resp, waitChain := store.DeadlockDetectSvr.detect(task.req, task.key)
 				if resp != nil {
-					store.DeadlockDetectCli.waitMgr.WakeUpForDeadlock(resp)
+					store.DeadlockDetectCli.waitMgr.WakeUpForDeadlock(resp, waitChain)
 				}
 			case <-store.closeCh:
 				return
@@ -1179,6 +1255,10 @@ func (store *MVCCStore) BatchGet(reqCtx *requestCtx, keys [][]byte, version uint
 	pairs := make([]*kvrpcpb.KvPair, 0, len(keys))
 	remain := make([][]byte, 0, len(keys))
 	for _, key := range keys {
+		if reqCtx.staleRead {
+			remain = append(remain, key)
+			continue
+		}
 		err := store.CheckKeysLock(version, key)
 		if err != nil {
 			pairs = append(pairs, &kvrpcpb.KvPair{Key: key, Error: convertToKeyError(err)})
@@ -1258,8 +1338,10 @@ const (
 )
 
 // Filter implements the badger.CompactionFilter interface.
-// Since we use txn ts as badger version, we only need to filter Delete, Rollback and Op_Lock.
+// Since we use txn ts as badger version, we only need to filter Delete.
 // It is called for the first valid version before safe point, older versions are discarded automatically.
+// The Rollback and Op_Lock records are hashed by the consistency check, so they are kept until they are deleted by
+// the GC task through raft, otherwise the peers would drop them at different indexes.
 func (f *GCCompactionFilter) Filter(key, value, userMeta []byte) badger.Decision {
 	switch key[0] {
 	case metaPrefix, tablePrefix:
@@ -1267,11 +1349,6 @@ func (f *GCCompactionFilter) Filter(key, value, userMeta []byte) badger.Decision
 		if mvcc.DBUserMeta(userMeta).CommitTS() < f.safePoint && len(value) == 0 {
 			return badger.DecisionMarkTombstone
 		}
-	case metaExtraPrefix, tableExtraPrefix:
-		// For latest version, we can only remove `delete` key, which has value len 0.
-		if mvcc.DBUserMeta(userMeta).StartTS() < f.safePoint {
-			return badger.DecisionDrop
-		}
 	}
 	// Older version are discarded automatically, we need to keep the first valid version.
 	return badger.DecisionKeep
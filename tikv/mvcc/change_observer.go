@@ -0,0 +1,58 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import "sync/atomic"
+
+// ChangeType is the type of a transactional change.
+type ChangeType byte
+
+const (
+	ChangePrewrite ChangeType = iota + 1
+	ChangeCommit
+	ChangeRollback
+)
+
+// ChangeEvent is a transactional change of a key, Op and Value are the op and value of the lock, they are
+// not set for a rollback.
+type ChangeEvent struct {
+	Type     ChangeType
+	Key      []byte
+	Op       uint8
+	Value    []byte
+	StartTS  uint64
+	CommitTS uint64
+}
+
+// ChangeObserver passes the transactional changes to the handler, CDC uses it to capture the row changes.
+// The changes are observed after the data is written to the DB and before the locks are removed from the
+// lock store, so a change is always observed before the resolved ts passes it.
+type ChangeObserver struct {
+	handler atomic.Value // func([]ChangeEvent)
+}
+
+// SetHandler sets the handler of the changes, the handler must not keep references to the events.
+func (o *ChangeObserver) SetHandler(handler func(events []ChangeEvent)) {
+	o.handler.Store(handler)
+}
+
+// Observe is called for every batch of changes written to the DB.
+func (o *ChangeObserver) Observe(events []ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if handler, _ := o.handler.Load().(func([]ChangeEvent)); handler != nil {
+		handler(events)
+	}
+}
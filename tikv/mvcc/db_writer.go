@@ -18,6 +18,7 @@ import (
 
 	"github.com/coocood/badger"
 	"github.com/ngaut/unistore/lockstore"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 )
 
@@ -27,6 +28,9 @@ type DBWriter interface {
 	Close()
 	Write(batch WriteBatch) error
 	DeleteRange(start, end []byte, latchHandle LatchHandle) error
+	// IngestSST ingests the uploaded SST file at path into the region of the ctx, the range of the file has
+	// been checked. A raft writer proposes the meta, every peer ingests the file from its own import directory.
+	IngestSST(ctx *kvrpcpb.Context, meta *import_sstpb.SSTMeta, path string, latchHandle LatchHandle) error
 	NewWriteBatch(startTS, commitTS uint64, ctx *kvrpcpb.Context) WriteBatch
 }
 
@@ -41,13 +45,32 @@ type WriteBatch interface {
 	Rollback(key []byte, deleleLock bool)
 	PessimisticLock(key []byte, lock *MvccLock)
 	PessimisticRollback(key []byte)
+	// RawPut and RawDelete write a raw key at the version allocated by the proposer, so the versions of a key
+	// keep increasing whichever peer writes it.
+	RawPut(key, value []byte, version uint64)
+	RawDelete(key []byte, version uint64)
+	// VerPut writes the version of a versioned KV key given by the client, an empty value is a delete.
+	// VerDelete removes the version of the key from the DB.
+	VerPut(key, value []byte, version uint64)
+	VerDelete(key []byte, version uint64)
+	// ImportPut and ImportDelete write a committed version of the key at commitTS without a lock, they are
+	// used by bulk load.
+	ImportPut(key, value []byte, commitTS uint64)
+	ImportDelete(key []byte, commitTS uint64)
+	// DeleteVersion writes a delete at the version of the DB key, which hides the older versions too.
+	// The version must be the latest version of the key, since a read is served by the newest write
+	// of the key, it is used by GC.
+	DeleteVersion(key []byte, version uint64)
 }
 
 type DBBundle struct {
-	DB         *badger.DB
-	LockStore  *lockstore.MemStore
-	MemStoreMu sync.Mutex
-	StateTS    uint64
+	DB           *badger.DB
+	LockStore    *lockstore.MemStore
+	MemStoreMu   sync.Mutex
+	StateTS      uint64
+	LockObserver LockObserver
+	// ChangeObserver is notified of the prewrites, commits and rollbacks written by the DBWriter.
+	ChangeObserver ChangeObserver
 }
 
 type DBSnapshot struct {
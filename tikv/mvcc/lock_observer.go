@@ -0,0 +1,99 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mvcc
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// maxObservedLocks is the max number of locks a LockObserver collects, the observer becomes dirty
+// when there are more locks.
+const maxObservedLocks = 1024
+
+// LockObserver collects the locks written to the lock store whose startTS is not greater than the
+// registered maxTS. Green GC uses it to find the locks written during a physical lock scan instead of
+// scanning locks on the whole cluster.
+type LockObserver struct {
+	maxTS   uint64
+	mu      sync.Mutex
+	isClean bool
+	locks   []*kvrpcpb.LockInfo
+}
+
+// Register starts observing locks for maxTS. The collected locks are cleared if maxTS is greater
+// than the current one.
+func (o *LockObserver) Register(maxTS uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	curMaxTS := atomic.LoadUint64(&o.maxTS)
+	if maxTS < curMaxTS {
+		return fmt.Errorf("lock observer is watching a greater max ts %d", curMaxTS)
+	}
+	if maxTS > curMaxTS {
+		o.isClean = true
+		o.locks = nil
+		atomic.StoreUint64(&o.maxTS, maxTS)
+	}
+	return nil
+}
+
+// Check returns the collected locks, isClean is false if some locks are not collected.
+func (o *LockObserver) Check(maxTS uint64) (isClean bool, locks []*kvrpcpb.LockInfo, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if curMaxTS := atomic.LoadUint64(&o.maxTS); maxTS != curMaxTS {
+		return false, nil, fmt.Errorf("lock observer is watching max ts %d", curMaxTS)
+	}
+	return o.isClean, append([]*kvrpcpb.LockInfo{}, o.locks...), nil
+}
+
+// Remove stops observing locks for maxTS.
+func (o *LockObserver) Remove(maxTS uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if curMaxTS := atomic.LoadUint64(&o.maxTS); maxTS != curMaxTS {
+		return fmt.Errorf("lock observer is watching max ts %d", curMaxTS)
+	}
+	o.locks = nil
+	atomic.StoreUint64(&o.maxTS, 0)
+	return nil
+}
+
+// Observe is called for every lock put into the lock store.
+func (o *LockObserver) Observe(key, lockVal []byte) {
+	maxTS := atomic.LoadUint64(&o.maxTS)
+	if maxTS == 0 {
+		return
+	}
+	lock := DecodeLock(lockVal)
+	if lock.StartTS > maxTS {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// The observer may be re-registered since the maxTS is loaded.
+	if lock.StartTS > o.maxTS || !o.isClean {
+		return
+	}
+	if len(o.locks) >= maxObservedLocks {
+		o.isClean = false
+		o.locks = nil
+		return
+	}
+	o.locks = append(o.locks, lock.ToLockInfo(key))
+}
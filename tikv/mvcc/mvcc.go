@@ -17,6 +17,7 @@ import (
 	"encoding/binary"
 	"unsafe"
 
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/tidb/util/codec"
 )
 
@@ -72,6 +73,18 @@ func (l *MvccLock) MarshalBinary() []byte {
 	return buf
 }
 
+// ToLockInfo converts the lock of the key to kvrpcpb.LockInfo, the key is copied.
+func (l *MvccLock) ToLockInfo(key []byte) *kvrpcpb.LockInfo {
+	return &kvrpcpb.LockInfo{
+		PrimaryLock:     l.Primary,
+		LockVersion:     l.StartTS,
+		Key:             append([]byte{}, key...),
+		LockTtl:         uint64(l.TTL),
+		LockType:        kvrpcpb.Op(l.Op),
+		LockForUpdateTs: l.ForUpdateTS,
+	}
+}
+
 // UserMeta value for lock.
 const (
 	LockUserMetaNoneByte   = 0
@@ -130,3 +143,90 @@ func DecodeExtraTxnStatusKey(extraKey []byte) (key []byte) {
 	key[0]--
 	return
 }
+
+// RawKeyPrefix is the prefix of the keys written by the RawKV API.
+// It is under the internal key prefix 0xff, so raw keys are never visited by MVCC scans. A raw delete is written
+// as a badger delete, which is dropped by the compaction along with the older versions once it's below the safe
+// point and no lower level has the key, so the deleted raw keys don't pile up.
+var RawKeyPrefix = []byte{0xff, 'r', 'a', 'w'}
+
+// NewRawUserMeta returns the user meta for a raw key entry, an entry without user meta is treated as a delete.
+func NewRawUserMeta(version uint64) DBUserMeta {
+	return NewDBUserMeta(version, version)
+}
+
+// EncodeRawKey encodes a RawKV key to the key in DB.
+func EncodeRawKey(key []byte) []byte {
+	return encodePrefixedKey(RawKeyPrefix, key)
+}
+
+// EncodeRawEndKey encodes a RawKV end key to the key in DB, empty end key means the end of the raw keyspace.
+func EncodeRawEndKey(key []byte) []byte {
+	return encodePrefixedEndKey(RawKeyPrefix, key)
+}
+
+// DecodeRawKey decodes the key in DB to the RawKV key.
+func DecodeRawKey(key []byte) []byte {
+	return key[len(RawKeyPrefix):]
+}
+
+// VerKeyPrefix is the prefix of the keys written by the versioned KV API.
+// Every version given by the client is a separate DB key, the key is encoded in memcomparable format followed by
+// the descending version, so the versions of a key are ordered from the newest to the oldest. As a DB key has only
+// one badger version, the GC by the safe point never drops a version, the versions are kept until they are deleted
+// by the versioned delete range. A delete is written as a version with an empty value.
+var VerKeyPrefix = []byte{0xff, 'v', 'e', 'r'}
+
+// NewVerUserMeta returns the user meta for a versioned key entry.
+func NewVerUserMeta(version uint64) DBUserMeta {
+	return NewDBUserMeta(version, version)
+}
+
+// EncodeVerKey encodes a version of a versioned KV key to the key in DB.
+func EncodeVerKey(key []byte, version uint64) []byte {
+	b := EncodeVerStartKey(key)
+	return codec.EncodeUintDesc(b, version)
+}
+
+// EncodeVerStartKey encodes a versioned KV start key to the key in DB, the versions of the key are above it.
+func EncodeVerStartKey(key []byte) []byte {
+	b := make([]byte, 0, len(VerKeyPrefix)+codec.EncodedBytesLength(len(key))+8)
+	b = append(b, VerKeyPrefix...)
+	return codec.EncodeBytes(b, key)
+}
+
+// EncodeVerEndKey encodes a versioned KV end key to the key in DB, empty end key means the end of the keyspace.
+func EncodeVerEndKey(key []byte) []byte {
+	if len(key) == 0 {
+		return encodePrefixedEndKey(VerKeyPrefix, nil)
+	}
+	return EncodeVerStartKey(key)
+}
+
+// DecodeVerKey decodes the key in DB to the versioned KV key and the version.
+func DecodeVerKey(key []byte) ([]byte, uint64, error) {
+	rest, k, err := codec.DecodeBytes(key[len(VerKeyPrefix):], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, version, err := codec.DecodeUintDesc(rest)
+	if err != nil {
+		return nil, 0, err
+	}
+	return k, version, nil
+}
+
+func encodePrefixedKey(prefix, key []byte) []byte {
+	b := make([]byte, 0, len(prefix)+len(key))
+	b = append(b, prefix...)
+	return append(b, key...)
+}
+
+func encodePrefixedEndKey(prefix, key []byte) []byte {
+	if len(key) == 0 {
+		end := append([]byte{}, prefix...)
+		end[len(end)-1]++
+		return end
+	}
+	return encodePrefixedKey(prefix, key)
+}
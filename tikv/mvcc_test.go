@@ -16,22 +16,28 @@ package tikv
 import (
 	"bytes"
 	"fmt"
+	"hash/crc64"
 	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/coocood/badger"
 	"github.com/coocood/badger/y"
 	"github.com/ngaut/unistore/config"
 	"github.com/ngaut/unistore/lockstore"
+	"github.com/ngaut/unistore/tikv/dbreader"
 	"github.com/ngaut/unistore/tikv/mvcc"
 	"github.com/ngaut/unistore/tikv/raftstore"
 	"github.com/ngaut/unistore/util/lockwaiter"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/coprocessor"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tipb/go-tipb"
 )
 
 var _ = Suite(&testMvccSuite{})
@@ -81,12 +87,13 @@ func newMutation(op kvrpcpb.Op, key, value []byte) *kvrpcpb.Mutation {
 	}
 }
 
-func CreateTestDB(dbPath, LogPath string) (*badger.DB, error) {
+func CreateTestDB(dbPath, LogPath string, safePoint *SafePoint) (*badger.DB, error) {
 	subPath := fmt.Sprintf("/%d", 0)
 	opts := badger.DefaultOptions
 	opts.Dir = dbPath + subPath
 	opts.ValueDir = LogPath + subPath
 	opts.ManagedTxns = true
+	opts.CompactionFilterFactory = safePoint.CreateCompactionFilter
 	return badger.Open(opts)
 }
 
@@ -99,8 +106,12 @@ func NewTestStore(dbPrefix string, logPrefix string, c *C) (*TestStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newTestStoreAt(dbPath, LogPath, c)
+}
+
+func newTestStoreAt(dbPath, LogPath string, c *C) (*TestStore, error) {
 	safePoint := &SafePoint{}
-	db, err := CreateTestDB(dbPath, LogPath)
+	db, err := CreateTestDB(dbPath, LogPath, safePoint)
 	if err != nil {
 		return nil, err
 	}
@@ -130,6 +141,18 @@ func NewTestStore(dbPrefix string, logPrefix string, c *C) (*TestStore, error) {
 	}, nil
 }
 
+// reopenTestStore closes the DB of the store and opens it again. Badger flushes the memtable and compacts the L0
+// tables on close, so the GC compaction filter is run at the safe point of the store.
+func reopenTestStore(store *TestStore) (*TestStore, error) {
+	if err := store.MvccStore.Close(); err != nil {
+		return nil, err
+	}
+	if err := store.MvccStore.db.Close(); err != nil {
+		return nil, err
+	}
+	return newTestStoreAt(store.DBPath, store.LogPath, store.c)
+}
+
 func CleanTestStore(store *TestStore) {
 	os.RemoveAll(store.DBPath)
 	os.RemoveAll(store.LogPath)
@@ -443,8 +466,12 @@ func MustPrewriteLockErr(pk []byte, key []byte, startTs uint64, store *TestStore
 	store.c.Assert(err, NotNil)
 }
 
-func MustGC(key []byte, safePoint uint64, s *TestStore) {
-	s.MvccStore.UpdateSafePoint(safePoint)
+func MustGC(key []byte, safePoint uint64, s *TestStore) GCStats {
+	reqCtx := s.newReqCtx()
+	defer reqCtx.finish()
+	stats, err := s.MvccStore.GC(reqCtx, safePoint)
+	s.c.Assert(err, IsNil)
+	return stats
 }
 
 func MustCleanup(key []byte, startTs, currentTs uint64, store *TestStore) {
@@ -1183,6 +1210,82 @@ func (s *testMvccSuite) TestGC(c *C) {
 	MustGetVal(k, v3, 62, store)
 }
 
+func (s *testMvccSuite) TestGCStats(c *C) {
+	store, err := NewTestStore("TestGCStats", "TestGCStats", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k1, k2, k3, k4 := []byte("ta"), []byte("tb"), []byte("tc"), []byte("td")
+	v1, v2 := []byte("v1"), []byte("v2")
+
+	MustPrewritePut(k1, k1, v1, 10, store)
+	MustCommit(k1, 10, 20, store)
+	MustPrewritePut(k1, k1, v2, 30, store)
+	MustCommit(k1, 30, 40, store)
+	MustPrewritePut(k2, k2, v1, 10, store)
+	MustCommit(k2, 10, 20, store)
+	MustPrewriteDelete(k2, k2, 30, store)
+	MustCommit(k2, 30, 40, store)
+	MustPrewritePut(k3, k3, v1, 10, store)
+	MustRollbackKey(k3, 10, store)
+	MustPrewritePut(k3, k3, v1, 50, store)
+	MustCommit(k3, 50, 60, store)
+	MustPrewriteLock(k4, k4, 10, store)
+	MustCommit(k4, 10, 20, store)
+
+	stats := MustGC(nil, 45, store)
+	c.Assert(stats.DeletedVersions, Equals, 2)
+	c.Assert(stats.ObsoleteVersions, Equals, 1)
+	c.Assert(stats.DeletedRollbacks, Equals, 1)
+	c.Assert(stats.DeletedOpLocks, Equals, 1)
+	MustGetVal(k1, v2, 45, store)
+	MustGetNone(k2, 45, store)
+	MustGetVal(k3, v1, 60, store)
+
+	// Nothing left to delete.
+	stats = MustGC(nil, 45, store)
+	c.Assert(stats.DeletedVersions, Equals, 0)
+	c.Assert(stats.DeletedRollbacks, Equals, 0)
+	c.Assert(stats.DeletedOpLocks, Equals, 0)
+}
+
+func (s *testMvccSuite) TestGCObsoleteVersions(c *C) {
+	store, err := NewTestStore("TestGCObsoleteVersions", "TestGCObsoleteVersions", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k := []byte("tk")
+	v1, v2, v3 := []byte("v1"), []byte("v2"), []byte("v3")
+	MustPrewritePut(k, k, v1, 5, store)
+	MustCommit(k, 5, 10, store)
+	MustPrewritePut(k, k, v2, 25, store)
+	MustCommit(k, 25, 30, store)
+	MustPrewritePut(k, k, v3, 45, store)
+	MustCommit(k, 45, 50, store)
+
+	// The version 10 is below the put at 30, the latest version before the safe point.
+	stats := MustGC(nil, 40, store)
+	c.Assert(stats.DeletedVersions, Equals, 0)
+	c.Assert(stats.ObsoleteVersions, Equals, 1)
+	store, err = reopenTestStore(store)
+	c.Assert(err, IsNil)
+
+	var versions []uint64
+	txn := store.MvccStore.db.NewTransaction(false)
+	defer txn.Discard()
+	it := dbreader.NewIterator(txn, false, nil, nil)
+	defer it.Close()
+	it.SetAllVersions(true)
+	for it.Seek(k); it.Valid() && bytes.Equal(it.Item().Key(), k); it.Next() {
+		versions = append(versions, it.Item().Version())
+	}
+	c.Assert(versions, DeepEquals, []uint64{50, 30})
+	MustGetVal(k, v2, 40, store)
+	MustGetVal(k, v3, 60, store)
+	stats = MustGC(nil, 40, store)
+	c.Assert(stats.ObsoleteVersions, Equals, 0)
+}
+
 func (s *testMvccSuite) TestPessimisticLock(c *C) {
 	store, err := NewTestStore("TestPessimisticLock", "TestPessimisticLock", c)
 	c.Assert(err, IsNil)
@@ -1475,3 +1578,128 @@ func (s *testMvccSuite) TestPessimisticLockForce(c *C) {
 	MustUnLocked(k, store)
 	MustGetVal(k, v2, 13, store)
 }
+
+func (s *testMvccSuite) TestLockObserver(c *C) {
+	store, err := NewTestStore("TestLockObserver", "TestLockObserver", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k1, k2, k3 := []byte("tk1"), []byte("tk2"), []byte("tk3")
+	v := []byte("v")
+	MustPrewritePut(k1, k1, v, 5, store)
+	c.Assert(store.MvccStore.RegisterLockObserver(10), IsNil)
+	// Locks are observed after registration, whether they come from prewrite or pessimistic lock.
+	MustAcquirePessimisticLock(k2, k2, 8, 8, store)
+	MustPrewritePut(k3, k3, v, 10, store)
+	MustPrewritePut([]byte("tk4"), []byte("tk4"), v, 11, store)
+
+	isClean, locks, err := store.MvccStore.CheckLockObserver(10)
+	c.Assert(err, IsNil)
+	c.Assert(isClean, IsTrue)
+	c.Assert(locks, HasLen, 2)
+	c.Assert(locks[0].Key, DeepEquals, k2)
+	c.Assert(locks[0].LockType, Equals, kvrpcpb.Op_PessimisticLock)
+	c.Assert(locks[1].Key, DeepEquals, k3)
+
+	// PhysicalScanLock returns the locks whose startTS is not greater than maxTS.
+	locks = store.MvccStore.PhysicalScanLock(nil, 10, 0)
+	c.Assert(locks, HasLen, 3)
+	locks = store.MvccStore.PhysicalScanLock(k2, 10, 1)
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].Key, DeepEquals, k2)
+
+	// A smaller maxTS can't be registered, a greater one resets the observer.
+	c.Assert(store.MvccStore.RegisterLockObserver(9), NotNil)
+	_, _, err = store.MvccStore.CheckLockObserver(9)
+	c.Assert(err, NotNil)
+	c.Assert(store.MvccStore.RegisterLockObserver(20), IsNil)
+	_, locks, err = store.MvccStore.CheckLockObserver(20)
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 0)
+
+	c.Assert(store.MvccStore.RemoveLockObserver(10), NotNil)
+	c.Assert(store.MvccStore.RemoveLockObserver(20), IsNil)
+	MustPrewritePut([]byte("tk5"), []byte("tk5"), v, 12, store)
+	_, _, err = store.MvccStore.CheckLockObserver(20)
+	c.Assert(err, NotNil)
+}
+
+func mustChecksum(req *tipb.ChecksumRequest, ranges []*coprocessor.KeyRange, startTS uint64, store *TestStore) *coprocessor.Response {
+	data, err := req.Marshal()
+	store.c.Assert(err, IsNil)
+	reqCtx := store.newImportReqCtx()
+	defer reqCtx.finish()
+	return store.Svr.handleCopChecksumRequest(reqCtx, &coprocessor.Request{Data: data, Ranges: ranges, StartTs: startTS})
+}
+
+func (s *testMvccSuite) TestChecksum(c *C) {
+	store, err := NewTestStore("TestChecksum", "TestChecksum", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	MustPrewritePut([]byte("ta"), []byte("ta"), []byte("va"), 5, store)
+	MustCommit([]byte("ta"), 5, 6, store)
+	MustPrewritePut([]byte("tb"), []byte("tb"), []byte("vb"), 7, store)
+	MustCommit([]byte("tb"), 7, 8, store)
+	MustPrewritePut([]byte("tc"), []byte("tc"), []byte("vc"), 10, store)
+
+	ranges := []*coprocessor.KeyRange{{Start: []byte("ta"), End: []byte("tc")}}
+	req := &tipb.ChecksumRequest{Algorithm: tipb.ChecksumAlgorithm_Crc64_Xor}
+	resp := mustChecksum(req, ranges, 9, store)
+	c.Assert(resp.OtherError, Equals, "")
+	checksumResp := new(tipb.ChecksumResponse)
+	c.Assert(checksumResp.Unmarshal(resp.Data), IsNil)
+	table := crc64.MakeTable(crc64.ECMA)
+	expected := crc64.Checksum([]byte("tava"), table) ^ crc64.Checksum([]byte("tbvb"), table)
+	c.Assert(checksumResp.Checksum, Equals, expected)
+	c.Assert(checksumResp.TotalKvs, Equals, uint64(2))
+	c.Assert(checksumResp.TotalBytes, Equals, uint64(8))
+
+	// Only the versions visible at the start ts are checksummed.
+	resp = mustChecksum(req, ranges, 7, store)
+	c.Assert(checksumResp.Unmarshal(resp.Data), IsNil)
+	c.Assert(checksumResp.Checksum, Equals, crc64.Checksum([]byte("tava"), table))
+	c.Assert(checksumResp.TotalKvs, Equals, uint64(1))
+
+	// The new prefix is replaced by the old prefix.
+	req.Rule = &tipb.ChecksumRewriteRule{OldPrefix: []byte("xy"), NewPrefix: []byte("t")}
+	resp = mustChecksum(req, ranges, 9, store)
+	c.Assert(checksumResp.Unmarshal(resp.Data), IsNil)
+	expected = crc64.Checksum([]byte("xyava"), table) ^ crc64.Checksum([]byte("xybvb"), table)
+	c.Assert(checksumResp.Checksum, Equals, expected)
+	c.Assert(checksumResp.TotalBytes, Equals, uint64(10))
+
+	// A lock in the ranges is reported.
+	resp = mustChecksum(req, []*coprocessor.KeyRange{{Start: []byte("ta"), End: []byte("td")}}, 11, store)
+	c.Assert(resp.Locked, NotNil)
+	c.Assert(resp.Locked.Key, BytesEquals, []byte("tc"))
+}
+
+func (s *testMvccSuite) TestPrepareStop(c *C) {
+	store, err := NewTestStore("TestPrepareStop", "TestPrepareStop", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+	svr := store.Svr
+	svr.innerServer = NewStandAlongInnerServer(&mvcc.DBBundle{DB: store.MvccStore.db})
+
+	// The running request is waited for, and the new requests are rejected.
+	reqCtx, err := svr.acquireRequestCtx(&kvrpcpb.Context{}, "KvPessimisticLock")
+	c.Assert(err, IsNil)
+	done := make(chan struct{})
+	go func() {
+		svr.PrepareStop(10 * time.Second)
+		close(done)
+	}()
+	for atomic.LoadInt32(&svr.stopped) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = svr.acquireRequestCtx(&kvrpcpb.Context{}, "KvPessimisticLock")
+	c.Assert(err, NotNil)
+	select {
+	case <-done:
+		c.Fatal("PrepareStop returns before the running request finishes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	reqCtx.finish()
+	<-done
+}
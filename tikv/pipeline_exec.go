@@ -0,0 +1,488 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/pingcap/kvproto/pkg/coprocessor"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/expression"
+	"github.com/pingcap/tidb/expression/aggregation"
+	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/sessionctx/stmtctx"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/chunk"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/pingcap/tipb/go-tipb"
+)
+
+// pipelineExecutor executes the DAGRequests that can not be flattened to a closure. The executors are built
+// into a tree from the scan to the root, every executor pulls the chunks of rows from its child.
+type pipelineExecutor struct {
+	scan      *scanExec
+	root      executor
+	sc        *stmtctx.StatementContext
+	outputOff []uint32
+	rowBuf    []byte
+}
+
+// executor is a node of the pipeline.
+type executor interface {
+	fieldTypes() []*types.FieldType
+	// next returns the next chunk of rows, nil is returned when there are no more rows.
+	next() (*chunk.Chunk, error)
+}
+
+func (svr *Server) buildPipelineExecutor(dagCtx *dagContext, dagReq *tipb.DAGRequest) (*pipelineExecutor, error) {
+	ce, err := svr.newClosureExecutor(dagCtx, dagReq)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	scan := &scanExec{closureExecutor: ce}
+	scan.proc = &scanBatchProcessor{closureExecutor: ce}
+	var exec executor = scan
+	for _, pbExec := range dagReq.Executors[1:] {
+		switch pbExec.Tp {
+		case tipb.ExecType_TypeSelection:
+			exec, err = newSelectionExec(exec, ce.sc, pbExec.Selection)
+		case tipb.ExecType_TypeLimit:
+			exec = &limitExec{child: exec, limit: pbExec.Limit.Limit}
+		case tipb.ExecType_TypeTopN:
+			exec, err = newTopNExec(exec, ce.sc, pbExec.TopN)
+		case tipb.ExecType_TypeAggregation, tipb.ExecType_TypeStreamAgg:
+			exec, err = newAggExec(exec, ce.sc, pbExec.Aggregation)
+		default:
+			err = errors.Errorf("unsupported executor type %s", pbExec.Tp)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, off := range dagReq.OutputOffsets {
+		if int(off) >= len(exec.fieldTypes()) {
+			return nil, errors.Errorf("invalid output offset %d", off)
+		}
+	}
+	return &pipelineExecutor{
+		scan:      scan,
+		root:      exec,
+		sc:        ce.sc,
+		outputOff: dagReq.OutputOffsets,
+	}, nil
+}
+
+func (e *pipelineExecutor) rangeCounts() []int64 {
+	return e.scan.counts
+}
+
+func (e *pipelineExecutor) execute() ([]tipb.Chunk, error) {
+	var chunks []tipb.Chunk
+	var rowCnt int
+	fieldTps := e.root.fieldTypes()
+	row := make([]types.Datum, len(e.outputOff))
+	for {
+		chk, err := e.root.next()
+		if err != nil {
+			return nil, err
+		}
+		if chk == nil {
+			return chunks, nil
+		}
+		for i := 0; i < chk.NumRows(); i++ {
+			for j, off := range e.outputOff {
+				row[j] = chk.GetRow(i).GetDatum(int(off), fieldTps[off])
+			}
+			e.rowBuf, err = codec.EncodeValue(e.sc, e.rowBuf[:0], row...)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			chunks = appendRow(chunks, e.rowBuf, rowCnt)
+			rowCnt++
+		}
+	}
+}
+
+// executeStream sends the result in a single batch, the executors above the scan may need all the rows to
+// produce the result.
+func (e *pipelineExecutor) executeStream(batchRows int, send streamSender) error {
+	chunks, err := e.execute()
+	if err != nil {
+		return err
+	}
+	var ran *coprocessor.KeyRange
+	if ranges := e.scan.kvRanges; len(ranges) > 0 {
+		ran = e.scan.coveredRange(ranges[0], ranges[len(ranges)-1])
+	}
+	return send(chunks, e.scan.counts, ran)
+}
+
+// scanExec scans the ranges and decodes at most chunkMaxRows rows for each call of next, a range which is not
+// finished is cut at the last scanned key.
+type scanExec struct {
+	*closureExecutor
+	proc     *scanBatchProcessor
+	rangeIdx int
+}
+
+func (e *scanExec) fieldTypes() []*types.FieldType {
+	return e.fieldTps
+}
+
+func (e *scanExec) next() (*chunk.Chunk, error) {
+	err := e.checkRangeLock()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	chk := chunk.NewChunkWithCapacity(e.fieldTps, chunkMaxRows)
+	e.scanCtx.chk = chk
+	dbReader := e.reqCtx.getDBReader()
+	for e.rangeIdx < len(e.kvRanges) && chk.NumRows() < chunkMaxRows {
+		ran := e.kvRanges[e.rangeIdx]
+		err = e.processRange(dbReader, e.rangeIdx, ran, e.proc)
+		if err != nil {
+			return nil, err
+		}
+		if chk.NumRows() < chunkMaxRows || (e.unique && ran.IsPoint()) {
+			e.rangeIdx++
+			continue
+		}
+		if e.scanCtx.desc {
+			ran.EndKey = append([]byte{}, e.proc.lastKey...)
+		} else {
+			ran.StartKey = kv.Key(e.proc.lastKey).Next()
+		}
+		if bytes.Compare(ran.StartKey, ran.EndKey) >= 0 {
+			e.rangeIdx++
+		} else {
+			e.kvRanges[e.rangeIdx] = ran
+		}
+	}
+	if chk.NumRows() == 0 {
+		return nil, nil
+	}
+	return chk, nil
+}
+
+// scanBatchProcessor decodes the rows into the chunk of the scan and breaks the scan when the chunk is full.
+type scanBatchProcessor struct {
+	skipVal
+	*closureExecutor
+	lastKey []byte
+}
+
+func (p *scanBatchProcessor) Process(key, value []byte) error {
+	err := p.processCore(key, value)
+	if err != nil {
+		return err
+	}
+	p.rowCount++
+	p.lastKey = append(p.lastKey[:0], key...)
+	if p.scanCtx.chk.NumRows() >= chunkMaxRows {
+		return dbreader.ScanBreak
+	}
+	return nil
+}
+
+func (p *scanBatchProcessor) Finish() error {
+	return nil
+}
+
+type selectionExec struct {
+	child      executor
+	sc         *stmtctx.StatementContext
+	conditions []expression.Expression
+}
+
+func newSelectionExec(child executor, sc *stmtctx.StatementContext, sel *tipb.Selection) (*selectionExec, error) {
+	conditions, err := convertToExprs(sc, child.fieldTypes(), sel.Conditions)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &selectionExec{child: child, sc: sc, conditions: conditions}, nil
+}
+
+func (e *selectionExec) fieldTypes() []*types.FieldType {
+	return e.child.fieldTypes()
+}
+
+func (e *selectionExec) next() (*chunk.Chunk, error) {
+	for {
+		chk, err := e.child.next()
+		if err != nil || chk == nil {
+			return nil, err
+		}
+		out := chunk.NewChunkWithCapacity(e.fieldTypes(), chk.NumRows())
+		for i := 0; i < chk.NumRows(); i++ {
+			row := chk.GetRow(i)
+			ok, err := evalConditions(e.sc, e.conditions, row)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				out.AppendRow(row)
+			}
+		}
+		if out.NumRows() > 0 {
+			return out, nil
+		}
+	}
+}
+
+type limitExec struct {
+	child executor
+	limit uint64
+	count uint64
+}
+
+func (e *limitExec) fieldTypes() []*types.FieldType {
+	return e.child.fieldTypes()
+}
+
+func (e *limitExec) next() (*chunk.Chunk, error) {
+	if e.count >= e.limit {
+		return nil, nil
+	}
+	chk, err := e.child.next()
+	if err != nil || chk == nil {
+		return nil, err
+	}
+	if left := e.limit - e.count; uint64(chk.NumRows()) > left {
+		chk.TruncateTo(int(left))
+	}
+	e.count += uint64(chk.NumRows())
+	return chk, nil
+}
+
+// topNExec keeps the top n rows of its child in a heap, a row in the heap is encoded to save the memory.
+type topNExec struct {
+	child        executor
+	sc           *stmtctx.StatementContext
+	heap         *topNHeap
+	orderByExprs []expression.Expression
+	done         bool
+}
+
+func newTopNExec(child executor, sc *stmtctx.StatementContext, topN *tipb.TopN) (*topNExec, error) {
+	pbConds := make([]*tipb.Expr, len(topN.OrderBy))
+	for i, item := range topN.OrderBy {
+		pbConds[i] = item.Expr
+	}
+	conds, err := convertToExprs(sc, child.fieldTypes(), pbConds)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &topNExec{
+		child: child,
+		sc:    sc,
+		heap: &topNHeap{
+			totalCount: int(topN.Limit),
+			topNSorter: topNSorter{orderByItems: topN.OrderBy, sc: sc},
+		},
+		orderByExprs: conds,
+	}, nil
+}
+
+func (e *topNExec) fieldTypes() []*types.FieldType {
+	return e.child.fieldTypes()
+}
+
+func (e *topNExec) newSortRow() *sortRow {
+	return &sortRow{key: make([]types.Datum, len(e.orderByExprs)), data: make([][]byte, 1)}
+}
+
+func (e *topNExec) next() (*chunk.Chunk, error) {
+	if e.done || e.heap.totalCount == 0 {
+		return nil, nil
+	}
+	e.done = true
+	fieldTps := e.fieldTypes()
+	sortRow := e.newSortRow()
+	for {
+		chk, err := e.child.next()
+		if err != nil {
+			return nil, err
+		}
+		if chk == nil {
+			break
+		}
+		for i := 0; i < chk.NumRows(); i++ {
+			row := chk.GetRow(i)
+			for j, expr := range e.orderByExprs {
+				d, err := expr.Eval(row)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				d.Copy(&sortRow.key[j])
+			}
+			if e.heap.tryToAddRow(sortRow) {
+				sortRow.data[0], err = codec.EncodeValue(e.sc, nil, row.GetDatumRow(fieldTps)...)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				sortRow = e.newSortRow()
+			}
+			if e.heap.err != nil {
+				return nil, errors.Trace(e.heap.err)
+			}
+		}
+	}
+	sort.Sort(&e.heap.topNSorter)
+	if e.heap.err != nil {
+		return nil, errors.Trace(e.heap.err)
+	}
+	if len(e.heap.rows) == 0 {
+		return nil, nil
+	}
+	out := chunk.NewChunkWithCapacity(fieldTps, len(e.heap.rows))
+	for _, row := range e.heap.rows {
+		if err := decodeToChunk(e.sc, row.data[0], fieldTps, 0, out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// aggExec computes the partial results of the aggregation functions for each group, a result row is the
+// partial results followed by the group by values.
+type aggExec struct {
+	child        executor
+	sc           *stmtctx.StatementContext
+	aggExprs     []aggregation.Aggregation
+	groupByExprs []expression.Expression
+	fieldTps     []*types.FieldType
+	groupKeys    [][]byte
+	aggCtxsMap   map[string][]*aggregation.AggEvaluateContext
+	done         bool
+}
+
+func newAggExec(child executor, sc *stmtctx.StatementContext, agg *tipb.Aggregation) (*aggExec, error) {
+	e := &aggExec{
+		child:      child,
+		sc:         sc,
+		aggCtxsMap: map[string][]*aggregation.AggEvaluateContext{},
+	}
+	for _, expr := range agg.AggFunc {
+		aggExpr, err := aggregation.NewDistAggFunc(expr, child.fieldTypes(), sc)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		e.aggExprs = append(e.aggExprs, aggExpr)
+		if expr.FieldType == nil {
+			return nil, errors.Errorf("no field type of aggregation function %s", expr.Tp)
+		}
+		// The partial result of avg is the count and the sum.
+		if expr.Tp == tipb.ExprType_Avg {
+			e.fieldTps = append(e.fieldTps, types.NewFieldType(mysql.TypeLonglong))
+		}
+		e.fieldTps = append(e.fieldTps, expression.FieldTypeFromPB(expr.FieldType))
+	}
+	var err error
+	e.groupByExprs, err = convertToExprs(sc, child.fieldTypes(), agg.GroupBy)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, expr := range e.groupByExprs {
+		e.fieldTps = append(e.fieldTps, expr.GetType())
+	}
+	return e, nil
+}
+
+func (e *aggExec) fieldTypes() []*types.FieldType {
+	return e.fieldTps
+}
+
+func (e *aggExec) next() (*chunk.Chunk, error) {
+	if e.done {
+		return nil, nil
+	}
+	e.done = true
+	for {
+		chk, err := e.child.next()
+		if err != nil {
+			return nil, err
+		}
+		if chk == nil {
+			break
+		}
+		for i := 0; i < chk.NumRows(); i++ {
+			if err = e.update(chk.GetRow(i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(e.groupKeys) == 0 {
+		return nil, nil
+	}
+	out := chunk.NewChunkWithCapacity(e.fieldTps, len(e.groupKeys))
+	for _, gk := range e.groupKeys {
+		colIdx := 0
+		aggCtxs := e.aggCtxsMap[string(gk)]
+		for i, agg := range e.aggExprs {
+			for _, d := range agg.GetPartialResult(aggCtxs[i]) {
+				out.AppendDatum(colIdx, &d)
+				colIdx++
+			}
+		}
+		if err := decodeToChunk(e.sc, gk, e.fieldTps[colIdx:], colIdx, out); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (e *aggExec) update(row chunk.Row) error {
+	var gk []byte
+	for _, item := range e.groupByExprs {
+		v, err := item.Eval(row)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		gk, err = codec.EncodeValue(e.sc, gk, v)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	aggCtxs, ok := e.aggCtxsMap[string(gk)]
+	if !ok {
+		aggCtxs = make([]*aggregation.AggEvaluateContext, 0, len(e.aggExprs))
+		for _, agg := range e.aggExprs {
+			aggCtxs = append(aggCtxs, agg.CreateContext(e.sc))
+		}
+		e.aggCtxsMap[string(gk)] = aggCtxs
+		e.groupKeys = append(e.groupKeys, gk)
+	}
+	for i, agg := range e.aggExprs {
+		if err := agg.Update(aggCtxs[i], e.sc, row); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// decodeToChunk decodes the values encoded by codec.EncodeValue to the columns of the chunk from colOff.
+func decodeToChunk(sc *stmtctx.StatementContext, data []byte, fieldTps []*types.FieldType, colOff int, chk *chunk.Chunk) error {
+	decoder := codec.NewDecoder(chk, sc.TimeZone)
+	var err error
+	for i, ft := range fieldTps {
+		data, err = decoder.DecodeOne(data, colOff+i, ft)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
@@ -15,11 +15,16 @@ package raftstore
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
 	"time"
 
 	"github.com/coocood/badger"
 	"github.com/coocood/badger/y"
+	"github.com/ngaut/unistore/lockstore"
 	"github.com/ngaut/unistore/tikv/dbreader"
 	"github.com/ngaut/unistore/tikv/mvcc"
 	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
@@ -148,9 +153,8 @@ type execResultRollbackMerge struct {
 }
 
 type execResultComputeHash struct {
-	region *metapb.Region
-	index  uint64
-	snap   *mvcc.DBSnapshot
+	index uint64
+	hash  []byte
 }
 
 type execResultVerifyHash struct {
@@ -162,6 +166,12 @@ type execResultDeleteRange struct {
 	ranges []keyRange
 }
 
+// execResultResolvedTS is the resolved ts proposed by the leader, the writes before it have been applied when the
+// result is handled, so the peer can serve the reads at or below it.
+type execResultResolvedTS struct {
+	ts uint64
+}
+
 type execResult = interface{}
 
 type applyResultType int
@@ -291,11 +301,17 @@ type applyContext struct {
 	wbLastKeys       uint64
 	lastAppliedIndex uint64
 	committedCount   int
+	// applyMsgs are the messages sent between appliers, like `CatchUpLogs` for merge.
+	applyMsgs applyMsgs
 
 	// Indicates that WAL can be synchronized when data is written to KV engine.
 	enableSyncLog bool
 	// Whether to use the delete range API instead of deleting one by one.
 	useDeleteRange bool
+	// The directory of the SST files uploaded for ingestion.
+	importPath string
+	// The SST files ingested into the write batch, they are deleted after the write batch is written.
+	ingestedSSTs []string
 }
 
 func newApplyContext(tag string, regionScheduler chan<- task, engines *Engines,
@@ -307,6 +323,7 @@ func newApplyContext(tag string, regionScheduler chan<- task, engines *Engines,
 		applyResCh:      applyResCh,
 		enableSyncLog:   cfg.SyncLog,
 		useDeleteRange:  cfg.UseDeleteRange,
+		importPath:      cfg.ImportPath,
 		wb:              new(WriteBatch),
 	}
 }
@@ -359,6 +376,12 @@ func (ac *applyContext) writeToDB() {
 		ac.wbLastBytes = 0
 		ac.wbLastKeys = 0
 	}
+	for _, path := range ac.ingestedSSTs {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.S().Warnf("%s failed to delete ingested sst %s, err %v", ac.tag, path, err)
+		}
+	}
+	ac.ingestedSSTs = ac.ingestedSSTs[:0]
 	doneApply := time.Now()
 	for _, cb := range ac.cbs {
 		cb.invokeAll(doneApply)
@@ -378,6 +401,7 @@ func (ac *applyContext) finishFor(d *applier, results []execResult) {
 		execResults:      results,
 		metrics:          d.metrics,
 		appliedIndexTerm: d.appliedIndexTerm,
+		merged:           d.merged,
 	}
 	ac.applyTaskResList = append(ac.applyTaskResList, res)
 }
@@ -447,6 +471,11 @@ func notifyStaleReq(term uint64, cb *Callback) {
 
 /// Checks if a write is needed to be issued before handling the command.
 func shouldWriteToEngine(rlog raftlog.RaftLog, wbKeys int) bool {
+	// A versioned KV key or an imported key may be written at an older version than the one in the current
+	// write batch, a badger write keeps only one entry for a key, so we must write the current write batch first.
+	if cl, ok := rlog.(*raftlog.CustomRaftLog); ok {
+		return cl.Type() == raftlog.TypeVerMut || cl.Type() == raftlog.TypeImport
+	}
 	cmd := rlog.GetRaftCmdRequest()
 	if cmd == nil {
 		return false
@@ -469,6 +498,9 @@ func shouldWriteToEngine(rlog raftlog.RaftLog, wbKeys int) bool {
 		if req.IngestSst != nil {
 			return true
 		}
+		if cf := req.GetPut().GetCf() + req.GetDelete().GetCf(); cf == CFVer || cf == CFImport {
+			return true
+		}
 	}
 	return false
 }
@@ -892,8 +924,7 @@ func (a *applier) execAdminCmd(aCtx *applyContext, req *raft_cmdpb.RaftCmdReques
 func (a *applier) execWriteCmd(aCtx *applyContext, rlog raftlog.RaftLog) (
 	resp *raft_cmdpb.RaftCmdResponse, result applyResult) {
 	if cl, ok := rlog.(*raftlog.CustomRaftLog); ok {
-		resp = a.execCustomLog(aCtx, cl)
-		return
+		return a.execCustomLog(aCtx, cl)
 	}
 	req := rlog.GetRaftCmdRequest()
 	requests := req.GetRequests()
@@ -907,9 +938,19 @@ func (a *applier) execWriteCmd(aCtx *applyContext, rlog raftlog.RaftLog) (
 			a.execCommit(aCtx, *x)
 		case *rollbackOp:
 			a.execRollback(aCtx, *x)
+		case *rawOp:
+			a.execRaw(aCtx, *x)
+		case *verOp:
+			a.execVer(aCtx, *x)
+		case *importOp:
+			a.execImport(aCtx, *x)
+		case *gcOp:
+			a.execGC(aCtx, *x)
 		case *raft_cmdpb.DeleteRangeRequest:
 			a.execDeleteRange(aCtx, x)
 			rangeDeleted = true
+		case *raft_cmdpb.IngestSSTRequest:
+			a.execIngestSST(aCtx, x)
 		default:
 			log.S().Fatalf("invalid input op=%v", x)
 		}
@@ -933,12 +974,13 @@ func (a *applier) execWriteCmd(aCtx *applyContext, rlog raftlog.RaftLog) (
 }
 
 func (a *applier) execCustomLog(actx *applyContext, cl *raftlog.CustomRaftLog) (
-	resp *raft_cmdpb.RaftCmdResponse) {
+	resp *raft_cmdpb.RaftCmdResponse, result applyResult) {
 	var cnt int
 	switch cl.Type() {
 	case raftlog.TypePrewrite, raftlog.TypePessimisticLock:
 		cl.IterateLock(func(key, val []byte) {
 			actx.wb.SetLock(key, val)
+			appendPrewriteChange(actx.wb, key, val)
 			cnt++
 		})
 	case raftlog.TypeCommit:
@@ -951,6 +993,7 @@ func (a *applier) execCustomLog(actx *applyContext, cl *raftlog.CustomRaftLog) (
 			actx.wb.Rollback(y.KeyWithTs(key, startTS))
 			if deleteLock {
 				actx.wb.DeleteLock(key)
+				actx.wb.AppendChange(mvcc.ChangeEvent{Type: mvcc.ChangeRollback, Key: key, StartTS: startTS})
 			}
 			cnt++
 		})
@@ -959,6 +1002,44 @@ func (a *applier) execCustomLog(actx *applyContext, cl *raftlog.CustomRaftLog) (
 			actx.wb.DeleteLock(key)
 			cnt++
 		})
+	case raftlog.TypeRawPut:
+		cl.IterateRawPut(func(key, val []byte, version uint64) {
+			a.rawPut(actx, key, val, version)
+			cnt++
+		})
+	case raftlog.TypeRawDelete:
+		cl.IterateRawDelete(func(key []byte, version uint64) {
+			a.rawDelete(actx, key, version)
+			cnt++
+		})
+	case raftlog.TypeVerMut:
+		cl.IterateVerMut(func(key, val []byte, version uint64, deleted bool) {
+			if deleted {
+				a.verDelete(actx, key, version)
+			} else {
+				a.verPut(actx, key, val, version)
+			}
+			cnt++
+		})
+	case raftlog.TypeImport:
+		cl.IterateImport(func(key, val []byte, commitTS uint64, deleted bool) {
+			if deleted {
+				a.importDelete(actx, key, commitTS)
+			} else {
+				a.importPut(actx, key, val, commitTS)
+			}
+			cnt++
+		})
+	case raftlog.TypeGC:
+		cl.IterateGC(func(key []byte, version uint64) {
+			actx.wb.Delete(y.KeyWithTs(key, version))
+			cnt++
+		})
+	case raftlog.TypeResolvedTS:
+		result = applyResult{
+			tp:   applyResultTypeExecResult,
+			data: &execResultResolvedTS{ts: cl.ResolvedTS()},
+		}
 	}
 	resp = &raft_cmdpb.RaftCmdResponse{Header: &raft_cmdpb.RaftResponseHeader{}}
 	resp.Responses = make([]*raft_cmdpb.Response, cnt)
@@ -985,16 +1066,31 @@ type rollbackOp struct {
 	delLock    *raft_cmdpb.DeleteRequest
 }
 
+// a raw op is either a put or a delete of a RawKV key.
+type rawOp struct {
+	put *raft_cmdpb.PutRequest
+	del *raft_cmdpb.DeleteRequest
+}
+
+// a ver op is either a put or a delete of a versioned KV key, the version is appended to the key.
+type verOp struct {
+	put *raft_cmdpb.PutRequest
+	del *raft_cmdpb.DeleteRequest
+}
+
+// an import op is either a put or a delete of a committed version, the commit ts is appended to the key.
+type importOp struct {
+	put *raft_cmdpb.PutRequest
+	del *raft_cmdpb.DeleteRequest
+}
+
+// a gc op deletes an obsolete version in the write CF.
+type gcOp struct {
+	delWrite *raft_cmdpb.DeleteRequest
+}
+
 // createWriteCmdOps regroups requests into operations.
 func createWriteCmdOps(requests []*raft_cmdpb.Request) (ops []interface{}) {
-	// If first request is delete write, then this is a GC command, we can ignore it.
-	if len(requests) > 0 {
-		if del := requests[0].Delete; del != nil {
-			if del.Cf == CFWrite {
-				return
-			}
-		}
-	}
 	for i := 0; i < len(requests); i++ {
 		req := requests[i]
 		switch req.CmdType {
@@ -1014,12 +1110,19 @@ func createWriteCmdOps(requests []*raft_cmdpb.Request) (ops []interface{}) {
 				})
 				i += 2
 			case CFWrite:
-				// This is collapse rollback, since we do local rollback GC, we can ignore it.
+				// Rollbacks are not collapsed, the write CF is only deleted by GC.
+				ops = append(ops, &gcOp{delWrite: del})
 			case CFLock:
 				// This is pessimistic rollback.
 				ops = append(ops, &rollbackOp{
 					delLock: req.Delete,
 				})
+			case CFRaw:
+				ops = append(ops, &rawOp{del: del})
+			case CFVer:
+				ops = append(ops, &verOp{del: del})
+			case CFImport:
+				ops = append(ops, &importOp{del: del})
 			default:
 				panic("unreachable")
 			}
@@ -1038,6 +1141,12 @@ func createWriteCmdOps(requests []*raft_cmdpb.Request) (ops []interface{}) {
 			case CFLock:
 				// Prewrite with short value.
 				ops = append(ops, &prewriteOp{putLock: put})
+			case CFRaw:
+				ops = append(ops, &rawOp{put: put})
+			case CFVer:
+				ops = append(ops, &verOp{put: put})
+			case CFImport:
+				ops = append(ops, &importOp{put: put})
 			case CFWrite:
 				writeType := put.Value[0]
 				if writeType == mvcc.WriteTypeRollback {
@@ -1073,7 +1182,7 @@ func createWriteCmdOps(requests []*raft_cmdpb.Request) (ops []interface{}) {
 		case raft_cmdpb.CmdType_DeleteRange:
 			ops = append(ops, &req.DeleteRange)
 		case raft_cmdpb.CmdType_IngestSST:
-			panic("ingestSST not unsupported")
+			ops = append(ops, req.IngestSst)
 		case raft_cmdpb.CmdType_Snap, raft_cmdpb.CmdType_Get:
 			// Readonly commands are handled in raftstore directly.
 			// Don't panic here in case there are old entries need to be applied.
@@ -1090,9 +1199,22 @@ func createWriteCmdOps(requests []*raft_cmdpb.Request) (ops []interface{}) {
 func (a *applier) execPrewrite(aCtx *applyContext, op prewriteOp) {
 	key, value := convertPrewriteToLock(op, aCtx.getTxn())
 	aCtx.wb.SetLock(key, value)
+	appendPrewriteChange(aCtx.wb, key, value)
 	return
 }
 
+// appendPrewriteChange records the prewrite of a lock for the change observer, a pessimistic lock is not a
+// change of the key.
+func appendPrewriteChange(wb *WriteBatch, key, val []byte) {
+	lock := mvcc.DecodeLock(val)
+	if lock.Op == uint8(kvrpcpb.Op_PessimisticLock) {
+		return
+	}
+	wb.AppendChange(mvcc.ChangeEvent{
+		Type: mvcc.ChangePrewrite, Key: key, Op: lock.Op, Value: lock.Value, StartTS: lock.StartTS,
+	})
+}
+
 func convertPrewriteToLock(op prewriteOp, txn *badger.Txn) (key, value []byte) {
 	_, rawKey, err := codec.DecodeBytes(op.putLock.Key, nil)
 	if err != nil {
@@ -1137,6 +1259,9 @@ func (a *applier) commitLock(aCtx *applyContext, rawKey []byte, val []byte, comm
 		a.metrics.sizeDiffHint += uint64(sizeDiff)
 	}
 	aCtx.wb.DeleteLock(rawKey)
+	aCtx.wb.AppendChange(mvcc.ChangeEvent{
+		Type: mvcc.ChangeCommit, Key: rawKey, Op: lock.Op, Value: lock.Value, StartTS: lock.StartTS, CommitTS: commitTS,
+	})
 }
 
 func (a *applier) getLock(aCtx *applyContext, rawKey []byte) []byte {
@@ -1158,9 +1283,11 @@ func (a *applier) execRollback(aCtx *applyContext, op rollbackOp) {
 		if err != nil {
 			panic(op.putWrite.Key)
 		}
-		aCtx.wb.Rollback(y.KeyWithTs(rawKey, mvcc.DecodeKeyTS(remain)))
+		startTS := mvcc.DecodeKeyTS(remain)
+		aCtx.wb.Rollback(y.KeyWithTs(rawKey, startTS))
 		if op.delLock != nil {
 			aCtx.wb.DeleteLock(rawKey)
+			aCtx.wb.AppendChange(mvcc.ChangeEvent{Type: mvcc.ChangeRollback, Key: rawKey, StartTS: startTS})
 		}
 		return
 	}
@@ -1173,6 +1300,99 @@ func (a *applier) execRollback(aCtx *applyContext, op rollbackOp) {
 	}
 }
 
+func (a *applier) execRaw(aCtx *applyContext, op rawOp) {
+	if op.put != nil {
+		key := op.put.Key[:len(op.put.Key)-8]
+		a.rawPut(aCtx, key, op.put.Value, mvcc.DecodeKeyTS(op.put.Key))
+	} else {
+		key := op.del.Key[:len(op.del.Key)-8]
+		a.rawDelete(aCtx, key, mvcc.DecodeKeyTS(op.del.Key))
+	}
+}
+
+func (a *applier) rawPut(aCtx *applyContext, key, val []byte, version uint64) {
+	aCtx.wb.SetWithUserMeta(y.KeyWithTs(mvcc.EncodeRawKey(key), version), val, mvcc.NewRawUserMeta(version))
+	a.metrics.sizeDiffHint += uint64(len(key) + len(val))
+}
+
+func (a *applier) rawDelete(aCtx *applyContext, key []byte, version uint64) {
+	aCtx.wb.Delete(y.KeyWithTs(mvcc.EncodeRawKey(key), version))
+}
+
+func (a *applier) execVer(aCtx *applyContext, op verOp) {
+	if op.put != nil {
+		key := op.put.Key[:len(op.put.Key)-8]
+		a.verPut(aCtx, key, op.put.Value, mvcc.DecodeKeyTS(op.put.Key))
+	} else {
+		key := op.del.Key[:len(op.del.Key)-8]
+		a.verDelete(aCtx, key, mvcc.DecodeKeyTS(op.del.Key))
+	}
+}
+
+func (a *applier) verPut(aCtx *applyContext, key, val []byte, version uint64) {
+	aCtx.wb.SetWithUserMeta(y.KeyWithTs(mvcc.EncodeVerKey(key, version), version), val, mvcc.NewVerUserMeta(version))
+	a.metrics.sizeDiffHint += uint64(len(key) + len(val))
+}
+
+func (a *applier) verDelete(aCtx *applyContext, key []byte, version uint64) {
+	aCtx.wb.Delete(y.KeyWithTs(mvcc.EncodeVerKey(key, version), version))
+}
+
+func (a *applier) execImport(aCtx *applyContext, op importOp) {
+	if op.put != nil {
+		key := op.put.Key[:len(op.put.Key)-8]
+		a.importPut(aCtx, key, op.put.Value, mvcc.DecodeKeyTS(op.put.Key))
+	} else {
+		key := op.del.Key[:len(op.del.Key)-8]
+		a.importDelete(aCtx, key, mvcc.DecodeKeyTS(op.del.Key))
+	}
+}
+
+func (a *applier) importPut(aCtx *applyContext, key, val []byte, commitTS uint64) {
+	aCtx.wb.SetWithUserMeta(y.KeyWithTs(key, commitTS), val, mvcc.NewDBUserMeta(commitTS, commitTS))
+	a.metrics.sizeDiffHint += uint64(len(key) + len(val))
+}
+
+func (a *applier) importDelete(aCtx *applyContext, key []byte, commitTS uint64) {
+	aCtx.wb.Delete(y.KeyWithTs(key, commitTS))
+}
+
+// execIngestSST writes the entries of the uploaded SST file into the write batch, the file is deleted after the
+// write batch is written. The file has been checked by the leader before it is proposed, so an error here is local
+// to the store, like a missing or corrupted file, the command is rolled back and the store panics, since the other
+// stores would ingest the file.
+func (a *applier) execIngestSST(aCtx *applyContext, req *raft_cmdpb.IngestSSTRequest) {
+	meta := req.Sst
+	path, err := importSSTPath(aCtx.importPath, meta)
+	if err == nil {
+		err = CheckImportSSTRange(meta, rawRegionKey(a.region.StartKey), rawRegionKey(a.region.EndKey))
+	}
+	if err == nil {
+		err = IterateImportSST(path, meta, func(key y.Key, value, userMeta []byte) error {
+			if userMeta == nil {
+				aCtx.wb.Delete(key)
+			} else {
+				aCtx.wb.SetWithUserMeta(key, safeCopy(value), userMeta)
+				a.metrics.sizeDiffHint += uint64(len(key.UserKey) + len(value))
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		aCtx.wb.RollbackToSafePoint()
+		panic(fmt.Sprintf("%s failed to ingest sst %x, err %v", a.tag, meta.GetUuid(), err))
+	}
+	aCtx.ingestedSSTs = append(aCtx.ingestedSSTs, path)
+}
+
+func (a *applier) execGC(aCtx *applyContext, op gcOp) {
+	remain, key, err := codec.DecodeBytes(op.delWrite.Key, nil)
+	if err != nil {
+		panic(op.delWrite.Key)
+	}
+	aCtx.wb.Delete(y.KeyWithTs(key, mvcc.DecodeKeyTS(remain)))
+}
+
 func (a *applier) execDeleteRange(aCtx *applyContext, req *raft_cmdpb.DeleteRangeRequest) {
 	_, startKey, err := codec.DecodeBytes(req.StartKey, nil)
 	if err != nil {
@@ -1216,59 +1436,32 @@ func (a *applier) execChangePeer(aCtx *applyContext, req *raft_cmdpb.AdminReques
 	log.S().Infof("%s exec ConfChange, peer_id %d, type %s, epoch %s",
 		a.tag, peer.Id, changeType, region.RegionEpoch)
 
-	// TODO: we should need more check, like peer validation, duplicated id, etc.
+	// The region may have changed since the conf change was proposed, so it's checked again.
+	if err = checkChangePeer(region, changeType, peer); err != nil {
+		log.S().Errorf("%s can't exec ConfChange, %v", a.tag, err)
+		return
+	}
 	region.RegionEpoch.ConfVer++
 
 	switch changeType {
 	case eraftpb.ConfChangeType_AddNode:
-		var exist bool
 		if p := findPeer(region, storeID); p != nil {
-			exist = true
-			if !p.IsLearner || p.Id != peer.Id {
-				errMsg := fmt.Sprintf("%s can't add duplicated peer, peer %s, region %s",
-					a.tag, p, a.region)
-				log.S().Error(errMsg)
-				err = errors.New(errMsg)
-				return
-			}
+			// Promote the learner to a voter.
 			p.IsLearner = false
-		}
-		if !exist {
-			// TODO: Do we allow adding peer in same node?
+		} else {
 			region.Peers = append(region.Peers, peer)
 		}
 		log.S().Infof("%s add peer successfully, peer %s, region %s", a.tag, peer, a.region)
 	case eraftpb.ConfChangeType_RemoveNode:
-		if p := removePeer(region, storeID); p != nil {
-			if !PeerEqual(p, peer) {
-				errMsg := fmt.Sprintf("%s ignore remove unmatched peer, expected_peer %s, got_peer %s",
-					a.tag, peer, p)
-				log.S().Error(errMsg)
-				err = errors.New(errMsg)
-				return
-			}
-			if a.id == peer.Id {
-				// Remove ourself, we will destroy all region data later.
-				// So we need not to apply following logs.
-				a.stopped = true
-				a.pendingRemove = true
-			}
-		} else {
-			errMsg := fmt.Sprintf("%s removing missing peers, peer %s, region %s",
-				a.tag, peer, a.region)
-			log.S().Error(errMsg)
-			err = errors.New(errMsg)
-			return
+		removePeer(region, storeID)
+		if a.id == peer.Id {
+			// Remove ourself, we will destroy all region data later.
+			// So we need not to apply following logs.
+			a.stopped = true
+			a.pendingRemove = true
 		}
 		log.S().Infof("%s remove peer successfully, peer %s, region %s", a.tag, peer, a.region)
 	case eraftpb.ConfChangeType_AddLearnerNode:
-		if findPeer(region, storeID) != nil {
-			errMsg := fmt.Sprintf("%s can't add duplicated learner, peer %s, region %s",
-				a.tag, peer, a.region)
-			log.S().Error(errMsg)
-			err = errors.New(errMsg)
-			return
-		}
 		region.Peers = append(region.Peers, peer)
 		log.S().Infof("%s add learner successfully, peer %s, region %s", a.tag, peer, a.region)
 	}
@@ -1396,17 +1589,135 @@ func (a *applier) execBatchSplit(aCtx *applyContext, req *raft_cmdpb.AdminReques
 
 func (a *applier) execPrepareMerge(aCtx *applyContext, req *raft_cmdpb.AdminRequest) (
 	resp *raft_cmdpb.AdminResponse, result applyResult, err error) {
-	return // TODO: merge
+	prepareMerge := req.PrepareMerge
+	index := prepareMerge.MinIndex
+	firstIndex := firstIndex(aCtx.execCtx.applyState)
+	if index < firstIndex {
+		// We filter `CompactLog` command before.
+		panic(fmt.Sprintf("%s first index %d > min_index %d, skip pre merge", a.tag, firstIndex, index))
+	}
+	region := new(metapb.Region)
+	if err := CloneMsg(a.region, region); err != nil {
+		panic(err)
+	}
+	region.RegionEpoch.Version++
+	// In theory conf version should not be increased when executing prepare_merge.
+	// However, we don't want to do conf change after prepare_merge is committed.
+	// This can also be done by iterating all proposal to find if prepare_merge is
+	// proposed before proposing conf change, but it make things complicated.
+	// Another way is make conf change also check region version, but this is not
+	// backward compatible.
+	region.RegionEpoch.ConfVer++
+	mergingState := &rspb.MergeState{
+		MinIndex: index,
+		Target:   prepareMerge.Target,
+		Commit:   aCtx.execCtx.index,
+	}
+	WritePeerState(aCtx.wb, region, rspb.PeerState_Merging, mergingState)
+	resp = new(raft_cmdpb.AdminResponse)
+	result = applyResult{tp: applyResultTypeExecResult, data: &execResultPrepareMerge{
+		region: region,
+		state:  mergingState,
+	}}
+	return
 }
 
+// The source peer may not have applied all the logs before `CommitMerge`, so the merge process
+// order would be:
+// 1. `execCommitMerge` in target applier asks the source applier to catch up logs and waits.
+// 2. `catchUpLogsForMerge` in source applier applies the missing logs and stops itself.
+// 3. `resumePendingMerge` in target applier executes the `CommitMerge` again.
+// 4. `onReadyCommitMerge` in target peer waits until the source peer has handled `PrepareMerge`.
 func (a *applier) execCommitMerge(aCtx *applyContext, req *raft_cmdpb.AdminRequest) (
 	resp *raft_cmdpb.AdminResponse, result applyResult, err error) {
-	return // TODO: merge
+	merge := req.CommitMerge
+	source := merge.Source
+	sourceRegionID := source.Id
+
+	// No matter whether the source peer has applied to the required index,
+	// it's a race to write apply state in both source applier and target
+	// applier. So asking the source applier to stop first.
+	if a.readySourceRegion != sourceRegionID {
+		if a.readySourceRegion != 0 {
+			panic(fmt.Sprintf("%s unexpected ready source region %d, expecting %d",
+				a.tag, a.readySourceRegion, sourceRegionID))
+		}
+		log.S().Infof("%s asking applier of region %d to stop", a.tag, sourceRegionID)
+		readyToMerge := atomic.NewUint64(0)
+		aCtx.applyMsgs.appendMsg(sourceRegionID, NewPeerMsg(MsgTypeApplyCatchUpLogs, sourceRegionID, &catchUpLogs{
+			targetRegionID: a.region.Id,
+			merge:          merge,
+			readyToMerge:   readyToMerge,
+		}))
+		resp = new(raft_cmdpb.AdminResponse)
+		result = applyResult{tp: applyResultTypeWaitMergeResource, data: readyToMerge}
+		return
+	}
+
+	log.S().Infof("%s execute CommitMerge, commit %d, entries %d, term %d, index %d, source %s",
+		a.tag, merge.Commit, len(merge.Entries), aCtx.execCtx.term, aCtx.execCtx.index, source)
+	a.readySourceRegion = 0
+
+	state, err1 := getRegionLocalState(aCtx.engines.kv.DB, sourceRegionID)
+	if err1 != nil {
+		panic(fmt.Sprintf("%s failed to get region state of %s, %v", a.tag, source, err1))
+	}
+	if state.State != rspb.PeerState_Merging {
+		panic(fmt.Sprintf("%s unexpected state of merging region %s", a.tag, state))
+	}
+	if !RegionEqual(state.Region, source) {
+		panic(fmt.Sprintf("%s source region %s not match exist region %s", a.tag, source, state.Region))
+	}
+	region := new(metapb.Region)
+	if err := CloneMsg(a.region, region); err != nil {
+		panic(err)
+	}
+	// Use a max value so that pd can ensure overlapped region has a priority.
+	version := source.RegionEpoch.Version
+	if version < region.RegionEpoch.Version {
+		version = region.RegionEpoch.Version
+	}
+	region.RegionEpoch.Version = version + 1
+	if len(region.EndKey) > 0 && bytes.Equal(region.EndKey, source.StartKey) {
+		region.EndKey = source.EndKey
+	} else {
+		region.StartKey = source.StartKey
+	}
+	WritePeerState(aCtx.wb, region, rspb.PeerState_Normal, nil)
+	// Mark the source region as tombstone to prevent it from recovering.
+	WritePeerState(aCtx.wb, source, rspb.PeerState_Tombstone, &rspb.MergeState{Target: a.region})
+	resp = new(raft_cmdpb.AdminResponse)
+	result = applyResult{tp: applyResultTypeExecResult, data: &execResultCommitMerge{
+		region: region,
+		source: source,
+	}}
+	return
 }
 
 func (a *applier) execRollbackMerge(aCtx *applyContext, req *raft_cmdpb.AdminRequest) (
 	resp *raft_cmdpb.AdminResponse, result applyResult, err error) {
-	return // TODO: merge
+	state, err1 := getRegionLocalState(aCtx.engines.kv.DB, a.region.Id)
+	if err1 != nil {
+		panic(fmt.Sprintf("%s failed to get region state, %v", a.tag, err1))
+	}
+	if state.State != rspb.PeerState_Merging {
+		panic(fmt.Sprintf("%s unexpected state of rollback merge region %s", a.tag, state))
+	}
+	rollback := req.RollbackMerge
+	if state.MergeState.Commit != rollback.Commit {
+		panic(fmt.Sprintf("%s rollback commit %d not match merge commit %d",
+			a.tag, rollback.Commit, state.MergeState.Commit))
+	}
+	region := state.Region
+	// Update version to avoid duplicated rollback requests.
+	region.RegionEpoch.Version++
+	WritePeerState(aCtx.wb, region, rspb.PeerState_Normal, nil)
+	resp = new(raft_cmdpb.AdminResponse)
+	result = applyResult{tp: applyResultTypeExecResult, data: &execResultRollbackMerge{
+		region: region,
+		commit: rollback.Commit,
+	}}
+	return
 }
 
 func (a *applier) execCompactLog(aCtx *applyContext, req *raft_cmdpb.AdminRequest) (
@@ -1446,10 +1757,82 @@ func (a *applier) execCompactLog(aCtx *applyContext, req *raft_cmdpb.AdminReques
 func (a *applier) execComputeHash(aCtx *applyContext, req *raft_cmdpb.AdminRequest) (
 	resp *raft_cmdpb.AdminResponse, result applyResult, err error) {
 	resp = new(raft_cmdpb.AdminResponse)
-	// TODO: run in goroutine.
+	// The write batch is written before the command, so the DB and the lock store are at the applied index. Badger
+	// has no snapshot that hides the writes applied later, so the hash is computed on the apply path.
+	txn := aCtx.engines.kv.DB.NewTransaction(false)
+	defer txn.Discard()
+	hash, err := computeRegionHash(txn, aCtx.engines.kv.LockStore, a.region)
+	if err != nil {
+		panic(fmt.Sprintf("%s failed to compute hash at index %d: %v", a.tag, aCtx.execCtx.index, err))
+	}
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, hash)
+	result = applyResult{tp: applyResultTypeExecResult, data: &execResultComputeHash{
+		index: aCtx.execCtx.index,
+		hash:  sum,
+	}}
 	return
 }
 
+/// computeRegionHash calculates the crc32 checksum of the region's data, which includes the locks, the latest version
+/// of the data keys, the rollback and op lock records, the latest version of the raw keys and the versioned keys.
+/// The older versions and the deletes are dropped by the compaction of each store separately, so they are not hashed.
+func computeRegionHash(txn *badger.Txn, lockStore *lockstore.MemStore, region *metapb.Region) (uint32, error) {
+	digest := crc32.NewIEEE()
+	startKey, endKey := RawStartKey(region), RawEndKey(region)
+
+	lockIt := lockStore.NewIterator()
+	for lockIt.Seek(startKey); lockIt.Valid() && bytes.Compare(lockIt.Key(), endKey) < 0; lockIt.Next() {
+		digest.Write(lockIt.Key())
+		digest.Write(lockIt.Value())
+	}
+
+	txn.SetReadTS(math.MaxUint64)
+	hashRange := func(start, end []byte, skip func(item *badger.Item) bool) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(start); it.Valid() && bytes.Compare(it.Item().Key(), end) < 0; it.Next() {
+			item := it.Item()
+			if skip(item) {
+				continue
+			}
+			val, err := item.Value()
+			if err != nil {
+				return err
+			}
+			digest.Write(item.Key())
+			digest.Write(item.UserMeta())
+			digest.Write(val)
+		}
+		return nil
+	}
+	// The MVCC deletes have no value, the extra keys are hashed with the region of the keys they belong to.
+	err := hashRange(startKey, endKey, func(item *badger.Item) bool {
+		return isExtraKey(item.Key()) || item.IsEmpty()
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The rollback records have no value, but they are kept until they are deleted by GC through raft.
+	extraStart, extraEnd := mvcc.EncodeExtraTxnStatusKey(startKey, math.MaxUint64), mvcc.EncodeExtraTxnStatusKey(endKey, 0)
+	err = hashRange(extraStart, extraEnd, func(item *badger.Item) bool {
+		return !isExtraKey(item.Key())
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Every version of a versioned key is a separate key, the deletes of the versioned keys are hashed too.
+	for _, r := range prefixedKeyRanges(startKey, endKey) {
+		err = hashRange(r.startKey, r.endKey, func(item *badger.Item) bool {
+			return item.IsEmpty() && !bytes.HasPrefix(item.Key(), mvcc.VerKeyPrefix)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return digest.Sum32(), nil
+}
+
 func (a *applier) execVerifyHash(aCtx *applyContext, req *raft_cmdpb.AdminRequest) (
 	resp *raft_cmdpb.AdminResponse, result applyResult, err error) {
 	verifyReq := req.VerifyHash
@@ -1462,8 +1845,9 @@ func (a *applier) execVerifyHash(aCtx *applyContext, req *raft_cmdpb.AdminReques
 }
 
 type catchUpLogs struct {
-	merge        *raft_cmdpb.CommitMergeRequest
-	readyToMerge *atomic.Uint64
+	targetRegionID uint64
+	merge          *raft_cmdpb.CommitMergeRequest
+	readyToMerge   *atomic.Uint64
 }
 
 func newApplierFromPeer(peer *peerFsm) *applier {
@@ -1562,12 +1946,98 @@ func (a *applier) handleDestroy(aCtx *applyContext, regionID uint64) {
 	}
 }
 
+/// Resumes the pending `CommitMerge` if the source applier has caught up its logs.
+///
+/// Returns false if the applier is still waiting for a source applier.
 func (a *applier) resumePendingMerge(aCtx *applyContext) bool {
-	return false // TODO: merge
+	state := a.waitMergeState
+	sourceRegionID := state.readyToMerge.Load()
+	if sourceRegionID == 0 {
+		return false
+	}
+	if aCtx.timer == nil {
+		now := time.Now()
+		aCtx.timer = &now
+	}
+	log.S().Infof("%s source region %d is ready to merge, resume pending merge", a.tag, sourceRegionID)
+	a.readySourceRegion = sourceRegionID
+	a.waitMergeState = nil
+	a.handleRaftCommittedEntries(aCtx, state.pendingEntries)
+	if a.waitMergeState != nil {
+		// Another CommitMerge in the pending entries needs to wait for its source.
+		a.waitMergeState.pendingMsgs = state.pendingMsgs
+		a.waitMergeState.catchUpLogs = state.catchUpLogs
+		return false
+	}
+	if a.pendingRemove {
+		a.destroy(aCtx)
+	}
+	for i, msg := range state.pendingMsgs {
+		a.handleTask(aCtx, msg)
+		if a.waitMergeState != nil {
+			a.waitMergeState.pendingMsgs = append(a.waitMergeState.pendingMsgs, state.pendingMsgs[i+1:]...)
+			a.waitMergeState.catchUpLogs = state.catchUpLogs
+			return false
+		}
+	}
+	if state.catchUpLogs != nil {
+		a.catchUpLogsForMerge(aCtx, state.catchUpLogs)
+	}
+	return true
 }
 
+/// Applies the logs of the source region up to the commit index of `CommitMerge`, then
+/// stops the applier and notifies the target applier to continue the merge.
 func (a *applier) catchUpLogsForMerge(aCtx *applyContext, logs *catchUpLogs) {
-	// TODO: merge
+	if aCtx.timer == nil {
+		now := time.Now()
+		aCtx.timer = &now
+	}
+	appliedIndex := a.applyState.appliedIndex
+	if appliedIndex < logs.merge.Commit && !a.stopped {
+		entries, err := a.entriesForCatchUp(aCtx, logs.merge)
+		if err != nil {
+			panic(fmt.Sprintf("%s failed to fetch entries for merge, %v", a.tag, err))
+		}
+		log.S().Infof("%s catch up logs for merge, applied %d, commit %d", a.tag, appliedIndex, logs.merge.Commit)
+		a.merged = true
+		a.metrics = applyMetrics{}
+		a.handleRaftCommittedEntries(aCtx, entries)
+		if a.waitMergeState != nil {
+			// The logs contain a CommitMerge, the cascaded merge needs to be finished first.
+			a.waitMergeState.catchUpLogs = logs
+			return
+		}
+	}
+	if !a.stopped {
+		a.destroy(aCtx)
+	}
+	logs.readyToMerge.Store(a.region.Id)
+	aCtx.applyMsgs.appendMsg(logs.targetRegionID, NewPeerMsg(MsgTypeApplyLogsUpToDate, logs.targetRegionID, nil))
+}
+
+/// Returns the entries in (applied_index, commit] of `CommitMerge`. Entries before the ones carried
+/// by the request have been replicated to all peers, so they can be read from the local raft log.
+func (a *applier) entriesForCatchUp(aCtx *applyContext, merge *raft_cmdpb.CommitMergeRequest) ([]eraftpb.Entry, error) {
+	low := a.applyState.appliedIndex + 1
+	high := merge.Commit + 1
+	if len(merge.Entries) > 0 {
+		high = merge.Entries[0].Index
+	}
+	var entries []eraftpb.Entry
+	if low < high {
+		var err error
+		entries, _, err = fetchEntriesTo(aCtx.engines.raft, a.region.Id, low, high, math.MaxUint64, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range merge.Entries {
+		if entry.Index >= low && entry.Index <= merge.Commit {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
 }
 
 func (a *applier) handleGenSnapshot(aCtx *applyContext, snapTask *GenSnapTask) {
@@ -1585,6 +2055,13 @@ func (a *applier) handleGenSnapshot(aCtx *applyContext, snapTask *GenSnapTask) {
 }
 
 func (a *applier) handleTask(aCtx *applyContext, msg Msg) {
+	if a.waitMergeState != nil && !a.resumePendingMerge(aCtx) {
+		// The source applier hasn't caught up its logs, handle the message after resuming.
+		if msg.Type != MsgTypeApplyLogsUpToDate {
+			a.waitMergeState.pendingMsgs = append(a.waitMergeState.pendingMsgs, msg)
+		}
+		return
+	}
 	switch msg.Type {
 	case MsgTypeApply:
 		a.handleApply(aCtx, msg.Data.(*apply))
@@ -0,0 +1,570 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/coocood/badger"
+	"github.com/coocood/badger/y"
+	"github.com/ngaut/unistore/rocksdb"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
+	"github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/raft_cmdpb"
+	rspb "github.com/pingcap/kvproto/pkg/raft_serverpb"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestApplier(t *testing.T, engines *Engines, region *metapb.Region) *applier {
+	wb := new(WriteBatch)
+	WritePeerState(wb, region, rspb.PeerState_Normal, nil)
+	writeInitialApplyState(wb, region.Id)
+	require.Nil(t, wb.WriteToKV(engines.kv))
+	return newApplier(&registration{
+		id:   region.Peers[0].Id,
+		term: RaftInitLogTerm,
+		applyState: applyState{
+			appliedIndex:   RaftInitLogIndex,
+			truncatedIndex: RaftInitLogIndex,
+			truncatedTerm:  RaftInitLogTerm,
+		},
+		appliedIndexTerm: RaftInitLogTerm,
+		region:           region,
+	})
+}
+
+func newTestAdminEntry(t *testing.T, index uint64, region *metapb.Region, admin *raft_cmdpb.AdminRequest) eraftpb.Entry {
+	req := newAdminRequest(region.Id, region.Peers[0])
+	req.Header.RegionEpoch = region.RegionEpoch
+	req.AdminRequest = admin
+	data, err := req.Marshal()
+	require.Nil(t, err)
+	return eraftpb.Entry{Index: index, Term: RaftInitLogTerm, Data: data}
+}
+
+func TestApplyMerge(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	source := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		EndKey:      []byte("k"),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	target := newTestApplier(t, engines, &metapb.Region{
+		Id:          3,
+		StartKey:    []byte("k"),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 4, StoreId: 1}},
+	})
+	appliers := map[uint64]*applier{1: source, 3: target}
+	applyResCh := make(chan Msg, 16)
+	aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+
+	// The source region hasn't applied PrepareMerge, it is carried by CommitMerge.
+	prepareMerge := newTestAdminEntry(t, 6, source.region, &raft_cmdpb.AdminRequest{
+		CmdType:      raft_cmdpb.AdminCmdType_PrepareMerge,
+		PrepareMerge: &raft_cmdpb.PrepareMergeRequest{MinIndex: 6, Target: target.region},
+	})
+	mergedSource := &metapb.Region{
+		Id:          1,
+		EndKey:      []byte("k"),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 2, Version: 2},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	}
+	commitMerge := newTestAdminEntry(t, 6, target.region, &raft_cmdpb.AdminRequest{
+		CmdType: raft_cmdpb.AdminCmdType_CommitMerge,
+		CommitMerge: &raft_cmdpb.CommitMergeRequest{
+			Source:  mergedSource,
+			Commit:  6,
+			Entries: []*eraftpb.Entry{&prepareMerge},
+		},
+	})
+	target.handleTask(aCtx, newApplyMsg(&apply{regionId: 3, term: RaftInitLogTerm, entries: []eraftpb.Entry{commitMerge}}))
+	require.NotNil(t, target.waitMergeState)
+
+	for len(aCtx.applyMsgs.msgs) > 0 {
+		msgs := aCtx.applyMsgs.msgs
+		aCtx.applyMsgs.msgs = nil
+		for _, msg := range msgs {
+			appliers[msg.RegionID].handleTask(aCtx, msg)
+		}
+	}
+	aCtx.flush()
+
+	assert.True(t, source.stopped)
+	assert.Nil(t, target.waitMergeState)
+	assert.Equal(t, uint64(6), target.applyState.appliedIndex)
+	assert.Len(t, target.region.StartKey, 0)
+	assert.Len(t, target.region.EndKey, 0)
+	assert.Equal(t, uint64(3), target.region.RegionEpoch.Version)
+
+	sourceState, err := getRegionLocalState(engines.kv.DB, 1)
+	require.Nil(t, err)
+	assert.Equal(t, rspb.PeerState_Tombstone, sourceState.State)
+	assert.Equal(t, uint64(3), sourceState.MergeState.Target.Id)
+	targetState, err := getRegionLocalState(engines.kv.DB, 3)
+	require.Nil(t, err)
+	assert.Equal(t, rspb.PeerState_Normal, targetState.State)
+	assert.Equal(t, uint64(3), targetState.Region.RegionEpoch.Version)
+
+	var sourceMerged, targetMerged bool
+	for len(applyResCh) > 0 {
+		res := (<-applyResCh).Data.(*applyTaskRes)
+		for _, result := range res.execResults {
+			switch x := result.(type) {
+			case *execResultPrepareMerge:
+				assert.Equal(t, uint64(1), res.regionID)
+				assert.True(t, res.merged)
+				assert.Equal(t, uint64(6), x.state.Commit)
+				sourceMerged = true
+			case *execResultCommitMerge:
+				assert.Equal(t, uint64(3), res.regionID)
+				assert.Equal(t, uint64(1), x.source.Id)
+				targetMerged = true
+			}
+		}
+	}
+	assert.True(t, sourceMerged)
+	assert.True(t, targetMerged)
+}
+
+func TestApplyRollbackMerge(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	source := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		EndKey:      []byte("k"),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	targetRegion := &metapb.Region{
+		Id:          3,
+		StartKey:    []byte("k"),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 4, StoreId: 1}},
+	}
+	applyResCh := make(chan Msg, 16)
+	aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+
+	prepareMerge := newTestAdminEntry(t, 6, source.region, &raft_cmdpb.AdminRequest{
+		CmdType:      raft_cmdpb.AdminCmdType_PrepareMerge,
+		PrepareMerge: &raft_cmdpb.PrepareMergeRequest{MinIndex: 6, Target: targetRegion},
+	})
+	source.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: []eraftpb.Entry{prepareMerge}}))
+	assert.True(t, source.isMerging)
+	assert.Equal(t, uint64(2), source.region.RegionEpoch.Version)
+	assert.Equal(t, uint64(2), source.region.RegionEpoch.ConfVer)
+
+	rollbackMerge := newTestAdminEntry(t, 7, source.region, &raft_cmdpb.AdminRequest{
+		CmdType:       raft_cmdpb.AdminCmdType_RollbackMerge,
+		RollbackMerge: &raft_cmdpb.RollbackMergeRequest{Commit: 6},
+	})
+	source.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: []eraftpb.Entry{rollbackMerge}}))
+	aCtx.flush()
+	assert.False(t, source.isMerging)
+	assert.Equal(t, uint64(3), source.region.RegionEpoch.Version)
+
+	state, err := getRegionLocalState(engines.kv.DB, 1)
+	require.Nil(t, err)
+	assert.Equal(t, rspb.PeerState_Normal, state.State)
+	assert.Nil(t, state.MergeState)
+	assert.Equal(t, uint64(3), state.Region.RegionEpoch.Version)
+}
+
+func TestApplyComputeHash(t *testing.T) {
+	region := &metapb.Region{
+		Id:          1,
+		EndKey:      codec.EncodeBytes(nil, []byte("n")),
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	}
+	newReplica := func(diverge func(wb *WriteBatch)) *Engines {
+		engines := newTestEngines(t)
+		wb := new(WriteBatch)
+		wb.SetWithUserMeta(y.KeyWithTs([]byte("ma"), 2), []byte("v2"), mvcc.NewDBUserMeta(1, 2))
+		wb.SetWithUserMeta(y.KeyWithTs([]byte("ma"), 4), []byte("v4"), mvcc.NewDBUserMeta(3, 4))
+		wb.SetWithUserMeta(y.KeyWithTs([]byte("mb"), 2), []byte("v2"), mvcc.NewDBUserMeta(1, 2))
+		wb.SetWithUserMeta(y.KeyWithTs([]byte("mb"), 4), nil, mvcc.NewDBUserMeta(3, 4))
+		wb.Rollback(y.KeyWithTs([]byte("mc"), 5))
+		wb.SetLock([]byte("md"), []byte("lock"))
+		wb.Set(y.KeyWithTs(mvcc.EncodeRawKey([]byte("ma")), 1), []byte("raw"))
+		wb.Set(y.KeyWithTs(mvcc.EncodeVerKey([]byte("ma"), 1), 1), []byte("ver"))
+		if diverge != nil {
+			diverge(wb)
+		}
+		require.Nil(t, wb.WriteToKV(engines.kv))
+		return engines
+	}
+	hash := func(engines *Engines) []byte {
+		a := newTestApplier(t, engines, region)
+		applyResCh := make(chan Msg, 16)
+		aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+		entry := newTestAdminEntry(t, 6, region, &raft_cmdpb.AdminRequest{
+			CmdType: raft_cmdpb.AdminCmdType_ComputeHash,
+		})
+		a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: []eraftpb.Entry{entry}}))
+		aCtx.flush()
+		var result *execResultComputeHash
+		for len(applyResCh) > 0 {
+			res := (<-applyResCh).Data.(*applyTaskRes)
+			for _, r := range res.execResults {
+				if x, ok := r.(*execResultComputeHash); ok {
+					result = x
+				}
+			}
+		}
+		require.NotNil(t, result)
+		assert.Equal(t, uint64(6), result.index)
+		return result.hash
+	}
+	// Two replicas with the same data.
+	replicas := [2]*Engines{newReplica(nil), newReplica(nil)}
+	defer cleanUpTestEngineData(replicas[0])
+	defer cleanUpTestEngineData(replicas[1])
+	expected := hash(replicas[0])
+	assert.Equal(t, expected, hash(replicas[1]))
+
+	// The old versions and the deletes dropped by the compaction of a store and the keys out of the region don't
+	// change the hash.
+	wb := new(WriteBatch)
+	wb.Delete(y.KeyWithTs([]byte("ma"), 2))
+	wb.Delete(y.KeyWithTs([]byte("mb"), 4))
+	wb.SetWithUserMeta(y.KeyWithTs([]byte("t"), 2), []byte("v2"), mvcc.NewDBUserMeta(1, 2))
+	require.Nil(t, wb.WriteToKV(replicas[1].kv))
+	assert.Equal(t, expected, hash(replicas[1]))
+
+	// A diverged data key, rollback record, lock, raw key or versioned key changes the hash.
+	diverges := []func(wb *WriteBatch){
+		func(wb *WriteBatch) {
+			wb.SetWithUserMeta(y.KeyWithTs([]byte("ma"), 6), []byte("v6"), mvcc.NewDBUserMeta(5, 6))
+		},
+		func(wb *WriteBatch) { wb.Rollback(y.KeyWithTs([]byte("ma"), 7)) },
+		func(wb *WriteBatch) { wb.SetLock([]byte("me"), []byte("lock")) },
+		func(wb *WriteBatch) { wb.Set(y.KeyWithTs(mvcc.EncodeRawKey([]byte("mb")), 1), []byte("raw")) },
+		func(wb *WriteBatch) { wb.Set(y.KeyWithTs(mvcc.EncodeVerKey([]byte("ma"), 2), 2), []byte("ver")) },
+	}
+	for i, diverge := range diverges {
+		engines := newReplica(diverge)
+		defer cleanUpTestEngineData(engines)
+		assert.NotEqual(t, expected, hash(engines), "diverge %d", i)
+	}
+}
+
+func TestApplyVerMut(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	a := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	applyResCh := make(chan Msg, 16)
+	aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+	ctx := &kvrpcpb.Context{RegionId: 1, RegionEpoch: a.region.RegionEpoch, Peer: a.region.Peers[0], Term: RaftInitLogTerm}
+
+	wb := &raftWriteBatch{ctx: ctx}
+	wb.VerPut([]byte("a"), []byte("a10"), 10)
+	wb.VerPut([]byte("b"), []byte("b10"), 10)
+	data, err := (&raft_cmdpb.RaftCmdRequest{
+		Header:   &raft_cmdpb.RaftRequestHeader{RegionId: 1, Peer: ctx.Peer, RegionEpoch: ctx.RegionEpoch},
+		Requests: wb.requests,
+	}).Marshal()
+	require.Nil(t, err)
+	customWB := NewCustomWriteBatch(0, 0, ctx)
+	customWB.VerPut([]byte("a"), []byte("a20"), 20)
+	customWB.VerPut([]byte("b"), nil, 20)
+	wb = &raftWriteBatch{ctx: ctx}
+	wb.VerPut([]byte("c"), []byte("c10"), 10)
+	wb.VerDelete([]byte("a"), 10)
+	data2, err := (&raft_cmdpb.RaftCmdRequest{
+		Header:   &raft_cmdpb.RaftRequestHeader{RegionId: 1, Peer: ctx.Peer, RegionEpoch: ctx.RegionEpoch},
+		Requests: wb.requests,
+	}).Marshal()
+	require.Nil(t, err)
+	customWB2 := NewCustomWriteBatch(0, 0, ctx)
+	customWB2.VerDelete([]byte("c"), 10)
+	entries := []eraftpb.Entry{
+		{Index: 6, Term: RaftInitLogTerm, Data: data},
+		{Index: 7, Term: RaftInitLogTerm, Data: customWB.(*customWriteBatch).builder.Build().Marshal()},
+		{Index: 8, Term: RaftInitLogTerm, Data: data2},
+		{Index: 9, Term: RaftInitLogTerm, Data: customWB2.(*customWriteBatch).builder.Build().Marshal()},
+	}
+	a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: entries}))
+	aCtx.flush()
+
+	// Every version is a separate key, a delete written by the client is recorded as an empty value, VerDelete
+	// removes the version.
+	versions := map[string]map[uint64]string{}
+	txn := engines.kv.DB.NewTransaction(false)
+	defer txn.Discard()
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Seek(mvcc.VerKeyPrefix); it.ValidForPrefix(mvcc.VerKeyPrefix); it.Next() {
+		item := it.Item()
+		key, version, err := mvcc.DecodeVerKey(item.Key())
+		require.Nil(t, err)
+		assert.Equal(t, version, item.Version())
+		if versions[string(key)] == nil {
+			versions[string(key)] = map[uint64]string{}
+		}
+		val, err := item.Value()
+		require.Nil(t, err)
+		versions[string(key)][version] = string(val)
+	}
+	assert.Equal(t, map[string]map[uint64]string{
+		"a": {20: "a20"},
+		"b": {10: "b10", 20: ""},
+	}, versions)
+}
+
+func TestApplyImport(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	a := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	applyResCh := make(chan Msg, 16)
+	aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+	ctx := &kvrpcpb.Context{RegionId: 1, RegionEpoch: a.region.RegionEpoch, Peer: a.region.Peers[0], Term: RaftInitLogTerm}
+
+	wb := &raftWriteBatch{ctx: ctx}
+	wb.ImportPut([]byte("a"), []byte("a20"), 20)
+	wb.ImportPut([]byte("b"), []byte("b10"), 10)
+	data, err := (&raft_cmdpb.RaftCmdRequest{
+		Header:   &raft_cmdpb.RaftRequestHeader{RegionId: 1, Peer: ctx.Peer, RegionEpoch: ctx.RegionEpoch},
+		Requests: wb.requests,
+	}).Marshal()
+	require.Nil(t, err)
+	// The imported versions may be older than the existing versions.
+	customWB := NewCustomWriteBatch(0, 0, ctx)
+	customWB.ImportPut([]byte("a"), []byte("a10"), 10)
+	customWB.ImportDelete([]byte("b"), 20)
+	entries := []eraftpb.Entry{
+		{Index: 6, Term: RaftInitLogTerm, Data: data},
+		{Index: 7, Term: RaftInitLogTerm, Data: customWB.(*customWriteBatch).builder.Build().Marshal()},
+	}
+	a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: entries}))
+	aCtx.flush()
+
+	// The versions of a key in badger, a delete is recorded as an empty value.
+	versions := map[string]map[uint64]string{}
+	txn := engines.kv.DB.NewTransaction(false)
+	defer txn.Discard()
+	it := txn.NewIterator(badger.IteratorOptions{AllVersions: true})
+	defer it.Close()
+	for it.Seek([]byte("a")); it.Valid() && bytes.Compare(it.Item().Key(), []byte("c")) < 0; it.Next() {
+		item := it.Item()
+		key := string(item.Key())
+		if versions[key] == nil {
+			versions[key] = map[uint64]string{}
+		}
+		val, err := item.Value()
+		require.Nil(t, err)
+		versions[key][item.Version()] = string(val)
+	}
+	assert.Equal(t, map[string]map[uint64]string{
+		"a": {10: "a10", 20: "a20"},
+		"b": {10: "b10", 20: ""},
+	}, versions)
+}
+
+func TestApplyMixedGC(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	a := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	applyResCh := make(chan Msg, 16)
+	aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+	ctx := &kvrpcpb.Context{RegionId: 1, RegionEpoch: a.region.RegionEpoch, Peer: a.region.Peers[0], Term: RaftInitLogTerm}
+	wb := new(WriteBatch)
+	wb.SetWithUserMeta(y.KeyWithTs([]byte("ta"), 10), nil, mvcc.NewDBUserMeta(5, 10))
+	require.Nil(t, wb.WriteToKV(engines.kv))
+
+	// A GC delete followed by other requests in the same command.
+	rwb := &raftWriteBatch{ctx: ctx}
+	rwb.DeleteVersion([]byte("ta"), 10)
+	rwb.RawPut([]byte("tb"), []byte("b1"), 20)
+	data, err := (&raft_cmdpb.RaftCmdRequest{
+		Header:   &raft_cmdpb.RaftRequestHeader{RegionId: 1, Peer: ctx.Peer, RegionEpoch: ctx.RegionEpoch},
+		Requests: rwb.requests,
+	}).Marshal()
+	require.Nil(t, err)
+	entries := []eraftpb.Entry{{Index: 6, Term: RaftInitLogTerm, Data: data}}
+	a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: entries}))
+	aCtx.flush()
+
+	txn := engines.kv.DB.NewTransaction(false)
+	defer txn.Discard()
+	_, err = txn.Get([]byte("ta"))
+	assert.Equal(t, badger.ErrKeyNotFound, err)
+	item, err := txn.Get(mvcc.EncodeRawKey([]byte("tb")))
+	require.Nil(t, err)
+	val, err := item.Value()
+	require.Nil(t, err)
+	assert.Equal(t, "b1", string(val))
+}
+
+func TestApplyResolvedTS(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	a := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	applyResCh := make(chan Msg, 16)
+	aCtx := newApplyContext("", nil, engines, applyResCh, NewDefaultConfig())
+
+	b := raftlog.NewBuilder(raftlog.CustomHeader{
+		RegionID: 1,
+		Epoch:    raftlog.NewEpoch(1, 1),
+		PeerID:   2,
+		StoreID:  1,
+		Term:     RaftInitLogTerm,
+	})
+	b.SetType(raftlog.TypeResolvedTS)
+	b.SetResolvedTS(100)
+	entry := eraftpb.Entry{Index: 6, Term: RaftInitLogTerm, Data: b.Build().Marshal()}
+	a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: []eraftpb.Entry{entry}}))
+	aCtx.flush()
+
+	var result *execResultResolvedTS
+	for len(applyResCh) > 0 {
+		res := (<-applyResCh).Data.(*applyTaskRes)
+		for _, r := range res.execResults {
+			if x, ok := r.(*execResultResolvedTS); ok {
+				result = x
+			}
+		}
+	}
+	require.NotNil(t, result)
+	assert.Equal(t, uint64(100), result.ts)
+}
+
+// writeTestImportSST writes the puts into the SST file of the meta in the import directory.
+func writeTestImportSST(t *testing.T, dir string, meta *import_sstpb.SSTMeta, puts map[string]uint64) {
+	path, err := importSSTPath(dir, meta)
+	require.Nil(t, err)
+	f, err := os.Create(path)
+	require.Nil(t, err)
+	keys := make([]string, 0, len(puts))
+	for key := range puts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	w := rocksdb.NewSstFileWriter(f, rocksdb.NewDefaultBlockBasedTableOptions(bytes.Compare))
+	for _, key := range keys {
+		require.Nil(t, w.Put(EncodeImportSSTKey([]byte(key), puts[key]), []byte(key)))
+	}
+	require.Nil(t, w.Finish())
+	require.Nil(t, w.Close())
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	meta.Crc32 = crc32.ChecksumIEEE(data)
+	meta.Length = uint64(len(data))
+}
+
+func TestApplyIngestSST(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	importDir, err := ioutil.TempDir("", "unistore_import")
+	require.Nil(t, err)
+	defer os.RemoveAll(importDir)
+	a := newTestApplier(t, engines, &metapb.Region{
+		Id:          1,
+		RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+		Peers:       []*metapb.Peer{{Id: 2, StoreId: 1}},
+	})
+	cfg := NewDefaultConfig()
+	cfg.ImportPath = importDir
+	header := &raft_cmdpb.RaftRequestHeader{RegionId: 1, Peer: a.region.Peers[0], RegionEpoch: a.region.RegionEpoch}
+	newIngestEntry := func(index uint64, meta *import_sstpb.SSTMeta) eraftpb.Entry {
+		data, err := (&raft_cmdpb.RaftCmdRequest{
+			Header: header,
+			Requests: []*raft_cmdpb.Request{{
+				CmdType:   raft_cmdpb.CmdType_IngestSST,
+				IngestSst: &raft_cmdpb.IngestSSTRequest{Sst: meta},
+			}},
+		}).Marshal()
+		require.Nil(t, err)
+		return eraftpb.Entry{Index: index, Term: RaftInitLogTerm, Data: data}
+	}
+	applyIngest := func(index uint64, meta *import_sstpb.SSTMeta) *applyContext {
+		aCtx := newApplyContext("", nil, engines, make(chan Msg, 16), cfg)
+		entries := []eraftpb.Entry{newIngestEntry(index, meta)}
+		a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: entries}))
+		aCtx.flush()
+		return aCtx
+	}
+
+	// The file is deleted after its entries are written.
+	meta := &import_sstpb.SSTMeta{Uuid: []byte("ok"), Range: &import_sstpb.Range{Start: []byte("ta"), End: []byte("tb")}}
+	writeTestImportSST(t, importDir, meta, map[string]uint64{"ta": 10, "tb": 10})
+	applyIngest(6, meta)
+	path, err := importSSTPath(importDir, meta)
+	require.Nil(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	txn := engines.kv.DB.NewTransaction(false)
+	item, err := txn.Get([]byte("tb"))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(10), item.Version())
+	txn.Discard()
+
+	// A follower without the file, or with a corrupted file, can't apply the command like the other stores.
+	missing := &import_sstpb.SSTMeta{Uuid: []byte("missing"), Range: meta.Range, Crc32: meta.Crc32, Length: meta.Length}
+	corrupted := &import_sstpb.SSTMeta{Uuid: []byte("corrupted"), Range: meta.Range}
+	writeTestImportSST(t, importDir, corrupted, map[string]uint64{"ta": 20, "tb": 20})
+	path, err = importSSTPath(importDir, corrupted)
+	require.Nil(t, err)
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	data[0]++
+	require.Nil(t, ioutil.WriteFile(path, data, 0644))
+	// The keys after the first one are out of the range of the meta.
+	outOfRange := &import_sstpb.SSTMeta{Uuid: []byte("out_of_range"), Range: &import_sstpb.Range{Start: []byte("ta"), End: []byte("ta")}}
+	writeTestImportSST(t, importDir, outOfRange, map[string]uint64{"ta": 30, "tb": 30, "tc": 30})
+	for _, meta := range []*import_sstpb.SSTMeta{missing, corrupted, outOfRange} {
+		aCtx := newApplyContext("", nil, engines, make(chan Msg, 16), cfg)
+		entries := []eraftpb.Entry{newIngestEntry(7, meta)}
+		assert.Panics(t, func() {
+			a.handleTask(aCtx, newApplyMsg(&apply{regionId: 1, term: RaftInitLogTerm, entries: entries}))
+		}, string(meta.Uuid))
+		// Nothing of the command is left in the write batch.
+		assert.Len(t, aCtx.wb.entries, 0, string(meta.Uuid))
+		assert.Len(t, aCtx.ingestedSSTs, 0, string(meta.Uuid))
+	}
+	txn = engines.kv.DB.NewTransaction(false)
+	defer txn.Discard()
+	item, err = txn.Get([]byte("ta"))
+	require.Nil(t, err)
+	assert.Equal(t, uint64(10), item.Version())
+}
@@ -0,0 +1,282 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coocood/badger"
+	"github.com/ngaut/unistore/config"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/pdpb"
+	rspb "github.com/pingcap/kvproto/pkg/raft_serverpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zhangjinpeng1987/raft"
+)
+
+type testMockPDClient struct {
+	id uint64
+}
+
+func (c *testMockPDClient) GetClusterID(ctx context.Context) uint64 { return 1 }
+
+func (c *testMockPDClient) AllocID(ctx context.Context) (uint64, error) {
+	return atomic.AddUint64(&c.id, 1) + 100, nil
+}
+
+func (c *testMockPDClient) Bootstrap(ctx context.Context, store *metapb.Store, region *metapb.Region) (*pdpb.BootstrapResponse, error) {
+	return &pdpb.BootstrapResponse{}, nil
+}
+
+func (c *testMockPDClient) IsBootstrapped(ctx context.Context) (bool, error)        { return true, nil }
+func (c *testMockPDClient) PutStore(ctx context.Context, store *metapb.Store) error { return nil }
+
+func (c *testMockPDClient) GetStore(ctx context.Context, storeID uint64) (*metapb.Store, error) {
+	return &metapb.Store{Id: storeID}, nil
+}
+
+func (c *testMockPDClient) GetRegion(ctx context.Context, key []byte) (*metapb.Region, *metapb.Peer, error) {
+	return nil, nil, nil
+}
+
+func (c *testMockPDClient) GetRegionByID(ctx context.Context, regionID uint64) (*metapb.Region, *metapb.Peer, error) {
+	return nil, nil, nil
+}
+
+func (c *testMockPDClient) ReportRegion(*pdpb.RegionHeartbeatRequest) {}
+
+func (c *testMockPDClient) AskSplit(ctx context.Context, region *metapb.Region) (*pdpb.AskSplitResponse, error) {
+	return nil, nil
+}
+
+func (c *testMockPDClient) AskBatchSplit(ctx context.Context, region *metapb.Region, count int) (*pdpb.AskBatchSplitResponse, error) {
+	return nil, nil
+}
+
+func (c *testMockPDClient) ReportBatchSplit(ctx context.Context, regions []*metapb.Region) error {
+	return nil
+}
+
+func (c *testMockPDClient) GetGCSafePoint(ctx context.Context) (uint64, error) { return 0, nil }
+func (c *testMockPDClient) StoreHeartbeat(ctx context.Context, stats *pdpb.StoreStats) error {
+	return nil
+}
+func (c *testMockPDClient) GetTS(ctx context.Context) (int64, int64, error) { return 0, 0, nil }
+
+func (c *testMockPDClient) SetRegionHeartbeatResponseHandler(h func(*pdpb.RegionHeartbeatResponse)) {}
+
+func (c *testMockPDClient) Close() {}
+
+type testPeerEventObserver struct{}
+
+func (o *testPeerEventObserver) OnPeerCreate(ctx *PeerEventContext, region *metapb.Region)    {}
+func (o *testPeerEventObserver) OnPeerApplySnap(ctx *PeerEventContext, region *metapb.Region) {}
+func (o *testPeerEventObserver) OnPeerDestroy(ctx *PeerEventContext)                          {}
+
+func (o *testPeerEventObserver) OnSplitRegion(derived *metapb.Region, regions []*metapb.Region, peers []*PeerEventContext) {
+}
+
+func (o *testPeerEventObserver) OnRegionConfChange(ctx *PeerEventContext, epoch *metapb.RegionEpoch) {
+}
+func (o *testPeerEventObserver) OnMergeRegion(ctx *PeerEventContext, region *metapb.Region) {}
+func (o *testPeerEventObserver) OnRoleChange(regionId uint64, newState raft.StateType)      {}
+
+// testTransport delivers the raft messages to the routers of the target stores, the messages matched by the
+// filter are dropped.
+type testTransport struct {
+	mu      sync.RWMutex
+	routers map[uint64]*router
+	filter  func(msg *rspb.RaftMessage) bool
+}
+
+func (t *testTransport) Send(msg *rspb.RaftMessage) error {
+	t.mu.RLock()
+	r := t.routers[msg.ToPeer.StoreId]
+	filter := t.filter
+	t.mu.RUnlock()
+	if r == nil || (filter != nil && filter(msg)) {
+		return nil
+	}
+	return r.sendRaftMessage(msg)
+}
+
+func (t *testTransport) setFilter(filter func(msg *rspb.RaftMessage) bool) {
+	t.mu.Lock()
+	t.filter = filter
+	t.mu.Unlock()
+}
+
+type testClusterStore struct {
+	cfg      *Config
+	engines  *Engines
+	system   *raftBatchSystem
+	router   *RaftstoreRouter
+	snapPath string
+}
+
+// testCluster runs a raftstore for every store in the process, the stores bootstrap a region with a peer on
+// every store.
+type testCluster struct {
+	t      *testing.T
+	region *metapb.Region
+	trans  *testTransport
+	stores map[uint64]*testClusterStore
+}
+
+func newTestCluster(t *testing.T, storeCount int) *testCluster {
+	region := &metapb.Region{
+		Id:          1,
+		StartKey:    []byte{},
+		EndKey:      []byte{},
+		RegionEpoch: &metapb.RegionEpoch{Version: InitEpochVer, ConfVer: InitEpochConfVer},
+	}
+	for i := 1; i <= storeCount; i++ {
+		region.Peers = append(region.Peers, &metapb.Peer{Id: uint64(i + 1), StoreId: uint64(i)})
+	}
+	c := &testCluster{
+		t:      t,
+		region: region,
+		trans:  &testTransport{routers: make(map[uint64]*router)},
+		stores: make(map[uint64]*testClusterStore),
+	}
+	for _, peer := range region.Peers {
+		engines := newTestEngines(t)
+		require.Nil(t, BootstrapStore(engines, 1, peer.StoreId))
+		require.Nil(t, writePrepareBootstrap(engines, region))
+		require.Nil(t, ClearPrepareBootstrapState(engines))
+		snapPath, err := ioutil.TempDir("", "unistore_snap")
+		require.Nil(t, err)
+		cfg := NewDefaultConfig()
+		cfg.RaftBaseTickInterval = 10 * time.Millisecond
+		cfg.RaftStoreMaxLeaderLease = 90 * time.Millisecond
+		cfg.SnapPath = snapPath
+		router, system := createRaftBatchSystem(&config.DefaultConf, cfg)
+		c.trans.routers[peer.StoreId] = router
+		c.stores[peer.StoreId] = &testClusterStore{
+			cfg:      cfg,
+			engines:  engines,
+			system:   system,
+			router:   &RaftstoreRouter{router: router, localReader: newLocalReader(router)},
+			snapPath: snapPath,
+		}
+	}
+	for storeID, s := range c.stores {
+		err := s.system.start(&metapb.Store{Id: storeID}, s.cfg, s.engines, c.trans, new(testMockPDClient),
+			NewSnapManager(s.snapPath, s.system.router), newWorker("pd-worker", new(sync.WaitGroup)),
+			new(testPeerEventObserver))
+		require.Nil(t, err)
+	}
+	return c
+}
+
+func (c *testCluster) shutdown() {
+	for _, s := range c.stores {
+		s.system.shutDown()
+		s.engines.kv.DB.Close()
+		s.engines.raft.Close()
+		cleanUpTestEngineData(s.engines)
+		os.RemoveAll(s.snapPath)
+	}
+}
+
+func (c *testCluster) context(storeID uint64) *kvrpcpb.Context {
+	return &kvrpcpb.Context{
+		RegionId:    c.region.Id,
+		RegionEpoch: c.region.RegionEpoch,
+		Peer:        findPeer(c.region, storeID),
+	}
+}
+
+// mustRawPut writes the raw key through the leader and returns the store of the leader.
+func (c *testCluster) mustRawPut(key, value []byte, version uint64) uint64 {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		for storeID, s := range c.stores {
+			writer := &raftDBWriter{router: s.router.router}
+			wb := writer.NewWriteBatch(version, version, c.context(storeID))
+			wb.RawPut(key, value, version)
+			if err := writer.Write(wb); err == nil {
+				return storeID
+			}
+		}
+	}
+	require.FailNow(c.t, "no leader is elected")
+	return 0
+}
+
+func (c *testCluster) rawGet(storeID uint64, key []byte) []byte {
+	txn := c.stores[storeID].engines.kv.DB.NewTransaction(false)
+	defer txn.Discard()
+	item, err := txn.Get(mvcc.EncodeRawKey(key))
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	require.Nil(c.t, err)
+	val, err := item.Value()
+	require.Nil(c.t, err)
+	return val
+}
+
+func TestFollowerReadIndex(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.shutdown()
+	leader := c.mustRawPut([]byte("ta"), []byte("a1"), 10)
+	var follower uint64
+	for storeID := range c.stores {
+		if storeID != leader {
+			follower = storeID
+			break
+		}
+	}
+
+	// The follower has applied the write on the leader when the read index returns.
+	_, err := c.stores[follower].router.ReadIndex(c.context(follower))
+	require.Nil(t, err)
+	assert.Equal(t, "a1", string(c.rawGet(follower, []byte("ta"))))
+
+	// The follower doesn't receive the log of the next write, the read waits until the follower has applied it.
+	c.trans.setFilter(func(msg *rspb.RaftMessage) bool {
+		return msg.ToPeer.StoreId == follower && msg.Message.MsgType == eraftpb.MessageType_MsgAppend
+	})
+	c.mustRawPut([]byte("ta"), []byte("a2"), 20)
+	assert.Equal(t, "a1", string(c.rawGet(follower, []byte("ta"))))
+	errCh := make(chan *errorpb.Error, 1)
+	go func() {
+		_, err := c.stores[follower].router.ReadIndex(c.context(follower))
+		errCh <- err
+	}()
+	select {
+	case <-errCh:
+		require.FailNow(t, "the read index returns before the follower applies the write")
+	case <-time.After(200 * time.Millisecond):
+	}
+	c.trans.setFilter(nil)
+	select {
+	case err = <-errCh:
+		require.Nil(t, err)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "the read index doesn't return after the follower catches up")
+	}
+	assert.Equal(t, "a2", string(c.rawGet(follower, []byte("ta"))))
+}
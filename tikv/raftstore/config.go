@@ -34,6 +34,8 @@ type Config struct {
 	RaftdbPath string
 
 	SnapPath string
+	// ImportPath is the directory of the SST files uploaded for ingestion.
+	ImportPath string
 
 	// store capacity. 0 means no limit.
 	Capacity uint64
@@ -50,6 +52,11 @@ type Config struct {
 	// When the entry exceed the max size, reject to propose it.
 	RaftEntryMaxSize uint64
 
+	// Stop ticking a region on every base tick once its leader and followers are in sync and idle, the raft group
+	// of a hibernated region is ticked every RaftHibernateTicks base ticks.
+	HibernateRegions   bool
+	RaftHibernateTicks int
+
 	// Interval to gc unnecessary raft log (ms).
 	RaftLogGCTickInterval time.Duration
 	// A threshold to gc stale raft log, must >= 1.
@@ -183,6 +190,7 @@ func NewDefaultConfig() *Config {
 		Prevote:                     true,
 		RaftdbPath:                  "",
 		SnapPath:                    "snap",
+		ImportPath:                  "import",
 		Capacity:                    0,
 		RaftBaseTickInterval:        1 * time.Second,
 		RaftHeartbeatTicks:          2,
@@ -192,6 +200,8 @@ func NewDefaultConfig() *Config {
 		RaftMaxSizePerMsg:           1 * MB,
 		RaftMaxInflightMsgs:         256,
 		RaftEntryMaxSize:            8 * MB,
+		HibernateRegions:            false,
+		RaftHibernateTicks:          10,
 		RaftLogGCTickInterval:       10 * time.Second,
 		RaftLogGcThreshold:          50,
 		// Assume the average size of entries is 1k.
@@ -291,6 +301,10 @@ func (c *Config) Validate() error {
 			c.RaftMinElectionTimeoutTicks, c.RaftMaxElectionTimeoutTicks, c.RaftElectionTimeoutTicks)
 	}
 
+	if c.HibernateRegions && c.RaftHibernateTicks <= 0 {
+		return fmt.Errorf("raft hibernate ticks must be greater than 0, not %v", c.RaftHibernateTicks)
+	}
+
 	if c.RaftLogGcThreshold < 1 {
 		return fmt.Errorf("raft log gc threshold must >= 1, not %v", c.RaftLogGcThreshold)
 	}
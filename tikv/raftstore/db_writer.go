@@ -14,6 +14,7 @@
 package raftstore
 
 import (
+	"path/filepath"
 	"time"
 
 	"github.com/coocood/badger/y"
@@ -23,7 +24,9 @@ import (
 	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	rcpb "github.com/pingcap/kvproto/pkg/raft_cmdpb"
 	"github.com/pingcap/tidb/util/codec"
 )
@@ -158,6 +161,81 @@ func (wb *raftWriteBatch) PessimisticRollback(key []byte) {
 	})
 }
 
+// RawPut, RawDelete, VerPut and VerDelete append the version to the key, the applier writes the key at the version.
+func (wb *raftWriteBatch) RawPut(key, value []byte, version uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Put,
+		Put: &rcpb.PutRequest{
+			Cf:    CFRaw,
+			Key:   codec.EncodeUintDesc(append([]byte{}, key...), version),
+			Value: value,
+		},
+	})
+}
+
+func (wb *raftWriteBatch) RawDelete(key []byte, version uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Delete,
+		Delete: &rcpb.DeleteRequest{
+			Cf:  CFRaw,
+			Key: codec.EncodeUintDesc(append([]byte{}, key...), version),
+		},
+	})
+}
+
+func (wb *raftWriteBatch) VerPut(key, value []byte, version uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Put,
+		Put: &rcpb.PutRequest{
+			Cf:    CFVer,
+			Key:   codec.EncodeUintDesc(append([]byte{}, key...), version),
+			Value: value,
+		},
+	})
+}
+
+func (wb *raftWriteBatch) VerDelete(key []byte, version uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Delete,
+		Delete: &rcpb.DeleteRequest{
+			Cf:  CFVer,
+			Key: codec.EncodeUintDesc(append([]byte{}, key...), version),
+		},
+	})
+}
+
+// ImportPut and ImportDelete append the commit ts to the key, the applier writes the key at the commit ts.
+func (wb *raftWriteBatch) ImportPut(key, value []byte, commitTS uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Put,
+		Put: &rcpb.PutRequest{
+			Cf:    CFImport,
+			Key:   codec.EncodeUintDesc(append([]byte{}, key...), commitTS),
+			Value: value,
+		},
+	})
+}
+
+func (wb *raftWriteBatch) ImportDelete(key []byte, commitTS uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Delete,
+		Delete: &rcpb.DeleteRequest{
+			Cf:  CFImport,
+			Key: codec.EncodeUintDesc(append([]byte{}, key...), commitTS),
+		},
+	})
+}
+
+func (wb *raftWriteBatch) DeleteVersion(key []byte, version uint64) {
+	wb.requests = append(wb.requests, &rcpb.Request{
+		CmdType: rcpb.CmdType_Delete,
+		Delete: &rcpb.DeleteRequest{
+			Cf:  CFWrite,
+			Key: codec.EncodeUintDesc(codec.EncodeBytes(nil, key), version),
+		},
+	})
+}
+
 func (writer *raftDBWriter) NewWriteBatch(startTS, commitTS uint64, ctx *kvrpcpb.Context) mvcc.WriteBatch {
 	if writer.useCustomRaftLog {
 		return NewCustomWriteBatch(startTS, commitTS, ctx)
@@ -234,6 +312,19 @@ func (writer *raftDBWriter) DeleteRange(startKey, endKey []byte, latchHandle mvc
 	return nil // TODO: stub
 }
 
+// IngestSST proposes the meta through the raft log, the file of the meta must be uploaded to every peer, every
+// peer deletes its file after the entries are written.
+func (writer *raftDBWriter) IngestSST(ctx *kvrpcpb.Context, meta *import_sstpb.SSTMeta, path string,
+	latchHandle mvcc.LatchHandle) error {
+	return writer.Write(&raftWriteBatch{
+		ctx: ctx,
+		requests: []*rcpb.Request{{
+			CmdType:   rcpb.CmdType_IngestSST,
+			IngestSst: &rcpb.IngestSSTRequest{Sst: meta},
+		}},
+	})
+}
+
 func NewDBWriter(conf *config.Config, router *RaftstoreRouter) mvcc.DBWriter {
 	return &raftDBWriter{
 		router:           router.router,
@@ -271,6 +362,22 @@ func (w *TestRaftWriter) DeleteRange(start, end []byte, latchHandle mvcc.LatchHa
 	return nil
 }
 
+func (w *TestRaftWriter) IngestSST(ctx *kvrpcpb.Context, meta *import_sstpb.SSTMeta, path string,
+	latchHandle mvcc.LatchHandle) error {
+	cfg := NewDefaultConfig()
+	cfg.ImportPath = filepath.Dir(path)
+	applier := &applier{region: &metapb.Region{Id: ctx.RegionId, Peers: []*metapb.Peer{ctx.Peer}}}
+	applyCtx := newApplyContext("test", nil, w.engine, nil, cfg)
+	applier.execWriteCmd(applyCtx, raftlog.NewRequest(&rcpb.RaftCmdRequest{
+		Header: &rcpb.RaftRequestHeader{RegionId: ctx.RegionId},
+		Requests: []*rcpb.Request{{
+			CmdType:   rcpb.CmdType_IngestSST,
+			IngestSst: &rcpb.IngestSSTRequest{Sst: meta},
+		}},
+	}))
+	return applyCtx.wb.WriteToKV(w.dbBundle)
+}
+
 func (w *TestRaftWriter) NewWriteBatch(startTS, commitTS uint64, ctx *kvrpcpb.Context) mvcc.WriteBatch {
 	return NewCustomWriteBatch(startTS, commitTS, ctx)
 }
@@ -323,6 +430,41 @@ func (wb *customWriteBatch) PessimisticRollback(key []byte) {
 	wb.builder.AppendPessimisticRollback(key)
 }
 
+func (wb *customWriteBatch) RawPut(key, value []byte, version uint64) {
+	wb.setType(raftlog.TypeRawPut)
+	wb.builder.AppendRawPut(key, value, version)
+}
+
+func (wb *customWriteBatch) RawDelete(key []byte, version uint64) {
+	wb.setType(raftlog.TypeRawDelete)
+	wb.builder.AppendRawDelete(key, version)
+}
+
+func (wb *customWriteBatch) VerPut(key, value []byte, version uint64) {
+	wb.setType(raftlog.TypeVerMut)
+	wb.builder.AppendVerMut(key, value, version, false)
+}
+
+func (wb *customWriteBatch) VerDelete(key []byte, version uint64) {
+	wb.setType(raftlog.TypeVerMut)
+	wb.builder.AppendVerMut(key, nil, version, true)
+}
+
+func (wb *customWriteBatch) ImportPut(key, value []byte, commitTS uint64) {
+	wb.setType(raftlog.TypeImport)
+	wb.builder.AppendImport(key, value, commitTS, false)
+}
+
+func (wb *customWriteBatch) ImportDelete(key []byte, commitTS uint64) {
+	wb.setType(raftlog.TypeImport)
+	wb.builder.AppendImport(key, nil, commitTS, true)
+}
+
+func (wb *customWriteBatch) DeleteVersion(key []byte, version uint64) {
+	wb.setType(raftlog.TypeGC)
+	wb.builder.AppendGC(key, version)
+}
+
 func NewCustomWriteBatch(startTS, commitTS uint64, ctx *kvrpcpb.Context) mvcc.WriteBatch {
 	header := raftlog.CustomHeader{
 		RegionID: ctx.RegionId,
@@ -156,11 +156,14 @@ func (en *Engines) SyncRaftWAL() error {
 type WriteBatch struct {
 	entries       []*badger.Entry
 	lockEntries   []*badger.Entry
+	changes       []mvcc.ChangeEvent
 	size          int
 	safePoint     int
 	safePointLock int
 	safePointSize int
 	safePointUndo int
+	// safePointChanges is the number of changes at the safe point.
+	safePointChanges int
 }
 
 func (wb *WriteBatch) Len() int {
@@ -225,6 +228,12 @@ func (wb *WriteBatch) Delete(key y.Key) {
 	wb.size += key.Len()
 }
 
+// AppendChange records a transactional change, it is passed to the change observer of the DB when the batch
+// is written.
+func (wb *WriteBatch) AppendChange(event mvcc.ChangeEvent) {
+	wb.changes = append(wb.changes, event)
+}
+
 func (wb *WriteBatch) SetMsg(key y.Key, msg proto.Message) error {
 	val, err := proto.Marshal(msg)
 	if err != nil {
@@ -238,12 +247,14 @@ func (wb *WriteBatch) SetSafePoint() {
 	wb.safePoint = len(wb.entries)
 	wb.safePointLock = len(wb.lockEntries)
 	wb.safePointSize = wb.size
+	wb.safePointChanges = len(wb.changes)
 }
 
 func (wb *WriteBatch) RollbackToSafePoint() {
 	wb.entries = wb.entries[:wb.safePoint]
 	wb.lockEntries = wb.lockEntries[:wb.safePointLock]
 	wb.size = wb.safePointSize
+	wb.changes = wb.changes[:wb.safePointChanges]
 }
 
 // WriteToKV flush WriteBatch to DB by two steps:
@@ -273,6 +284,9 @@ func (wb *WriteBatch) WriteToKV(bundle *mvcc.DBBundle) error {
 			return errors.WithStack(err)
 		}
 	}
+	// The changes are observed before the locks are removed, so the resolved ts doesn't pass a commit
+	// that is not observed.
+	bundle.ChangeObserver.Observe(wb.changes)
 	if len(wb.lockEntries) > 0 {
 		start := time.Now()
 		hint := new(lockstore.Hint)
@@ -283,6 +297,7 @@ func (wb *WriteBatch) WriteToKV(bundle *mvcc.DBBundle) error {
 				bundle.LockStore.DeleteWithHint(entry.Key.UserKey, hint)
 			default:
 				bundle.LockStore.PutWithHint(entry.Key.UserKey, entry.Value, hint)
+				bundle.LockObserver.Observe(entry.Key.UserKey, entry.Value)
 			}
 		}
 		bundle.MemStoreMu.Unlock()
@@ -337,11 +352,16 @@ func (wb *WriteBatch) Reset() {
 		wb.lockEntries[i] = nil
 	}
 	wb.lockEntries = wb.lockEntries[:0]
+	for i := range wb.changes {
+		wb.changes[i] = mvcc.ChangeEvent{}
+	}
+	wb.changes = wb.changes[:0]
 	wb.size = 0
 	wb.safePoint = 0
 	wb.safePointLock = 0
 	wb.safePointSize = 0
 	wb.safePointUndo = 0
+	wb.safePointChanges = 0
 }
 
 // Todo, the following code redundant to unistore/tikv/worker.go, just as a place holder now.
@@ -350,13 +370,17 @@ const delRangeBatchSize = 4096
 
 const maxSystemTS = math.MaxUint64
 
+// deleteRange deletes the data of the data range [startKey, endKey) including the keys in the prefixed key spaces.
 func deleteRange(db *mvcc.DBBundle, startKey, endKey []byte) error {
 	// Delete keys first.
 	keys := make([]y.Key, 0, delRangeBatchSize)
-	txn := db.DB.NewTransaction(false)
-	reader := dbreader.NewDBReader(startKey, endKey, txn)
-	keys = collectRangeKeys(reader.GetIter(), startKey, endKey, keys)
-	reader.Close()
+	ranges := append([]keyRange{{startKey: startKey, endKey: endKey}}, prefixedKeyRanges(startKey, endKey)...)
+	for _, r := range ranges {
+		txn := db.DB.NewTransaction(false)
+		reader := dbreader.NewDBReader(r.startKey, r.endKey, txn)
+		keys = collectRangeKeys(reader.GetIter(), r.startKey, r.endKey, keys)
+		reader.Close()
+	}
 	if err := deleteKeysInBatch(db, keys, delRangeBatchSize); err != nil {
 		return err
 	}
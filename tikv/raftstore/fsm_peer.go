@@ -16,12 +16,14 @@ package raftstore
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"sync/atomic"
 	"time"
 
 	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
 
 	"github.com/coocood/badger/y"
-	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/metrics"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/eraftpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
@@ -37,6 +39,10 @@ type peerFsm struct {
 	stopped  bool
 	hasReady bool
 	ticker   *ticker
+	// A hibernated peer is only ticked every RaftHibernateTicks base ticks.
+	hibernated bool
+	// The count of the raft ticks the peer has been idle for.
+	idleTicks int
 }
 
 type PeerEventContext struct {
@@ -55,6 +61,8 @@ type PeerEventObserver interface {
 	OnSplitRegion(derived *metapb.Region, regions []*metapb.Region, peers []*PeerEventContext)
 	// OnRegionConfChange will be invoked after conf change updated region's epoch.
 	OnRegionConfChange(ctx *PeerEventContext, epoch *metapb.RegionEpoch)
+	// OnMergeRegion will be invoked after the source region is merged into the target region.
+	OnMergeRegion(ctx *PeerEventContext, region *metapb.Region)
 	// OnRoleChange will be invoked after peer state has changed
 	OnRoleChange(regionId uint64, newState raft.StateType)
 }
@@ -157,6 +165,9 @@ func newRaftMsgHandler(fsm *peerFsm, ctx *RaftContext) *peerMsgHandler {
 }
 
 func (d *peerMsgHandler) HandleMsgs(msgs ...Msg) {
+	if d.hasPendingMergeApplyResult() {
+		d.resumeHandlePendingApplyResult()
+	}
 	for _, msg := range msgs {
 		switch msg.Type {
 		case MsgTypeRaftMessage:
@@ -166,6 +177,11 @@ func (d *peerMsgHandler) HandleMsgs(msgs ...Msg) {
 			}
 		case MsgTypeRaftCmd:
 			raftCMD := msg.Data.(*MsgRaftCmd)
+			if isResolvedTSLog(raftCMD.Request) {
+				d.proposeResolvedTS(raftCMD.Request, raftCMD.Callback)
+				continue
+			}
+			d.wakeUp()
 			d.proposeRaftCommand(raftCMD.Request, raftCMD.Callback)
 		case MsgTypeTick:
 			d.onTick()
@@ -177,8 +193,10 @@ func (d *peerMsgHandler) HandleMsgs(msgs ...Msg) {
 			}
 			d.onApplyResult(res)
 		case MsgTypeSignificantMsg:
+			d.wakeUp()
 			d.onSignificantMsg(msg.Data.(*MsgSignificant))
 		case MsgTypeSplitRegion:
+			d.wakeUp()
 			split := msg.Data.(*MsgSplitRegion)
 			log.S().Infof("%s on split with %v", d.peer.Tag, split.SplitKeys)
 			d.onPrepareSplitRegion(split.RegionEpoch, split.SplitKeys, split.Callback)
@@ -192,9 +210,11 @@ func (d *peerMsgHandler) HandleMsgs(msgs ...Msg) {
 		case MsgTypeCompactionDeclineBytes:
 			d.onCompactionDeclinedBytes(msg.Data.(uint64))
 		case MsgTypeHalfSplitRegion:
+			d.wakeUp()
 			half := msg.Data.(*MsgHalfSplitRegion)
 			d.onScheduleHalfSplitRegion(half.RegionEpoch)
 		case MsgTypeMergeResult:
+			d.wakeUp()
 			result := msg.Data.(*MsgMergeResult)
 			d.onMergeResult(result.TargetPeer, result.Stale)
 		case MsgTypeGcSnap:
@@ -204,6 +224,8 @@ func (d *peerMsgHandler) HandleMsgs(msgs ...Msg) {
 			d.onClearRegionSize()
 		case MsgTypeStart:
 			d.startTicker()
+		case MsgTypeEvictLeader:
+			d.onEvictLeader(msg.Data.(*MsgEvictLeader).Callback)
 		case MsgTypeNoop:
 		}
 	}
@@ -213,7 +235,7 @@ func (d *peerMsgHandler) onTick() {
 	if d.stopped {
 		return
 	}
-	d.ticker.tickClock()
+	d.ticker.tickClockTo(d.ctx.tickCount)
 	if d.ticker.isOnTick(PeerTickRaft) {
 		d.onRaftBaseTick()
 	}
@@ -239,6 +261,9 @@ func (d *peerMsgHandler) startTicker() {
 		d.notifyPrepareMerge()
 	}
 	d.ticker = newTicker(d.regionID(), d.ctx.cfg)
+	d.ticker.tickClockTo(d.ctx.tickCount)
+	d.hibernated = false
+	d.ctx.awakePeers[d.regionID()] = struct{}{}
 	d.ticker.schedule(PeerTickRaft)
 	d.ticker.schedule(PeerTickRaftLogGC)
 	d.ticker.schedule(PeerTickSplitRegionCheck)
@@ -247,12 +272,49 @@ func (d *peerMsgHandler) startTicker() {
 	d.onCheckMerge()
 }
 
+// notifyPrepareMerge notifies the target peer that `PrepareMerge` of this region has been handled,
+// so a `CommitMerge` waiting for it can continue.
 func (d *peerMsgHandler) notifyPrepareMerge() {
-	// TODO: merge func
+	regionID := d.regionID()
+	version := d.region().RegionEpoch.Version
+	d.ctx.storeMetaLock.Lock()
+	meta := d.ctx.storeMeta
+	lock := meta.mergeLocks[regionID]
+	if lock == nil || lock.version < version {
+		meta.mergeLocks[regionID] = &mergeLock{version: version}
+		d.ctx.storeMetaLock.Unlock()
+		return
+	}
+	d.ctx.storeMetaLock.Unlock()
+	if lock.version == version && lock.readyToMerge != nil && atomic.LoadUint32(lock.readyToMerge) == 0 {
+		atomic.StoreUint32(lock.readyToMerge, 1)
+		targetID := d.peer.PendingMergeState.Target.Id
+		// Trigger the target peer to resume handling the pending apply result.
+		_ = d.ctx.router.send(targetID, NewPeerMsg(MsgTypeNoop, targetID, nil))
+	}
 }
 
+// resumeHandlePendingApplyResult handles the apply results that wait for the source peer.
+// It returns false if there is still a `CommitMerge` waiting.
 func (d *peerMsgHandler) resumeHandlePendingApplyResult() bool {
-	return false // TODO: merge func
+	state := d.peer.PendingMergeApplyResult
+	if state == nil {
+		return true
+	}
+	if atomic.LoadUint32(state.readyToMerge) == 0 {
+		return false
+	}
+	log.S().Infof("%s resume handling pending apply result", d.tag())
+	d.peer.PendingMergeApplyResult = nil
+	for i, res := range state.results {
+		d.onApplyResult(res)
+		if pending := d.peer.PendingMergeApplyResult; pending != nil {
+			// Another `CommitMerge` needs to wait, keep the rest results.
+			pending.results = append(pending.results, state.results[i+1:]...)
+			return false
+		}
+	}
+	return true
 }
 
 func (d *peerMsgHandler) onGCSnap(snaps []SnapKeyWithSending) {
@@ -296,6 +358,27 @@ func (d *peerMsgHandler) onClearRegionSize() {
 	d.peer.ApproximateKeys = nil
 }
 
+// onEvictLeader proposes a TransferLeader command to a healthy follower if the peer is the leader, the leader is
+// kept if there is no such follower.
+func (d *peerMsgHandler) onEvictLeader(cb func(evicted bool)) {
+	if d.peer.IsLeader() {
+		if target := d.peer.pickTransferee(d.ctx.cfg); target != nil {
+			d.wakeUp()
+			req := newAdminRequest(d.regionID(), d.peer.Meta)
+			req.Header.RegionEpoch = d.region().RegionEpoch
+			req.AdminRequest = &raft_cmdpb.AdminRequest{
+				CmdType:        raft_cmdpb.AdminCmdType_TransferLeader,
+				TransferLeader: &raft_cmdpb.TransferLeaderRequest{Peer: target},
+			}
+			d.proposeRaftCommand(raftlog.NewRequest(req), NewCallback())
+			cb(false)
+			return
+		}
+		log.S().Debugf("%s no healthy follower to transfer leader to", d.tag())
+	}
+	cb(d.peer.Store().AppliedIndex() >= d.peer.RaftGroup.StatusWithoutProgress().Commit)
+}
+
 func (d *peerMsgHandler) onSignificantMsg(msg *MsgSignificant) {
 	switch msg.Type {
 	case MsgSignificantTypeStatus:
@@ -369,10 +452,99 @@ func (d *peerMsgHandler) onRaftBaseTick() {
 	}
 	// TODO: make Tick returns bool to indicate if there is ready.
 	d.peer.RaftGroup.Tick()
+	d.peer.RetryPendingReads()
 	d.hasReady = d.peer.RaftGroup.HasReady()
+	d.checkHibernate()
 	d.ticker.schedule(PeerTickRaft)
 }
 
+// checkHibernate hibernates the region after the leader has been idle for an election timeout, and wakes up a
+// hibernated peer once it can't hibernate, like a follower campaigns after missing the leader for an election
+// timeout of the hibernated ticks.
+func (d *peerMsgHandler) checkHibernate() {
+	if !d.ctx.cfg.HibernateRegions {
+		return
+	}
+	if !d.peer.canHibernate() {
+		// The idle ticks are kept, the replication of a resolved ts proposal doesn't make the region busy.
+		if d.hibernated {
+			d.wakeUp()
+		}
+		return
+	}
+	if d.hibernated {
+		return
+	}
+	d.idleTicks++
+	// A follower hibernates on the heartbeat of the leader. The follower that missed the last heartbeat before
+	// the leader hibernated hibernates before its election timeout, or it would campaign and wake up the region.
+	idleTicks := d.ctx.cfg.RaftElectionTimeoutTicks
+	if !d.peer.IsLeader() {
+		idleTicks /= 2
+	}
+	if d.idleTicks >= idleTicks {
+		log.S().Debugf("%s hibernates", d.tag())
+		d.hibernate()
+	}
+}
+
+// proposeResolvedTS proposes the resolved ts without waking up the region or resetting its idle ticks, the
+// proposals of the resolved ts would keep every region awake. A hibernated region has no write to resolve, its
+// resolved ts is not proposed and the stale reads above its safe ts fall back to the leader.
+func (d *peerMsgHandler) proposeResolvedTS(rlog raftlog.RaftLog, cb *Callback) {
+	if d.hibernated {
+		cb.Done(ErrResp(errors.Errorf("%s is hibernated", d.tag())))
+		return
+	}
+	d.proposeRaftCommand(rlog, cb)
+}
+
+// isResolvedTSLog returns whether the log is a resolved ts proposal.
+func isResolvedTSLog(rlog raftlog.RaftLog) bool {
+	cl, ok := rlog.(*raftlog.CustomRaftLog)
+	return ok && cl.Type() == raftlog.TypeResolvedTS
+}
+
+// isIdleRaftMessage returns whether the raft message doesn't wake up the region, the heartbeats and the appends
+// of the resolved ts proposals are sent to a hibernated region.
+func isIdleRaftMessage(msg *eraftpb.Message) bool {
+	switch msg.MsgType {
+	case eraftpb.MessageType_MsgHeartbeat, eraftpb.MessageType_MsgHeartbeatResponse:
+		return true
+	case eraftpb.MessageType_MsgAppendResponse:
+		return !msg.Reject
+	case eraftpb.MessageType_MsgAppend:
+		for _, entry := range msg.Entries {
+			data := entry.Data
+			if len(data) < 2 || data[0] != raftlog.CustomRaftLogFlag ||
+				raftlog.CustomRaftLogType(data[1]) != raftlog.TypeResolvedTS {
+				return false
+			}
+		}
+		return len(msg.Entries) > 0
+	}
+	return false
+}
+
+// hibernate stops ticking the peer on every base tick. The raft group is still ticked every RaftHibernateTicks
+// base ticks, so the leader sends heartbeats and the followers campaign with a slower clock, which only delays
+// the election and keeps the leader lease safe.
+func (d *peerMsgHandler) hibernate() {
+	d.hibernated = true
+	delete(d.ctx.awakePeers, d.regionID())
+}
+
+// wakeUp ticks the peer on every base tick again.
+func (d *peerMsgHandler) wakeUp() {
+	d.idleTicks = 0
+	if !d.hibernated {
+		return
+	}
+	log.S().Debugf("%s wakes up", d.tag())
+	d.hibernated = false
+	d.ctx.awakePeers[d.regionID()] = struct{}{}
+}
+
 func (d *peerMsgHandler) onApplyResult(res *applyTaskRes) {
 	if res.destroyPeerID != 0 {
 		y.Assert(res.destroyPeerID == d.peerID())
@@ -441,6 +613,15 @@ func (d *peerMsgHandler) onRaftMsg(msg *rspb.RaftMessage) error {
 		d.ctx.snapMgr.DeleteSnapshot(*key, s, false)
 		return nil
 	}
+	msgType := msg.GetMessage().GetMsgType()
+	if msgType == eraftpb.MessageType_MsgTimeoutNow && atomic.LoadUint32(&d.ctx.evictingLeaders) == 1 {
+		// The store is evicting its leaders before shutting down, it must not take the leadership back.
+		log.S().Infof("%s ignore %s from %d while evicting leaders", d.tag(), msgType, msg.GetFromPeer().GetId())
+		return nil
+	}
+	if !isIdleRaftMessage(msg.GetMessage()) {
+		d.wakeUp()
+	}
 	d.peer.insertPeerCache(msg.GetFromPeer())
 	err = d.peer.Step(msg.GetMessage())
 	if err != nil {
@@ -449,6 +630,10 @@ func (d *peerMsgHandler) onRaftMsg(msg *rspb.RaftMessage) error {
 	if d.peer.AnyNewPeerCatchUp(msg.FromPeer.Id) {
 		d.peer.HeartbeatPd(d.ctx.pdTaskSender)
 	}
+	if msgType == eraftpb.MessageType_MsgHeartbeat && d.ctx.cfg.HibernateRegions && d.peer.canHibernate() {
+		// The follower is in sync with the leader, it keeps hibernated until the leader sends it logs.
+		d.hibernate()
+	}
 	d.hasReady = true
 	return nil
 }
@@ -548,8 +733,39 @@ func handleStaleMsg(trans Transport, msg *rspb.RaftMessage, curEpoch *metapb.Reg
 	}
 }
 
+// needGCMerge checks whether the peer should be destroyed when it receives a message with a merge target,
+// which means the peers of this region on other stores have been merged.
 func (d *peerMsgHandler) needGCMerge(msg *rspb.RaftMessage) (bool, error) {
-	return false, nil // TODO: merge func
+	mergeTarget := msg.MergeTarget
+	targetRegionID := mergeTarget.Id
+
+	// The epoch in merge target is the state of target peer at the time when source peer is merged.
+	// So here we record the merge target epoch to let the target peer on this store to decide whether
+	// to destroy the source peer.
+	d.ctx.storeMetaLock.Lock()
+	meta := d.ctx.storeMeta
+	meta.targetsMap[d.regionID()] = targetRegionID
+	targets := meta.pendingMergeTargets[targetRegionID]
+	if targets == nil {
+		targets = map[uint64]*metapb.RegionEpoch{}
+		meta.pendingMergeTargets[targetRegionID] = targets
+	}
+	targets[d.regionID()] = mergeTarget.RegionEpoch
+	targetRegion := meta.regions[targetRegionID]
+	d.ctx.storeMetaLock.Unlock()
+
+	if targetRegion != nil {
+		log.S().Infof("%s checking target %d, target epoch %s", d.tag(), targetRegionID, targetRegion.RegionEpoch)
+		// If the local target peer is staler than the merge target, it will catch up logs or
+		// apply a snapshot which destroys this peer later, otherwise this peer can be destroyed now.
+		return !IsEpochStale(targetRegion.RegionEpoch, mergeTarget.RegionEpoch), nil
+	}
+	state, err := getRegionLocalState(d.ctx.engine.kv.DB, targetRegionID)
+	if err == nil && state.State != rspb.PeerState_Tombstone {
+		return false, errors.Errorf("%s target region %d is not tombstone: %s", d.tag(), targetRegionID, state)
+	}
+	// The target peer doesn't exist or has been destroyed, nobody will merge this peer.
+	return true, nil
 }
 
 func (d *peerMsgHandler) handleGCPeerMsg(msg *rspb.RaftMessage) {
@@ -916,40 +1132,234 @@ func (d *peerMsgHandler) onReadySplitRegion(derived *metapb.Region, regions []*m
 	d.ctx.peerEventObserver.OnSplitRegion(derived, regions, newPeers)
 }
 
+// validateMergePeer checks whether the target region on this store matches the expected one.
+// It returns false if the target peer should be waited.
 func (d *peerMsgHandler) validateMergePeer(targetRegion *metapb.Region) (bool, error) {
-	return false, nil // TODO: merge func
+	regionID := targetRegion.Id
+	d.ctx.storeMetaLock.RLock()
+	existRegion := d.ctx.storeMeta.regions[regionID]
+	d.ctx.storeMetaLock.RUnlock()
+	if existRegion != nil {
+		existEpoch := existRegion.RegionEpoch
+		expectEpoch := targetRegion.RegionEpoch
+		// exist_epoch > expect_epoch
+		if IsEpochStale(expectEpoch, existEpoch) {
+			return false, errors.Errorf("target region changed %s -> %s", targetRegion, existRegion)
+		}
+		// exist_epoch < expect_epoch
+		if IsEpochStale(existEpoch, expectEpoch) {
+			log.S().Infof("%s target region still not catch up, skip. target %s, exist %s",
+				d.tag(), targetRegion, existRegion)
+			return false, nil
+		}
+		return true, nil
+	}
+	state, err := getRegionLocalState(d.ctx.engine.kv.DB, regionID)
+	if err != nil {
+		log.S().Infof("%s seems to merge into a new replica of target region %d, let's wait", d.tag(), regionID)
+		return false, nil
+	}
+	if state.State != rspb.PeerState_Tombstone {
+		panic(fmt.Sprintf("%s meta corrupted: %s != %s", d.tag(), targetRegion, state))
+	}
+	return false, errors.Errorf("target region has been removed: %s", targetRegion)
 }
 
+// scheduleMerge sends `CommitMerge` with the logs after `PrepareMerge`'s min index to the target peer on this store.
 func (d *peerMsgHandler) scheduleMerge() error {
-	return nil // TODO: merge func
+	state := d.peer.PendingMergeState
+	targetRegion := state.Target
+	ok, err := d.validateMergePeer(targetRegion)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Wait till next round.
+		return nil
+	}
+	low := state.MinIndex
+	if minIndex := d.peer.GetMinProgress() + 1; minIndex > low {
+		low = minIndex
+	}
+	var entries []*eraftpb.Entry
+	if low <= state.Commit {
+		ents, err := d.peer.Store().Entries(low, state.Commit+1, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		entries = make([]*eraftpb.Entry, 0, len(ents))
+		for i := range ents {
+			entries = append(entries, &ents[i])
+		}
+	}
+	targetPeer := findPeer(targetRegion, d.storeID())
+	request := newAdminRequest(targetRegion.Id, targetPeer)
+	request.Header.RegionEpoch = targetRegion.RegionEpoch
+	request.AdminRequest = &raft_cmdpb.AdminRequest{
+		CmdType: raft_cmdpb.AdminCmdType_CommitMerge,
+		CommitMerge: &raft_cmdpb.CommitMergeRequest{
+			Source:  d.region(),
+			Commit:  state.Commit,
+			Entries: entries,
+		},
+	}
+	// Please note that, here assumes that the unit of network isolation is store rather than
+	// peer. So a quorum stores of source region should also be the quorum stores of target
+	// region. Otherwise we need to enable proposal forwarding.
+	return d.ctx.router.sendRaftCommand(&MsgRaftCmd{
+		SendTime: time.Now(),
+		Request:  raftlog.NewRequest(request),
+	})
 }
 
 func (d *peerMsgHandler) rollbackMerge() {
-	// TODO: merge func
+	request := newAdminRequest(d.regionID(), d.peer.Meta)
+	request.Header.RegionEpoch = d.region().RegionEpoch
+	request.AdminRequest = &raft_cmdpb.AdminRequest{
+		CmdType: raft_cmdpb.AdminCmdType_RollbackMerge,
+		RollbackMerge: &raft_cmdpb.RollbackMergeRequest{
+			Commit: d.peer.PendingMergeState.Commit,
+		},
+	}
+	d.proposeRaftCommand(raftlog.NewRequest(request), nil)
 }
 
 func (d *peerMsgHandler) onCheckMerge() {
-	// TODO: merge func
+	if d.stopped || d.peer.PendingMergeState == nil {
+		return
+	}
+	d.ticker.schedule(PeerTickCheckMerge)
+	if err := d.scheduleMerge(); err != nil {
+		log.S().Infof("%s failed to schedule merge, rollback, err %v", d.tag(), err)
+		d.rollbackMerge()
+	}
 }
 
 func (d *peerMsgHandler) onReadyPrepareMerge(region *metapb.Region, state *rspb.MergeState, merged bool) {
-	// TODO: merge func
+	d.ctx.storeMetaLock.Lock()
+	d.ctx.storeMeta.setRegion(region, d.peer)
+	d.ctx.storeMetaLock.Unlock()
+	d.ctx.peerEventObserver.OnRegionConfChange(d.peer.getEventContext(), &metapb.RegionEpoch{
+		ConfVer: region.RegionEpoch.ConfVer,
+		Version: region.RegionEpoch.Version,
+	})
+	d.peer.PendingMergeState = state
+	d.notifyPrepareMerge()
+	if merged {
+		// CommitMerge will try to catch up log for source region. If PrepareMerge is executed
+		// in the progress of catching up, there is no need to schedule merge again.
+		return
+	}
+	d.onCheckMerge()
 }
 
 func (d *peerMsgHandler) onReadyCommitMerge(region, source *metapb.Region) *uint32 {
-	return nil // TODO: merge func
+	d.ctx.storeMetaLock.Lock()
+	meta := d.ctx.storeMeta
+	version := source.RegionEpoch.Version
+	lock := meta.mergeLocks[source.Id]
+	if lock == nil || lock.version < version {
+		// The source peer hasn't handled `PrepareMerge` yet, wait for it.
+		lock = &mergeLock{version: version, readyToMerge: new(uint32)}
+		meta.mergeLocks[source.Id] = lock
+		d.ctx.storeMetaLock.Unlock()
+		log.S().Infof("%s source region %d is not ready to merge, wait for it", d.tag(), source.Id)
+		return lock.readyToMerge
+	}
+	if lock.version > version {
+		panic(fmt.Sprintf("%s unexpected merge lock version %d of source %s", d.tag(), lock.version, source))
+	}
+	if lock.readyToMerge != nil && atomic.LoadUint32(lock.readyToMerge) == 0 {
+		d.ctx.storeMetaLock.Unlock()
+		return lock.readyToMerge
+	}
+	delete(meta.mergeLocks, source.Id)
+	if regionIDFromBytes(meta.regionRanges.Get(source.EndKey, nil)) != source.Id ||
+		!meta.regionRanges.Delete(source.EndKey) {
+		panic(fmt.Sprintf("%s meta corrupted, source %s not found", d.tag(), source))
+	}
+	prevEndKey := region.EndKey
+	if bytes.Equal(region.EndKey, source.EndKey) {
+		// The source region is on the right side.
+		prevEndKey = source.StartKey
+	}
+	if regionIDFromBytes(meta.regionRanges.Get(prevEndKey, nil)) != region.Id ||
+		!meta.regionRanges.Delete(prevEndKey) {
+		panic(fmt.Sprintf("%s meta corrupted, region %s not found", d.tag(), region))
+	}
+	meta.regionRanges.Put(region.EndKey, regionIDToBytes(region.Id))
+	delete(meta.regions, source.Id)
+	meta.setRegion(region, d.peer)
+	d.ctx.storeMetaLock.Unlock()
+
+	// Make approximate size and keys updated in time.
+	d.peer.SizeDiffHint = d.ctx.cfg.RegionSplitCheckDiff
+	d.ctx.peerEventObserver.OnMergeRegion(d.peer.getEventContext(), region)
+	if d.peer.IsLeader() {
+		log.S().Infof("%s notify pd with merge %s into %s", d.tag(), source, region)
+		d.peer.HeartbeatPd(d.ctx.pdTaskSender)
+	}
+	err := d.ctx.router.send(source.Id, NewPeerMsg(MsgTypeMergeResult, source.Id, &MsgMergeResult{
+		TargetPeer: d.peer.Meta,
+		Stale:      false,
+	}))
+	if err != nil {
+		panic(fmt.Sprintf("%s failed to send merge result to source %d, %v", d.tag(), source.Id, err))
+	}
+	return nil
 }
 
+// onReadyRollbackMerge handles the result of `RollbackMerge`. A rollback happens when the target
+// region can't commit the merge, or implicitly when a snapshot is applied while merging.
 func (d *peerMsgHandler) onReadyRollbackMerge(commit uint64, region *metapb.Region) {
-	// TODO: merge func
+	if state := d.peer.PendingMergeState; state != nil && commit != 0 && state.Commit != commit {
+		panic(fmt.Sprintf("%s rollbacks a wrong merge: %d != %d", d.tag(), state.Commit, commit))
+	}
+	d.peer.PendingMergeState = nil
+	d.ctx.storeMetaLock.Lock()
+	delete(d.ctx.storeMeta.mergeLocks, d.regionID())
+	if region != nil {
+		d.ctx.storeMeta.setRegion(region, d.peer)
+	}
+	d.ctx.storeMetaLock.Unlock()
+	if region != nil {
+		d.ctx.peerEventObserver.OnRegionConfChange(d.peer.getEventContext(), &metapb.RegionEpoch{
+			ConfVer: region.RegionEpoch.ConfVer,
+			Version: region.RegionEpoch.Version,
+		})
+	}
+	if d.peer.IsLeader() {
+		log.S().Infof("%s notify pd with rollback merge %d", d.tag(), commit)
+		d.peer.HeartbeatPd(d.ctx.pdTaskSender)
+	}
 }
 
 func (d *peerMsgHandler) onMergeResult(target *metapb.Peer, stale bool) {
-	// TODO: merge func
+	if state := d.peer.PendingMergeState; state != nil {
+		exists := false
+		for _, peer := range state.Target.Peers {
+			if peer.Id == target.Id && peer.StoreId == target.StoreId {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			panic(fmt.Sprintf("%s unexpected merge result: %s %s %v", d.tag(), state, target, stale))
+		}
+	}
+	if !stale {
+		log.S().Infof("%s merge finished, target %s", d.tag(), target)
+		d.destroyPeer(true)
+	} else {
+		d.onStaleMerge()
+	}
 }
 
 func (d *peerMsgHandler) onStaleMerge() {
-	// TODO: merge func
+	log.S().Infof("%s successful merge can't be continued, try to gc stale peer", d.tag())
+	if job := d.peer.MaybeDestroy(); job != nil {
+		d.handleDestroyPeer(job)
+	}
 }
 
 func (d *peerMsgHandler) onReadyApplySnapshot(applyResult *ApplySnapResult) {
@@ -992,24 +1402,59 @@ func (d *peerMsgHandler) onReadyResult(merged bool, execResults []execResult) (*
 		case *execResultPrepareMerge:
 			d.onReadyPrepareMerge(x.region, x.state, merged)
 		case *execResultCommitMerge:
+			// The locks of the source region may be older than the safe ts.
+			d.peer.leaderChecker.safeTS.Store(0)
 			if readyToMerge := d.onReadyCommitMerge(x.region, x.source); readyToMerge != nil {
 				return readyToMerge, execResults[i:]
 			}
 		case *execResultRollbackMerge:
 			d.onReadyRollbackMerge(x.commit, x.region)
 		case *execResultComputeHash:
-			d.onReadyComputeHash(x.region, x.index, x.snap)
+			d.onReadyComputeHash(x.index, x.hash)
 		case *execResultVerifyHash:
 			d.onReadyVerifyHash(x.index, x.hash)
 		case *execResultDeleteRange:
 			// TODO: clean user properties?
+		case *execResultResolvedTS:
+			d.peer.leaderChecker.advanceSafeTS(x.ts)
 		}
 	}
 	return nil, nil
 }
 
 func (d *peerMsgHandler) checkMergeProposal(msg *raft_cmdpb.RaftCmdRequest) error {
-	return nil // TODO: merge func
+	adminReq := msg.GetAdminRequest()
+	if adminReq.GetPrepareMerge() == nil && adminReq.GetCommitMerge() == nil {
+		return nil
+	}
+	region := d.region()
+	if prepareMerge := adminReq.GetPrepareMerge(); prepareMerge != nil {
+		targetRegion := prepareMerge.Target
+		d.ctx.storeMetaLock.RLock()
+		r := d.ctx.storeMeta.regions[targetRegion.Id]
+		d.ctx.storeMetaLock.RUnlock()
+		if r == nil {
+			return errors.Errorf("target region %d doesn't exist", targetRegion.Id)
+		}
+		if !RegionEqual(r, targetRegion) {
+			return errors.Errorf("target region not matched, skip proposing: %s != %s", r, targetRegion)
+		}
+		if !isSiblingRegions(targetRegion, region) {
+			return errors.Errorf("%s and %s are not sibling, skip proposing", targetRegion, region)
+		}
+		if !regionOnSameStores(targetRegion, region) {
+			return errors.Errorf("peers doesn't match %s != %s, reject merge", region.Peers, targetRegion.Peers)
+		}
+	} else {
+		sourceRegion := adminReq.CommitMerge.Source
+		if !isSiblingRegions(sourceRegion, region) {
+			return errors.Errorf("%s and %s should be sibling", sourceRegion, region)
+		}
+		if !regionOnSameStores(sourceRegion, region) {
+			return errors.Errorf("peers not matched: %s %s", sourceRegion, region)
+		}
+	}
+	return nil
 }
 
 func (d *peerMsgHandler) preProposeRaftCommand(rlog raftlog.RaftLog) (*raft_cmdpb.RaftCmdResponse, error) {
@@ -1026,7 +1471,7 @@ func (d *peerMsgHandler) preProposeRaftCommand(rlog raftlog.RaftLog) (*raft_cmdp
 	// Check whether the store has the right peer to handle the request.
 	regionID := d.regionID()
 	leaderID := d.peer.LeaderId()
-	if !d.peer.IsLeader() {
+	if !d.peer.IsLeader() && !isReplicaReadRequest(req) {
 		leader := d.peer.getPeerFromCache(leaderID)
 		return nil, &ErrNotLeader{regionID, leader}
 	}
@@ -1381,17 +1826,9 @@ func (d *peerMsgHandler) onCheckPeerStaleStateTick() {
 	}
 }
 
-func (d *peerMsgHandler) onReadyComputeHash(region *metapb.Region, index uint64, snap *mvcc.DBSnapshot) {
+func (d *peerMsgHandler) onReadyComputeHash(index uint64, hash []byte) {
 	d.peer.ConsistencyState.LastCheckTime = time.Now()
-	log.S().Infof("%s schedule compute hash task", d.tag())
-	d.ctx.computeHashTaskSender <- task{
-		tp: taskTypeComputeHash,
-		data: &computeHashTask{
-			region: region,
-			index:  index,
-			snap:   snap,
-		},
-	}
+	d.onHashComputed(index, hash)
 }
 
 func (d *peerMsgHandler) onReadyVerifyHash(expectedIndex uint64, expectedHash []byte) {
@@ -1421,10 +1858,13 @@ func (d *peerMsgHandler) verifyAndStoreHash(expectedIndex uint64, expectedHash [
 			return false
 		}
 		if !bytes.Equal(state.Hash, expectedHash) {
-			panic(fmt.Sprintf("%s hash at %d not correct want %v, got %v",
-				d.tag(), index, expectedHash, state.Hash))
+			// Keep serving so the diverged peers can be located by the log and metric.
+			metrics.ConsistencyCheckFailures.Inc()
+			log.S().Errorf("%s consistency check failed, peer %d, index %d, want %x, got %x",
+				d.tag(), d.peer.PeerId(), index, expectedHash, state.Hash)
+		} else {
+			log.S().Infof("%s consistency check pass, index %d", d.tag(), index)
 		}
-		log.S().Infof("%s consistency check pass, index %d", d.tag(), index)
 		state.Hash = nil
 		return false
 	}
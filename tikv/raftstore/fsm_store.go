@@ -76,26 +76,32 @@ func (m *storeMeta) setRegion(region *metapb.Region, peer *Peer) {
 }
 
 type mergeLock struct {
+	// The epoch version of the source region after `PrepareMerge`.
+	version uint64
+	// It is set by the target peer if `CommitMerge` is handled before `PrepareMerge`,
+	// the source peer stores 1 to it after handling `PrepareMerge`.
+	readyToMerge *uint32
 }
 
 type GlobalContext struct {
-	cfg                   *Config
-	engine                *Engines
-	store                 *metapb.Store
-	storeMeta             *storeMeta
-	storeMetaLock         *sync.RWMutex
-	snapMgr               *SnapManager
-	router                *router
-	trans                 Transport
-	pdTaskSender          chan<- task
-	regionTaskSender      chan<- task
-	computeHashTaskSender chan<- task
-	raftLogGCTaskSender   chan<- task
-	splitCheckTaskSender  chan<- task
-	compactTaskSender     chan<- task
-	pdClient              pd.Client
-	peerEventObserver     PeerEventObserver
-	globalStats           *storeStats
+	cfg                  *Config
+	engine               *Engines
+	store                *metapb.Store
+	storeMeta            *storeMeta
+	storeMetaLock        *sync.RWMutex
+	snapMgr              *SnapManager
+	router               *router
+	trans                Transport
+	pdTaskSender         chan<- task
+	regionTaskSender     chan<- task
+	raftLogGCTaskSender  chan<- task
+	splitCheckTaskSender chan<- task
+	compactTaskSender    chan<- task
+	pdClient             pd.Client
+	peerEventObserver    PeerEventObserver
+	globalStats          *storeStats
+	// evictingLeaders is set to 1 when the store is moving its leaders away before shutting down.
+	evictingLeaders uint32
 }
 
 type StoreContext struct {
@@ -114,6 +120,10 @@ type RaftContext struct {
 	queuedSnaps  map[uint64]struct{}
 	isBusy       bool
 	localStats   *storeStats
+	// The count of the base ticks of the raft worker.
+	tickCount int64
+	// The peers that are not hibernated, they are ticked on every base tick.
+	awakePeers map[uint64]struct{}
 }
 
 type storeStats struct {
@@ -347,13 +357,12 @@ func (bs *raftBatchSystem) clearStaleMeta(kvWB, raftWB *WriteBatch, originState
 }
 
 type workers struct {
-	pdWorker          *worker
-	raftLogGCWorker   *worker
-	computeHashWorker *worker
-	splitCheckWorker  *worker
-	regionWorker      *worker
-	compactWorker     *worker
-	wg                *sync.WaitGroup
+	pdWorker         *worker
+	raftLogGCWorker  *worker
+	splitCheckWorker *worker
+	regionWorker     *worker
+	compactWorker    *worker
+	wg               *sync.WaitGroup
 }
 
 type raftBatchSystem struct {
@@ -385,32 +394,30 @@ func (bs *raftBatchSystem) start(
 	}
 	wg := new(sync.WaitGroup)
 	bs.workers = &workers{
-		splitCheckWorker:  newWorker("split-check", wg),
-		regionWorker:      newWorker("snapshot-worker", wg),
-		raftLogGCWorker:   newWorker("raft-gc-worker", wg),
-		compactWorker:     newWorker("compact-worker", wg),
-		pdWorker:          pdWorker,
-		computeHashWorker: newWorker("compute-hash", wg),
-		wg:                wg,
+		splitCheckWorker: newWorker("split-check", wg),
+		regionWorker:     newWorker("snapshot-worker", wg),
+		raftLogGCWorker:  newWorker("raft-gc-worker", wg),
+		compactWorker:    newWorker("compact-worker", wg),
+		pdWorker:         pdWorker,
+		wg:               wg,
 	}
 	bs.ctx = &GlobalContext{
-		cfg:                   cfg,
-		engine:                engines,
-		store:                 meta,
-		storeMeta:             newStoreMeta(),
-		storeMetaLock:         new(sync.RWMutex),
-		snapMgr:               snapMgr,
-		router:                bs.router,
-		trans:                 trans,
-		pdTaskSender:          bs.workers.pdWorker.sender,
-		regionTaskSender:      bs.workers.regionWorker.sender,
-		computeHashTaskSender: bs.workers.computeHashWorker.sender,
-		splitCheckTaskSender:  bs.workers.splitCheckWorker.sender,
-		raftLogGCTaskSender:   bs.workers.raftLogGCWorker.sender,
-		compactTaskSender:     bs.workers.compactWorker.sender,
-		pdClient:              pdClient,
-		peerEventObserver:     observer,
-		globalStats:           new(storeStats),
+		cfg:                  cfg,
+		engine:               engines,
+		store:                meta,
+		storeMeta:            newStoreMeta(),
+		storeMetaLock:        new(sync.RWMutex),
+		snapMgr:              snapMgr,
+		router:               bs.router,
+		trans:                trans,
+		pdTaskSender:         bs.workers.pdWorker.sender,
+		regionTaskSender:     bs.workers.regionWorker.sender,
+		splitCheckTaskSender: bs.workers.splitCheckWorker.sender,
+		raftLogGCTaskSender:  bs.workers.raftLogGCWorker.sender,
+		compactTaskSender:    bs.workers.compactWorker.sender,
+		pdClient:             pdClient,
+		peerEventObserver:    observer,
+		globalStats:          new(storeStats),
 	}
 	regionPeers, err := bs.loadPeers()
 	if err != nil {
@@ -449,7 +456,6 @@ func (bs *raftBatchSystem) startWorkers(peers []*peerFsm) {
 	workers.raftLogGCWorker.start(&raftLogGCTaskHandler{})
 	workers.compactWorker.start(&compactTaskHandler{engine: engines.kv.DB})
 	workers.pdWorker.start(newPDTaskHandler(ctx.store.Id, ctx.pdClient, bs.router))
-	workers.computeHashWorker.start(&computeHashTaskHandler{router: bs.router})
 }
 
 func (bs *raftBatchSystem) shutDown() {
@@ -464,7 +470,6 @@ func (bs *raftBatchSystem) shutDown() {
 	workers.splitCheckWorker.sender <- stopTask
 	workers.regionWorker.sender <- stopTask
 	workers.raftLogGCWorker.sender <- stopTask
-	workers.computeHashWorker.sender <- stopTask
 	workers.pdWorker.sender <- stopTask
 	workers.compactWorker.sender <- stopTask
 	workers.wg.Wait()
@@ -523,7 +528,15 @@ func (d *storeMsgHandler) checkMsg(msg *rspb.RaftMessage) (bool, error) {
 	region := localState.Region
 	regionEpoch := region.RegionEpoch
 	if localState.MergeState != nil {
-		// TODO: Merge
+		log.S().Infof("merged peer receives a stale message. region_id:%d, current_region_epoch:%s, msg_type:%s",
+			regionID, regionEpoch, msgType)
+		var mergeTarget *metapb.Region
+		if peer := findPeer(region, fromStoreID); peer != nil {
+			// Let stale peer decides whether it should wait for merging or just remove itself.
+			mergeTarget = localState.MergeState.Target
+		}
+		// If a peer is isolated before prepare_merge and conf remove, it should just remove itself.
+		handleStaleMsg(d.ctx.trans, msg, regionEpoch, true, mergeTarget)
 		return true, nil
 	}
 	// The region in this peer is already destroyed
@@ -762,9 +775,6 @@ func (d *storeMsgHandler) onSnapMgrGC() {
 
 func (d *storeMsgHandler) onComputeHashTick() {
 	d.ticker.scheduleStore(StoreTickConsistencyCheck)
-	if len(d.ctx.computeHashTaskSender) > 0 {
-		return
-	}
 	targetRegion := d.findTargetRegionForComputeHash()
 	if targetRegion == nil {
 		return
@@ -18,6 +18,7 @@ import (
 	"encoding/binary"
 
 	"github.com/coocood/badger/y"
+	"github.com/ngaut/unistore/tikv/mvcc"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/tidb/util/codec"
@@ -160,6 +161,43 @@ func RawEndKey(region *metapb.Region) []byte {
 	return decoded
 }
 
+// prefixedKeyPrefixes are the prefixes of the key spaces stored out of the data keys in the kv DB, the key of a
+// region in such a key space is the prefix followed by the key.
+var prefixedKeyPrefixes = [][]byte{mvcc.RawKeyPrefix, mvcc.VerKeyPrefix}
+
+// prefixedKeyRanges returns the ranges of the data range [startKey, endKey) in the prefixed key spaces, i.e. the
+// raw keys and the versioned keys, MinDataKey and MaxDataKey stand for the unbounded start and end of a region.
+func prefixedKeyRanges(startKey, endKey []byte) []keyRange {
+	if bytes.Equal(startKey, MinDataKey) {
+		startKey = nil
+	}
+	if bytes.Equal(endKey, MaxDataKey) {
+		endKey = nil
+	}
+	verStart := append([]byte{}, mvcc.VerKeyPrefix...)
+	if len(startKey) > 0 {
+		verStart = mvcc.EncodeVerStartKey(startKey)
+	}
+	return []keyRange{
+		{startKey: mvcc.EncodeRawKey(startKey), endKey: mvcc.EncodeRawEndKey(endKey)},
+		{startKey: verStart, endKey: mvcc.EncodeVerEndKey(endKey)},
+	}
+}
+
+// isExtraKey returns whether the key is a rollback or op lock record of a data key.
+func isExtraKey(key []byte) bool {
+	return len(key) > 0 && (key[0] == 'n' || key[0] == 'u')
+}
+
+func isPrefixedKey(key []byte) bool {
+	for _, prefix := range prefixedKeyPrefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 /// RaftLogIndex gets the log index from raft log key generated by `raft_log_key`.
 func RaftLogIndex(key []byte) (uint64, error) {
 	if len(key) != RegionRaftLogLen {
@@ -43,6 +43,7 @@ const (
 	MsgTypeStart                  MsgType = 14
 	MsgTypeApplyRes               MsgType = 15
 	MsgTypeNoop                   MsgType = 16
+	MsgTypeEvictLeader            MsgType = 17
 
 	MsgTypeStoreRaftMessage   MsgType = 101
 	MsgTypeStoreSnapshotStats MsgType = 102
@@ -164,6 +165,12 @@ type MsgMergeResult struct {
 	Stale      bool
 }
 
+// MsgEvictLeader asks a leader to transfer its leadership to a healthy follower, the callback is told whether
+// the peer is not the leader and has applied all its committed logs.
+type MsgEvictLeader struct {
+	Callback func(evicted bool)
+}
+
 type SnapKeyWithSending struct {
 	SnapKey   SnapKey
 	IsSending bool
@@ -56,6 +56,7 @@ type ReadIndexRequest struct {
 	id             uint64
 	cmds           []*ReqCbPair
 	renewLeaseTime *time.Time
+	readIndex      uint64
 }
 
 func NewReadIndexRequest(id uint64, cmds []*ReqCbPair, renewLeaseTime *time.Time) *ReadIndexRequest {
@@ -102,6 +103,20 @@ func (r *ReadIndexQueue) NextId() uint64 {
 	return r.idAllocator
 }
 
+/// Sets the read index of the request with the id and all the requests before it. A follower may lose
+/// the ReadIndexResp messages, so the read states are not guaranteed to match the requests one by one.
+func (r *ReadIndexQueue) Advance(id []byte, readIndex uint64) {
+	for i := r.readyCnt; i < len(r.reads); i++ {
+		if bytes.Equal(r.reads[i].binaryId(), id) {
+			for _, read := range r.reads[r.readyCnt : i+1] {
+				read.readIndex = readIndex
+			}
+			r.readyCnt = i + 1
+			return
+		}
+	}
+}
+
 func (r *ReadIndexQueue) ClearUncommitted(term uint64) {
 	uncommitted := r.reads[r.readyCnt:]
 	r.reads = r.reads[:r.readyCnt]
@@ -772,6 +787,36 @@ func (p *Peer) isMerging() bool {
 	return p.lastCommittedPrepareMergeIdx > p.Store().AppliedIndex() || p.PendingMergeState != nil
 }
 
+// canHibernate returns whether the region can be hibernated on the peer. The leader requires the followers to
+// have replicated all its logs, and a follower requires a leader. A follower may not know the commit of its last
+// logs, which is sent by the next heartbeat of the leader.
+func (p *Peer) canHibernate() bool {
+	raftLog := p.RaftGroup.Raft.RaftLog
+	lastIndex := raftLog.LastIndex()
+	status := p.RaftGroup.StatusWithoutProgress()
+	if p.Store().AppliedIndex() != status.Commit || len(p.pendingReads.reads) > 0 ||
+		p.HasPendingSnapshot() || p.IsApplyingSnapshot() || p.PendingMergeState != nil || p.PendingRemove {
+		return false
+	}
+	switch status.RaftState {
+	case raft.StateLeader:
+		if status.Commit != lastIndex || status.LeadTransferee != InvalidID {
+			return false
+		}
+		for _, prs := range []map[uint64]*raft.Progress{p.RaftGroup.Raft.Prs, p.RaftGroup.Raft.LearnerPrs} {
+			for _, pr := range prs {
+				if pr.Match != lastIndex {
+					return false
+				}
+			}
+		}
+		return true
+	case raft.StateFollower:
+		return status.Lead != InvalidID
+	}
+	return false
+}
+
 func (p *Peer) TakeApplyProposals() *regionProposal {
 	if len(p.applyProposals) == 0 {
 		return nil
@@ -1079,31 +1124,24 @@ func (p *Peer) HandleRaftReadyApply(kv *mvcc.DBBundle, applyMsgs *applyMsgs, rea
 
 func (p *Peer) ApplyReads(kv *mvcc.DBBundle, ready *raft.Ready) {
 	var proposeTime *time.Time
-	if p.readyToHandleRead() {
+	if !p.IsLeader() {
 		for _, state := range ready.ReadStates {
-			read := p.pendingReads.PopFront()
-			if read == nil {
-				panic("read should exist")
-			}
-			if bytes.Compare(state.RequestCtx, read.binaryId()) != 0 {
-				panic(fmt.Sprintf("request ctx: %v not equal to read id: %v", state.RequestCtx, read.binaryId()))
-			}
-			for _, reqCb := range read.cmds {
-				resp := p.handleRead(kv, reqCb.Req, true)
-				reqCb.Cb.Done(resp)
-			}
-			read.cmds = nil
-			proposeTime = read.renewLeaseTime
+			p.pendingReads.Advance(state.RequestCtx, state.Index)
 		}
+		p.postPendingReadIndexOnReplica(kv)
 	} else {
 		for _, state := range ready.ReadStates {
 			read := p.pendingReads.reads[p.pendingReads.readyCnt]
 			if bytes.Compare(state.RequestCtx, read.binaryId()) != 0 {
 				panic(fmt.Sprintf("request ctx: %v not equal to read id: %v", state.RequestCtx, read.binaryId()))
 			}
+			read.readIndex = state.Index
 			p.pendingReads.readyCnt += 1
 			proposeTime = read.renewLeaseTime
 		}
+		if p.readyToHandleRead() {
+			p.handleReadyReads(kv)
+		}
 	}
 
 	// Note that only after handle read_states can we identify what requests are
@@ -1151,19 +1189,10 @@ func (p *Peer) PostApply(kv *mvcc.DBBundle, applyState applyState, appliedIndexT
 		hasReady = true
 	}
 
-	if p.pendingReads.readyCnt > 0 && p.readyToHandleRead() {
-		for i := 0; i < p.pendingReads.readyCnt; i++ {
-			read := p.pendingReads.PopFront()
-			if read == nil {
-				panic("read is nil, this should not happen")
-			}
-			for _, reqCb := range read.cmds {
-				resp := p.handleRead(kv, reqCb.Req, true)
-				reqCb.Cb.Done(resp)
-			}
-			read.cmds = nil
-		}
-		p.pendingReads.readyCnt = 0
+	if !p.IsLeader() {
+		p.postPendingReadIndexOnReplica(kv)
+	} else if p.pendingReads.readyCnt > 0 && p.readyToHandleRead() {
+		p.handleReadyReads(kv)
 	}
 
 	// Only leaders need to update applied_index_term.
@@ -1174,6 +1203,47 @@ func (p *Peer) PostApply(kv *mvcc.DBBundle, applyState applyState, appliedIndexT
 	return hasReady
 }
 
+/// Responds all the read requests which have got the read index.
+func (p *Peer) handleReadyReads(kv *mvcc.DBBundle) {
+	for ; p.pendingReads.readyCnt > 0; p.pendingReads.readyCnt-- {
+		read := p.pendingReads.PopFront()
+		if read == nil {
+			panic("read is nil, this should not happen")
+		}
+		p.respondRead(kv, read)
+	}
+}
+
+/// Responds the read requests of a follower whose read index has been applied.
+func (p *Peer) postPendingReadIndexOnReplica(kv *mvcc.DBBundle) {
+	for ; p.pendingReads.readyCnt > 0; p.pendingReads.readyCnt-- {
+		read := p.pendingReads.reads[0]
+		if read.readIndex > p.Store().AppliedIndex() {
+			return
+		}
+		p.pendingReads.PopFront()
+		p.respondRead(kv, read)
+	}
+}
+
+func (p *Peer) respondRead(kv *mvcc.DBBundle, read *ReadIndexRequest) {
+	for _, reqCb := range read.cmds {
+		resp := p.handleRead(kv, reqCb.Req, true, read.readIndex)
+		reqCb.Cb.Done(resp)
+	}
+	read.cmds = nil
+}
+
+/// Retries the read index of a follower in case the request or the response is dropped.
+func (p *Peer) RetryPendingReads() {
+	if p.IsLeader() || len(p.pendingReads.reads) == p.pendingReads.readyCnt || p.preReadIndex() != nil {
+		return
+	}
+	// The read index of the last read covers all the reads before it.
+	read := p.pendingReads.reads[len(p.pendingReads.reads)-1]
+	p.RaftGroup.ReadIndex(read.binaryId())
+}
+
 func (p *Peer) PostSplit() {
 	// Reset delete_keys_hint and size_diff_hint.
 	p.deleteKeysHint = 0
@@ -1267,14 +1337,15 @@ func (p *Peer) countHealthyNode(progress map[uint64]raft.Progress) int {
 
 /// Validate the `ConfChange` request and check whether it's safe to
 /// propose the specified conf change request.
-/// It's safe iff at least the quorum of the Raft group is still healthy
-/// right after that conf change is applied.
-/// Define the total number of nodes in current Raft cluster to be `total`.
+/// The peer must not conflict with the peers of the region, see `checkChangePeer`.
+/// It's safe iff at least the quorum of the voters of the Raft group is still healthy
+/// right after that conf change is applied, learners don't vote so they are not counted.
+/// Define the total number of voters in current Raft cluster to be `total`.
 /// To ensure the above safety, if the cmd is
 /// 1. A `AddNode` request
-///    Then at least '(total + 1)/2 + 1' nodes need to be up to date for now.
+///    Then at least '(total + 1)/2 + 1' voters need to be up to date for now.
 /// 2. A `RemoveNode` request
-///    Then at least '(total - 1)/2 + 1' other nodes (the node about to be removed is excluded)
+///    Then at least '(total - 1)/2 + 1' other voters (the voter about to be removed is excluded)
 ///    need to be up to date for now. If 'allow_remove_leader' is false then
 ///    the peer to be removed should not be the leader.
 func (p *Peer) checkConfChange(cfg *Config, cmd *raft_cmdpb.RaftCmdRequest) error {
@@ -1288,6 +1359,10 @@ func (p *Peer) checkConfChange(cfg *Config, cmd *raft_cmdpb.RaftCmdRequest) erro
 		log.S().Warnf("%s conf change type: %v, but got peer %v", p.Tag, changeType, peer)
 		return fmt.Errorf("invalid conf change request")
 	}
+	if err := checkChangePeer(p.Region(), changeType, peer); err != nil {
+		log.S().Warnf("%s rejects conf change request %v, %v", p.Tag, changePeer, err)
+		return err
+	}
 
 	if changeType == eraftpb.ConfChangeType_RemoveNode && !cfg.AllowRemoveLeader && peer.Id == p.PeerId() {
 		log.S().Warnf("%s rejects remove leader request %v", p.Tag, changePeer)
@@ -1295,9 +1370,16 @@ func (p *Peer) checkConfChange(cfg *Config, cmd *raft_cmdpb.RaftCmdRequest) erro
 	}
 
 	status := p.RaftGroup.Status()
-	total := len(status.Progress)
-	if total == 1 {
-		// It's always safe if there is only one node in the cluster.
+	// status.Progress includes learner progress.
+	voters := make(map[uint64]raft.Progress, len(status.Progress))
+	for id, pr := range status.Progress {
+		if !pr.IsLearner {
+			voters[id] = pr
+		}
+	}
+	total := len(voters)
+	if total == 1 && changeType != eraftpb.ConfChangeType_RemoveNode {
+		// It's always safe to add a node if there is only one voter in the cluster.
 		return nil
 	}
 
@@ -1306,28 +1388,24 @@ func (p *Peer) checkConfChange(cfg *Config, cmd *raft_cmdpb.RaftCmdRequest) erro
 		if pr, ok := status.Progress[peer.Id]; ok && pr.IsLearner {
 			// For promote learner to voter.
 			pr.IsLearner = false
-			status.Progress[peer.Id] = pr
+			voters[peer.Id] = pr
 		} else {
-			status.Progress[peer.Id] = raft.Progress{}
+			voters[peer.Id] = raft.Progress{}
 		}
 	case eraftpb.ConfChangeType_RemoveNode:
-		if peer.GetIsLearner() {
-			// If the node is a learner, we can return directly.
-			return nil
-		}
-		if _, ok := status.Progress[peer.Id]; ok {
-			delete(status.Progress, peer.Id)
+		if _, ok := voters[peer.Id]; ok {
+			delete(voters, peer.Id)
 		} else {
-			// It's always safe to remove a not existing node.
+			// It's always safe to remove a learner or a not existing node.
 			return nil
 		}
 	case eraftpb.ConfChangeType_AddLearnerNode:
 		return nil
 	}
 
-	healthy := p.countHealthyNode(status.Progress)
-	quorumAfterChange := Quorum(len(status.Progress))
-	if healthy >= quorumAfterChange {
+	healthy := p.countHealthyNode(voters)
+	quorumAfterChange := Quorum(len(voters))
+	if len(voters) > 0 && healthy >= quorumAfterChange {
 		return nil
 	}
 
@@ -1352,7 +1430,7 @@ func (p *Peer) readyToTransferLeader(cfg *Config, peer *metapb.Peer) bool {
 	peerId := peer.GetId()
 	status := p.RaftGroup.Status()
 
-	if _, ok := status.Progress[peerId]; !ok {
+	if pr, ok := status.Progress[peerId]; !ok || pr.IsLearner {
 		return false
 	}
 
@@ -1371,8 +1449,29 @@ func (p *Peer) readyToTransferLeader(cfg *Config, peer *metapb.Peer) bool {
 	return lastIndex <= status.Progress[peerId].Match+cfg.LeaderTransferMaxLogLag
 }
 
+// pickTransferee returns the voter with the most matched logs among the followers which are not down and are ready
+// to take over the leadership, nil is returned if there is no such follower.
+func (p *Peer) pickTransferee(cfg *Config) *metapb.Peer {
+	var target *metapb.Peer
+	var targetMatch uint64
+	for _, peer := range p.Region().GetPeers() {
+		if peer.GetId() == p.PeerId() || peer.GetIsLearner() {
+			continue
+		}
+		lastHeartbeat, ok := p.PeerHeartbeats[peer.GetId()]
+		if !ok || time.Since(lastHeartbeat) >= cfg.MaxPeerDownDuration || !p.readyToTransferLeader(cfg, peer) {
+			continue
+		}
+		pr := p.RaftGroup.Raft.Prs[peer.GetId()]
+		if pr != nil && (target == nil || pr.Match > targetMatch) {
+			target, targetMatch = peer, pr.Match
+		}
+	}
+	return target
+}
+
 func (p *Peer) readLocal(kv *mvcc.DBBundle, req *raft_cmdpb.RaftCmdRequest, cb *Callback) {
-	resp := p.handleRead(kv, req, false)
+	resp := p.handleRead(kv, req, false, p.Store().AppliedIndex())
 	cb.Done(resp)
 }
 
@@ -1401,12 +1500,16 @@ func (p *Peer) readIndex(cfg *Config, req *raft_cmdpb.RaftCmdRequest, errResp *r
 		return false
 	}
 
+	if !p.IsLeader() {
+		return p.readIndexOnReplica(req, errResp, cb)
+	}
+
 	now := time.Now()
 	renewLeaseTime := &now
 	readsLen := len(p.pendingReads.reads)
 	if readsLen > 0 {
 		read := p.pendingReads.reads[readsLen-1]
-		if read.renewLeaseTime.Add(cfg.RaftStoreMaxLeaderLease).After(*renewLeaseTime) {
+		if read.renewLeaseTime != nil && read.renewLeaseTime.Add(cfg.RaftStoreMaxLeaderLease).After(*renewLeaseTime) {
 			read.cmds = append(read.cmds, &ReqCbPair{Req: req, Cb: cb})
 			return false
 		}
@@ -1449,6 +1552,22 @@ func (p *Peer) readIndex(cfg *Config, req *raft_cmdpb.RaftCmdRequest, errResp *r
 	return true
 }
 
+/// Asks the leader for the read index, the read is responded after the follower has applied to the read index.
+func (p *Peer) readIndexOnReplica(req *raft_cmdpb.RaftCmdRequest, errResp *raft_cmdpb.RaftCmdResponse, cb *Callback) bool {
+	if p.LeaderId() == raft.None {
+		BindRespError(errResp, &ErrNotLeader{RegionId: p.regionId})
+		cb.Done(errResp)
+		return false
+	}
+	id := p.pendingReads.NextId()
+	ctx := make([]byte, 8)
+	binary.BigEndian.PutUint64(ctx, id)
+	p.RaftGroup.ReadIndex(ctx)
+	cmds := []*ReqCbPair{{req, cb}}
+	p.pendingReads.reads = append(p.pendingReads.reads, NewReadIndexRequest(id, cmds, nil))
+	return true
+}
+
 func (p *Peer) GetMinProgress() uint64 {
 	var minMatch uint64 = math.MaxUint64
 	hasProgress := false
@@ -1483,7 +1602,8 @@ func (p *Peer) preProposePrepareMerge(cfg *Config, req *raft_cmdpb.RaftCmdReques
 		if entry.EntryType == eraftpb.EntryType_EntryConfChange {
 			return fmt.Errorf("log gap contains conf change, skip merging.")
 		}
-		if len(entry.Data) == 0 {
+		if len(entry.Data) == 0 || entry.Data[0] == raftlog.CustomRaftLogFlag {
+			// Custom raft logs only contain writes, which don't change epoch.
 			continue
 		}
 		cmd := raft_cmdpb.RaftCmdRequest{}
@@ -1644,9 +1764,9 @@ func (p *Peer) ProposeConfChange(cfg *Config, req *raft_cmdpb.RaftCmdRequest) (u
 	return proposeIndex, nil
 }
 
-func (p *Peer) handleRead(kv *mvcc.DBBundle, req *raft_cmdpb.RaftCmdRequest, checkEpoch bool) *raft_cmdpb.RaftCmdResponse {
+func (p *Peer) handleRead(kv *mvcc.DBBundle, req *raft_cmdpb.RaftCmdRequest, checkEpoch bool, readIndex uint64) *raft_cmdpb.RaftCmdResponse {
 	readExecutor := NewReadExecutor(checkEpoch)
-	resp := readExecutor.Execute(req, p.Region())
+	resp := readExecutor.Execute(req, p.Region(), readIndex)
 	BindRespTerm(resp, p.Term())
 	return resp
 }
@@ -1693,6 +1813,10 @@ func (p *Peer) inspect(rlog raftlog.RaftLog) (RequestPolicy, error) {
 	if req == nil {
 		return RequestPolicy_ProposeNormal, nil
 	}
+	// A follower can only serve the replica read after getting the read index from the leader.
+	if !p.IsLeader() && isReplicaReadRequest(req) {
+		return RequestPolicy_ReadIndex, nil
+	}
 	return Inspect(p, req)
 }
 
@@ -1710,7 +1834,7 @@ func Inspect(i RequestInspector, req *raft_cmdpb.RaftCmdRequest) (RequestPolicy,
 	hasRead, hasWrite := false, false
 	for _, r := range req.Requests {
 		switch r.CmdType {
-		case raft_cmdpb.CmdType_Get, raft_cmdpb.CmdType_Snap:
+		case raft_cmdpb.CmdType_Get, raft_cmdpb.CmdType_Snap, raft_cmdpb.CmdType_ReadIndex:
 			hasRead = true
 		case raft_cmdpb.CmdType_Delete, raft_cmdpb.CmdType_Put, raft_cmdpb.CmdType_DeleteRange,
 			raft_cmdpb.CmdType_IngestSST:
@@ -1760,7 +1884,7 @@ func NewReadExecutor(checkEpoch bool) *ReadExecutor {
 	}
 }
 
-func (r *ReadExecutor) Execute(msg *raft_cmdpb.RaftCmdRequest, region *metapb.Region) *raft_cmdpb.RaftCmdResponse {
+func (r *ReadExecutor) Execute(msg *raft_cmdpb.RaftCmdRequest, region *metapb.Region, readIndex uint64) *raft_cmdpb.RaftCmdResponse {
 	if r.checkEpoch {
 		if err := CheckRegionEpoch(msg, region, true); err != nil {
 			log.S().Debugf("[region %v] epoch not match, err: %v", region.Id, err)
@@ -1774,6 +1898,10 @@ func (r *ReadExecutor) Execute(msg *raft_cmdpb.RaftCmdRequest, region *metapb.Re
 		case raft_cmdpb.CmdType_Snap:
 			resp = new(raft_cmdpb.Response)
 			resp.CmdType = req.CmdType
+		case raft_cmdpb.CmdType_ReadIndex:
+			resp = new(raft_cmdpb.Response)
+			resp.CmdType = req.CmdType
+			resp.ReadIndex = &raft_cmdpb.ReadIndexResponse{ReadIndex: readIndex}
 		default:
 			panic("unreachable")
 		}
@@ -218,3 +218,25 @@ func TestRequestInspector(t *testing.T) {
 		assert.NotNil(t, err)
 	}
 }
+
+func TestReadIndexQueueAdvance(t *testing.T) {
+	queue := new(ReadIndexQueue)
+	for i := 0; i < 3; i++ {
+		queue.reads = append(queue.reads, NewReadIndexRequest(queue.NextId(), nil, nil))
+	}
+	// The response of the first read is lost, it's covered by the read index of the second one.
+	queue.Advance(queue.reads[1].binaryId(), 10)
+	assert.Equal(t, 2, queue.readyCnt)
+	assert.Equal(t, uint64(10), queue.reads[0].readIndex)
+	assert.Equal(t, uint64(10), queue.reads[1].readIndex)
+	assert.Equal(t, uint64(0), queue.reads[2].readIndex)
+
+	// A duplicated response is ignored.
+	queue.Advance(queue.reads[0].binaryId(), 12)
+	assert.Equal(t, 2, queue.readyCnt)
+	assert.Equal(t, uint64(10), queue.reads[0].readIndex)
+
+	queue.Advance(queue.reads[2].binaryId(), 12)
+	assert.Equal(t, 3, queue.readyCnt)
+	assert.Equal(t, uint64(12), queue.reads[2].readIndex)
+}
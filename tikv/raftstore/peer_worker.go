@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/ngaut/unistore/metrics"
+	"github.com/pingcap/log"
 )
 
 // peerState contains the peer states that needs to run raft command and apply command.
@@ -70,6 +71,7 @@ func newRaftWorker(ctx *GlobalContext, ch chan Msg, pm *router) *raftWorker {
 		kvWB:          new(WriteBatch),
 		raftWB:        new(WriteBatch),
 		localStats:    new(storeStats),
+		awakePeers:    make(map[uint64]struct{}),
 	}
 	applyResCh := make(chan Msg, cap(ch))
 	return &raftWorker{
@@ -103,10 +105,7 @@ func (rw *raftWorker) run(closeCh <-chan struct{}, wg *sync.WaitGroup) {
 		case msg := <-rw.applyResCh:
 			msgs = append(msgs, msg)
 		case <-timeTicker.C:
-			rw.pr.peers.Range(func(key, value interface{}) bool {
-				msgs = append(msgs, NewPeerMsg(MsgTypeTick, key.(uint64), nil))
-				return true
-			})
+			msgs = rw.appendTickMsgs(msgs)
 		}
 		pending := len(rw.raftCh)
 		for i := 0; i < pending; i++ {
@@ -156,6 +155,29 @@ func (rw *raftWorker) run(closeCh <-chan struct{}, wg *sync.WaitGroup) {
 	}
 }
 
+// appendTickMsgs appends the tick messages of the peers for a base tick, the hibernated peers are only ticked
+// every RaftHibernateTicks base ticks.
+func (rw *raftWorker) appendTickMsgs(msgs []Msg) []Msg {
+	ctx := rw.raftCtx
+	ctx.tickCount++
+	if !ctx.cfg.HibernateRegions || ctx.tickCount%int64(ctx.cfg.RaftHibernateTicks) == 0 {
+		rw.pr.peers.Range(func(key, value interface{}) bool {
+			msgs = append(msgs, NewPeerMsg(MsgTypeTick, key.(uint64), nil))
+			return true
+		})
+		return msgs
+	}
+	for regionID := range ctx.awakePeers {
+		if rw.pr.get(regionID) == nil {
+			// The peer is destroyed.
+			delete(ctx.awakePeers, regionID)
+			continue
+		}
+		msgs = append(msgs, NewPeerMsg(MsgTypeTick, regionID, nil))
+	}
+	return msgs
+}
+
 func (rw *raftWorker) getPeerState(peersMap map[uint64]*peerState, regionID uint64) *peerState {
 	peer, ok := peersMap[regionID]
 	if !ok {
@@ -256,10 +278,32 @@ func (aw *applyWorker) run(wg *sync.WaitGroup) {
 			}
 			ps.apply.handleTask(aw.ctx, msg)
 		}
+		aw.handleApplyMsgs(batch)
 		aw.ctx.flush()
 	}
 }
 
+// handleApplyMsgs handles the messages sent between appliers, like `CatchUpLogs` and `LogsUpToDate` for merge.
+func (aw *applyWorker) handleApplyMsgs(batch *applyBatch) {
+	applyMsgs := &aw.ctx.applyMsgs
+	for len(applyMsgs.msgs) > 0 {
+		msgs := applyMsgs.msgs
+		applyMsgs.msgs = nil
+		for _, msg := range msgs {
+			ps := batch.peers[msg.RegionID]
+			if ps == nil {
+				ps = aw.r.get(msg.RegionID)
+				if ps == nil {
+					log.S().Warnf("[region %d] applier not found, drop message %d", msg.RegionID, msg.Type)
+					continue
+				}
+				batch.peers[msg.RegionID] = ps
+			}
+			ps.apply.handleTask(aw.ctx, msg)
+		}
+	}
+}
+
 // storeWorker runs store commands.
 type storeWorker struct {
 	store *storeMsgHandler
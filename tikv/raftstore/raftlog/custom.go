@@ -31,9 +31,16 @@ const (
 	TypeRolback             CustomRaftLogType = 3
 	TypePessimisticLock     CustomRaftLogType = 4
 	TypePessimisticRollback CustomRaftLogType = 5
+	TypeRawPut              CustomRaftLogType = 6
+	TypeRawDelete           CustomRaftLogType = 7
+	TypeGC                  CustomRaftLogType = 8
+	TypeVerMut              CustomRaftLogType = 9
+	TypeImport              CustomRaftLogType = 10
+	TypeResolvedTS          CustomRaftLogType = 11
 )
 
-// CustomRaftLog is the raft log format for unistore to store Prewrite/Commit/PessimisticLock.
+// CustomRaftLog is the raft log format for unistore to store Prewrite/Commit/PessimisticLock, RawKV, versioned KV
+// and bulk load writes, and the resolved ts of the region.
 //  | flag(1) | type(1) | version(2) | header(40) | entries
 //
 // It reduces the cost of marshal/unmarshal and avoid DB lookup during apply.
@@ -176,6 +183,58 @@ func (rl *CustomRaftLog) IteratePessimisticRollback(itFunc func(key []byte)) {
 	}
 }
 
+func (rl *CustomRaftLog) IterateRawPut(itFunc func(key, val []byte, version uint64)) {
+	rl.IterateCommit(itFunc)
+}
+
+func (rl *CustomRaftLog) IterateRawDelete(itFunc func(key []byte, version uint64)) {
+	rl.IterateGC(itFunc)
+}
+
+// IterateVerMut iterates the versioned KV mutations, an empty value that is not deleted is a delete written by
+// the client, a deleted mutation removes the version.
+func (rl *CustomRaftLog) IterateVerMut(itFunc func(key, val []byte, version uint64, deleted bool)) {
+	rl.IterateImport(itFunc)
+}
+
+func (rl *CustomRaftLog) IterateImport(itFunc func(key, val []byte, commitTS uint64, deleted bool)) {
+	i := 4 + headerSize
+	for i < len(rl.Data) {
+		keyLen := endian.Uint16(rl.Data[i:])
+		i += 2
+		key := rl.Data[i : i+int(keyLen)]
+		i += int(keyLen)
+		valLen := endian.Uint32(rl.Data[i:])
+		i += 4
+		val := rl.Data[i : i+int(valLen)]
+		i += int(valLen)
+		commitTS := endian.Uint64(rl.Data[i:])
+		i += 8
+		del := rl.Data[i]
+		i++
+		itFunc(key, val, commitTS, del > 0)
+	}
+}
+
+func (rl *CustomRaftLog) IterateGC(itFunc func(key []byte, version uint64)) {
+	i := 4 + headerSize
+	for i < len(rl.Data) {
+		keyLen := endian.Uint16(rl.Data[i:])
+		i += 2
+		key := rl.Data[i : i+int(keyLen)]
+		i += int(keyLen)
+		version := endian.Uint64(rl.Data[i:])
+		i += 8
+		itFunc(key, version)
+	}
+}
+
+// ResolvedTS returns the resolved ts of a TypeResolvedTS log, no transaction can commit at or below it in the
+// region after the log.
+func (rl *CustomRaftLog) ResolvedTS() uint64 {
+	return endian.Uint64(rl.Data[4+headerSize:])
+}
+
 type CustomBuilder struct {
 	data []byte
 	cnt  int
@@ -223,6 +282,38 @@ func (b *CustomBuilder) AppendPessimisticRollback(key []byte) {
 	b.cnt++
 }
 
+func (b *CustomBuilder) AppendRawPut(key, value []byte, version uint64) {
+	b.AppendCommit(key, value, version)
+}
+
+func (b *CustomBuilder) AppendRawDelete(key []byte, version uint64) {
+	b.AppendGC(key, version)
+}
+
+func (b *CustomBuilder) AppendVerMut(key, value []byte, version uint64, deleted bool) {
+	b.AppendImport(key, value, version, deleted)
+}
+
+func (b *CustomBuilder) AppendImport(key, value []byte, commitTS uint64, deleted bool) {
+	b.AppendCommit(key, value, commitTS)
+	if deleted {
+		b.data = append(b.data, 1)
+	} else {
+		b.data = append(b.data, 0)
+	}
+}
+
+func (b *CustomBuilder) AppendGC(key []byte, version uint64) {
+	b.data = append(b.data, u16ToBytes(uint16(len(key)))...)
+	b.data = append(b.data, key...)
+	b.data = append(b.data, u64ToBytes(version)...)
+	b.cnt++
+}
+
+func (b *CustomBuilder) SetResolvedTS(ts uint64) {
+	b.data = append(b.data[:4+headerSize], u64ToBytes(ts)...)
+}
+
 func (b *CustomBuilder) SetType(tp CustomRaftLogType) {
 	b.data[1] = byte(tp)
 }
@@ -15,10 +15,12 @@ package raftstore
 
 import (
 	"fmt"
+	"sync"
 	stdatomic "sync/atomic"
 	"time"
 	"unsafe"
 
+	"github.com/ngaut/unistore/metrics"
 	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/errorpb"
@@ -30,6 +32,10 @@ import (
 
 type LeaderChecker interface {
 	IsLeader(ctx *kvrpcpb.Context, router *RaftstoreRouter) *errorpb.Error
+	// SafeTS returns the latest resolved ts applied by the peer, the peer has all the commits at or below it.
+	SafeTS() uint64
+	// Term returns the term in which the peer became the leader last time.
+	Term() uint64
 }
 
 type leaderChecker struct {
@@ -37,50 +43,30 @@ type leaderChecker struct {
 	invalid          atomic.Bool
 	term             atomic.Uint64
 	appliedIndexTerm atomic.Uint64
+	safeTS           atomic.Uint64
 	leaderLease      unsafe.Pointer // *RemoteLease
 	region           unsafe.Pointer // *metapb.Region
 }
 
-func (c *leaderChecker) IsLeader(ctx *kvrpcpb.Context, router *RaftstoreRouter) *errorpb.Error {
-	snapTime := time.Now()
-	isExpired, err := c.isExpired(ctx, &snapTime)
-	if err != nil {
-		return RaftstoreErrToPbError(err)
-	}
-	if !isExpired {
-		return nil
-	}
-
-	cb := NewCallback()
-	req := new(raft_cmdpb.Request)
-	req.CmdType = raft_cmdpb.CmdType_Snap
-	header := &raft_cmdpb.RaftRequestHeader{
-		RegionId:    ctx.RegionId,
-		Peer:        ctx.Peer,
-		RegionEpoch: ctx.RegionEpoch,
-		Term:        ctx.Term,
-		SyncLog:     ctx.SyncLog,
-	}
-	cmd := &raft_cmdpb.RaftCmdRequest{
-		Header:   header,
-		Requests: []*raft_cmdpb.Request{req},
-	}
-	msg := &MsgRaftCmd{
-		SendTime: time.Now(),
-		Request:  raftlog.NewRequest(cmd),
-		Callback: cb,
-	}
-	err = router.router.sendRaftCommand(msg)
-	if err != nil {
-		return RaftstoreErrToPbError(err)
-	}
+func (c *leaderChecker) SafeTS() uint64 {
+	return c.safeTS.Load()
+}
 
-	cb.wg.Wait()
+func (c *leaderChecker) Term() uint64 {
+	return c.term.Load()
+}
 
-	if cb.resp.Header.Error != nil {
-		return cb.resp.Header.Error
+func (c *leaderChecker) advanceSafeTS(ts uint64) {
+	for {
+		safeTS := c.safeTS.Load()
+		if ts <= safeTS || c.safeTS.CAS(safeTS, ts) {
+			return
+		}
 	}
-	return nil
+}
+
+func (c *leaderChecker) IsLeader(ctx *kvrpcpb.Context, router *RaftstoreRouter) *errorpb.Error {
+	return router.localReader.checkLeader(c, ctx)
 }
 
 func (c *leaderChecker) isExpired(ctx *kvrpcpb.Context, snapTime *time.Time) (bool, error) {
@@ -113,8 +99,126 @@ func (c *leaderChecker) isExpired(ctx *kvrpcpb.Context, snapTime *time.Time) (bo
 		return false, err
 	}
 
-	if appliedIndexTerm != term {
+	// The replica read needs to get the read index from the leader.
+	if ctx.ReplicaRead || appliedIndexTerm != term {
 		return true, nil
 	}
-	return lease.Inspect(snapTime) == LeaseState_Expired, nil
+	return lease == nil || lease.Inspect(snapTime) == LeaseState_Expired, nil
+}
+
+// LocalReader serves the reads of the regions led by the store without the raft worker while the leader lease of
+// the region is valid. Otherwise a Snap command is sent to the raft worker, the leader gets a read index which
+// renews the lease, and the concurrent leader reads of the region wait for the command and check the lease again
+// instead of sending their own commands.
+type LocalReader struct {
+	router *router
+
+	mu       sync.Mutex
+	inflight map[uint64]*Callback
+}
+
+func newLocalReader(router *router) *LocalReader {
+	return &LocalReader{
+		router:   router,
+		inflight: make(map[uint64]*Callback),
+	}
+}
+
+// checkLeader returns nil if the peer of the checker can serve a read of the context.
+func (r *LocalReader) checkLeader(c *leaderChecker, ctx *kvrpcpb.Context) *errorpb.Error {
+	waited := false
+	for {
+		snapTime := time.Now()
+		isExpired, err := c.isExpired(ctx, &snapTime)
+		if err != nil {
+			return RaftstoreErrToPbError(err)
+		}
+		if !isExpired {
+			metrics.LocalReadRequests.WithLabelValues("local").Inc()
+			return nil
+		}
+		metrics.LocalReadRequests.WithLabelValues("redirect").Inc()
+		// A replica read needs a read index got after the read arrives.
+		if ctx.ReplicaRead || waited {
+			return r.sendSnap(ctx, NewCallback())
+		}
+		r.mu.Lock()
+		cb := r.inflight[ctx.RegionId]
+		if cb == nil {
+			cb = NewCallback()
+			r.inflight[ctx.RegionId] = cb
+			r.mu.Unlock()
+			regErr := r.sendSnap(ctx, cb)
+			r.mu.Lock()
+			delete(r.inflight, ctx.RegionId)
+			r.mu.Unlock()
+			return regErr
+		}
+		r.mu.Unlock()
+		cb.wg.Wait()
+		waited = true
+	}
+}
+
+// sendSnap sends a Snap command of the context to the raft worker and waits for it.
+func (r *LocalReader) sendSnap(ctx *kvrpcpb.Context, cb *Callback) *errorpb.Error {
+	cmd := &raft_cmdpb.RaftCmdRequest{
+		Header:   newReadHeader(ctx),
+		Requests: []*raft_cmdpb.Request{{CmdType: raft_cmdpb.CmdType_Snap}},
+	}
+	msg := &MsgRaftCmd{
+		SendTime: time.Now(),
+		Request:  raftlog.NewRequest(cmd),
+		Callback: cb,
+	}
+	if err := r.router.sendRaftCommand(msg); err != nil {
+		// Wake up the reads waiting for the command.
+		cb.Done(ErrResp(err))
+		return RaftstoreErrToPbError(err)
+	}
+	cb.wg.Wait()
+	return cb.resp.Header.Error
+}
+
+// read executes the Snap command locally if the leader lease is valid, false is returned if the command needs
+// to be sent to the raft worker.
+func (r *LocalReader) read(req *raft_cmdpb.RaftCmdRequest, cb *Callback) bool {
+	if req.AdminRequest != nil || req.Header.GetReadQuorum() || req.Header.GetReplicaRead() || len(req.Requests) == 0 {
+		return false
+	}
+	for _, request := range req.Requests {
+		if request.CmdType != raft_cmdpb.CmdType_Snap {
+			return false
+		}
+	}
+	ps := r.router.get(req.Header.GetRegionId())
+	if ps == nil {
+		return false
+	}
+	c := &ps.peer.peer.leaderChecker
+	ctx := &kvrpcpb.Context{
+		RegionId:    req.Header.GetRegionId(),
+		Peer:        req.Header.GetPeer(),
+		RegionEpoch: req.Header.GetRegionEpoch(),
+		Term:        req.Header.GetTerm(),
+	}
+	snapTime := time.Now()
+	if isExpired, err := c.isExpired(ctx, &snapTime); err != nil || isExpired {
+		return false
+	}
+	metrics.LocalReadRequests.WithLabelValues("local").Inc()
+	region := (*metapb.Region)(stdatomic.LoadPointer(&c.region))
+	cb.Done(NewReadExecutor(true).Execute(req, region, 0))
+	return true
+}
+
+func newReadHeader(ctx *kvrpcpb.Context) *raft_cmdpb.RaftRequestHeader {
+	return &raft_cmdpb.RaftRequestHeader{
+		RegionId:    ctx.RegionId,
+		Peer:        ctx.Peer,
+		RegionEpoch: ctx.RegionEpoch,
+		Term:        ctx.Term,
+		SyncLog:     ctx.SyncLog,
+		ReplicaRead: ctx.ReplicaRead,
+	}
 }
@@ -0,0 +1,99 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/raft_cmdpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLocalReader() (*LocalReader, *leaderChecker, *Lease) {
+	region := &metapb.Region{Id: 1, RegionEpoch: &metapb.RegionEpoch{Version: 1, ConfVer: 1}}
+	peer := &Peer{}
+	c := &peer.leaderChecker
+	c.peerID = 2
+	c.term.Store(5)
+	c.appliedIndexTerm.Store(5)
+	c.region = unsafe.Pointer(region)
+	lease := NewLease(time.Second)
+	c.leaderLease = unsafe.Pointer(lease.MaybeNewRemoteLease(5))
+	pr := newRouter(nil, nil)
+	pr.peers.Store(region.Id, &peerState{peer: &peerFsm{peer: peer}})
+	return newLocalReader(pr), c, lease
+}
+
+func TestLocalReaderLease(t *testing.T) {
+	reader, c, lease := newTestLocalReader()
+	ctx := &kvrpcpb.Context{
+		RegionId:    1,
+		Peer:        &metapb.Peer{Id: 2, StoreId: 1},
+		RegionEpoch: &metapb.RegionEpoch{Version: 1, ConfVer: 1},
+	}
+	req := &raft_cmdpb.RaftCmdRequest{
+		Header:   newReadHeader(ctx),
+		Requests: []*raft_cmdpb.Request{{CmdType: raft_cmdpb.CmdType_Snap}},
+	}
+	// There is no valid lease.
+	assert.False(t, reader.read(req, NewCallback()))
+
+	lease.Renew(time.Now())
+	assert.Nil(t, reader.checkLeader(c, ctx))
+	cb := NewCallback()
+	assert.True(t, reader.read(req, cb))
+	cb.wg.Wait()
+	assert.Nil(t, cb.resp.GetHeader().GetError())
+	assert.Len(t, cb.resp.Responses, 1)
+
+	// The replica read gets a read index from the leader.
+	req.Header.ReplicaRead = true
+	assert.False(t, reader.read(req, NewCallback()))
+	// The read with a stale epoch is rejected.
+	ctx.RegionEpoch = &metapb.RegionEpoch{Version: 0, ConfVer: 1}
+	assert.NotNil(t, reader.checkLeader(c, ctx).GetEpochNotMatch())
+}
+
+func TestLocalReaderWaitInflightSnap(t *testing.T) {
+	reader, c, lease := newTestLocalReader()
+	ctx := &kvrpcpb.Context{
+		RegionId:    1,
+		Peer:        &metapb.Peer{Id: 2, StoreId: 1},
+		RegionEpoch: &metapb.RegionEpoch{Version: 1, ConfVer: 1},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, reader.checkLeader(c, ctx))
+		}()
+	}
+	// Only one Snap command is sent while the lease is expired, the other reads wait for it.
+	msg := <-reader.router.peerSender
+	assert.Equal(t, MsgTypeRaftCmd, msg.Type)
+	cmd := msg.Data.(*MsgRaftCmd)
+	assert.Equal(t, raft_cmdpb.CmdType_Snap, cmd.Request.GetRaftCmdRequest().Requests[0].CmdType)
+	// The command renews the lease.
+	lease.Renew(time.Now())
+	cmd.Callback.Done(&raft_cmdpb.RaftCmdResponse{Header: new(raft_cmdpb.RaftResponseHeader)})
+	wg.Wait()
+	assert.Len(t, reader.router.peerSender, 0)
+	assert.Len(t, reader.inflight, 0)
+}
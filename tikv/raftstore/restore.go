@@ -88,7 +88,12 @@ func restoreAppliedEntry(entry *eraftpb.Entry, txn *badger.Txn, lockStore *locks
 		case *commitOp:
 			restoreCommit(*x, lockStore)
 		case *rollbackOp:
+		case *rawOp:
+		case *verOp:
+		case *importOp:
+		case *gcOp:
 		case *raft_cmdpb.DeleteRangeRequest:
+		case *raft_cmdpb.IngestSSTRequest:
 		default:
 			log.S().Fatalf("invalid input op=%v", x)
 		}
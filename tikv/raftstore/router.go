@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
+	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/kvproto/pkg/raft_cmdpb"
@@ -101,12 +102,16 @@ func (pr *router) sendStore(msg Msg) {
 
 // RaftstoreRouter exports SendCommand method for other packages.
 type RaftstoreRouter struct {
-	router *router
-	// TODO: add localReader here.
+	router      *router
+	localReader *LocalReader
 }
 
+// SendCommand sends the command to the raft worker, a Snap command is executed by the local reader if the leader
+// lease is valid.
 func (r *RaftstoreRouter) SendCommand(req *raft_cmdpb.RaftCmdRequest, cb *Callback) error {
-	// TODO: support local reader
+	if r.localReader.read(req, cb) {
+		return nil
+	}
 	msg := &MsgRaftCmd{
 		SendTime: time.Now(),
 		Request:  raftlog.NewRequest(req),
@@ -130,4 +135,52 @@ func (r *RaftstoreRouter) SplitRegion(ctx *kvrpcpb.Context, keys [][]byte) ([]*m
 	return cb.resp.GetAdminResponse().GetSplits().GetRegions(), nil
 }
 
+// ReadIndex gets the read index of the region from the leader, the replica has applied to the read index
+// when it returns.
+func (r *RaftstoreRouter) ReadIndex(ctx *kvrpcpb.Context) (uint64, *errorpb.Error) {
+	cb := NewCallback()
+	req := &raft_cmdpb.RaftCmdRequest{
+		Header: &raft_cmdpb.RaftRequestHeader{
+			RegionId:    ctx.RegionId,
+			Peer:        ctx.Peer,
+			RegionEpoch: ctx.RegionEpoch,
+			Term:        ctx.Term,
+			SyncLog:     ctx.SyncLog,
+			ReadQuorum:  true,
+			ReplicaRead: true,
+		},
+		Requests: []*raft_cmdpb.Request{{
+			CmdType:   raft_cmdpb.CmdType_ReadIndex,
+			ReadIndex: new(raft_cmdpb.ReadIndexRequest),
+		}},
+	}
+	if err := r.SendCommand(req, cb); err != nil {
+		return 0, RaftstoreErrToPbError(err)
+	}
+	cb.wg.Wait()
+	if cb.resp.Header.GetError() != nil {
+		return 0, cb.resp.Header.Error
+	}
+	return cb.resp.Responses[0].GetReadIndex().GetReadIndex(), nil
+}
+
+// ProposeResolvedTS proposes the resolved ts of the region led by the peer of the ctx, it doesn't wait for the
+// proposal, every peer advances its safe ts when the proposal is applied.
+func (r *RaftstoreRouter) ProposeResolvedTS(ctx *kvrpcpb.Context, ts uint64) error {
+	b := raftlog.NewBuilder(raftlog.CustomHeader{
+		RegionID: ctx.RegionId,
+		Epoch:    raftlog.NewEpoch(ctx.RegionEpoch.Version, ctx.RegionEpoch.ConfVer),
+		PeerID:   ctx.Peer.Id,
+		StoreID:  ctx.Peer.StoreId,
+		Term:     ctx.Term,
+	})
+	b.SetType(raftlog.TypeResolvedTS)
+	b.SetResolvedTS(ts)
+	return r.router.sendRaftCommand(&MsgRaftCmd{
+		SendTime: time.Now(),
+		Request:  b.Build(),
+		Callback: NewCallback(),
+	})
+}
+
 var errPeerNotFound = errors.New("peer not found")
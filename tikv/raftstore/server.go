@@ -18,6 +18,7 @@ import (
 	"encoding/binary"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ngaut/unistore/config"
@@ -38,6 +39,7 @@ type RaftInnerServer struct {
 	node          *Node
 	snapManager   *SnapManager
 	router        *router
+	localReader   *LocalReader
 	batchSystem   *raftBatchSystem
 	pdWorker      *worker
 	resolveWorker *worker
@@ -98,26 +100,25 @@ func (ris *RaftInnerServer) Setup(pdClient pd.Client) {
 	ris.resolveWorker = newWorker("resolver", &wg)
 	ris.snapWorker = newWorker("snap-worker", &wg)
 
-	// TODO: create local reader
-	// TODO: create storage read pool
-	// TODO: create cop read pool
 	// TODO: create cop endpoint
 
 	cfg := ris.raftConfig
 	router, batchSystem := createRaftBatchSystem(ris.globalConfig, cfg)
 
-	ris.router = router // TODO: init with local reader
+	ris.router = router
+	ris.localReader = newLocalReader(router)
 	ris.snapManager = NewSnapManager(cfg.SnapPath, router)
 	ris.batchSystem = batchSystem
 	ris.lsDumper = &lockStoreDumper{
 		stopCh:      make(chan struct{}),
+		doneCh:      make(chan struct{}),
 		engines:     ris.engines,
 		fileNumDiff: 2,
 	}
 }
 
 func (ris *RaftInnerServer) GetRaftstoreRouter() *RaftstoreRouter {
-	return &RaftstoreRouter{router: ris.router}
+	return &RaftstoreRouter{router: ris.router, localReader: ris.localReader}
 }
 
 func (ris *RaftInnerServer) GetStoreMeta() *metapb.Store {
@@ -147,9 +148,66 @@ func (ris *RaftInnerServer) Start(pdClient pd.Client) error {
 	return nil
 }
 
+// evictLeaderCheckInterval is the interval to check whether the leaders of the store are evicted.
+const evictLeaderCheckInterval = 200 * time.Millisecond
+
+// EvictLeaders transfers the leaders of the store to healthy followers by TransferLeader commands and waits for the
+// committed logs of the local peers to be applied, the error of ctx is returned if it's done before that. A leader
+// without healthy followers keeps its leadership.
+func (ris *RaftInnerServer) EvictLeaders(ctx context.Context) error {
+	atomic.StoreUint32(&ris.batchSystem.ctx.evictingLeaders, 1)
+	for {
+		var (
+			wg        sync.WaitGroup
+			remaining int32
+		)
+		ris.router.peers.Range(func(key, value interface{}) bool {
+			regionID := key.(uint64)
+			wg.Add(1)
+			msg := NewPeerMsg(MsgTypeEvictLeader, regionID, &MsgEvictLeader{Callback: func(evicted bool) {
+				if !evicted {
+					atomic.AddInt32(&remaining, 1)
+				}
+				wg.Done()
+			}})
+			if ris.router.send(regionID, msg) != nil {
+				wg.Done()
+			}
+			return true
+		})
+		// The message to a peer destroyed before handling it is dropped, so the wait is bounded by ctx.
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if atomic.LoadInt32(&remaining) == 0 {
+			return nil
+		}
+		log.S().Infof("waiting for %d regions to evict leader or apply logs", remaining)
+		select {
+		case <-time.After(evictLeaderCheckInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 func (ris *RaftInnerServer) Stop() error {
+	close(ris.lsDumper.stopCh)
+	<-ris.lsDumper.doneCh
 	ris.snapWorker.stop()
 	ris.node.stop()
+	// The raft logs are not applied any more once the raft and apply workers are stopped, so all the applied logs
+	// are before the current offset and the lock store doesn't change during the dump.
+	if err := ris.lsDumper.dump(ris.engines.raft.GetVLogOffset()); err != nil {
+		log.Error("dump lock store failed", zap.Error(err))
+	}
 	ris.resolveWorker.stop()
 	if err := ris.engines.raft.Close(); err != nil {
 		return err
@@ -166,9 +224,12 @@ type lockStoreDumper struct {
 	stopCh      chan struct{}
 	engines     *Engines
 	fileNumDiff uint64
+	// doneCh is closed when run returns, so the dump on shutdown is the last one.
+	doneCh chan struct{}
 }
 
 func (dumper *lockStoreDumper) run() {
+	defer close(dumper.doneCh)
 	ticker := time.NewTicker(time.Second * 10)
 	lastFileNum := dumper.engines.raft.GetVLogOffset() >> 32
 	for {
@@ -177,13 +238,14 @@ func (dumper *lockStoreDumper) run() {
 			vlogOffset := dumper.engines.raft.GetVLogOffset()
 			currentFileNum := vlogOffset >> 32
 			if currentFileNum-lastFileNum >= dumper.fileNumDiff {
-				meta := make([]byte, 8)
-				binary.LittleEndian.PutUint64(meta, vlogOffset)
 				// Waiting for the raft log to be applied.
 				// TODO: it is possible that some log is not applied after sleep, find a better way to make sure this.
-				time.Sleep(5 * time.Second)
-				err := dumper.engines.kv.LockStore.DumpToFile(filepath.Join(dumper.engines.kvPath, LockstoreFileName), meta)
-				if err != nil {
+				select {
+				case <-time.After(5 * time.Second):
+				case <-dumper.stopCh:
+					return
+				}
+				if err := dumper.dump(vlogOffset); err != nil {
 					log.Error("dump lock store failed", zap.Error(err))
 					continue
 				}
@@ -194,3 +256,10 @@ func (dumper *lockStoreDumper) run() {
 		}
 	}
 }
+
+// dump dumps the lock store with the raft vlog offset up to which the logs are applied.
+func (dumper *lockStoreDumper) dump(vlogOffset uint64) error {
+	meta := make([]byte, 8)
+	binary.LittleEndian.PutUint64(meta, vlogOffset)
+	return dumper.engines.kv.LockStore.DumpToFile(filepath.Join(dumper.engines.kvPath, LockstoreFileName), meta)
+}
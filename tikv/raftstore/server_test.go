@@ -0,0 +1,60 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictLeaders(t *testing.T) {
+	pr := newRouter(nil, nil)
+	for id := uint64(1); id <= 3; id++ {
+		pr.peers.Store(id, &peerState{})
+	}
+	ris := &RaftInnerServer{
+		router:      pr,
+		batchSystem: &raftBatchSystem{ctx: &GlobalContext{}},
+	}
+	// Region 2 is evicted in the third round, region 3 is destroyed and drops the messages.
+	var region2Rounds int32
+	go func() {
+		for msg := range pr.peerSender {
+			assert.Equal(t, MsgTypeEvictLeader, msg.Type)
+			cb := msg.Data.(*MsgEvictLeader).Callback
+			switch msg.RegionID {
+			case 1:
+				cb(true)
+			case 2:
+				cb(atomic.AddInt32(&region2Rounds, 1) >= 3)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ris.EvictLeaders(ctx))
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&ris.batchSystem.ctx.evictingLeaders))
+
+	pr.close(3)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, ris.EvictLeaders(ctx))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&region2Rounds))
+	close(pr.peerSender)
+}
@@ -45,6 +45,12 @@ const (
 	CFLock    CFName = "lock"
 	CFWrite   CFName = "write"
 	CFRaft    CFName = "raft"
+	// CFRaw is used by the RawKV API, it is stored in the kv DB under mvcc.RawKeyPrefix.
+	CFRaw CFName = "raw"
+	// CFVer is used by the versioned KV API, it is stored in the kv DB under mvcc.VerKeyPrefix.
+	CFVer CFName = "ver"
+	// CFImport is used by bulk load, it is stored in the kv DB as committed versions.
+	CFImport CFName = "import"
 
 	snapGenPrefix       = "gen" // Name prefix for the self-generated snapshot file.
 	snapRevPrefix       = "rev" // Name prefix for the received snapshot file.
@@ -760,6 +766,12 @@ func (s *Snap) Apply(opts ApplyOptions) (ApplyResult, error) {
 		}
 		switch item.applySnapType {
 		case applySnapTypePut:
+			if isPrefixedKey(item.key.UserKey) {
+				// The prefixed keys of the regions ingested together would overlap with each other in the
+				// ingested tables, so they are written by the write batch.
+				opts.WB.SetWithUserMeta(item.key, item.val, item.userMeta)
+				break
+			}
 			result.HasPut = true
 			opts.Builder.Add(item.key, y.ValueStruct{
 				Value:    item.val,
@@ -767,6 +779,7 @@ func (s *Snap) Apply(opts ApplyOptions) (ApplyResult, error) {
 			})
 		case applySnapTypeLock:
 			opts.DBBundle.LockStore.Put(item.key.UserKey, item.val)
+			opts.DBBundle.LockObserver.Observe(item.key.UserKey, item.val)
 		case applySnapTypeRollback:
 			opts.WB.Rollback(item.key)
 		case applySnapTypeOpLock:
@@ -40,6 +40,7 @@ func newSnapBuilder(cfFiles []*CFFile, snap *regionSnapshot, region *metapb.Regi
 	// extraIterator doesn't need to read all versions because startTS is encoded in the key.
 	b.extraIterator = b.txn.NewIterator(badger.DefaultIteratorOptions)
 	startKey := RawStartKey(region)
+	b.prefixedRanges = prefixedKeyRanges(startKey, b.endKey)
 
 	b.dbIterator.Seek(startKey)
 	if b.dbIterator.Valid() && !b.reachEnd(b.dbIterator.Item().Key()) {
@@ -75,6 +76,7 @@ func newSnapBuilder(cfFiles []*CFFile, snap *regionSnapshot, region *metapb.Regi
 // TODO: handle rollbacks and locks the region later.
 type snapBuilder struct {
 	endKey          []byte
+	prefixedRanges  []keyRange
 	extraEndKey     []byte
 	txn             *badger.Txn
 	lockIterator    *lockstore.Iterator
@@ -99,6 +101,18 @@ func (b *snapBuilder) build() error {
 		b.extraIterator.Close()
 		b.txn.Discard()
 	}()
+	if err := b.buildDataRange(); err != nil {
+		return err
+	}
+	for _, r := range b.prefixedRanges {
+		if err := b.addPrefixedRange(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *snapBuilder) buildDataRange() error {
 	for {
 		var err error
 		switch b.currentKeyType() {
@@ -242,6 +256,38 @@ func (b *snapBuilder) addExtraEntry() error {
 	return nil
 }
 
+// addPrefixedRange adds all the versions of the keys in a prefixed key range, e.g. the raw keys. They are added
+// with their DB keys, which are greater than the data keys, and their versions as the commit ts, so the applier
+// writes them back as they are.
+func (b *snapBuilder) addPrefixedRange(r keyRange) error {
+	itOpt := badger.DefaultIteratorOptions
+	itOpt.AllVersions = true
+	it := b.txn.NewIterator(itOpt)
+	defer it.Close()
+	for it.Seek(r.startKey); it.Valid(); it.Next() {
+		item := it.Item()
+		if bytes.Compare(item.Key(), r.endKey) >= 0 {
+			break
+		}
+		var val []byte
+		if !item.IsDeleted() {
+			var err error
+			if val, err = item.Value(); err != nil {
+				return err
+			}
+		}
+		writeType := byte(kvrpcpb.Op_Put)
+		if len(val) == 0 {
+			writeType = byte(kvrpcpb.Op_Del)
+		}
+		version := item.Version()
+		if err := b.addSSTKey(item.Key(), version, version, val, writeType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *snapBuilder) addSSTKey(key []byte, startTS, commitTS uint64, val []byte, writeType byte) error {
 	writeCFKey := encodeRocksDBSSTKey(key, &commitTS)
 	writeCFVal := new(writeCFValue)
@@ -250,7 +296,7 @@ func (b *snapBuilder) addSSTKey(key []byte, startTS, commitTS uint64, val []byte
 	if len(val) <= shortValueMaxLen {
 		writeCFVal.shortValue = val
 	} else {
-		defaultCFKey := encodeRocksDBSSTKey(b.curDBKey, &startTS)
+		defaultCFKey := encodeRocksDBSSTKey(key, &startTS)
 		err := b.defaultCFWriter.Put(defaultCFKey, val)
 		if err != nil {
 			return err
@@ -0,0 +1,399 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coocood/badger/y"
+	"github.com/ngaut/unistore/rocksdb"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// An SST file to import is written by rocksdb.SstFileWriter, every key is encoded by EncodeImportSSTKey with
+// the commit ts of the entry, a Put entry is ingested as a committed value and a Delete entry is ingested as
+// a delete at the commit ts. The range in the SSTMeta is the inclusive range of the raw keys in the file.
+// A file contains at most one version of a key, since a badger write keeps only one entry for a key.
+//
+// An SST file of an incremental backup is replayed, its cf name in the SSTMeta is ReplayCF or ReplayTxnStatusCF.
+// The key of an entry is encoded with the badger key and version, and the value is the user meta followed by
+// the value, so the deletes and the rollback and op lock records are written back as they were. The keys of a
+// ReplayTxnStatusCF file are the extra txn status keys, the range of the meta is the range of the decoded keys.
+
+var (
+	errImportFileExists  = errors.New("import file already exists")
+	errInvalidImportUUID = errors.New("invalid import file uuid")
+	errBadImportSSTKey   = errors.New("bad import sst key")
+	errDupImportSSTKey   = errors.New("duplicated key in import sst")
+	errBadReplaySSTValue = errors.New("bad replay sst value")
+)
+
+// backupChecksumTable is the crc64 table of the Crc64Xor of a backup file, it is the ECMA polynomial.
+var backupChecksumTable = crc64.MakeTable(crc64.ECMA)
+
+const (
+	ReplayCF          = "replay"
+	ReplayTxnStatusCF = "replay_txn_status"
+
+	replayUserMetaLen = 16
+)
+
+// EncodeImportSSTKey encodes the key of an entry in an SST file to import.
+func EncodeImportSSTKey(key []byte, commitTS uint64) []byte {
+	return encodeRocksDBSSTKey(key, &commitTS)
+}
+
+// EncodeReplaySSTValue encodes the value of an entry in a replay SST file.
+func EncodeReplaySSTValue(userMeta, value []byte) []byte {
+	return append(append(make([]byte, 0, len(userMeta)+len(value)), userMeta...), value...)
+}
+
+func isReplayCF(cf string) bool {
+	return cf == ReplayCF || cf == ReplayTxnStatusCF
+}
+
+// decodeImportSSTKey decodes the key of an entry, the raw key is the key checked against the range of the meta.
+func decodeImportSSTKey(cf string, ikey []byte) (key, rawKey []byte, ts uint64, err error) {
+	if len(ikey) <= 8 {
+		return nil, nil, 0, errors.WithStack(errBadImportSSTKey)
+	}
+	key, ts, err = decodeRocksDBSSTKey(ikey)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	rawKey = key
+	if cf == ReplayTxnStatusCF {
+		if rawKey = mvcc.DecodeExtraTxnStatusKey(key); rawKey == nil {
+			return nil, nil, 0, errors.WithStack(errBadImportSSTKey)
+		}
+	}
+	return key, rawKey, ts, nil
+}
+
+// SSTImporter manages the SST files uploaded for ingestion, a file is named by the uuid in its SSTMeta.
+type SSTImporter struct {
+	dir string
+}
+
+func NewSSTImporter(dir string) *SSTImporter {
+	return &SSTImporter{dir: dir}
+}
+
+func importSSTPath(dir string, meta *import_sstpb.SSTMeta) (string, error) {
+	if len(meta.GetUuid()) == 0 {
+		return "", errors.WithStack(errInvalidImportUUID)
+	}
+	return filepath.Join(dir, fmt.Sprintf("%x%s", meta.Uuid, sstFileSuffix)), nil
+}
+
+// Path returns the path of the uploaded file of the meta.
+func (imp *SSTImporter) Path(meta *import_sstpb.SSTMeta) (string, error) {
+	return importSSTPath(imp.dir, meta)
+}
+
+// Create creates a file to receive the content of the SST file, the file can be ingested after it is finished.
+func (imp *SSTImporter) Create(meta *import_sstpb.SSTMeta) (*ImportFile, error) {
+	path, err := imp.Path(meta)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(path); err == nil {
+		return nil, errors.WithStack(errImportFileExists)
+	}
+	if err = os.MkdirAll(imp.dir, os.ModePerm); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tmpPath := path + tmpFileSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &ImportFile{
+		meta:    meta,
+		path:    path,
+		tmpPath: tmpPath,
+		file:    file,
+		digest:  crc32.NewIEEE(),
+	}, nil
+}
+
+// Download copies the entries of the backup SST file at srcPath into the file of the meta for ingestion, the
+// prefix of a key and its commit ts are rewritten by the rule, the ts of a replay file can not be rewritten, and
+// the rewritten keys out of the inclusive range of the meta are skipped, an empty end of the range is unbounded.
+// The backup file is rejected if its sha256 or the xor of the crc64 of its entries doesn't match the checksums
+// in the backup manifest. It returns the meta of the downloaded file, or nil if no key is in the range.
+func (imp *SSTImporter) Download(meta *import_sstpb.SSTMeta, srcPath string, sha256Sum []byte, crc64Xor uint64,
+	rule *import_sstpb.RewriteRule) (*import_sstpb.SSTMeta, error) {
+	cf := meta.GetCfName()
+	if isReplayCF(cf) && rule.GetNewTimestamp() > 0 {
+		return nil, errors.New("the ts of a replay sst can not be rewritten")
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer src.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, src); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !bytes.Equal(h.Sum(nil), sha256Sum) {
+		return nil, errors.Errorf("backup file %s sha256 mismatch", filepath.Base(srcPath))
+	}
+	it, err := rocksdb.NewSstFileIterator(src)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	path, err := imp.Path(meta)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(path); err == nil {
+		return nil, errors.WithStack(errImportFileExists)
+	}
+	if err = os.MkdirAll(imp.dir, os.ModePerm); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tmpPath := path + tmpFileSuffix
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer os.Remove(tmpPath)
+	w := rocksdb.NewSstFileWriter(file, rocksdb.NewDefaultBlockBasedTableOptions(bytes.Compare))
+	defer w.Close()
+	rng := meta.GetRange()
+	var (
+		start, end []byte
+		crcBuf     []byte
+		crc        uint64
+	)
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		ikey := it.Key()
+		key, rawKey, commitTS, err := decodeImportSSTKey(cf, ikey.UserKey)
+		if err != nil {
+			return nil, err
+		}
+		crcBuf = append(append(crcBuf[:0], key...), it.Value()...)
+		crc ^= crc64.Checksum(crcBuf, backupChecksumTable)
+		if !bytes.HasPrefix(rawKey, rule.GetOldKeyPrefix()) {
+			return nil, errors.Errorf("key %q does not have the rewrite prefix %q", rawKey, rule.GetOldKeyPrefix())
+		}
+		rawKey = append(append([]byte{}, rule.GetNewKeyPrefix()...), rawKey[len(rule.GetOldKeyPrefix()):]...)
+		if rng != nil && (bytes.Compare(rawKey, rng.Start) < 0 ||
+			(len(rng.End) > 0 && bytes.Compare(rawKey, rng.End) > 0)) {
+			continue
+		}
+		if cf == ReplayTxnStatusCF {
+			key = mvcc.EncodeExtraTxnStatusKey(rawKey, mvcc.DecodeKeyTS(key))
+		} else {
+			key = rawKey
+		}
+		if rule.GetNewTimestamp() > 0 {
+			commitTS = rule.GetNewTimestamp()
+		}
+		if ikey.ValueType == rocksdb.TypeDeletion {
+			err = w.Delete(EncodeImportSSTKey(key, commitTS))
+		} else {
+			err = w.Put(EncodeImportSSTKey(key, commitTS), it.Value())
+		}
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if start == nil {
+			start = rawKey
+		}
+		end = rawKey
+	}
+	if err = it.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if crc != crc64Xor {
+		return nil, errors.Errorf("backup file %s crc64xor mismatch", filepath.Base(srcPath))
+	}
+	if start == nil {
+		return nil, nil
+	}
+	if err = w.Finish(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = file.Sync(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	data, err := ioutil.ReadFile(tmpPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	downloaded := *meta
+	downloaded.Range = &import_sstpb.Range{Start: start, End: end}
+	downloaded.Crc32 = crc32.ChecksumIEEE(data)
+	downloaded.Length = uint64(len(data))
+	return &downloaded, nil
+}
+
+// Delete deletes the uploaded file of the meta.
+func (imp *SSTImporter) Delete(meta *import_sstpb.SSTMeta) error {
+	path, err := imp.Path(meta)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// ImportFile is an SST file being uploaded, it is written to a temporary file which is renamed after the
+// length and the checksum are verified.
+type ImportFile struct {
+	meta    *import_sstpb.SSTMeta
+	path    string
+	tmpPath string
+	file    *os.File
+	digest  hash.Hash32
+	length  uint64
+}
+
+func (f *ImportFile) Append(data []byte) error {
+	if _, err := f.file.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+	f.digest.Write(data)
+	f.length += uint64(len(data))
+	return nil
+}
+
+func (f *ImportFile) Finish() error {
+	if f.length != f.meta.Length {
+		return errors.Errorf("import file length mismatch, expect %d, got %d", f.meta.Length, f.length)
+	}
+	if crc := f.digest.Sum32(); crc != f.meta.Crc32 {
+		return errors.Errorf("import file crc32 mismatch, expect %d, got %d", f.meta.Crc32, crc)
+	}
+	if err := f.file.Sync(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := f.file.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	f.file = nil
+	return errors.WithStack(os.Rename(f.tmpPath, f.path))
+}
+
+// Cleanup removes the temporary file if the file is not finished.
+func (f *ImportFile) Cleanup() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+		os.Remove(f.tmpPath)
+	}
+}
+
+// CheckImportSSTRange checks the range of the meta is in the raw range [startKey, endKey), an empty endKey
+// means no upper bound.
+func CheckImportSSTRange(meta *import_sstpb.SSTMeta, startKey, endKey []byte) error {
+	rng := meta.GetRange()
+	if rng == nil || bytes.Compare(rng.Start, rng.End) > 0 {
+		return errors.Errorf("invalid import range %v", rng)
+	}
+	if bytes.Compare(rng.Start, startKey) < 0 || (len(endKey) > 0 && bytes.Compare(rng.End, endKey) >= 0) {
+		return errors.Errorf("import range [%q, %q] is not in [%q, %q)", rng.Start, rng.End, startKey, endKey)
+	}
+	return nil
+}
+
+// rawRegionKey decodes the start or end key of a region, an empty key is not decoded.
+func rawRegionKey(key []byte) []byte {
+	if len(key) == 0 {
+		return nil
+	}
+	_, rawKey, err := codec.DecodeBytes(key, nil)
+	if err != nil {
+		panic(key)
+	}
+	return rawKey
+}
+
+// IterateImportSST iterates the entries of the SST file at path in order as the badger entries to write, a nil
+// user meta means a delete. The length and the crc32 of the file must match the meta, every raw key must be in the
+// range of the meta and a key must not be duplicated.
+func IterateImportSST(path string, meta *import_sstpb.SSTMeta, f func(key y.Key, value, userMeta []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+	digest := crc32.NewIEEE()
+	length, err := io.Copy(digest, file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if uint64(length) != meta.Length {
+		return errors.Errorf("import file length mismatch, expect %d, got %d", meta.Length, length)
+	}
+	if crc := digest.Sum32(); crc != meta.Crc32 {
+		return errors.Errorf("import file crc32 mismatch, expect %d, got %d", meta.Crc32, crc)
+	}
+	it, err := rocksdb.NewSstFileIterator(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	cf := meta.GetCfName()
+	rng := meta.GetRange()
+	var lastKey []byte
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		ikey := it.Key()
+		key, rawKey, ts, err := decodeImportSSTKey(cf, ikey.UserKey)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(rawKey, rng.GetStart()) < 0 || bytes.Compare(rawKey, rng.GetEnd()) > 0 {
+			return errors.Errorf("import key %q is not in range [%q, %q]", rawKey, rng.GetStart(), rng.GetEnd())
+		}
+		if lastKey != nil && bytes.Equal(key, lastKey) {
+			return errors.WithStack(errDupImportSSTKey)
+		}
+		lastKey = key
+		value := it.Value()
+		var userMeta []byte
+		if isReplayCF(cf) {
+			if ikey.ValueType == rocksdb.TypeDeletion || len(value) < replayUserMetaLen {
+				return errors.WithStack(errBadReplaySSTValue)
+			}
+			userMeta = append([]byte{}, value[:replayUserMetaLen]...)
+			value = value[replayUserMetaLen:]
+		} else if ikey.ValueType != rocksdb.TypeDeletion {
+			userMeta = mvcc.NewDBUserMeta(ts, ts)
+		}
+		if err = f(y.KeyWithTs(key, ts), value, userMeta); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
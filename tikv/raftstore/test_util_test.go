@@ -37,6 +37,7 @@ func newTestEngines(t *testing.T) *Engines {
 	kvOpts.Dir = engines.kvPath
 	kvOpts.ValueDir = engines.kvPath
 	kvOpts.ValueThreshold = 256
+	kvOpts.ManagedTxns = true
 	engines.kv.DB, err = badger.Open(kvOpts)
 	engines.kv.LockStore = lockstore.NewMemStore(16 * 1024)
 	require.Nil(t, err)
@@ -56,6 +56,12 @@ func (t *ticker) tickClock() {
 	t.tick++
 }
 
+// tickClockTo sets the clock of a peer to the base tick count of the raft worker, the clock of a hibernated
+// peer advances by more than one tick since it is not ticked on every base tick.
+func (t *ticker) tickClockTo(tick int64) {
+	t.tick = tick
+}
+
 // schedule arrange the next run for the PeerTick.
 func (t *ticker) schedule(tp PeerTick) {
 	sched := &t.schedules[int(tp)]
@@ -66,10 +72,15 @@ func (t *ticker) schedule(tp PeerTick) {
 	sched.runAt = t.tick + sched.interval
 }
 
-// isOnTick checks if the PeerTick should run.
+// isOnTick checks if the PeerTick should run, the schedule is consumed and the PeerTick needs to be scheduled
+// again.
 func (t *ticker) isOnTick(tp PeerTick) bool {
 	sched := &t.schedules[int(tp)]
-	return sched.runAt == t.tick
+	if sched.runAt <= 0 || sched.runAt > t.tick {
+		return false
+	}
+	sched.runAt = -1
+	return true
 }
 
 func (t *ticker) isOnStoreTick(tp StoreTick) bool {
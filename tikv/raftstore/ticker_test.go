@@ -0,0 +1,153 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raftstore
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/ngaut/unistore/tikv/raftstore/raftlog"
+	"github.com/pingcap/kvproto/pkg/eraftpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTickerSkipsTicks(t *testing.T) {
+	cfg := NewDefaultConfig()
+	tk := newTicker(1, cfg)
+	tk.tickClockTo(100)
+	tk.schedule(PeerTickRaft)
+	tk.schedule(PeerTickRaftLogGC)
+	assert.False(t, tk.isOnTick(PeerTickRaft))
+
+	// A hibernated peer is ticked after more than one base tick.
+	tk.tickClockTo(110)
+	assert.True(t, tk.isOnTick(PeerTickRaft))
+	assert.True(t, tk.isOnTick(PeerTickRaftLogGC))
+	assert.False(t, tk.isOnTick(PeerTickPdHeartbeat))
+	// The schedule is consumed.
+	tk.tickClockTo(111)
+	assert.False(t, tk.isOnTick(PeerTickRaft))
+	tk.schedule(PeerTickRaft)
+	tk.tickClockTo(112)
+	assert.True(t, tk.isOnTick(PeerTickRaft))
+}
+
+func TestHibernatedPeerTicks(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cfg.HibernateRegions = true
+	cfg.RaftHibernateTicks = 3
+	pr := newRouter(nil, nil)
+	for id := uint64(1); id <= 3; id++ {
+		pr.peers.Store(id, &peerState{})
+	}
+	rw := &raftWorker{
+		pr: pr,
+		raftCtx: &RaftContext{
+			GlobalContext: &GlobalContext{cfg: cfg},
+			awakePeers:    map[uint64]struct{}{1: {}, 4: {}},
+		},
+	}
+	tickedPeers := func() []uint64 {
+		var ids []uint64
+		for _, msg := range rw.appendTickMsgs(nil) {
+			assert.Equal(t, MsgTypeTick, msg.Type)
+			ids = append(ids, msg.RegionID)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+	assert.Equal(t, []uint64{1}, tickedPeers())
+	// The destroyed peer is removed from the awake peers.
+	assert.NotContains(t, rw.raftCtx.awakePeers, uint64(4))
+	assert.Equal(t, []uint64{1}, tickedPeers())
+	assert.Equal(t, []uint64{1, 2, 3}, tickedPeers())
+	assert.Equal(t, []uint64{1}, tickedPeers())
+
+	cfg.HibernateRegions = false
+	assert.Equal(t, []uint64{1, 2, 3}, tickedPeers())
+}
+
+func TestResolvedTSKeepsRegionIdle(t *testing.T) {
+	engines := newTestEngines(t)
+	defer cleanUpTestEngineData(engines)
+	require.Nil(t, BootstrapStore(engines, 1, 1))
+	region, err := PrepareBootstrap(engines, 1, 1, 1)
+	require.Nil(t, err)
+	cfg := NewDefaultConfig()
+	cfg.HibernateRegions = true
+	// The peer of the single peer region campaigns and becomes the leader.
+	fsm, err := createPeerFsm(1, cfg, nil, engines, region)
+	require.Nil(t, err)
+	require.True(t, fsm.peer.IsLeader())
+	ctx := &RaftContext{
+		GlobalContext: &GlobalContext{cfg: cfg, engine: engines, store: &metapb.Store{Id: 1}},
+		awakePeers:    map[uint64]struct{}{},
+	}
+	d := newRaftMsgHandler(fsm, ctx)
+	d.startTicker()
+	proposeResolvedTS := func(ts uint64) *Callback {
+		b := raftlog.NewBuilder(raftlog.CustomHeader{
+			RegionID: region.Id,
+			Epoch:    raftlog.NewEpoch(region.RegionEpoch.Version, region.RegionEpoch.ConfVer),
+			PeerID:   fsm.peer.PeerId(),
+			StoreID:  1,
+			Term:     fsm.peer.Term(),
+		})
+		b.SetType(raftlog.TypeResolvedTS)
+		b.SetResolvedTS(ts)
+		cb := NewCallback()
+		d.HandleMsgs(NewPeerMsg(MsgTypeRaftCmd, region.Id, &MsgRaftCmd{Request: b.Build(), Callback: cb}))
+		return cb
+	}
+
+	// The proposal of the resolved ts doesn't reset the idle ticks of the awake leader.
+	d.idleTicks = 5
+	lastIndex := d.peer.RaftGroup.Raft.RaftLog.LastIndex()
+	proposeResolvedTS(10)
+	assert.Equal(t, 5, d.idleTicks)
+	assert.Equal(t, lastIndex+1, d.peer.RaftGroup.Raft.RaftLog.LastIndex())
+
+	// The hibernated leader doesn't propose the resolved ts.
+	d.hibernate()
+	lastIndex = d.peer.RaftGroup.Raft.RaftLog.LastIndex()
+	cb := proposeResolvedTS(20)
+	assert.NotNil(t, cb.resp.GetHeader().GetError())
+	assert.True(t, d.hibernated)
+	assert.NotContains(t, ctx.awakePeers, region.Id)
+	assert.Equal(t, lastIndex, d.peer.RaftGroup.Raft.RaftLog.LastIndex())
+}
+
+func TestIdleRaftMessage(t *testing.T) {
+	resolvedTS := raftlog.NewBuilder(raftlog.CustomHeader{})
+	resolvedTS.SetType(raftlog.TypeResolvedTS)
+	resolvedTS.SetResolvedTS(10)
+	prewrite := raftlog.NewBuilder(raftlog.CustomHeader{})
+	prewrite.SetType(raftlog.TypePrewrite)
+	resolvedTSEntry := &eraftpb.Entry{Data: resolvedTS.Build().Marshal()}
+	prewriteEntry := &eraftpb.Entry{Data: prewrite.Build().Marshal()}
+
+	assert.True(t, isIdleRaftMessage(&eraftpb.Message{MsgType: eraftpb.MessageType_MsgHeartbeat}))
+	assert.True(t, isIdleRaftMessage(&eraftpb.Message{MsgType: eraftpb.MessageType_MsgAppendResponse}))
+	assert.False(t, isIdleRaftMessage(&eraftpb.Message{MsgType: eraftpb.MessageType_MsgAppendResponse, Reject: true}))
+	assert.True(t, isIdleRaftMessage(&eraftpb.Message{
+		MsgType: eraftpb.MessageType_MsgAppend, Entries: []*eraftpb.Entry{resolvedTSEntry},
+	}))
+	assert.False(t, isIdleRaftMessage(&eraftpb.Message{
+		MsgType: eraftpb.MessageType_MsgAppend, Entries: []*eraftpb.Entry{resolvedTSEntry, prewriteEntry},
+	}))
+	assert.False(t, isIdleRaftMessage(&eraftpb.Message{MsgType: eraftpb.MessageType_MsgAppend}))
+	assert.False(t, isIdleRaftMessage(&eraftpb.Message{MsgType: eraftpb.MessageType_MsgRequestPreVote}))
+}
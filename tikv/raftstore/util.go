@@ -387,6 +387,84 @@ func removePeer(region *metapb.Region, storeID uint64) *metapb.Peer {
 	return nil
 }
 
+/// `checkChangePeer` checks the peer of a conf change against the region. A peer is added with an unused id
+/// to a store without a peer of the region, only a learner is promoted to a voter by `AddNode`, and a removed
+/// peer must be in the region.
+func checkChangePeer(region *metapb.Region, changeType eraftpb.ConfChangeType, peer *metapb.Peer) error {
+	if peer.GetId() == InvalidID || peer.GetStoreId() == 0 {
+		return errors.Errorf("invalid peer %s", peer)
+	}
+	var byID, byStore *metapb.Peer
+	for _, p := range region.Peers {
+		if p.Id == peer.Id {
+			byID = p
+		}
+		if p.StoreId == peer.StoreId {
+			byStore = p
+		}
+	}
+	switch changeType {
+	case eraftpb.ConfChangeType_AddNode, eraftpb.ConfChangeType_AddLearnerNode:
+		if byID != nil && byID.StoreId != peer.StoreId {
+			return errors.Errorf("duplicated peer id %d, peer %s, region %d", peer.Id, byID, region.Id)
+		}
+		if byStore != nil && byStore.Id != peer.Id {
+			return errors.Errorf("duplicated store %d, peer %s, region %d", peer.StoreId, byStore, region.Id)
+		}
+		if byID != nil && (changeType == eraftpb.ConfChangeType_AddLearnerNode || !byID.IsLearner) {
+			return errors.Errorf("peer %s already exists in region %d", byID, region.Id)
+		}
+	case eraftpb.ConfChangeType_RemoveNode:
+		if byID == nil || byID.StoreId != peer.StoreId {
+			return errors.Errorf("removing missing peer %s, region %d", peer, region.Id)
+		}
+	}
+	return nil
+}
+
+/// `isReplicaReadRequest` checks whether the request is a read-only request that a follower can serve.
+func isReplicaReadRequest(req *raft_cmdpb.RaftCmdRequest) bool {
+	if !req.GetHeader().GetReplicaRead() || req.AdminRequest != nil || len(req.Requests) == 0 {
+		return false
+	}
+	for _, r := range req.Requests {
+		if r.CmdType != raft_cmdpb.CmdType_Snap && r.CmdType != raft_cmdpb.CmdType_ReadIndex {
+			return false
+		}
+	}
+	return true
+}
+
+/// `isSiblingRegions` checks whether the two regions are adjacent.
+func isSiblingRegions(lhs, rhs *metapb.Region) bool {
+	if lhs.Id == rhs.Id {
+		return false
+	}
+	if bytes.Equal(lhs.StartKey, rhs.EndKey) && len(rhs.EndKey) != 0 {
+		return true
+	}
+	if bytes.Equal(lhs.EndKey, rhs.StartKey) && len(lhs.EndKey) != 0 {
+		return true
+	}
+	return false
+}
+
+/// `regionOnSameStores` checks whether the two regions have peers on the same stores.
+func regionOnSameStores(lhs, rhs *metapb.Region) bool {
+	if len(lhs.Peers) != len(rhs.Peers) {
+		return false
+	}
+	// Because every store can only have one replica for the same region,
+	// so just one round check is enough.
+	for _, lp := range lhs.Peers {
+		rp := findPeer(rhs, lp.StoreId)
+		if rp == nil || rp.IsLearner != lp.IsLearner {
+			return false
+		}
+	}
+	return true
+}
+
 func isVoteMessage(msg *eraftpb.Message) bool {
 	tp := msg.GetMsgType()
 	return tp == eraftpb.MessageType_MsgRequestVote || tp == eraftpb.MessageType_MsgRequestPreVote
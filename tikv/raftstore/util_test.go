@@ -308,9 +308,86 @@ func TestCheckRegionEpoch(t *testing.T) {
 	}
 }
 
+func TestIsSiblingRegions(t *testing.T) {
+	left := &metapb.Region{Id: 1, EndKey: []byte("k")}
+	right := &metapb.Region{Id: 2, StartKey: []byte("k")}
+	assert.True(t, isSiblingRegions(left, right))
+	assert.True(t, isSiblingRegions(right, left))
+	assert.False(t, isSiblingRegions(left, left))
+	assert.False(t, isSiblingRegions(left, &metapb.Region{Id: 3, StartKey: []byte("m")}))
+	// Regions with empty start key and empty end key are not adjacent.
+	assert.False(t, isSiblingRegions(&metapb.Region{Id: 4}, &metapb.Region{Id: 5}))
+}
+
+func TestRegionOnSameStores(t *testing.T) {
+	region := &metapb.Region{Peers: []*metapb.Peer{{Id: 1, StoreId: 1}, {Id: 2, StoreId: 2}}}
+	assert.True(t, regionOnSameStores(region, &metapb.Region{Peers: []*metapb.Peer{{Id: 4, StoreId: 2}, {Id: 3, StoreId: 1}}}))
+	assert.False(t, regionOnSameStores(region, &metapb.Region{Peers: []*metapb.Peer{{Id: 3, StoreId: 1}}}))
+	assert.False(t, regionOnSameStores(region, &metapb.Region{Peers: []*metapb.Peer{{Id: 3, StoreId: 1}, {Id: 4, StoreId: 3}}}))
+	assert.False(t, regionOnSameStores(region, &metapb.Region{Peers: []*metapb.Peer{{Id: 3, StoreId: 1}, {Id: 4, StoreId: 2, IsLearner: true}}}))
+}
+
 func cloneEpoch(epoch *metapb.RegionEpoch) *metapb.RegionEpoch {
 	return &metapb.RegionEpoch{
 		ConfVer: epoch.ConfVer,
 		Version: epoch.Version,
 	}
 }
+
+func TestIsReplicaReadRequest(t *testing.T) {
+	newReq := func(replicaRead bool, cmdTypes ...raft_cmdpb.CmdType) *raft_cmdpb.RaftCmdRequest {
+		req := &raft_cmdpb.RaftCmdRequest{Header: &raft_cmdpb.RaftRequestHeader{ReplicaRead: replicaRead}}
+		for _, tp := range cmdTypes {
+			req.Requests = append(req.Requests, &raft_cmdpb.Request{CmdType: tp})
+		}
+		return req
+	}
+	assert.True(t, isReplicaReadRequest(newReq(true, raft_cmdpb.CmdType_Snap)))
+	assert.True(t, isReplicaReadRequest(newReq(true, raft_cmdpb.CmdType_ReadIndex)))
+	assert.False(t, isReplicaReadRequest(newReq(false, raft_cmdpb.CmdType_Snap)))
+	assert.False(t, isReplicaReadRequest(newReq(true)))
+	assert.False(t, isReplicaReadRequest(newReq(true, raft_cmdpb.CmdType_Snap, raft_cmdpb.CmdType_Put)))
+
+	admin := newReq(true)
+	admin.AdminRequest = &raft_cmdpb.AdminRequest{CmdType: raft_cmdpb.AdminCmdType_CompactLog}
+	assert.False(t, isReplicaReadRequest(admin))
+}
+
+func TestCheckChangePeer(t *testing.T) {
+	region := &metapb.Region{
+		Id: 1,
+		Peers: []*metapb.Peer{
+			{Id: 1, StoreId: 1},
+			{Id: 2, StoreId: 2},
+			{Id: 3, StoreId: 3, IsLearner: true},
+		},
+	}
+	tbl := []struct {
+		changeType eraftpb.ConfChangeType
+		peer       *metapb.Peer
+		ok         bool
+	}{
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 4, StoreId: 4}, true},
+		{eraftpb.ConfChangeType_AddLearnerNode, &metapb.Peer{Id: 4, StoreId: 4, IsLearner: true}, true},
+		// Promote the learner.
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 3, StoreId: 3}, true},
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 0, StoreId: 4}, false},
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 4, StoreId: 0}, false},
+		// Duplicated ids.
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 2, StoreId: 4}, false},
+		{eraftpb.ConfChangeType_AddLearnerNode, &metapb.Peer{Id: 1, StoreId: 4, IsLearner: true}, false},
+		// Duplicated stores.
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 4, StoreId: 2}, false},
+		{eraftpb.ConfChangeType_AddLearnerNode, &metapb.Peer{Id: 4, StoreId: 3, IsLearner: true}, false},
+		// The peer exists.
+		{eraftpb.ConfChangeType_AddNode, &metapb.Peer{Id: 2, StoreId: 2}, false},
+		{eraftpb.ConfChangeType_AddLearnerNode, &metapb.Peer{Id: 3, StoreId: 3, IsLearner: true}, false},
+		{eraftpb.ConfChangeType_RemoveNode, &metapb.Peer{Id: 3, StoreId: 3, IsLearner: true}, true},
+		{eraftpb.ConfChangeType_RemoveNode, &metapb.Peer{Id: 2, StoreId: 3}, false},
+		{eraftpb.ConfChangeType_RemoveNode, &metapb.Peer{Id: 4, StoreId: 4}, false},
+	}
+	for i, tt := range tbl {
+		err := checkChangePeer(region, tt.changeType, tt.peer)
+		assert.Equal(t, tt.ok, err == nil, "case %d: %v", i, err)
+	}
+}
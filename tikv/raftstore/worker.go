@@ -30,7 +30,6 @@ import (
 	"github.com/ngaut/unistore/config"
 	"github.com/ngaut/unistore/lockstore"
 	"github.com/ngaut/unistore/tikv/dbreader"
-	"github.com/ngaut/unistore/tikv/mvcc"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/eraftpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
@@ -49,7 +48,6 @@ const (
 	taskTypeStop           taskType = 0
 	taskTypeRaftLogGC      taskType = 1
 	taskTypeSplitCheck     taskType = 2
-	taskTypeComputeHash    taskType = 3
 	taskTypeHalfSplitCheck taskType = 4
 
 	taskTypePDAskSplit         taskType = 101
@@ -102,12 +100,6 @@ type splitCheckTask struct {
 	region *metapb.Region
 }
 
-type computeHashTask struct {
-	index  uint64
-	region *metapb.Region
-	snap   *mvcc.DBSnapshot
-}
-
 type pdAskSplitTask struct {
 	region   *metapb.Region
 	splitKey []byte
@@ -1040,11 +1032,3 @@ type compactTaskHandler struct {
 func (r *compactTaskHandler) handle(t task) {
 	// TODO: stub
 }
-
-type computeHashTaskHandler struct {
-	router *router
-}
-
-func (r *computeHashTaskHandler) handle(t task) {
-	// TODO: stub
-}
@@ -0,0 +1,264 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/coocood/badger"
+	"github.com/juju/errors"
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/tikv/raftstore"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// Raw keys are stored in the kv DB with mvcc.RawKeyPrefix, they are not versioned by transactions,
+// every write gets a new version from PD, so reading at math.MaxUint64 always returns the latest value.
+// The raw keys of a region are in [RawKeyPrefix + start key, RawKeyPrefix + end key), which is covered
+// by the snapshots and the destroy of the region.
+
+var (
+	errInvalidRawCF  = errors.New("invalid cf name, only the default cf is supported for raw keys")
+	errEmptyRawValue = errors.New("raw value must not be empty")
+)
+
+func checkRawCF(cf string) error {
+	if cf != "" && cf != "default" {
+		return errInvalidRawCF
+	}
+	return nil
+}
+
+// checkKeysInRegion returns a KeyNotInRegion error if any of the keys is out of the region of the request.
+func checkKeysInRegion(reqCtx *requestCtx, keys ...[]byte) error {
+	regCtx := reqCtx.regCtx
+	for _, key := range keys {
+		if regCtx.lessThanStartKey(key) || regCtx.greaterEqualEndKey(key) {
+			return &raftstore.RaftError{RequestErr: &errorpb.Error{
+				Message: "key not in region",
+				KeyNotInRegion: &errorpb.KeyNotInRegion{
+					Key:      key,
+					RegionId: reqCtx.rpcCtx.RegionId,
+					StartKey: regCtx.startKey,
+					EndKey:   regCtx.endKey,
+				},
+			}}
+		}
+	}
+	return nil
+}
+
+func (store *MVCCStore) RawGet(reqCtx *requestCtx, key []byte) ([]byte, error) {
+	if err := checkKeysInRegion(reqCtx, key); err != nil {
+		return nil, err
+	}
+	val, err := reqCtx.getDBReader().Get(mvcc.EncodeRawKey(key), math.MaxUint64)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return safeCopy(val), nil
+}
+
+func (store *MVCCStore) RawBatchGet(reqCtx *requestCtx, keys [][]byte) ([]*kvrpcpb.KvPair, error) {
+	if err := checkKeysInRegion(reqCtx, keys...); err != nil {
+		return nil, err
+	}
+	rawKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		rawKeys[i] = mvcc.EncodeRawKey(key)
+	}
+	pairs := make([]*kvrpcpb.KvPair, 0, len(keys))
+	reqCtx.getDBReader().BatchGet(rawKeys, math.MaxUint64, func(key, value []byte, err error) {
+		if err != nil {
+			pairs = append(pairs, &kvrpcpb.KvPair{Key: safeCopy(mvcc.DecodeRawKey(key)), Error: convertToKeyError(err)})
+		} else if len(value) != 0 {
+			pairs = append(pairs, &kvrpcpb.KvPair{Key: safeCopy(mvcc.DecodeRawKey(key)), Value: safeCopy(value)})
+		}
+	})
+	return pairs, nil
+}
+
+func (store *MVCCStore) RawBatchPut(reqCtx *requestCtx, pairs []*kvrpcpb.KvPair) error {
+	if len(pairs) == 0 {
+		return nil
+	}
+	keys := make([][]byte, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	if err := checkKeysInRegion(reqCtx, keys...); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		keys[i] = mvcc.EncodeRawKey(key)
+	}
+	hashVals := keysToHashVals(keys...)
+	regCtx := reqCtx.regCtx
+	regCtx.AcquireLatches(hashVals)
+	defer regCtx.ReleaseLatches(hashVals)
+
+	// The version is allocated in the latches, so the later write of a key always has the larger version.
+	version, err := store.allocRawVersion()
+	if err != nil {
+		return err
+	}
+	batch := store.dbWriter.NewWriteBatch(0, 0, reqCtx.rpcCtx)
+	for _, pair := range pairs {
+		batch.RawPut(pair.Key, pair.Value, version)
+	}
+	return store.dbWriter.Write(batch)
+}
+
+func (store *MVCCStore) RawBatchDelete(reqCtx *requestCtx, keys [][]byte) error {
+	if err := checkKeysInRegion(reqCtx, keys...); err != nil {
+		return err
+	}
+	rawKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		rawKeys[i] = mvcc.EncodeRawKey(key)
+	}
+	return store.rawDeleteKeys(reqCtx, rawKeys)
+}
+
+func (store *MVCCStore) rawDeleteKeys(reqCtx *requestCtx, rawKeys [][]byte) error {
+	if len(rawKeys) == 0 {
+		return nil
+	}
+	hashVals := keysToHashVals(rawKeys...)
+	regCtx := reqCtx.regCtx
+	regCtx.AcquireLatches(hashVals)
+	defer regCtx.ReleaseLatches(hashVals)
+
+	version, err := store.allocRawVersion()
+	if err != nil {
+		return err
+	}
+	batch := store.dbWriter.NewWriteBatch(0, 0, reqCtx.rpcCtx)
+	for _, rawKey := range rawKeys {
+		batch.RawDelete(mvcc.DecodeRawKey(rawKey), version)
+	}
+	return store.dbWriter.Write(batch)
+}
+
+// RawScan scans the raw keys in [startKey, endKey), if reverse is true, the range is [endKey, startKey) and
+// the keys are returned in descending order. The range is limited to the region of the request.
+func (store *MVCCStore) RawScan(reqCtx *requestCtx, startKey, endKey []byte, limit int, keyOnly, reverse bool) ([]*kvrpcpb.KvPair, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	lower, upper := startKey, endKey
+	if reverse {
+		lower, upper = endKey, startKey
+	}
+	lower, upper = store.clampRawRange(reqCtx, lower, upper)
+	var pairs []*kvrpcpb.KvPair
+	err := store.iterateRawRange(reqCtx, lower, upper, reverse, func(key, value []byte) bool {
+		pair := &kvrpcpb.KvPair{Key: safeCopy(key)}
+		if !keyOnly {
+			pair.Value = safeCopy(value)
+		}
+		pairs = append(pairs, pair)
+		return len(pairs) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// RawDeleteRange deletes the raw keys in [startKey, endKey) limited to the region of the request.
+func (store *MVCCStore) RawDeleteRange(reqCtx *requestCtx, startKey, endKey []byte) error {
+	startKey, endKey = store.clampRawRange(reqCtx, startKey, endKey)
+	var rawKeys [][]byte
+	err := store.iterateRawRange(reqCtx, startKey, endKey, false, func(key, value []byte) bool {
+		rawKeys = append(rawKeys, mvcc.EncodeRawKey(key))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for len(rawKeys) > 0 {
+		n := delRangeBatchSize
+		if n > len(rawKeys) {
+			n = len(rawKeys)
+		}
+		if err = store.rawDeleteKeys(reqCtx, rawKeys[:n]); err != nil {
+			return err
+		}
+		rawKeys = rawKeys[n:]
+	}
+	return nil
+}
+
+func (store *MVCCStore) clampRawRange(reqCtx *requestCtx, lower, upper []byte) ([]byte, []byte) {
+	regCtx := reqCtx.regCtx
+	if regCtx.lessThanStartKey(lower) {
+		lower = regCtx.startKey
+	}
+	if len(upper) == 0 || regCtx.greaterThanEndKey(upper) {
+		upper = regCtx.endKey
+	}
+	return lower, upper
+}
+
+// iterateRawRange calls f for every raw key in [lower, upper), an empty upper means no upper bound.
+// The iteration stops when f returns false.
+func (store *MVCCStore) iterateRawRange(reqCtx *requestCtx, lower, upper []byte, reverse bool,
+	f func(key, value []byte) bool) error {
+	if len(upper) > 0 && bytes.Compare(lower, upper) >= 0 {
+		return nil
+	}
+	startKey, endKey := mvcc.EncodeRawKey(lower), mvcc.EncodeRawEndKey(upper)
+	return iterateDBRange(reqCtx.getDBReader().GetTxn(), startKey, endKey, math.MaxUint64, reverse,
+		func(item *badger.Item) (bool, error) {
+			val, err := item.Value()
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			return f(mvcc.DecodeRawKey(item.Key()), val), nil
+		})
+}
+
+// iterateDBRange calls f for the latest version at or below readTS of every DB key in [startKey, endKey),
+// deleted keys are skipped. The iteration stops when f returns false or an error.
+func iterateDBRange(txn *badger.Txn, startKey, endKey []byte, readTS uint64, reverse bool,
+	f func(item *badger.Item) (bool, error)) error {
+	txn.SetReadTS(readTS)
+	it := dbreader.NewIterator(txn, reverse, startKey, endKey)
+	defer it.Close()
+	seekKey := startKey
+	if reverse {
+		seekKey = endKey
+	}
+	for it.Seek(seekKey); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if bytes.Compare(key, startKey) < 0 || bytes.Compare(key, endKey) >= 0 {
+			if reverse && bytes.Equal(key, endKey) {
+				continue
+			}
+			break
+		}
+		if item.IsEmpty() {
+			continue
+		}
+		if goOn, err := f(item); err != nil || !goOn {
+			return err
+		}
+	}
+	return nil
+}
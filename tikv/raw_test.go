@@ -0,0 +1,155 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+func MustRawPut(key, val []byte, store *TestStore) {
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	err := store.MvccStore.RawBatchPut(reqCtx, []*kvrpcpb.KvPair{{Key: key, Value: val}})
+	store.c.Assert(err, IsNil)
+}
+
+func MustRawGetVal(key, val []byte, store *TestStore) {
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	got, err := store.MvccStore.RawGet(reqCtx, key)
+	store.c.Assert(err, IsNil)
+	store.c.Assert(got, BytesEquals, val)
+}
+
+func MustRawScanKeys(startKey, endKey []byte, limit int, reverse bool, keys []string, store *TestStore) {
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	pairs, err := store.MvccStore.RawScan(reqCtx, startKey, endKey, limit, false, reverse)
+	store.c.Assert(err, IsNil)
+	got := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		got = append(got, string(pair.Key))
+	}
+	store.c.Assert(got, DeepEquals, keys)
+}
+
+func (s *testMvccSuite) TestRawPutGetDelete(c *C) {
+	store, err := NewTestStore("TestRawPutGetDelete", "TestRawPutGetDelete", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k := []byte("ta")
+	MustRawGetVal(k, nil, store)
+	MustRawPut(k, []byte("v1"), store)
+	MustRawGetVal(k, []byte("v1"), store)
+	MustRawPut(k, []byte("v2"), store)
+	MustRawGetVal(k, []byte("v2"), store)
+
+	// Raw keys are not visible to transactional reads.
+	MustGetVal(k, nil, 100, store)
+
+	reqCtx := store.newReqCtx()
+	c.Assert(store.MvccStore.RawBatchDelete(reqCtx, [][]byte{k}), IsNil)
+	reqCtx.finish()
+	MustRawGetVal(k, nil, store)
+}
+
+func (s *testMvccSuite) TestRawBatchGetAndScan(c *C) {
+	store, err := NewTestStore("TestRawBatchGetAndScan", "TestRawBatchGetAndScan", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	for _, k := range []string{"ta", "tb", "tc", "td"} {
+		MustRawPut([]byte(k), []byte("v"+k), store)
+	}
+
+	reqCtx := store.newReqCtx()
+	pairs, err := store.MvccStore.RawBatchGet(reqCtx, [][]byte{[]byte("ta"), []byte("tx"), []byte("tc")})
+	reqCtx.finish()
+	c.Assert(err, IsNil)
+	c.Assert(pairs, HasLen, 2)
+	c.Assert(pairs[0].Key, BytesEquals, []byte("ta"))
+	c.Assert(pairs[0].Value, BytesEquals, []byte("vta"))
+	c.Assert(pairs[1].Key, BytesEquals, []byte("tc"))
+
+	MustRawScanKeys([]byte("ta"), nil, 10, false, []string{"ta", "tb", "tc", "td"}, store)
+	MustRawScanKeys([]byte("tb"), []byte("td"), 10, false, []string{"tb", "tc"}, store)
+	MustRawScanKeys([]byte("ta"), nil, 2, false, []string{"ta", "tb"}, store)
+	MustRawScanKeys([]byte("td"), []byte("ta"), 10, true, []string{"tc", "tb", "ta"}, store)
+	MustRawScanKeys(nil, []byte("tb"), 10, true, []string{"td", "tc", "tb"}, store)
+	MustRawScanKeys([]byte("ta"), nil, 0, false, []string{}, store)
+
+	reqCtx = store.newReqCtx()
+	c.Assert(store.MvccStore.RawDeleteRange(reqCtx, []byte("tb"), []byte("td")), IsNil)
+	reqCtx.finish()
+	MustRawScanKeys([]byte("ta"), nil, 10, false, []string{"ta", "td"}, store)
+}
+
+func (s *testMvccSuite) TestRawKeyNotInRegion(c *C) {
+	store, err := NewTestStore("TestRawKeyNotInRegion", "TestRawKeyNotInRegion", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	checkKeyNotInRegion := func(err error) {
+		regErr := extractRegionError(err)
+		c.Assert(regErr, NotNil)
+		c.Assert(regErr.GetKeyNotInRegion().GetKey(), BytesEquals, []byte("ua"))
+	}
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	checkKeyNotInRegion(store.MvccStore.RawBatchPut(reqCtx, []*kvrpcpb.KvPair{
+		{Key: []byte("ta"), Value: []byte("v")}, {Key: []byte("ua"), Value: []byte("v")}}))
+	checkKeyNotInRegion(store.MvccStore.RawBatchDelete(reqCtx, [][]byte{[]byte("ua")}))
+	_, err = store.MvccStore.RawGet(reqCtx, []byte("ua"))
+	checkKeyNotInRegion(err)
+	_, err = store.MvccStore.RawBatchGet(reqCtx, [][]byte{[]byte("ta"), []byte("ua")})
+	checkKeyNotInRegion(err)
+
+	// Nothing of the rejected batch is written.
+	MustRawGetVal([]byte("ta"), nil, store)
+}
+
+func (s *testMvccSuite) TestRawDeleteCompacted(c *C) {
+	store, err := NewTestStore("TestRawDeleteCompacted", "TestRawDeleteCompacted", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	MustRawPut([]byte("ta"), []byte("v1"), store)
+	MustRawPut([]byte("tb"), []byte("v1"), store)
+	MustRawPut([]byte("tb"), []byte("v2"), store)
+	reqCtx := store.newReqCtx()
+	c.Assert(store.MvccStore.RawBatchDelete(reqCtx, [][]byte{[]byte("ta")}), IsNil)
+	reqCtx.finish()
+	store.MvccStore.UpdateSafePoint(100)
+	store, err = reopenTestStore(store)
+	c.Assert(err, IsNil)
+
+	// The delete and the versions below it are dropped, only the latest version of the live key is kept.
+	var versions []string
+	txn := store.MvccStore.db.NewTransaction(false)
+	defer txn.Discard()
+	it := dbreader.NewIterator(txn, false, mvcc.RawKeyPrefix, mvcc.EncodeRawEndKey(nil))
+	defer it.Close()
+	it.SetAllVersions(true)
+	for it.Seek(mvcc.RawKeyPrefix); it.Valid(); it.Next() {
+		val, err := it.Item().Value()
+		c.Assert(err, IsNil)
+		versions = append(versions, string(mvcc.DecodeRawKey(it.Item().Key()))+"="+string(val))
+	}
+	c.Assert(versions, DeepEquals, []string{"tb=v2"})
+	MustRawGetVal([]byte("tb"), []byte("v2"), store)
+}
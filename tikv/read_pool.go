@@ -0,0 +1,149 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ngaut/unistore/metrics"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The priority levels of a read pool, a free slot is given to the waiting request of the highest priority.
+const (
+	readPriorityHigh = iota
+	readPriorityNormal
+	readPriorityLow
+	readPriorityCount
+)
+
+var readPriorityNames = [readPriorityCount]string{"high", "normal", "low"}
+
+func readPriorityLevel(pri kvrpcpb.CommandPri) int {
+	switch pri {
+	case kvrpcpb.CommandPri_High:
+		return readPriorityHigh
+	case kvrpcpb.CommandPri_Low:
+		return readPriorityLow
+	}
+	return readPriorityNormal
+}
+
+// readPool bounds the number of the reads running at the same time, a read waits in the queue of its priority
+// for a slot and is rejected if the queue is full.
+type readPool struct {
+	name        string
+	concurrency int
+	maxPending  int
+
+	mu      sync.Mutex
+	running int
+	queues  [readPriorityCount][]chan struct{}
+
+	pendingGauges [readPriorityCount]prometheus.Gauge
+	runningGauge  prometheus.Gauge
+}
+
+// newReadPool creates a read pool, the reads are not bounded if concurrency is not positive.
+func newReadPool(name string, concurrency, maxTasksPerWorker int) *readPool {
+	p := &readPool{
+		name:         name,
+		concurrency:  concurrency,
+		maxPending:   concurrency * maxTasksPerWorker,
+		runningGauge: metrics.ReadPoolRunningTasks.WithLabelValues(name),
+	}
+	for i := range p.pendingGauges {
+		p.pendingGauges[i] = metrics.ReadPoolPendingTasks.WithLabelValues(name, readPriorityNames[i])
+	}
+	return p
+}
+
+// acquire waits for a slot to run a read of the priority until ctx is done, a ServerIsBusy error is returned if
+// the queue of the priority is full.
+func (p *readPool) acquire(ctx context.Context, pri kvrpcpb.CommandPri) *errorpb.Error {
+	if p.concurrency <= 0 {
+		return nil
+	}
+	level := readPriorityLevel(pri)
+	p.mu.Lock()
+	if p.running < p.concurrency {
+		// A released slot is handed to a waiting read, so there is no waiting read if a slot is free.
+		p.running++
+		p.mu.Unlock()
+		p.runningGauge.Inc()
+		return nil
+	}
+	if len(p.queues[level]) >= p.maxPending {
+		p.mu.Unlock()
+		metrics.ReadPoolRejectedTasks.WithLabelValues(p.name, readPriorityNames[level]).Inc()
+		reason := fmt.Sprintf("%s read pool is full", p.name)
+		return &errorpb.Error{Message: reason, ServerIsBusy: &errorpb.ServerIsBusy{Reason: reason}}
+	}
+	ch := make(chan struct{})
+	p.queues[level] = append(p.queues[level], ch)
+	p.mu.Unlock()
+	p.pendingGauges[level].Inc()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+	regErr := &errorpb.Error{Message: fmt.Sprintf("wait for %s read pool: %v", p.name, ctx.Err())}
+	p.mu.Lock()
+	for i, waiting := range p.queues[level] {
+		if waiting == ch {
+			p.queues[level] = append(p.queues[level][:i], p.queues[level][i+1:]...)
+			p.mu.Unlock()
+			p.pendingGauges[level].Dec()
+			return regErr
+		}
+	}
+	p.mu.Unlock()
+	// The slot was handed to the read before the read is canceled.
+	p.release()
+	return regErr
+}
+
+// release releases the slot of a read, the slot is handed to the waiting read of the highest priority.
+func (p *readPool) release() {
+	if p.concurrency <= 0 {
+		return
+	}
+	p.mu.Lock()
+	for level, queue := range p.queues {
+		if len(queue) > 0 {
+			ch := queue[0]
+			queue[0] = nil
+			p.queues[level] = queue[1:]
+			p.mu.Unlock()
+			p.pendingGauges[level].Dec()
+			close(ch)
+			return
+		}
+	}
+	p.running--
+	p.mu.Unlock()
+	p.runningGauge.Dec()
+}
+
+// pendingCount returns the number of the reads of the priority waiting in the pool.
+func (p *readPool) pendingCount(pri kvrpcpb.CommandPri) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queues[readPriorityLevel(pri)])
+}
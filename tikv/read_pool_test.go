@@ -0,0 +1,85 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+var _ = Suite(&testReadPoolSuite{})
+
+type testReadPoolSuite struct{}
+
+func (s *testReadPoolSuite) TestReadPoolPriority(c *C) {
+	pool := newReadPool("test", 1, 1)
+	c.Assert(pool.acquire(context.Background(), kvrpcpb.CommandPri_Normal), IsNil)
+
+	done := make(chan kvrpcpb.CommandPri, 3)
+	waitPending := func(pri kvrpcpb.CommandPri, n int) {
+		for pool.pendingCount(pri) != n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for _, pri := range []kvrpcpb.CommandPri{kvrpcpb.CommandPri_Low, kvrpcpb.CommandPri_Normal,
+		kvrpcpb.CommandPri_High} {
+		go func(pri kvrpcpb.CommandPri) {
+			c.Check(pool.acquire(context.Background(), pri), IsNil)
+			done <- pri
+		}(pri)
+		waitPending(pri, 1)
+	}
+	// The queue of a priority is full.
+	regErr := pool.acquire(context.Background(), kvrpcpb.CommandPri_High)
+	c.Assert(regErr.GetServerIsBusy(), NotNil)
+
+	// The slot is handed to the waiting read of the highest priority.
+	for _, pri := range []kvrpcpb.CommandPri{kvrpcpb.CommandPri_High, kvrpcpb.CommandPri_Normal,
+		kvrpcpb.CommandPri_Low} {
+		pool.release()
+		c.Assert(<-done, Equals, pri)
+	}
+	pool.release()
+	c.Assert(pool.running, Equals, 0)
+
+	// The reads are not bounded without concurrency.
+	pool = newReadPool("test", 0, 1)
+	for i := 0; i < 10; i++ {
+		c.Assert(pool.acquire(context.Background(), kvrpcpb.CommandPri_Normal), IsNil)
+	}
+}
+
+func (s *testReadPoolSuite) TestReadPoolCancel(c *C) {
+	pool := newReadPool("test", 1, 1)
+	c.Assert(pool.acquire(context.Background(), kvrpcpb.CommandPri_Normal), IsNil)
+
+	// The canceled read leaves the queue.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Check(pool.acquire(ctx, kvrpcpb.CommandPri_Normal), NotNil)
+		close(done)
+	}()
+	for pool.pendingCount(kvrpcpb.CommandPri_Normal) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	c.Assert(pool.pendingCount(kvrpcpb.CommandPri_Normal), Equals, 0)
+	pool.release()
+	c.Assert(pool.running, Equals, 0)
+}
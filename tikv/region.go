@@ -16,6 +16,7 @@ package tikv
 import (
 	"bytes"
 	"encoding/binary"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -58,9 +59,16 @@ type regionCtx struct {
 	endKey          []byte
 	approximateSize int64
 	diff            int64
+	load            regionLoad
 
 	latches       *latches
 	leaderChecker raftstore.LeaderChecker
+	// safeTS is the safe ts of a region without a raft peer, the safe ts of a raft peer is in its leaderChecker.
+	safeTS uint64
+	// proposedTS is the last resolved ts proposed by the raft leader at proposedTime, they are only accessed by
+	// the resolved ts worker.
+	proposedTS   uint64
+	proposedTime time.Time
 }
 
 type latches struct {
@@ -141,6 +149,24 @@ func (ri *regionCtx) updateRegionEpoch(epoch *metapb.RegionEpoch) {
 	atomic.StorePointer(&ri.regionEpoch, (unsafe.Pointer)(epoch))
 }
 
+// getSafeTS returns the safe ts of the local peer, no transaction can commit at or below it any more and the
+// peer has all the commits at or below it, so the reads at or below it don't need the leader and the locks.
+func (ri *regionCtx) getSafeTS() uint64 {
+	if ri.leaderChecker != nil {
+		return ri.leaderChecker.SafeTS()
+	}
+	return atomic.LoadUint64(&ri.safeTS)
+}
+
+func (ri *regionCtx) advanceSafeTS(ts uint64) {
+	for {
+		safeTS := atomic.LoadUint64(&ri.safeTS)
+		if ts <= safeTS || atomic.CompareAndSwapUint64(&ri.safeTS, safeTS, ts) {
+			return
+		}
+	}
+}
+
 func (ri *regionCtx) rawStartKey() []byte {
 	if len(ri.meta.StartKey) == 0 {
 		return nil
@@ -223,7 +249,20 @@ type RegionOptions struct {
 
 type RegionManager interface {
 	GetRegionFromCtx(ctx *kvrpcpb.Context) (*regionCtx, *errorpb.Error)
+	GetContextFromKey(key []byte) (*kvrpcpb.Context, *errorpb.Error)
 	SplitRegion(req *kvrpcpb.SplitRegionRequest) *kvrpcpb.SplitRegionResponse
+	ReadIndex(req *kvrpcpb.ReadIndexRequest) *kvrpcpb.ReadIndexResponse
+	// GetStaleReadRegion returns the region of the ctx if readTS is not greater than the safe ts of the local peer,
+	// the read is served without checking the leader and the locks. nil is returned otherwise.
+	GetStaleReadRegion(ctx *kvrpcpb.Context, readTS uint64) *regionCtx
+	// GetRegionsInRange returns the regions overlapping the raw range [startKey, endKey) sorted by the start key,
+	// an empty endKey means no upper bound.
+	GetRegionsInRange(startKey, endKey []byte) []*regionCtx
+	// AdvanceResolvedTS advances the safe ts of the regions led by the store to the resolved ts returned by
+	// resolve.
+	AdvanceResolvedTS(resolve func(regCtx *regionCtx) uint64)
+	// SplitHotRegion splits the region at the split key picked from its load if the region is led by the store.
+	SplitHotRegion(regCtx *regionCtx, splitKey []byte) error
 	Close() error
 }
 
@@ -271,6 +310,75 @@ func (rm *regionManager) GetRegionFromCtx(ctx *kvrpcpb.Context) (*regionCtx, *er
 	return ri, nil
 }
 
+// GetContextFromKey returns the context of the local peer of the region which contains the key, it is used by
+// the requests without a context.
+func (rm *regionManager) GetContextFromKey(key []byte) (*kvrpcpb.Context, *errorpb.Error) {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	for _, ri := range rm.regions {
+		if ri.lessThanStartKey(key) || ri.greaterEqualEndKey(key) {
+			continue
+		}
+		return rm.newPeerContext(ri), nil
+	}
+	return nil, &errorpb.Error{
+		Message:        "region not found",
+		KeyNotInRegion: &errorpb.KeyNotInRegion{Key: key},
+	}
+}
+
+func (rm *regionManager) newPeerContext(ri *regionCtx) *kvrpcpb.Context {
+	ctx := &kvrpcpb.Context{
+		RegionId:    ri.meta.Id,
+		RegionEpoch: ri.getRegionEpoch(),
+	}
+	for _, peer := range ri.meta.Peers {
+		if peer.StoreId == rm.storeMeta.Id {
+			ctx.Peer = peer
+		}
+	}
+	return ctx
+}
+
+func (rm *regionManager) getRegions() []*regionCtx {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	regions := make([]*regionCtx, 0, len(rm.regions))
+	for _, ri := range rm.regions {
+		regions = append(regions, ri)
+	}
+	return regions
+}
+
+func (rm *regionManager) GetRegionsInRange(startKey, endKey []byte) []*regionCtx {
+	var regions []*regionCtx
+	for _, ri := range rm.getRegions() {
+		if ri.greaterEqualEndKey(startKey) || (len(endKey) > 0 && bytes.Compare(ri.startKey, endKey) >= 0) {
+			continue
+		}
+		regions = append(regions, ri)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return bytes.Compare(regions[i].startKey, regions[j].startKey) < 0
+	})
+	return regions
+}
+
+func (rm *regionManager) GetStaleReadRegion(ctx *kvrpcpb.Context, readTS uint64) *regionCtx {
+	ri, err := rm.GetRegionFromCtx(ctx)
+	if err != nil || readTS > ri.getSafeTS() {
+		return nil
+	}
+	return ri
+}
+
+// AdvanceResolvedTS advances the safe ts of every region, the store without raft leads all its regions.
+func (rm *regionManager) AdvanceResolvedTS(resolve func(regCtx *regionCtx) uint64) {
+	for _, ri := range rm.getRegions() {
+		ri.advanceSafeTS(resolve(ri))
+	}
+}
+
 func (rm *regionManager) isEpochStale(lhs, rhs *metapb.RegionEpoch) bool {
 	return lhs.GetConfVer() != rhs.GetConfVer() || lhs.GetVersion() != rhs.GetVersion()
 }
@@ -397,6 +505,18 @@ func (rm *RaftRegionManager) OnRegionConfChange(ctx *raftstore.PeerEventContext,
 	}
 }
 
+type regionMergeEvent struct {
+	ctx    *raftstore.PeerEventContext
+	region *metapb.Region
+}
+
+func (rm *RaftRegionManager) OnMergeRegion(ctx *raftstore.PeerEventContext, region *metapb.Region) {
+	rm.eventCh <- &regionMergeEvent{
+		ctx:    ctx,
+		region: region,
+	}
+}
+
 type regionRoleChangeEvent struct {
 	regionId uint64
 	newState raft.StateType
@@ -417,6 +537,56 @@ func (rm *RaftRegionManager) GetRegionFromCtx(ctx *kvrpcpb.Context) (*regionCtx,
 	return regionCtx, nil
 }
 
+// AdvanceResolvedTS proposes the resolved ts of the regions led by the store, the safe ts of every peer is
+// advanced when the proposal is applied. Every proposal is a raft log replicated to all the peers, so a region
+// proposes its resolved ts every resolvedTSProposeInterval at most, and only if it moves.
+func (rm *RaftRegionManager) AdvanceResolvedTS(resolve func(regCtx *regionCtx) uint64) {
+	now := time.Now()
+	for _, ri := range rm.getRegions() {
+		if now.Sub(ri.proposedTime) < resolvedTSProposeInterval {
+			continue
+		}
+		ctx := rm.newPeerContext(ri)
+		if ctx.Peer == nil {
+			continue
+		}
+		// The proposal is rejected once the peer is not the leader of the term, the locks of the region may be
+		// changed by a new leader.
+		ctx.Term = ri.leaderChecker.Term()
+		// A leader that passes the check has applied the logs of the previous terms, so the lock store has all
+		// the locks of the region.
+		if _, err := rm.GetRegionFromCtx(ctx); err != nil {
+			continue
+		}
+		// The resolved ts doesn't move until the min lock of the region is resolved.
+		ts := resolve(ri)
+		if ts <= ri.getSafeTS() || ts <= ri.proposedTS {
+			continue
+		}
+		if err := rm.router.ProposeResolvedTS(ctx, ts); err != nil {
+			log.Warn("propose resolved ts failed", zap.Uint64("region", ri.meta.Id), zap.Error(err))
+			continue
+		}
+		ri.proposedTS, ri.proposedTime = ts, now
+	}
+}
+
+// SplitHotRegion splits the region through the SplitRegion and AskBatchSplit flow if the local peer is the leader.
+func (rm *RaftRegionManager) SplitHotRegion(regCtx *regionCtx, splitKey []byte) error {
+	ctx := rm.newPeerContext(regCtx)
+	if ctx.Peer == nil {
+		return nil
+	}
+	if _, err := rm.GetRegionFromCtx(ctx); err != nil {
+		return nil
+	}
+	resp := rm.SplitRegion(&kvrpcpb.SplitRegionRequest{Context: ctx, SplitKeys: [][]byte{splitKey}})
+	if resp.RegionError != nil {
+		return errors.New(resp.RegionError.Message)
+	}
+	return nil
+}
+
 func (rm *RaftRegionManager) Close() error {
 	return nil
 }
@@ -440,6 +610,10 @@ func (rm *RaftRegionManager) runEventHandler() {
 			region := rm.regions[x.ctx.RegionId]
 			rm.mu.RUnlock()
 			region.updateRegionEpoch(x.epoch)
+		case *regionMergeEvent:
+			rm.mu.Lock()
+			rm.regions[x.region.Id] = newRegionCtx(x.region, rm.latches, x.ctx.LeaderChecker)
+			rm.mu.Unlock()
 		case *peerDestroyEvent:
 			rm.mu.Lock()
 			delete(rm.regions, x.regionID)
@@ -476,6 +650,14 @@ func (rm *RaftRegionManager) SplitRegion(req *kvrpcpb.SplitRegionRequest) *kvrpc
 	return &kvrpcpb.SplitRegionResponse{Regions: regions}
 }
 
+func (rm *RaftRegionManager) ReadIndex(req *kvrpcpb.ReadIndexRequest) *kvrpcpb.ReadIndexResponse {
+	readIndex, err := rm.router.ReadIndex(req.GetContext())
+	if err != nil {
+		return &kvrpcpb.ReadIndexResponse{RegionError: err}
+	}
+	return &kvrpcpb.ReadIndexResponse{ReadIndex: readIndex}
+}
+
 type StandAloneRegionManager struct {
 	regionManager
 	bundle     *mvcc.DBBundle
@@ -484,6 +666,8 @@ type StandAloneRegionManager struct {
 	regionSize int64
 	closeCh    chan struct{}
 	wg         sync.WaitGroup
+	// splitMu serializes the size-based split and the load-based split.
+	splitMu sync.Mutex
 }
 
 func NewStandAloneRegionManager(bundle *mvcc.DBBundle, opts RegionOptions, pdc pd.Client) *StandAloneRegionManager {
@@ -715,9 +899,11 @@ func (rm *StandAloneRegionManager) runSplitWorker() {
 			}
 		}
 		rm.mu.RUnlock()
+		rm.splitMu.Lock()
 		for _, ri := range regionsToCheck {
 			rm.splitCheckRegion(ri)
 		}
+		rm.splitMu.Unlock()
 
 		regionsToSave = regionsToSave[:0]
 		rm.mu.RLock()
@@ -857,10 +1043,30 @@ func (rm *StandAloneRegionManager) splitRegion(oldRegionCtx *regionCtx, splitKey
 	return nil
 }
 
+// SplitHotRegion splits the region at the split key, the size of the region is assumed to be split evenly until the
+// next size check.
+func (rm *StandAloneRegionManager) SplitHotRegion(regCtx *regionCtx, splitKey []byte) error {
+	rm.splitMu.Lock()
+	defer rm.splitMu.Unlock()
+	rm.mu.RLock()
+	current := rm.regions[regCtx.meta.Id]
+	rm.mu.RUnlock()
+	if current != regCtx {
+		// The region has been split by size.
+		return nil
+	}
+	size := regCtx.approximateSize + atomic.LoadInt64(&regCtx.diff)
+	return rm.splitRegion(regCtx, splitKey, size, size/2)
+}
+
 func (rm *StandAloneRegionManager) SplitRegion(req *kvrpcpb.SplitRegionRequest) *kvrpcpb.SplitRegionResponse {
 	return &kvrpcpb.SplitRegionResponse{}
 }
 
+func (rm *StandAloneRegionManager) ReadIndex(req *kvrpcpb.ReadIndexRequest) *kvrpcpb.ReadIndexResponse {
+	return &kvrpcpb.ReadIndexResponse{}
+}
+
 func (rm *StandAloneRegionManager) Close() error {
 	close(rm.closeCh)
 	rm.wg.Wait()
@@ -0,0 +1,79 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const (
+	resolvedTSInterval = time.Second
+	// resolvedTSProposeInterval is the min interval of the resolved ts proposals of a raft region.
+	resolvedTSProposeInterval = 10 * time.Second
+)
+
+// resolvedTSWorker advances the resolved ts of the regions led by the store. The resolved ts of a region is the
+// min start ts of the locks in the region, or the current ts if there is no lock, no transaction can commit at
+// or below it any more.
+// A raft leader proposes the resolved ts, a peer advances its safe ts when the proposal is applied, so the peer
+// has all the commits at or below the safe ts, and the reads at or below it are served by any peer without
+// checking the leader and the locks.
+type resolvedTSWorker struct {
+	store         *MVCCStore
+	regionManager RegionManager
+	getTS         func() (uint64, error)
+	interval      time.Duration
+	closeCh       chan struct{}
+}
+
+func newResolvedTSWorker(store *MVCCStore, rm RegionManager) *resolvedTSWorker {
+	return &resolvedTSWorker{
+		store:         store,
+		regionManager: rm,
+		getTS:         store.getPDTS,
+		interval:      resolvedTSInterval,
+		closeCh:       make(chan struct{}),
+	}
+}
+
+func (w *resolvedTSWorker) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closeCh:
+			return
+		case <-ticker.C:
+			w.resolve()
+		}
+	}
+}
+
+func (w *resolvedTSWorker) resolve() {
+	ts, err := w.getTS()
+	if err != nil {
+		log.Warn("resolved ts get ts failed", zap.Error(err))
+		return
+	}
+	w.regionManager.AdvanceResolvedTS(func(regCtx *regionCtx) uint64 {
+		return w.store.minLockTS(regCtx.startKey, regCtx.endKey, ts)
+	})
+}
+
+func (w *resolvedTSWorker) close() {
+	close(w.closeCh)
+}
@@ -0,0 +1,76 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	"github.com/ngaut/unistore/tikv/mvcc"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+func (s *testMvccSuite) TestStaleRead(c *C) {
+	store, err := NewTestStore("TestStaleRead", "TestStaleRead", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	bundle := &mvcc.DBBundle{DB: store.MvccStore.db, LockStore: store.MvccStore.lockStore}
+	rm, err := NewMockRegionManager(bundle, 1, RegionOptions{})
+	c.Assert(err, IsNil)
+	peer := &metapb.Peer{Id: 2, StoreId: 1}
+	region := &metapb.Region{Id: 1, RegionEpoch: &metapb.RegionEpoch{}, Peers: []*metapb.Peer{peer}}
+	c.Assert(rm.Bootstrap([]*metapb.Store{{Id: 1}}, region), IsNil)
+	svr := store.Svr
+	svr.regionManager = rm
+	svr.resolver.regionManager = rm
+	svr.resolver.getTS = func() (uint64, error) { return 100, nil }
+	rpcCtx := &kvrpcpb.Context{RegionId: 1, RegionEpoch: region.RegionEpoch, Peer: peer}
+
+	k1, k2, k3 := []byte("k1"), []byte("k2"), []byte("k3")
+	MustPrewritePut(k1, k1, []byte("v1"), 1, store)
+	MustCommit(k1, 1, 2, store)
+	MustPrewritePut(k2, k2, []byte("v2"), 5, store)
+	// The resolved ts is blocked by the lock of k2.
+	svr.resolver.resolve()
+	c.Assert(rm.regions[1].getSafeTS(), Equals, uint64(5))
+
+	// A transaction older than the safe ts commits after the resolved ts, the stale read doesn't see its lock.
+	MustPrewritePut(k3, k3, []byte("v3"), 3, store)
+	getResp, err := svr.KvGet(context.Background(), &kvrpcpb.GetRequest{Context: rpcCtx, Key: k3, Version: 4})
+	c.Assert(err, IsNil)
+	c.Assert(getResp.Error, IsNil)
+	c.Assert(getResp.Value, HasLen, 0)
+	scanResp, err := svr.KvScan(context.Background(), &kvrpcpb.ScanRequest{
+		Context: rpcCtx, StartKey: k1, Limit: 10, Version: 5,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(scanResp.Pairs, HasLen, 1)
+	c.Assert(scanResp.Pairs[0].Error, IsNil)
+	c.Assert(scanResp.Pairs[0].Value, BytesEquals, []byte("v1"))
+	// A read above the safe ts checks the locks.
+	getResp, err = svr.KvGet(context.Background(), &kvrpcpb.GetRequest{Context: rpcCtx, Key: k2, Version: 6})
+	c.Assert(err, IsNil)
+	c.Assert(getResp.Error.GetLocked(), NotNil)
+
+	MustCommit(k2, 5, 101, store)
+	MustRollbackKey(k3, 3, store)
+	svr.resolver.resolve()
+	c.Assert(rm.regions[1].getSafeTS(), Equals, uint64(100))
+	// The safe ts doesn't go back.
+	svr.resolver.getTS = func() (uint64, error) { return 50, nil }
+	svr.resolver.resolve()
+	c.Assert(rm.regions[1].getSafeTS(), Equals, uint64(100))
+}
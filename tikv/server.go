@@ -41,17 +41,43 @@ type Server struct {
 	mvccStore     *MVCCStore
 	regionManager RegionManager
 	innerServer   InnerServer
-	wg            sync.WaitGroup
-	refCount      int32
-	stopped       int32
+	cdc           *cdcHub
+	resolver      *resolvedTSWorker
+	// loadSplit is nil if the load-based split is disabled.
+	loadSplit *loadSplitWorker
+	// flowControl is nil if the write flow control is disabled.
+	flowControl *flowController
+	// The pools bounding the running KV reads and coprocessor requests.
+	storageReadPool *readPool
+	copReadPool     *readPool
+	wg              sync.WaitGroup
+	refCount        int32
+	stopped         int32
 }
 
 func NewServer(rm RegionManager, store *MVCCStore, innerServer InnerServer) *Server {
-	return &Server{
+	svr := &Server{
 		mvccStore:     store,
 		regionManager: rm,
 		innerServer:   innerServer,
+		cdc:           newCDCHub(store, rm),
+		resolver:      newResolvedTSWorker(store, rm),
+	}
+	readPoolConf := store.conf.ReadPool
+	svr.storageReadPool = newReadPool("storage", readPoolConf.StorageConcurrency, readPoolConf.MaxTasksPerWorker)
+	svr.copReadPool = newReadPool("coprocessor", readPoolConf.CoprocessorConcurrency, readPoolConf.MaxTasksPerWorker)
+	if rm != nil {
+		go svr.resolver.run()
+		if store.conf.LoadSplit.QPSThreshold > 0 {
+			svr.loadSplit = newLoadSplitWorker(rm, store.conf.LoadSplit)
+			go svr.loadSplit.run()
+		}
+		if store.conf.FlowControl.Enable {
+			svr.flowControl = newFlowController(store.db, store.conf)
+			go svr.flowControl.run()
+		}
 	}
+	return svr
 }
 
 const requestMaxSize = 6 * 1024 * 1024
@@ -67,6 +93,29 @@ func (svr *Server) checkRequestSize(size int) *errorpb.Error {
 	return nil
 }
 
+// PrepareStop moves the leaders of the store away, then rejects the new client requests and waits for the running
+// ones to finish, including the lock waiters. The rest of the steps are skipped once the timeout is reached, the lock
+// store is dumped by Stop after the raft logs stop being applied.
+func (svr *Server) PrepareStop(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := svr.innerServer.EvictLeaders(ctx); err != nil {
+		log.Warn("evict leaders failed", zap.Error(err))
+		return
+	}
+	// No request can add a lock waiter once the new requests are rejected.
+	atomic.StoreInt32(&svr.stopped, 1)
+	for atomic.LoadInt32(&svr.refCount) > 0 {
+		select {
+		case <-time.After(time.Millisecond * 10):
+		case <-ctx.Done():
+			log.Warn("wait for running requests failed", zap.Error(ctx.Err()),
+				zap.Int("lock waiters", svr.mvccStore.lockWaiterManager.WaitingCount()))
+			return
+		}
+	}
+}
+
 func (svr *Server) Stop() {
 	atomic.StoreInt32(&svr.stopped, 1)
 	for {
@@ -75,6 +124,14 @@ func (svr *Server) Stop() {
 		}
 		time.Sleep(time.Millisecond * 10)
 	}
+	svr.cdc.close()
+	svr.resolver.close()
+	if svr.loadSplit != nil {
+		svr.loadSplit.close()
+	}
+	if svr.flowControl != nil {
+		svr.flowControl.close()
+	}
 
 	if err := svr.mvccStore.Close(); err != nil {
 		log.Error("close mvcc store failed", zap.Error(err))
@@ -96,22 +153,86 @@ type requestCtx struct {
 	method    string
 	startTime time.Time
 	rpcCtx    *kvrpcpb.Context
+	// readPool is the pool the read holds a slot of.
+	readPool *readPool
+	// staleRead is set if the read ts is not greater than the safe ts of the region, the read doesn't check
+	// the locks.
+	staleRead bool
 }
 
 func newRequestCtx(svr *Server, ctx *kvrpcpb.Context, method string) (*requestCtx, error) {
+	req, err := svr.acquireRequestCtx(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	req.regCtx, req.regErr = svr.regionManager.GetRegionFromCtx(ctx)
+	return req, nil
+}
+
+// newReadRequestCtx creates the context of a read at readTS running in the read pool, the read is a stale read
+// served by any peer without checking the leader if readTS is not greater than the safe ts of the region.
+func newReadRequestCtx(ctx context.Context, svr *Server, pool *readPool, rpcCtx *kvrpcpb.Context, method string,
+	readTS uint64) (*requestCtx, error) {
+	req, err := svr.acquireRequestCtx(rpcCtx, method)
+	if err != nil {
+		return nil, err
+	}
+	if req.regErr = req.acquireReadPool(ctx, pool); req.regErr != nil {
+		return req, nil
+	}
+	if req.regCtx = svr.regionManager.GetStaleReadRegion(rpcCtx, readTS); req.regCtx != nil {
+		req.staleRead = true
+		return req, nil
+	}
+	req.regCtx, req.regErr = svr.regionManager.GetRegionFromCtx(rpcCtx)
+	return req, nil
+}
+
+// newRawReadRequestCtx creates the context of a raw or versioned read running in the storage read pool, the
+// read doesn't check the locks so it is never served as a stale read.
+func newRawReadRequestCtx(ctx context.Context, svr *Server, rpcCtx *kvrpcpb.Context,
+	method string) (*requestCtx, error) {
+	req, err := svr.acquireRequestCtx(rpcCtx, method)
+	if err != nil {
+		return nil, err
+	}
+	if req.regErr = req.acquireReadPool(ctx, svr.storageReadPool); req.regErr != nil {
+		return req, nil
+	}
+	req.regCtx, req.regErr = svr.regionManager.GetRegionFromCtx(rpcCtx)
+	return req, nil
+}
+
+// acquireReadPool waits for a slot of the pool until ctx is done, the slot is released when the request
+// finishes.
+func (req *requestCtx) acquireReadPool(ctx context.Context, pool *readPool) *errorpb.Error {
+	if regErr := pool.acquire(ctx, req.rpcCtx.GetPriority()); regErr != nil {
+		return regErr
+	}
+	req.readPool = pool
+	return nil
+}
+
+// releaseReadPool releases the slot of the read pool held by the request.
+func (req *requestCtx) releaseReadPool() {
+	if req.readPool != nil {
+		req.readPool.release()
+		req.readPool = nil
+	}
+}
+
+func (svr *Server) acquireRequestCtx(ctx *kvrpcpb.Context, method string) (*requestCtx, error) {
 	atomic.AddInt32(&svr.refCount, 1)
 	if atomic.LoadInt32(&svr.stopped) > 0 {
 		atomic.AddInt32(&svr.refCount, -1)
 		return nil, ErrRetryable("server is closed")
 	}
-	req := &requestCtx{
+	return &requestCtx{
 		svr:       svr,
 		method:    method,
 		startTime: time.Now(),
 		rpcCtx:    ctx,
-	}
-	req.regCtx, req.regErr = svr.regionManager.GetRegionFromCtx(ctx)
-	return req, nil
+	}, nil
 }
 
 // For read-only requests that doesn't acquire latches, this function must be called after all locks has been checked.
@@ -124,15 +245,31 @@ func (req *requestCtx) getDBReader() *dbreader.DBReader {
 	return req.reader
 }
 
+// recordLoad records the request and samples its keys in the load of the region for the load-based split.
+func (req *requestCtx) recordLoad(isWrite bool, keys ...[]byte) {
+	if req.svr.loadSplit != nil {
+		req.regCtx.load.record(isWrite, keys...)
+	}
+}
+
+// throttleWrite returns a ServerIsBusy error if the write is throttled by the flow control.
+func (req *requestCtx) throttleWrite(critical bool) *errorpb.Error {
+	if req.svr.flowControl == nil {
+		return nil
+	}
+	return req.svr.flowControl.throttle(req.method, critical)
+}
+
 func (req *requestCtx) finish() {
 	atomic.AddInt32(&req.svr.refCount, -1)
 	if req.reader != nil {
 		req.reader.Close()
 	}
+	req.releaseReadPool()
 }
 
 func (svr *Server) KvGet(ctx context.Context, req *kvrpcpb.GetRequest) (*kvrpcpb.GetResponse, error) {
-	reqCtx, err := newRequestCtx(svr, req.Context, "KvGet")
+	reqCtx, err := newReadRequestCtx(ctx, svr, svr.storageReadPool, req.Context, "KvGet", req.GetVersion())
 	if err != nil {
 		return &kvrpcpb.GetResponse{Error: convertToKeyError(err)}, nil
 	}
@@ -140,9 +277,12 @@ func (svr *Server) KvGet(ctx context.Context, req *kvrpcpb.GetRequest) (*kvrpcpb
 	if reqCtx.regErr != nil {
 		return &kvrpcpb.GetResponse{RegionError: reqCtx.regErr}, nil
 	}
-	err = svr.mvccStore.CheckKeysLock(req.GetVersion(), req.Key)
-	if err != nil {
-		return &kvrpcpb.GetResponse{Error: convertToKeyError(err)}, nil
+	reqCtx.recordLoad(false, req.Key)
+	if !reqCtx.staleRead {
+		err = svr.mvccStore.CheckKeysLock(req.GetVersion(), req.Key)
+		if err != nil {
+			return &kvrpcpb.GetResponse{Error: convertToKeyError(err)}, nil
+		}
 	}
 	reader := reqCtx.getDBReader()
 	val, err := reader.Get(req.Key, req.GetVersion())
@@ -158,7 +298,7 @@ func (svr *Server) KvGet(ctx context.Context, req *kvrpcpb.GetRequest) (*kvrpcpb
 }
 
 func (svr *Server) KvScan(ctx context.Context, req *kvrpcpb.ScanRequest) (*kvrpcpb.ScanResponse, error) {
-	reqCtx, err := newRequestCtx(svr, req.Context, "KvScan")
+	reqCtx, err := newReadRequestCtx(ctx, svr, svr.storageReadPool, req.Context, "KvScan", req.GetVersion())
 	if err != nil {
 		return &kvrpcpb.ScanResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
 	}
@@ -186,9 +326,11 @@ func (svr *Server) KvScan(ctx context.Context, req *kvrpcpb.ScanRequest) (*kvrpc
 		}
 	}
 
-	err = svr.mvccStore.CheckRangeLock(req.GetVersion(), startKey, endKey)
-	if err != nil {
-		return &kvrpcpb.ScanResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	if !reqCtx.staleRead {
+		err = svr.mvccStore.CheckRangeLock(req.GetVersion(), startKey, endKey)
+		if err != nil {
+			return &kvrpcpb.ScanResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+		}
 	}
 
 	var scanProc = &kvScanProcessor{}
@@ -232,6 +374,9 @@ func (svr *Server) KvPessimisticLock(ctx context.Context, req *kvrpcpb.Pessimist
 	if reqCtx.regErr != nil {
 		return &kvrpcpb.PessimisticLockResponse{RegionError: reqCtx.regErr}, nil
 	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.PessimisticLockResponse{RegionError: regErr}, nil
+	}
 	resp := &kvrpcpb.PessimisticLockResponse{}
 	waiter, err := svr.mvccStore.PessimisticLock(reqCtx, req, resp)
 	resp.Errors, resp.RegionError = convertToPBErrors(err)
@@ -245,12 +390,14 @@ func (svr *Server) KvPessimisticLock(ctx context.Context, req *kvrpcpb.Pessimist
 		return resp, nil
 	}
 	if result.DeadlockResp != nil {
-		log.Error("deadlock found", zap.Stringer("entry", &result.DeadlockResp.Entry))
+		log.Error("deadlock found", zap.Stringer("entry", &result.DeadlockResp.Entry),
+			zap.String("wait chain", formatWaitChain(result.WaitChain)))
 		errLocked := err.(*ErrLocked)
 		deadlockErr := &ErrDeadlock{
 			LockKey:         errLocked.Key,
 			LockTS:          errLocked.StartTS,
 			DeadlockKeyHash: result.DeadlockResp.DeadlockKeyHash,
+			WaitChain:       result.WaitChain,
 		}
 		resp.Errors, resp.RegionError = convertToPBErrors(deadlockErr)
 		return resp, nil
@@ -336,6 +483,16 @@ func (svr *Server) KvPrewrite(ctx context.Context, req *kvrpcpb.PrewriteRequest)
 	if reqCtx.regErr != nil {
 		return &kvrpcpb.PrewriteResponse{RegionError: reqCtx.regErr}, nil
 	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.PrewriteResponse{RegionError: regErr}, nil
+	}
+	if svr.loadSplit != nil {
+		keys := make([][]byte, len(req.Mutations))
+		for i, m := range req.Mutations {
+			keys[i] = m.Key
+		}
+		reqCtx.recordLoad(true, keys...)
+	}
 	err = svr.mvccStore.Prewrite(reqCtx, req)
 	resp := &kvrpcpb.PrewriteResponse{}
 	resp.Errors, resp.RegionError = convertToPBErrors(err)
@@ -351,6 +508,9 @@ func (svr *Server) KvCommit(ctx context.Context, req *kvrpcpb.CommitRequest) (*k
 	if reqCtx.regErr != nil {
 		return &kvrpcpb.CommitResponse{RegionError: reqCtx.regErr}, nil
 	}
+	if regErr := reqCtx.throttleWrite(true); regErr != nil {
+		return &kvrpcpb.CommitResponse{RegionError: regErr}, nil
+	}
 	resp := new(kvrpcpb.CommitResponse)
 	err = svr.mvccStore.Commit(reqCtx, req.Keys, req.GetStartVersion(), req.GetCommitVersion())
 	if err != nil {
@@ -359,8 +519,42 @@ func (svr *Server) KvCommit(ctx context.Context, req *kvrpcpb.CommitRequest) (*k
 	return resp, nil
 }
 
-func (svr *Server) KvImport(context.Context, *kvrpcpb.ImportRequest) (*kvrpcpb.ImportResponse, error) {
-	// TODO
+func (svr *Server) KvImport(ctx context.Context, req *kvrpcpb.ImportRequest) (*kvrpcpb.ImportResponse, error) {
+	muts := req.GetMutations()
+	if err := checkImportMutations(muts, req.GetCommitVersion()); err != nil {
+		return &kvrpcpb.ImportResponse{Error: err.Error()}, nil
+	}
+	// The request has no context, the sorted mutations are imported to the regions which contain them.
+	for len(muts) > 0 {
+		rpcCtx, regErr := svr.regionManager.GetContextFromKey(muts[0].Key)
+		if regErr != nil {
+			return &kvrpcpb.ImportResponse{RegionError: regErr}, nil
+		}
+		reqCtx, err := newRequestCtx(svr, rpcCtx, "KvImport")
+		if err != nil {
+			return &kvrpcpb.ImportResponse{Error: err.Error()}, nil
+		}
+		if reqCtx.regErr != nil {
+			reqCtx.finish()
+			return &kvrpcpb.ImportResponse{RegionError: reqCtx.regErr}, nil
+		}
+		if regErr := reqCtx.throttleWrite(false); regErr != nil {
+			reqCtx.finish()
+			return &kvrpcpb.ImportResponse{RegionError: regErr}, nil
+		}
+		n := 1
+		for n < len(muts) && !reqCtx.regCtx.greaterEqualEndKey(muts[n].Key) {
+			n++
+		}
+		err = svr.mvccStore.Import(reqCtx, muts[:n], req.GetCommitVersion())
+		reqCtx.finish()
+		if err != nil {
+			resp := new(kvrpcpb.ImportResponse)
+			resp.Error, resp.RegionError = convertToRawError(err)
+			return resp, nil
+		}
+		muts = muts[n:]
+	}
 	return &kvrpcpb.ImportResponse{}, nil
 }
 
@@ -385,7 +579,7 @@ func (svr *Server) KvCleanup(ctx context.Context, req *kvrpcpb.CleanupRequest) (
 }
 
 func (svr *Server) KvBatchGet(ctx context.Context, req *kvrpcpb.BatchGetRequest) (*kvrpcpb.BatchGetResponse, error) {
-	reqCtx, err := newRequestCtx(svr, req.Context, "KvBatchGet")
+	reqCtx, err := newReadRequestCtx(ctx, svr, svr.storageReadPool, req.Context, "KvBatchGet", req.GetVersion())
 	if err != nil {
 		return &kvrpcpb.BatchGetResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
 	}
@@ -393,6 +587,7 @@ func (svr *Server) KvBatchGet(ctx context.Context, req *kvrpcpb.BatchGetRequest)
 	if reqCtx.regErr != nil {
 		return &kvrpcpb.BatchGetResponse{RegionError: reqCtx.regErr}, nil
 	}
+	reqCtx.recordLoad(false, req.Keys...)
 	pairs := svr.mvccStore.BatchGet(reqCtx, req.Keys, req.GetVersion())
 	return &kvrpcpb.BatchGetResponse{
 		Pairs: pairs,
@@ -456,8 +651,19 @@ func (svr *Server) KvResolveLock(ctx context.Context, req *kvrpcpb.ResolveLockRe
 }
 
 func (svr *Server) KvGC(ctx context.Context, req *kvrpcpb.GCRequest) (*kvrpcpb.GCResponse, error) {
-	svr.mvccStore.UpdateSafePoint(req.SafePoint)
-	return &kvrpcpb.GCResponse{}, nil
+	reqCtx, err := newRequestCtx(svr, req.Context, "KvGC")
+	if err != nil {
+		return &kvrpcpb.GCResponse{Error: convertToKeyError(err)}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.GCResponse{RegionError: reqCtx.regErr}, nil
+	}
+	// GCResponse has no field for the progress, it is logged and reported in metrics by the GC worker.
+	_, err = svr.mvccStore.GC(reqCtx, req.SafePoint)
+	resp := new(kvrpcpb.GCResponse)
+	resp.Error, resp.RegionError = convertToPBError(err)
+	return resp, nil
 }
 
 func (svr *Server) KvDeleteRange(ctx context.Context, req *kvrpcpb.DeleteRangeRequest) (*kvrpcpb.DeleteRangeResponse, error) {
@@ -469,6 +675,9 @@ func (svr *Server) KvDeleteRange(ctx context.Context, req *kvrpcpb.DeleteRangeRe
 	if reqCtx.regErr != nil {
 		return &kvrpcpb.DeleteRangeResponse{RegionError: reqCtx.regErr}, nil
 	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.DeleteRangeResponse{RegionError: regErr}, nil
+	}
 	err = svr.mvccStore.dbWriter.DeleteRange(req.StartKey, req.EndKey, reqCtx.regCtx)
 	if err != nil {
 		log.Error("delete range failed", zap.Error(err))
@@ -477,45 +686,207 @@ func (svr *Server) KvDeleteRange(ctx context.Context, req *kvrpcpb.DeleteRangeRe
 }
 
 // RawKV commands.
-func (svr *Server) RawGet(context.Context, *kvrpcpb.RawGetRequest) (*kvrpcpb.RawGetResponse, error) {
-	return &kvrpcpb.RawGetResponse{}, nil
+func (svr *Server) RawGet(ctx context.Context, req *kvrpcpb.RawGetRequest) (*kvrpcpb.RawGetResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "RawGet")
+	if err != nil {
+		return &kvrpcpb.RawGetResponse{Error: err.Error()}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawGetResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawGetResponse{Error: err.Error()}, nil
+	}
+	val, err := svr.mvccStore.RawGet(reqCtx, req.Key)
+	if err != nil {
+		resp := new(kvrpcpb.RawGetResponse)
+		resp.Error, resp.RegionError = convertToRawError(err)
+		return resp, nil
+	}
+	return &kvrpcpb.RawGetResponse{Value: val, NotFound: len(val) == 0}, nil
 }
 
-func (svr *Server) RawPut(context.Context, *kvrpcpb.RawPutRequest) (*kvrpcpb.RawPutResponse, error) {
-	return &kvrpcpb.RawPutResponse{}, nil
+func (svr *Server) RawPut(ctx context.Context, req *kvrpcpb.RawPutRequest) (*kvrpcpb.RawPutResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "RawPut")
+	if err != nil {
+		return &kvrpcpb.RawPutResponse{Error: err.Error()}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawPutResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.RawPutResponse{RegionError: regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawPutResponse{Error: err.Error()}, nil
+	}
+	if len(req.Value) == 0 {
+		return &kvrpcpb.RawPutResponse{Error: errEmptyRawValue.Error()}, nil
+	}
+	err = svr.mvccStore.RawBatchPut(reqCtx, []*kvrpcpb.KvPair{{Key: req.Key, Value: req.Value}})
+	resp := new(kvrpcpb.RawPutResponse)
+	resp.Error, resp.RegionError = convertToRawError(err)
+	return resp, nil
 }
 
-func (svr *Server) RawDelete(context.Context, *kvrpcpb.RawDeleteRequest) (*kvrpcpb.RawDeleteResponse, error) {
-	return &kvrpcpb.RawDeleteResponse{}, nil
+func (svr *Server) RawDelete(ctx context.Context, req *kvrpcpb.RawDeleteRequest) (*kvrpcpb.RawDeleteResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "RawDelete")
+	if err != nil {
+		return &kvrpcpb.RawDeleteResponse{Error: err.Error()}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawDeleteResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.RawDeleteResponse{RegionError: regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawDeleteResponse{Error: err.Error()}, nil
+	}
+	err = svr.mvccStore.RawBatchDelete(reqCtx, [][]byte{req.Key})
+	resp := new(kvrpcpb.RawDeleteResponse)
+	resp.Error, resp.RegionError = convertToRawError(err)
+	return resp, nil
 }
 
-func (svr *Server) RawScan(context.Context, *kvrpcpb.RawScanRequest) (*kvrpcpb.RawScanResponse, error) {
-	return &kvrpcpb.RawScanResponse{}, nil
+func (svr *Server) RawScan(ctx context.Context, req *kvrpcpb.RawScanRequest) (*kvrpcpb.RawScanResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "RawScan")
+	if err != nil {
+		return &kvrpcpb.RawScanResponse{Kvs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawScanResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawScanResponse{Kvs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	kvs, err := svr.mvccStore.RawScan(reqCtx, req.StartKey, req.EndKey, int(req.Limit), req.KeyOnly, req.Reverse)
+	if err != nil {
+		return &kvrpcpb.RawScanResponse{Kvs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	return &kvrpcpb.RawScanResponse{Kvs: kvs}, nil
 }
 
-func (svr *Server) RawBatchDelete(context.Context, *kvrpcpb.RawBatchDeleteRequest) (*kvrpcpb.RawBatchDeleteResponse, error) {
-	return &kvrpcpb.RawBatchDeleteResponse{}, nil
+func (svr *Server) RawBatchDelete(ctx context.Context, req *kvrpcpb.RawBatchDeleteRequest) (*kvrpcpb.RawBatchDeleteResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "RawBatchDelete")
+	if err != nil {
+		return &kvrpcpb.RawBatchDeleteResponse{Error: err.Error()}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawBatchDeleteResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.RawBatchDeleteResponse{RegionError: regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawBatchDeleteResponse{Error: err.Error()}, nil
+	}
+	err = svr.mvccStore.RawBatchDelete(reqCtx, req.Keys)
+	resp := new(kvrpcpb.RawBatchDeleteResponse)
+	resp.Error, resp.RegionError = convertToRawError(err)
+	return resp, nil
 }
 
-func (svr *Server) RawBatchGet(context.Context, *kvrpcpb.RawBatchGetRequest) (*kvrpcpb.RawBatchGetResponse, error) {
-	return &kvrpcpb.RawBatchGetResponse{}, nil
+func (svr *Server) RawBatchGet(ctx context.Context, req *kvrpcpb.RawBatchGetRequest) (*kvrpcpb.RawBatchGetResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "RawBatchGet")
+	if err != nil {
+		return &kvrpcpb.RawBatchGetResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawBatchGetResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawBatchGetResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	pairs, err := svr.mvccStore.RawBatchGet(reqCtx, req.Keys)
+	if err != nil {
+		if regErr := extractRegionError(err); regErr != nil {
+			return &kvrpcpb.RawBatchGetResponse{RegionError: regErr}, nil
+		}
+		return &kvrpcpb.RawBatchGetResponse{Pairs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	return &kvrpcpb.RawBatchGetResponse{Pairs: pairs}, nil
 }
 
-func (svr *Server) RawBatchPut(context.Context, *kvrpcpb.RawBatchPutRequest) (*kvrpcpb.RawBatchPutResponse, error) {
-	return &kvrpcpb.RawBatchPutResponse{}, nil
+func (svr *Server) RawBatchPut(ctx context.Context, req *kvrpcpb.RawBatchPutRequest) (*kvrpcpb.RawBatchPutResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "RawBatchPut")
+	if err != nil {
+		return &kvrpcpb.RawBatchPutResponse{Error: err.Error()}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawBatchPutResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.RawBatchPutResponse{RegionError: regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawBatchPutResponse{Error: err.Error()}, nil
+	}
+	for _, pair := range req.Pairs {
+		if len(pair.Value) == 0 {
+			return &kvrpcpb.RawBatchPutResponse{Error: errEmptyRawValue.Error()}, nil
+		}
+	}
+	err = svr.mvccStore.RawBatchPut(reqCtx, req.Pairs)
+	resp := new(kvrpcpb.RawBatchPutResponse)
+	resp.Error, resp.RegionError = convertToRawError(err)
+	return resp, nil
 }
 
-func (svr *Server) RawBatchScan(context.Context, *kvrpcpb.RawBatchScanRequest) (*kvrpcpb.RawBatchScanResponse, error) {
-	return &kvrpcpb.RawBatchScanResponse{}, nil
+func (svr *Server) RawBatchScan(ctx context.Context, req *kvrpcpb.RawBatchScanRequest) (*kvrpcpb.RawBatchScanResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "RawBatchScan")
+	if err != nil {
+		return &kvrpcpb.RawBatchScanResponse{Kvs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawBatchScanResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawBatchScanResponse{Kvs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+	}
+	var kvs []*kvrpcpb.KvPair
+	for _, ran := range req.Ranges {
+		rangeKvs, err := svr.mvccStore.RawScan(reqCtx, ran.StartKey, ran.EndKey, int(req.EachLimit), req.KeyOnly, req.Reverse)
+		if err != nil {
+			return &kvrpcpb.RawBatchScanResponse{Kvs: []*kvrpcpb.KvPair{{Error: convertToKeyError(err)}}}, nil
+		}
+		kvs = append(kvs, rangeKvs...)
+	}
+	return &kvrpcpb.RawBatchScanResponse{Kvs: kvs}, nil
 }
 
-func (svr *Server) RawDeleteRange(context.Context, *kvrpcpb.RawDeleteRangeRequest) (*kvrpcpb.RawDeleteRangeResponse, error) {
-	return &kvrpcpb.RawDeleteRangeResponse{}, nil
+func (svr *Server) RawDeleteRange(ctx context.Context, req *kvrpcpb.RawDeleteRangeRequest) (*kvrpcpb.RawDeleteRangeResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "RawDeleteRange")
+	if err != nil {
+		return &kvrpcpb.RawDeleteRangeResponse{Error: err.Error()}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.RawDeleteRangeResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.RawDeleteRangeResponse{RegionError: regErr}, nil
+	}
+	if err = checkRawCF(req.Cf); err != nil {
+		return &kvrpcpb.RawDeleteRangeResponse{Error: err.Error()}, nil
+	}
+	err = svr.mvccStore.RawDeleteRange(reqCtx, req.StartKey, req.EndKey)
+	resp := new(kvrpcpb.RawDeleteRangeResponse)
+	resp.Error, resp.RegionError = convertToRawError(err)
+	return resp, nil
 }
 
 // SQL push down commands.
 func (svr *Server) Coprocessor(ctx context.Context, req *coprocessor.Request) (*coprocessor.Response, error) {
-	reqCtx, err := newRequestCtx(svr, req.Context, "Coprocessor")
+	reqCtx, err := newReadRequestCtx(ctx, svr, svr.copReadPool, req.Context, "Coprocessor", req.GetStartTs())
 	if err != nil {
 		return &coprocessor.Response{OtherError: convertToKeyError(err).String()}, nil
 	}
@@ -523,6 +894,7 @@ func (svr *Server) Coprocessor(ctx context.Context, req *coprocessor.Request) (*
 	if reqCtx.regErr != nil {
 		return &coprocessor.Response{RegionError: reqCtx.regErr}, nil
 	}
+	reqCtx.recordLoad(false, copRangeStartKeys(req.Ranges)...)
 	switch req.Tp {
 	case kv.ReqTypeDAG:
 		return svr.handleCopDAGRequest(reqCtx, req), nil
@@ -534,13 +906,92 @@ func (svr *Server) Coprocessor(ctx context.Context, req *coprocessor.Request) (*
 	return &coprocessor.Response{OtherError: fmt.Sprintf("unsupported request type %d", req.GetTp())}, nil
 }
 
-func (svr *Server) CoprocessorStream(*coprocessor.Request, tikvpb.Tikv_CoprocessorStreamServer) error {
-	// TODO
-	return nil
+func copRangeStartKeys(ranges []*coprocessor.KeyRange) [][]byte {
+	keys := make([][]byte, len(ranges))
+	for i, ran := range ranges {
+		keys[i] = ran.Start
+	}
+	return keys
+}
+
+func (svr *Server) CoprocessorStream(req *coprocessor.Request, stream tikvpb.Tikv_CoprocessorStreamServer) error {
+	if req.Tp != kv.ReqTypeDAG {
+		// Only DAG responses can be split into chunks, other requests are sent in a single response.
+		resp, err := svr.Coprocessor(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		return stream.Send(resp)
+	}
+	reqCtx, err := newReadRequestCtx(stream.Context(), svr, svr.copReadPool, req.Context, "CoprocessorStream",
+		req.GetStartTs())
+	if err != nil {
+		return stream.Send(&coprocessor.Response{OtherError: convertToKeyError(err).String()})
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return stream.Send(&coprocessor.Response{RegionError: reqCtx.regErr})
+	}
+	reqCtx.recordLoad(false, copRangeStartKeys(req.Ranges)...)
+	// The slot of the read pool is released while a chunk is sent, a slow client doesn't hold the slot.
+	send := func(resp *coprocessor.Response) error {
+		reqCtx.releaseReadPool()
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		if regErr := reqCtx.acquireReadPool(stream.Context(), svr.copReadPool); regErr != nil {
+			return errors.New(regErr.Message)
+		}
+		return nil
+	}
+	return svr.handleCopStreamRequest(reqCtx, req, send)
 }
 
-func (svr *Server) BatchCoprocessor(*coprocessor.BatchRequest, tikvpb.Tikv_BatchCoprocessorServer) error {
-	panic("todo")
+func (svr *Server) BatchCoprocessor(req *coprocessor.BatchRequest, stream tikvpb.Tikv_BatchCoprocessorServer) error {
+	// BatchResponse can't carry a region error or a lock, so the regions are all handled before any response is
+	// sent, the client retries the whole request if a region fails.
+	resps := make([]*coprocessor.Response, 0, len(req.Regions))
+	for _, region := range req.Regions {
+		var rpcCtx kvrpcpb.Context
+		if req.Context != nil {
+			rpcCtx = *req.Context
+		}
+		rpcCtx.RegionId = region.RegionId
+		rpcCtx.RegionEpoch = region.RegionEpoch
+		resp, err := svr.Coprocessor(stream.Context(), &coprocessor.Request{
+			Context:   &rpcCtx,
+			Tp:        req.Tp,
+			Data:      req.Data,
+			StartTs:   req.StartTs,
+			Ranges:    region.Ranges,
+			SchemaVer: req.SchemaVer,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.RegionError != nil {
+			return stream.Send(&coprocessor.BatchResponse{
+				OtherError: fmt.Sprintf("region %d: %s", region.RegionId, resp.RegionError),
+			})
+		}
+		if resp.Locked != nil {
+			return stream.Send(&coprocessor.BatchResponse{
+				OtherError: fmt.Sprintf("region %d: key is locked: %s", region.RegionId, resp.Locked),
+			})
+		}
+		resps = append(resps, resp)
+	}
+	for _, resp := range resps {
+		batchResp := &coprocessor.BatchResponse{
+			Data:        resp.Data,
+			OtherError:  resp.OtherError,
+			ExecDetails: resp.ExecDetails,
+		}
+		if err := stream.Send(batchResp); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Raft commands (tikv <-> tikv).
@@ -560,9 +1011,8 @@ func (svr *Server) SplitRegion(ctx context.Context, req *kvrpcpb.SplitRegionRequ
 	return svr.regionManager.SplitRegion(req), nil
 }
 
-func (svr *Server) ReadIndex(context.Context, *kvrpcpb.ReadIndexRequest) (*kvrpcpb.ReadIndexResponse, error) {
-	// TODO:
-	return &kvrpcpb.ReadIndexResponse{}, nil
+func (svr *Server) ReadIndex(ctx context.Context, req *kvrpcpb.ReadIndexRequest) (*kvrpcpb.ReadIndexResponse, error) {
+	return svr.regionManager.ReadIndex(req), nil
 }
 
 // transaction debugger commands.
@@ -610,11 +1060,12 @@ func (svr *Server) UnsafeDestroyRange(ctx context.Context, req *kvrpcpb.UnsafeDe
 }
 
 // deadlock detection related services
-// GetWaitForEntries tries to get the waitFor entries
+// GetWaitForEntries returns the waitFor entries of the detector, only the detector on the leader of the first
+// region has the entries of the cluster.
 func (svr *Server) GetWaitForEntries(ctx context.Context,
 	req *deadlockPb.WaitForEntriesRequest) (*deadlockPb.WaitForEntriesResponse, error) {
-	// TODO
-	return &deadlockPb.WaitForEntriesResponse{}, nil
+	entries := svr.mvccStore.DeadlockDetectSvr.Detector.GetWaitForEntries()
+	return &deadlockPb.WaitForEntriesResponse{Entries: entries}, nil
 }
 
 // Detect will handle detection rpc from other nodes
@@ -642,9 +1093,12 @@ func (svr *Server) Detect(stream deadlockPb.Deadlock_DetectServer) error {
 	return nil
 }
 
-func (svr *Server) CheckLockObserver(context.Context, *kvrpcpb.CheckLockObserverRequest) (*kvrpcpb.CheckLockObserverResponse, error) {
-	// TODO: implement Observer
-	return &kvrpcpb.CheckLockObserverResponse{IsClean: true}, nil
+func (svr *Server) CheckLockObserver(ctx context.Context, req *kvrpcpb.CheckLockObserverRequest) (*kvrpcpb.CheckLockObserverResponse, error) {
+	isClean, locks, err := svr.mvccStore.CheckLockObserver(req.MaxTs)
+	if err != nil {
+		return &kvrpcpb.CheckLockObserverResponse{Error: err.Error()}, nil
+	}
+	return &kvrpcpb.CheckLockObserverResponse{IsClean: isClean, Locks: locks}, nil
 }
 
 func (svr *Server) PhysicalScanLock(ctx context.Context, req *kvrpcpb.PhysicalScanLockRequest) (*kvrpcpb.PhysicalScanLockResponse, error) {
@@ -653,38 +1107,136 @@ func (svr *Server) PhysicalScanLock(ctx context.Context, req *kvrpcpb.PhysicalSc
 	return resp, nil
 }
 
-func (svr *Server) RegisterLockObserver(context.Context, *kvrpcpb.RegisterLockObserverRequest) (*kvrpcpb.RegisterLockObserverResponse, error) {
-	// TODO: implement Observer
+func (svr *Server) RegisterLockObserver(ctx context.Context, req *kvrpcpb.RegisterLockObserverRequest) (*kvrpcpb.RegisterLockObserverResponse, error) {
+	if err := svr.mvccStore.RegisterLockObserver(req.MaxTs); err != nil {
+		return &kvrpcpb.RegisterLockObserverResponse{Error: err.Error()}, nil
+	}
 	return &kvrpcpb.RegisterLockObserverResponse{}, nil
 }
 
-func (svr *Server) RemoveLockObserver(context.Context, *kvrpcpb.RemoveLockObserverRequest) (*kvrpcpb.RemoveLockObserverResponse, error) {
-	// TODO: implement Observer
+func (svr *Server) RemoveLockObserver(ctx context.Context, req *kvrpcpb.RemoveLockObserverRequest) (*kvrpcpb.RemoveLockObserverResponse, error) {
+	if err := svr.mvccStore.RemoveLockObserver(req.MaxTs); err != nil {
+		return &kvrpcpb.RemoveLockObserverResponse{Error: err.Error()}, nil
+	}
 	return &kvrpcpb.RemoveLockObserverResponse{}, nil
 }
 
-func (svr *Server) VerGet(context.Context, *kvrpcpb.VerGetRequest) (*kvrpcpb.VerGetResponse, error) {
-	panic("unimplemented")
+// Versioned KV commands.
+func (svr *Server) VerGet(ctx context.Context, req *kvrpcpb.VerGetRequest) (*kvrpcpb.VerGetResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "VerGet")
+	if err != nil {
+		return &kvrpcpb.VerGetResponse{Error: convertToVerError(err)}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.VerGetResponse{RegionError: reqCtx.regErr}, nil
+	}
+	val, err := svr.mvccStore.VerGet(reqCtx, req.Key, req.StartVersion)
+	if err != nil {
+		resp := new(kvrpcpb.VerGetResponse)
+		resp.Error, resp.RegionError = convertToVerErrors(err)
+		return resp, nil
+	}
+	return &kvrpcpb.VerGetResponse{Value: val, NotFound: val == nil}, nil
 }
 
-func (svr *Server) VerBatchGet(context.Context, *kvrpcpb.VerBatchGetRequest) (*kvrpcpb.VerBatchGetResponse, error) {
-	panic("unimplemented")
+func (svr *Server) VerBatchGet(ctx context.Context, req *kvrpcpb.VerBatchGetRequest) (*kvrpcpb.VerBatchGetResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "VerBatchGet")
+	if err != nil {
+		return &kvrpcpb.VerBatchGetResponse{Pairs: []*kvrpcpb.VerKvPair{{Error: convertToVerError(err)}}}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.VerBatchGetResponse{RegionError: reqCtx.regErr}, nil
+	}
+	pairs, err := svr.mvccStore.VerBatchGet(reqCtx, req.Key, req.StartVersion)
+	if err != nil {
+		if regErr := extractRegionError(err); regErr != nil {
+			return &kvrpcpb.VerBatchGetResponse{RegionError: regErr}, nil
+		}
+		return &kvrpcpb.VerBatchGetResponse{Pairs: []*kvrpcpb.VerKvPair{{Error: convertToVerError(err)}}}, nil
+	}
+	return &kvrpcpb.VerBatchGetResponse{Pairs: pairs}, nil
 }
 
-func (svr *Server) VerMut(context.Context, *kvrpcpb.VerMutRequest) (*kvrpcpb.VerMutResponse, error) {
-	panic("unimplemented")
+func (svr *Server) VerMut(ctx context.Context, req *kvrpcpb.VerMutRequest) (*kvrpcpb.VerMutResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "VerMut")
+	if err != nil {
+		return &kvrpcpb.VerMutResponse{Error: convertToVerError(err)}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.VerMutResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.VerMutResponse{RegionError: regErr}, nil
+	}
+	var muts []*kvrpcpb.VerMutation
+	if req.Mut != nil {
+		muts = append(muts, req.Mut)
+	}
+	if err = checkVerMutations(muts, req.Version); err != nil {
+		return &kvrpcpb.VerMutResponse{Error: convertToVerError(err)}, nil
+	}
+	err = svr.mvccStore.VerBatchMut(reqCtx, muts, req.Version)
+	resp := new(kvrpcpb.VerMutResponse)
+	resp.Error, resp.RegionError = convertToVerErrors(err)
+	return resp, nil
 }
 
-func (svr *Server) VerBatchMut(context.Context, *kvrpcpb.VerBatchMutRequest) (*kvrpcpb.VerBatchMutResponse, error) {
-	panic("unimplemented")
+func (svr *Server) VerBatchMut(ctx context.Context, req *kvrpcpb.VerBatchMutRequest) (*kvrpcpb.VerBatchMutResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "VerBatchMut")
+	if err != nil {
+		return &kvrpcpb.VerBatchMutResponse{Error: convertToVerError(err)}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.VerBatchMutResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.VerBatchMutResponse{RegionError: regErr}, nil
+	}
+	if err = checkVerMutations(req.Muts, req.Version); err != nil {
+		return &kvrpcpb.VerBatchMutResponse{Error: convertToVerError(err)}, nil
+	}
+	err = svr.mvccStore.VerBatchMut(reqCtx, req.Muts, req.Version)
+	resp := new(kvrpcpb.VerBatchMutResponse)
+	resp.Error, resp.RegionError = convertToVerErrors(err)
+	return resp, nil
 }
 
-func (svr *Server) VerScan(context.Context, *kvrpcpb.VerScanRequest) (*kvrpcpb.VerScanResponse, error) {
-	panic("unimplemented")
+func (svr *Server) VerScan(ctx context.Context, req *kvrpcpb.VerScanRequest) (*kvrpcpb.VerScanResponse, error) {
+	reqCtx, err := newRawReadRequestCtx(ctx, svr, req.Context, "VerScan")
+	if err != nil {
+		return &kvrpcpb.VerScanResponse{Pairs: []*kvrpcpb.VerKvPair{{Error: convertToVerError(err)}}}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.VerScanResponse{RegionError: reqCtx.regErr}, nil
+	}
+	pairs, err := svr.mvccStore.VerScan(reqCtx, req.StartKey, req.EndKey, int(req.Limit), req.KeyOnly, req.Reverse, req.StartVersion)
+	if err != nil {
+		return &kvrpcpb.VerScanResponse{Pairs: []*kvrpcpb.VerKvPair{{Error: convertToVerError(err)}}}, nil
+	}
+	return &kvrpcpb.VerScanResponse{Pairs: pairs}, nil
 }
 
-func (svr *Server) VerDeleteRange(context.Context, *kvrpcpb.VerDeleteRangeRequest) (*kvrpcpb.VerDeleteRangeResponse, error) {
-	panic("unimplemented")
+func (svr *Server) VerDeleteRange(ctx context.Context, req *kvrpcpb.VerDeleteRangeRequest) (*kvrpcpb.VerDeleteRangeResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "VerDeleteRange")
+	if err != nil {
+		return &kvrpcpb.VerDeleteRangeResponse{Error: convertToVerError(err)}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &kvrpcpb.VerDeleteRangeResponse{RegionError: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &kvrpcpb.VerDeleteRangeResponse{RegionError: regErr}, nil
+	}
+	err = svr.mvccStore.VerDeleteRange(reqCtx, req.StartKey, req.EndKey)
+	resp := new(kvrpcpb.VerDeleteRangeResponse)
+	resp.Error, resp.RegionError = convertToVerErrors(err)
+	return resp, nil
 }
 
 func convertToKeyError(err error) *kvrpcpb.KeyError {
@@ -770,6 +1322,30 @@ func convertToPBErrors(err error) ([]*kvrpcpb.KeyError, *errorpb.Error) {
 	return nil, nil
 }
 
+func convertToRawError(err error) (string, *errorpb.Error) {
+	if err == nil {
+		return "", nil
+	}
+	if regErr := extractRegionError(err); regErr != nil {
+		return "", regErr
+	}
+	return err.Error(), nil
+}
+
+func convertToVerError(err error) *kvrpcpb.VerError {
+	if err == nil {
+		return nil
+	}
+	return &kvrpcpb.VerError{Error: err.Error()}
+}
+
+func convertToVerErrors(err error) (*kvrpcpb.VerError, *errorpb.Error) {
+	if regErr := extractRegionError(err); regErr != nil {
+		return nil, regErr
+	}
+	return convertToVerError(err), nil
+}
+
 func extractRegionError(err error) *errorpb.Error {
 	if raftError, ok := err.(*raftstore.RaftError); ok {
 		return raftError.RequestErr
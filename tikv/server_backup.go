@@ -0,0 +1,144 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"os"
+	"sync/atomic"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// Backup service, every store backs up the regions it leads in the range into the storage backend, and then
+// the files are restored by ImportSST Download and Ingest. A request with a start version backs up the changes
+// since the backup at the start version.
+
+// Backup sends a response for every region in the range led by the store, the files written are listed in
+// the manifest of the store.
+func (svr *Server) Backup(req *backup.BackupRequest, stream backup.Backup_BackupServer) error {
+	dir, err := backupStorageDir(svr.mvccStore.conf, req.StorageBackend)
+	if err == nil && req.IsRawKv {
+		err = errors.New("raw kv backup is not supported")
+	}
+	if err == nil && req.StartVersion > req.EndVersion {
+		err = errors.Errorf("invalid backup version range (%d, %d]", req.StartVersion, req.EndVersion)
+	}
+	// The versions below the safe point may be collected, an increment since then misses them.
+	if safePoint := atomic.LoadUint64(&svr.mvccStore.safePoint.timestamp); err == nil && isIncrementalBackup(req) &&
+		req.StartVersion < safePoint {
+		err = errors.Errorf("backup start version %d is less than the gc safe point %d", req.StartVersion, safePoint)
+	}
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		return stream.Send(&backup.BackupResponse{Error: &backup.Error{Msg: err.Error()}})
+	}
+	var (
+		storeID uint64
+		files   []*backup.File
+	)
+	for _, regCtx := range svr.regionManager.GetRegionsInRange(req.StartKey, req.EndKey) {
+		ctx, regErr := svr.regionManager.GetContextFromKey(regCtx.startKey)
+		if regErr != nil {
+			continue
+		}
+		storeID = ctx.Peer.GetStoreId()
+		resp := svr.backupRegion(ctx, regCtx, req, dir)
+		if resp.Error.GetRegionError().GetNotLeader() != nil {
+			// The leader on another store backs up the region.
+			continue
+		}
+		if err = stream.Send(resp); err != nil {
+			return err
+		}
+		files = append(files, resp.Files...)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	meta := &backup.BackupMeta{
+		ClusterId:    req.ClusterId,
+		StartVersion: req.StartVersion,
+		EndVersion:   req.EndVersion,
+		Files:        files,
+	}
+	if err = writeBackupManifest(dir, backupManifestName(storeID, req), meta); err != nil {
+		return stream.Send(&backup.BackupResponse{Error: &backup.Error{Msg: err.Error()}})
+	}
+	return nil
+}
+
+// backupRegion backs up the intersection of the request range and the region range.
+func (svr *Server) backupRegion(ctx *kvrpcpb.Context, regCtx *regionCtx, req *backup.BackupRequest,
+	dir string) *backup.BackupResponse {
+	startKey, endKey := req.StartKey, req.EndKey
+	if bytes.Compare(startKey, regCtx.startKey) < 0 {
+		startKey = regCtx.startKey
+	}
+	if len(endKey) == 0 || (len(regCtx.endKey) > 0 && bytes.Compare(endKey, regCtx.endKey) > 0) {
+		endKey = regCtx.endKey
+	}
+	resp := &backup.BackupResponse{StartKey: startKey, EndKey: endKey}
+	reqCtx, err := newRequestCtx(svr, ctx, "Backup")
+	if err != nil {
+		resp.Error = &backup.Error{Msg: err.Error()}
+		return resp
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		resp.Error = &backup.Error{Msg: reqCtx.regErr.Message, Detail: &backup.Error_RegionError{RegionError: reqCtx.regErr}}
+		return resp
+	}
+	scanEndKey := endKey
+	if len(scanEndKey) == 0 {
+		scanEndKey = InternalKeyPrefix
+	}
+	if err = svr.mvccStore.CheckRangeLock(req.EndVersion, startKey, scanEndKey); err != nil {
+		resp.Error = &backup.Error{Msg: err.Error(), Detail: &backup.Error_KvError{KvError: convertToKeyError(err)}}
+		return resp
+	}
+	name := func(kind string) string {
+		return backupFileName(ctx.Peer.GetStoreId(), ctx.RegionId, startKey, req.EndVersion, kind)
+	}
+	if isIncrementalBackup(req) {
+		resp.Files, err = svr.mvccStore.backupIncrementalRange(reqCtx.getDBReader(), dir, name, startKey, scanEndKey,
+			req.StartVersion, req.EndVersion)
+	} else {
+		var file *backup.File
+		file, err = svr.mvccStore.backupRange(reqCtx.getDBReader(), dir, name("default"), startKey, scanEndKey,
+			req.EndVersion)
+		if file != nil {
+			resp.Files = []*backup.File{file}
+		}
+	}
+	if err != nil {
+		resp.Files = nil
+		resp.Error = &backup.Error{Msg: err.Error()}
+		return resp
+	}
+	for _, file := range resp.Files {
+		file.EndKey = endKey
+	}
+	return resp
+}
+
+// isIncrementalBackup returns whether the request backs up the changes in (start version, end version], the
+// start version of a full backup is 0 or the end version.
+func isIncrementalBackup(req *backup.BackupRequest) bool {
+	return req.StartVersion > 0 && req.StartVersion != req.EndVersion
+}
@@ -0,0 +1,126 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pingcap/kvproto/pkg/cdcpb"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+// ChangeData service, a consumer subscribes to the row changes of regions from checkpoint ts in an event feed.
+
+// EventFeed receives the subscriptions of the regions and sends their events until the stream is closed.
+func (svr *Server) EventFeed(stream cdcpb.ChangeData_EventFeedServer) error {
+	feed := newCDCFeed()
+	defer svr.cdc.unregisterFeed(feed)
+	go svr.recvChangeDataRequests(stream, feed)
+	for {
+		var event *cdcpb.Event
+		select {
+		case event = <-feed.eventCh:
+		case <-feed.closeCh:
+			return feed.err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		resp := &cdcpb.ChangeDataEvent{Events: []*cdcpb.Event{event}}
+	batch:
+		for len(resp.Events) < cdcSendBatchSize {
+			select {
+			case event = <-feed.eventCh:
+				resp.Events = append(resp.Events, event)
+			default:
+				break batch
+			}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (svr *Server) recvChangeDataRequests(stream cdcpb.ChangeData_EventFeedServer, feed *cdcFeed) {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				feed.fail(err)
+			}
+			return
+		}
+		sub, cdcErr := svr.newCDCSubscription(feed, req)
+		if cdcErr == nil {
+			cdcErr = svr.cdc.register(sub)
+		}
+		if cdcErr != nil {
+			event := &cdcpb.Event{RegionId: req.RegionId, RequestId: req.RequestId}
+			event.Event = &cdcpb.Event_Error{Error: cdcErr}
+			if feed.sendWait(event) != nil {
+				return
+			}
+		}
+	}
+}
+
+// newCDCSubscription checks the region of the request is led by the store, the range of the subscription is
+// the intersection of the request range and the region range.
+func (svr *Server) newCDCSubscription(feed *cdcFeed, req *cdcpb.ChangeDataRequest) (*cdcSubscription, *cdcpb.Error) {
+	startKey, err := decodeCDCKey(req.StartKey)
+	if err != nil {
+		return nil, &cdcpb.Error{RegionNotFound: &errorpb.RegionNotFound{RegionId: req.RegionId}}
+	}
+	endKey, err := decodeCDCKey(req.EndKey)
+	if err != nil {
+		return nil, &cdcpb.Error{RegionNotFound: &errorpb.RegionNotFound{RegionId: req.RegionId}}
+	}
+	ctx, regErr := svr.regionManager.GetContextFromKey(startKey)
+	if regErr != nil {
+		return nil, convertToCDCError(regErr, req.RegionId)
+	}
+	if ctx.RegionId != req.RegionId {
+		return nil, &cdcpb.Error{RegionNotFound: &errorpb.RegionNotFound{RegionId: req.RegionId}}
+	}
+	ctx.RegionEpoch = req.RegionEpoch
+	regCtx, regErr := svr.regionManager.GetRegionFromCtx(ctx)
+	if regErr != nil {
+		return nil, convertToCDCError(regErr, req.RegionId)
+	}
+	if bytes.Compare(startKey, regCtx.startKey) < 0 {
+		startKey = regCtx.startKey
+	}
+	if len(endKey) == 0 || (len(regCtx.endKey) > 0 && bytes.Compare(endKey, regCtx.endKey) > 0) {
+		endKey = regCtx.endKey
+	}
+	return &cdcSubscription{
+		feed:         feed,
+		ctx:          ctx,
+		requestID:    req.RequestId,
+		startKey:     startKey,
+		endKey:       endKey,
+		checkpointTS: req.CheckpointTs,
+	}, nil
+}
+
+// decodeCDCKey decodes a memcomparable encoded key of the request, an empty key is not encoded.
+func decodeCDCKey(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	_, rawKey, err := codec.DecodeBytes(key, nil)
+	return rawKey, err
+}
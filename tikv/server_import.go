@@ -0,0 +1,132 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/juju/errors"
+	"github.com/pingcap/kvproto/pkg/backup"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ImportSST service, an SST file is uploaded or downloaded to every peer of the region and then ingested by the
+// leader.
+
+// SwitchMode is a no-op, badger does not need to be tuned for import.
+func (svr *Server) SwitchMode(context.Context, *import_sstpb.SwitchModeRequest) (*import_sstpb.SwitchModeResponse, error) {
+	return &import_sstpb.SwitchModeResponse{}, nil
+}
+
+// Upload receives an SST file, the first chunk of the stream is the meta and the rest are the data.
+func (svr *Server) Upload(stream import_sstpb.ImportSST_UploadServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	meta := req.GetMeta()
+	if meta == nil {
+		return errors.New("the first chunk of upload must be the meta")
+	}
+	file, err := svr.mvccStore.importer.Create(meta)
+	if err != nil {
+		return err
+	}
+	defer file.Cleanup()
+	for {
+		req, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data := req.GetData()
+		if data == nil {
+			return errors.New("the chunks after the meta must be the data")
+		}
+		if err = file.Append(data); err != nil {
+			return err
+		}
+	}
+	if err = file.Finish(); err != nil {
+		return err
+	}
+	return stream.SendAndClose(&import_sstpb.UploadResponse{})
+}
+
+func (svr *Server) Ingest(ctx context.Context, req *import_sstpb.IngestRequest) (*import_sstpb.IngestResponse, error) {
+	reqCtx, err := newRequestCtx(svr, req.Context, "Ingest")
+	if err != nil {
+		return &import_sstpb.IngestResponse{Error: &errorpb.Error{Message: err.Error()}}, nil
+	}
+	defer reqCtx.finish()
+	if reqCtx.regErr != nil {
+		return &import_sstpb.IngestResponse{Error: reqCtx.regErr}, nil
+	}
+	if regErr := reqCtx.throttleWrite(false); regErr != nil {
+		return &import_sstpb.IngestResponse{Error: regErr}, nil
+	}
+	err = svr.mvccStore.IngestSST(reqCtx, req.Sst)
+	if err != nil {
+		if regErr := extractRegionError(err); regErr != nil {
+			return &import_sstpb.IngestResponse{Error: regErr}, nil
+		}
+		return &import_sstpb.IngestResponse{Error: &errorpb.Error{Message: err.Error()}}, nil
+	}
+	return &import_sstpb.IngestResponse{}, nil
+}
+
+// Compact is a no-op, badger compacts the ingested data itself.
+func (svr *Server) Compact(context.Context, *import_sstpb.CompactRequest) (*import_sstpb.CompactResponse, error) {
+	return &import_sstpb.CompactResponse{}, nil
+}
+
+func (svr *Server) SetDownloadSpeedLimit(context.Context, *import_sstpb.SetDownloadSpeedLimitRequest) (*import_sstpb.SetDownloadSpeedLimitResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "download is not supported")
+}
+
+// Download rewrites a backup file in the storage backend into the SST file of the meta, it must be called on every
+// peer of the region before the file is ingested. The file is verified by the checksums in its manifest.
+func (svr *Server) Download(_ context.Context, req *import_sstpb.DownloadRequest) (*import_sstpb.DownloadResponse, error) {
+	dir, err := backupStorageDir(svr.mvccStore.conf, req.StorageBackend)
+	if err == nil && (req.Name == "" || filepath.Base(req.Name) != req.Name) {
+		err = errors.Errorf("invalid backup file name %q", req.Name)
+	}
+	var file *backup.File
+	if err == nil {
+		file, err = findBackupFile(dir, req.Name)
+	}
+	if err != nil {
+		return &import_sstpb.DownloadResponse{Error: &import_sstpb.Error{Message: err.Error()}}, nil
+	}
+	meta, err := svr.mvccStore.importer.Download(&req.Sst, filepath.Join(dir, req.Name), file.Sha256, file.Crc64Xor,
+		&req.RewriteRule)
+	if err != nil {
+		return &import_sstpb.DownloadResponse{Error: &import_sstpb.Error{Message: err.Error()}}, nil
+	}
+	if meta == nil {
+		return &import_sstpb.DownloadResponse{IsEmpty: true}, nil
+	}
+	return &import_sstpb.DownloadResponse{Range: *meta.Range, Crc32: meta.Crc32, Length: meta.Length}, nil
+}
+
+func (svr *Server) Write(import_sstpb.ImportSST_WriteServer) error {
+	return status.Error(codes.Unimplemented, "write is not supported")
+}
@@ -0,0 +1,103 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"strings"
+
+	"github.com/ngaut/unistore/tikv/mvcc"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/coprocessor"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"google.golang.org/grpc"
+)
+
+type mockBatchCopStream struct {
+	grpc.ServerStream
+	resps []*coprocessor.BatchResponse
+}
+
+func (s *mockBatchCopStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *mockBatchCopStream) Send(resp *coprocessor.BatchResponse) error {
+	s.resps = append(s.resps, resp)
+	return nil
+}
+
+func (s *testMvccSuite) TestBatchCoprocessorRegionError(c *C) {
+	store, err := NewTestStore("TestBatchCoprocessorRegionError", "TestBatchCoprocessorRegionError", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+	bundle := &mvcc.DBBundle{DB: store.MvccStore.db, LockStore: store.MvccStore.lockStore}
+	rm, err := NewMockRegionManager(bundle, 1, RegionOptions{})
+	c.Assert(err, IsNil)
+	region := &metapb.Region{Id: 1, RegionEpoch: &metapb.RegionEpoch{}, Peers: []*metapb.Peer{{Id: 2, StoreId: 1}}}
+	c.Assert(rm.Bootstrap([]*metapb.Store{{Id: 1}}, region), IsNil)
+	rm.Split(1, 3, []byte("t"), []uint64{4}, 4)
+	svr := store.Svr
+	svr.regionManager = rm
+
+	regionInfo := func(id uint64) *coprocessor.RegionInfo {
+		return &coprocessor.RegionInfo{RegionId: id, RegionEpoch: rm.GetRegion(id).RegionEpoch}
+	}
+	// The request type is not supported, so every region returns an other error.
+	stream := new(mockBatchCopStream)
+	req := &coprocessor.BatchRequest{Regions: []*coprocessor.RegionInfo{regionInfo(1), regionInfo(3)}}
+	c.Assert(svr.BatchCoprocessor(req, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 2)
+
+	// A region that is not found in the middle of the batch fails the request before any region is sent.
+	stream = new(mockBatchCopStream)
+	req = &coprocessor.BatchRequest{Regions: []*coprocessor.RegionInfo{regionInfo(1), {RegionId: 100}, regionInfo(3)}}
+	c.Assert(svr.BatchCoprocessor(req, stream), IsNil)
+	c.Assert(stream.resps, HasLen, 1)
+	c.Assert(strings.HasPrefix(stream.resps[0].OtherError, "region 100:"), IsTrue)
+	c.Assert(stream.resps[0].Data, HasLen, 0)
+}
+
+func (s *testMvccSuite) TestRawAndVerReadsUseReadPool(c *C) {
+	store, err := NewTestStore("TestRawAndVerReadsUseReadPool", "TestRawAndVerReadsUseReadPool", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+	svr := store.Svr
+	// The only slot of the pool is taken, a read waits for it until the request is canceled.
+	svr.storageReadPool = newReadPool("test", 1, 1)
+	c.Assert(svr.storageReadPool.acquire(context.Background(), kvrpcpb.CommandPri_Normal), IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	rawBatchScanResp, err := svr.RawBatchScan(ctx, &kvrpcpb.RawBatchScanRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(rawBatchScanResp.RegionError, NotNil)
+	verGetResp, err := svr.VerGet(ctx, &kvrpcpb.VerGetRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(verGetResp.RegionError, NotNil)
+	verBatchGetResp, err := svr.VerBatchGet(ctx, &kvrpcpb.VerBatchGetRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(verBatchGetResp.RegionError, NotNil)
+	verScanResp, err := svr.VerScan(ctx, &kvrpcpb.VerScanRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(verScanResp.RegionError, NotNil)
+	for _, regErr := range []*errorpb.Error{rawBatchScanResp.RegionError, verGetResp.RegionError,
+		verBatchGetResp.RegionError, verScanResp.RegionError} {
+		c.Assert(strings.Contains(regErr.Message, "test read pool"), IsTrue, Commentf("%v", regErr))
+	}
+	svr.storageReadPool.release()
+	c.Assert(svr.storageReadPool.running, Equals, 0)
+}
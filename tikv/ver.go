@@ -0,0 +1,271 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"math"
+
+	"github.com/coocood/badger"
+	"github.com/juju/errors"
+	"github.com/ngaut/unistore/tikv/dbreader"
+	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// Versioned keys are stored in the kv DB with mvcc.VerKeyPrefix, every version given by the client is a separate
+// DB key, there is no lock or 2PC, a mutation is visible once it is written.
+// A read at a version sees the latest version at or below it, a read at version 0 sees the latest version.
+// The versions are not dropped by the GC, they are kept until they are deleted by VerDeleteRange.
+// The versioned keys of a region are in [EncodeVerStartKey(start key), EncodeVerEndKey(end key)), which is covered
+// by the snapshots and the destroy of the region.
+
+var (
+	errEmptyVerValue = errors.New("versioned value must not be empty")
+	errZeroVersion   = errors.New("version must be greater than 0")
+)
+
+func verReadTS(version uint64) uint64 {
+	if version == 0 {
+		return math.MaxUint64
+	}
+	return version
+}
+
+func checkVerMutations(muts []*kvrpcpb.VerMutation, version uint64) error {
+	if version == 0 {
+		return errZeroVersion
+	}
+	for _, mut := range muts {
+		if mut.Op == kvrpcpb.VerOp_VerPut && len(mut.Value) == 0 {
+			return errEmptyVerValue
+		}
+	}
+	return nil
+}
+
+// VerGet returns the latest version of the key at or below version, nil is returned if the key is not found.
+func (store *MVCCStore) VerGet(reqCtx *requestCtx, key []byte, version uint64) (*kvrpcpb.VerValue, error) {
+	if err := checkKeysInRegion(reqCtx, key); err != nil {
+		return nil, err
+	}
+	var verVal *kvrpcpb.VerValue
+	startKey := mvcc.EncodeVerKey(key, verReadTS(version))
+	endKey := append(mvcc.EncodeVerKey(key, 0), 0)
+	err := iterateVerRange(reqCtx.getDBReader().GetTxn(), startKey, endKey, verReadTS(version), false,
+		func(_ []byte, ver uint64, val []byte) bool {
+			verVal = &kvrpcpb.VerValue{Value: safeCopy(val), Version: ver}
+			return false
+		})
+	if err != nil {
+		return nil, err
+	}
+	return verVal, nil
+}
+
+func (store *MVCCStore) VerBatchGet(reqCtx *requestCtx, keys [][]byte, version uint64) ([]*kvrpcpb.VerKvPair, error) {
+	if err := checkKeysInRegion(reqCtx, keys...); err != nil {
+		return nil, err
+	}
+	pairs := make([]*kvrpcpb.VerKvPair, 0, len(keys))
+	for _, key := range keys {
+		val, err := store.VerGet(reqCtx, key, version)
+		if err != nil {
+			pairs = append(pairs, &kvrpcpb.VerKvPair{Key: key, Error: &kvrpcpb.VerError{Error: err.Error()}})
+		} else if val != nil {
+			pairs = append(pairs, &kvrpcpb.VerKvPair{Key: key, Value: val})
+		}
+	}
+	return pairs, nil
+}
+
+// VerBatchMut writes the mutations at version, a delete is written as an empty value which hides the key for the
+// reads at or above version until a newer version is written.
+func (store *MVCCStore) VerBatchMut(reqCtx *requestCtx, muts []*kvrpcpb.VerMutation, version uint64) error {
+	if len(muts) == 0 {
+		return nil
+	}
+	keys := make([][]byte, len(muts))
+	for i, mut := range muts {
+		keys[i] = mut.Key
+	}
+	if err := checkKeysInRegion(reqCtx, keys...); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		keys[i] = mvcc.EncodeVerStartKey(key)
+	}
+	hashVals := keysToHashVals(keys...)
+	regCtx := reqCtx.regCtx
+	regCtx.AcquireLatches(hashVals)
+	defer regCtx.ReleaseLatches(hashVals)
+
+	batch := store.dbWriter.NewWriteBatch(0, 0, reqCtx.rpcCtx)
+	for _, mut := range muts {
+		if mut.Op == kvrpcpb.VerOp_VerDel {
+			batch.VerPut(mut.Key, nil, version)
+		} else {
+			batch.VerPut(mut.Key, mut.Value, version)
+		}
+	}
+	return store.dbWriter.Write(batch)
+}
+
+// VerScan scans the versioned keys in [startKey, endKey) at version, if reverse is true, the range is
+// [endKey, startKey) and the keys are returned in descending order. The range is limited to the region
+// of the request.
+func (store *MVCCStore) VerScan(reqCtx *requestCtx, startKey, endKey []byte, limit int, keyOnly, reverse bool,
+	version uint64) ([]*kvrpcpb.VerKvPair, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	lower, upper := startKey, endKey
+	if reverse {
+		lower, upper = endKey, startKey
+	}
+	lower, upper = store.clampRawRange(reqCtx, lower, upper)
+	if len(upper) > 0 && bytes.Compare(lower, upper) >= 0 {
+		return nil, nil
+	}
+	var pairs []*kvrpcpb.VerKvPair
+	err := iterateVerRange(reqCtx.getDBReader().GetTxn(), mvcc.EncodeVerStartKey(lower), mvcc.EncodeVerEndKey(upper),
+		verReadTS(version), reverse, func(key []byte, ver uint64, val []byte) bool {
+			pair := &kvrpcpb.VerKvPair{Key: safeCopy(key), Value: &kvrpcpb.VerValue{Version: ver}}
+			if !keyOnly {
+				pair.Value.Value = safeCopy(val)
+			}
+			pairs = append(pairs, pair)
+			return len(pairs) < limit
+		})
+	if err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// iterateVerRange calls f with the latest version at or below readTS of every versioned key in the DB range
+// [startKey, endKey), the deleted keys are skipped. The iteration stops when f returns false.
+func iterateVerRange(txn *badger.Txn, startKey, endKey []byte, readTS uint64, reverse bool,
+	f func(key []byte, version uint64, val []byte) bool) error {
+	txn.SetReadTS(math.MaxUint64)
+	it := dbreader.NewIterator(txn, reverse, startKey, endKey)
+	defer it.Close()
+	seekKey := startKey
+	if reverse {
+		seekKey = endKey
+	}
+	// The versions of a key are visited from the newest in a forward iteration and from the oldest in a reverse
+	// iteration, so the version found for the current key is emitted when the iteration moves to the next key.
+	var (
+		curKey     []byte
+		curVersion uint64
+		curVal     []byte
+		started    bool
+		found      bool
+	)
+	emit := func() bool {
+		if !found {
+			return true
+		}
+		found = false
+		return len(curVal) == 0 || f(curKey, curVersion, curVal)
+	}
+	for it.Seek(seekKey); it.Valid(); it.Next() {
+		item := it.Item()
+		dbKey := item.Key()
+		if bytes.Compare(dbKey, startKey) < 0 || bytes.Compare(dbKey, endKey) >= 0 {
+			if reverse && bytes.Equal(dbKey, endKey) {
+				continue
+			}
+			break
+		}
+		key, version, err := mvcc.DecodeVerKey(dbKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		sameKey := started && bytes.Equal(key, curKey)
+		if version > readTS || (sameKey && !reverse) {
+			continue
+		}
+		if !sameKey && !emit() {
+			return nil
+		}
+		val, err := item.Value()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		started, curKey = true, append(curKey[:0], key...)
+		curVersion, curVal, found = version, append(curVal[:0], val...), true
+	}
+	emit()
+	return nil
+}
+
+type verKeyVersion struct {
+	key     []byte
+	version uint64
+}
+
+// VerDeleteRange deletes all the versions of the versioned keys in [startKey, endKey) limited to the region
+// of the request.
+func (store *MVCCStore) VerDeleteRange(reqCtx *requestCtx, startKey, endKey []byte) error {
+	startKey, endKey = store.clampRawRange(reqCtx, startKey, endKey)
+	if len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return nil
+	}
+	lower, upper := mvcc.EncodeVerStartKey(startKey), mvcc.EncodeVerEndKey(endKey)
+	txn := reqCtx.getDBReader().GetTxn()
+	txn.SetReadTS(math.MaxUint64)
+	it := dbreader.NewIterator(txn, false, lower, upper)
+	defer it.Close()
+	versions := make([]verKeyVersion, 0, delRangeBatchSize)
+	for it.Seek(lower); it.Valid(); it.Next() {
+		dbKey := it.Item().Key()
+		if bytes.Compare(dbKey, upper) >= 0 {
+			break
+		}
+		key, version, err := mvcc.DecodeVerKey(dbKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		versions = append(versions, verKeyVersion{key: key, version: version})
+		if len(versions) == delRangeBatchSize {
+			if err = store.verDeleteVersions(reqCtx, versions); err != nil {
+				return err
+			}
+			versions = versions[:0]
+		}
+	}
+	if len(versions) > 0 {
+		return store.verDeleteVersions(reqCtx, versions)
+	}
+	return nil
+}
+
+func (store *MVCCStore) verDeleteVersions(reqCtx *requestCtx, versions []verKeyVersion) error {
+	keys := make([][]byte, len(versions))
+	for i, v := range versions {
+		keys[i] = mvcc.EncodeVerStartKey(v.key)
+	}
+	hashVals := keysToHashVals(keys...)
+	regCtx := reqCtx.regCtx
+	regCtx.AcquireLatches(hashVals)
+	defer regCtx.ReleaseLatches(hashVals)
+
+	batch := store.dbWriter.NewWriteBatch(0, 0, reqCtx.rpcCtx)
+	for _, v := range versions {
+		batch.VerDelete(v.key, v.version)
+	}
+	return store.dbWriter.Write(batch)
+}
@@ -0,0 +1,174 @@
+// Copyright 2019-present PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+func MustVerPut(key, val []byte, version uint64, store *TestStore) {
+	MustVerMut(&kvrpcpb.VerMutation{Op: kvrpcpb.VerOp_VerPut, Key: key, Value: val}, version, store)
+}
+
+func MustVerDelete(key []byte, version uint64, store *TestStore) {
+	MustVerMut(&kvrpcpb.VerMutation{Op: kvrpcpb.VerOp_VerDel, Key: key}, version, store)
+}
+
+func MustVerMut(mut *kvrpcpb.VerMutation, version uint64, store *TestStore) {
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	err := store.MvccStore.VerBatchMut(reqCtx, []*kvrpcpb.VerMutation{mut}, version)
+	store.c.Assert(err, IsNil)
+}
+
+func MustVerGetVal(key, val []byte, version, expectVersion uint64, store *TestStore) {
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	got, err := store.MvccStore.VerGet(reqCtx, key, version)
+	store.c.Assert(err, IsNil)
+	if val == nil {
+		store.c.Assert(got, IsNil)
+		return
+	}
+	store.c.Assert(got, NotNil)
+	store.c.Assert(got.Value, BytesEquals, val)
+	store.c.Assert(got.Version, Equals, expectVersion)
+}
+
+func MustVerScanKeys(startKey, endKey []byte, limit int, reverse bool, version uint64, keys []string, store *TestStore) {
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	pairs, err := store.MvccStore.VerScan(reqCtx, startKey, endKey, limit, false, reverse, version)
+	store.c.Assert(err, IsNil)
+	got := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		got = append(got, string(pair.Key))
+	}
+	store.c.Assert(got, DeepEquals, keys)
+}
+
+func (s *testMvccSuite) TestVerGetAtVersion(c *C) {
+	store, err := NewTestStore("TestVerGetAtVersion", "TestVerGetAtVersion", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k := []byte("ta")
+	MustVerPut(k, []byte("v10"), 10, store)
+	// The versions may be written out of order.
+	MustVerPut(k, []byte("v5"), 5, store)
+	MustVerPut(k, []byte("v20"), 20, store)
+
+	MustVerGetVal(k, nil, 4, 0, store)
+	MustVerGetVal(k, []byte("v5"), 5, 5, store)
+	MustVerGetVal(k, []byte("v5"), 9, 5, store)
+	MustVerGetVal(k, []byte("v10"), 15, 10, store)
+	MustVerGetVal(k, []byte("v20"), 25, 20, store)
+	MustVerGetVal(k, []byte("v20"), 0, 20, store)
+
+	// A delete hides the key until a newer version is written.
+	MustVerDelete(k, 30, store)
+	MustVerGetVal(k, []byte("v20"), 29, 20, store)
+	MustVerGetVal(k, nil, 30, 0, store)
+	MustVerPut(k, []byte("v40"), 40, store)
+	MustVerGetVal(k, nil, 35, 0, store)
+	MustVerGetVal(k, []byte("v40"), 0, 40, store)
+
+	// Versioned keys are not visible to transactional and raw reads.
+	MustGetVal(k, nil, 100, store)
+	MustRawGetVal(k, nil, store)
+
+	reqCtx := store.newReqCtx()
+	pairs, err := store.MvccStore.VerBatchGet(reqCtx, [][]byte{k, []byte("tx")}, 15)
+	reqCtx.finish()
+	c.Assert(err, IsNil)
+	c.Assert(pairs, HasLen, 1)
+	c.Assert(pairs[0].Key, BytesEquals, k)
+	c.Assert(pairs[0].Value.Version, Equals, uint64(10))
+}
+
+func (s *testMvccSuite) TestVerScanAndDeleteRange(c *C) {
+	store, err := NewTestStore("TestVerScanAndDeleteRange", "TestVerScanAndDeleteRange", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	for i, k := range []string{"ta", "tb", "tc", "td"} {
+		MustVerPut([]byte(k), []byte("v"+k), uint64(10+i), store)
+	}
+	MustVerPut([]byte("tb"), []byte("vtb2"), 20, store)
+	MustVerDelete([]byte("tc"), 20, store)
+
+	MustVerScanKeys([]byte("ta"), nil, 10, false, 0, []string{"ta", "tb", "td"}, store)
+	MustVerScanKeys([]byte("ta"), nil, 10, false, 12, []string{"ta", "tb", "tc"}, store)
+	MustVerScanKeys([]byte("ta"), nil, 2, false, 19, []string{"ta", "tb"}, store)
+	MustVerScanKeys([]byte("td"), []byte("ta"), 10, true, 19, []string{"tc", "tb", "ta"}, store)
+	MustVerScanKeys([]byte("ta"), nil, 0, false, 0, []string{}, store)
+
+	reqCtx := store.newReqCtx()
+	c.Assert(store.MvccStore.VerDeleteRange(reqCtx, []byte("tb"), []byte("td")), IsNil)
+	reqCtx.finish()
+	MustVerScanKeys([]byte("ta"), nil, 10, false, 0, []string{"ta", "td"}, store)
+	MustVerScanKeys([]byte("ta"), nil, 10, false, 12, []string{"ta"}, store)
+}
+
+func (s *testMvccSuite) TestVerKeyNotInRegion(c *C) {
+	store, err := NewTestStore("TestVerKeyNotInRegion", "TestVerKeyNotInRegion", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	checkKeyNotInRegion := func(err error) {
+		regErr := extractRegionError(err)
+		c.Assert(regErr, NotNil)
+		c.Assert(regErr.GetKeyNotInRegion().GetKey(), BytesEquals, []byte("ua"))
+	}
+	reqCtx := store.newReqCtx()
+	defer reqCtx.finish()
+	checkKeyNotInRegion(store.MvccStore.VerBatchMut(reqCtx, []*kvrpcpb.VerMutation{
+		{Op: kvrpcpb.VerOp_VerPut, Key: []byte("ta"), Value: []byte("v")},
+		{Op: kvrpcpb.VerOp_VerPut, Key: []byte("ua"), Value: []byte("v")}}, 10))
+	_, err = store.MvccStore.VerGet(reqCtx, []byte("ua"), 0)
+	checkKeyNotInRegion(err)
+	_, err = store.MvccStore.VerBatchGet(reqCtx, [][]byte{[]byte("ta"), []byte("ua")}, 0)
+	checkKeyNotInRegion(err)
+
+	// Nothing of the rejected batch is written.
+	MustVerGetVal([]byte("ta"), nil, 0, 0, store)
+}
+
+func (s *testMvccSuite) TestVerVersionsKeptByCompaction(c *C) {
+	store, err := NewTestStore("TestVerVersionsKeptByCompaction", "TestVerVersionsKeptByCompaction", c)
+	c.Assert(err, IsNil)
+	defer CleanTestStore(store)
+
+	k := []byte("ta")
+	MustVerPut(k, []byte("v10"), 10, store)
+	MustVerPut(k, []byte("v20"), 20, store)
+	MustVerDelete(k, 30, store)
+	MustVerPut([]byte("tb"), []byte("v10"), 10, store)
+	reqCtx := store.newReqCtx()
+	c.Assert(store.MvccStore.VerDeleteRange(reqCtx, []byte("tb"), []byte("tc")), IsNil)
+	reqCtx.finish()
+	// The client versions are far below the safe point, the compaction still keeps every version.
+	store.MvccStore.UpdateSafePoint(100)
+	store, err = reopenTestStore(store)
+	c.Assert(err, IsNil)
+
+	MustVerGetVal(k, nil, 5, 0, store)
+	MustVerGetVal(k, []byte("v10"), 15, 10, store)
+	MustVerGetVal(k, []byte("v20"), 25, 20, store)
+	MustVerGetVal(k, nil, 35, 0, store)
+	MustVerGetVal([]byte("tb"), nil, 15, 0, store)
+	MustVerScanKeys([]byte("ta"), nil, 10, false, 15, []string{"ta"}, store)
+	MustVerScanKeys([]byte("tc"), []byte("ta"), 10, true, 25, []string{"ta"}, store)
+}
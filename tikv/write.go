@@ -25,6 +25,8 @@ import (
 	"github.com/ngaut/unistore/lockstore"
 	"github.com/ngaut/unistore/tikv/dbreader"
 	"github.com/ngaut/unistore/tikv/mvcc"
+	"github.com/ngaut/unistore/tikv/raftstore"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 )
 
@@ -50,7 +52,7 @@ func (batch *writeDBBatch) set(key y.Key, val []byte, userMeta []byte) {
 	})
 }
 
-// delete is a badger level operation, only used in DeleteRange, so we don't need to set UserMeta.
+// delete is a badger level operation, used in DeleteRange, RawDelete, VerDelete and GC, so we don't need to set UserMeta.
 // Then we can tell the entry is delete if UserMeta is nil.
 func (batch *writeDBBatch) delete(key y.Key) {
 	batch.entries = append(batch.entries, &badger.Entry{
@@ -167,6 +169,7 @@ func (w writeLockWorker) run() {
 				default:
 					insertCnt++
 					ls.PutWithHint(entry.Key.UserKey, entry.Value, hint)
+					w.writer.bundle.LockObserver.Observe(entry.Key.UserKey, entry.Value)
 				}
 			}
 			batch.wg.Done()
@@ -224,6 +227,9 @@ func (writer *dbWriter) Write(batch mvcc.WriteBatch) error {
 			return err
 		}
 	}
+	// The changes are observed before the locks are removed, so the resolved ts doesn't pass a commit
+	// that is not observed.
+	writer.bundle.ChangeObserver.Observe(wb.changes)
 	if len(wb.lockBatch.entries) > 0 {
 		// We must delete lock after commit succeed, or there will be inconsistency.
 		wb.lockBatch.wg.Add(1)
@@ -239,10 +245,15 @@ type writeBatch struct {
 	commitTS  uint64
 	dbBatch   writeDBBatch
 	lockBatch writeLockBatch
+	bundle    *mvcc.DBBundle
+	changes   []mvcc.ChangeEvent
 }
 
 func (wb *writeBatch) Prewrite(key []byte, lock *mvcc.MvccLock) {
 	wb.lockBatch.set(key, lock.MarshalBinary())
+	wb.changes = append(wb.changes, mvcc.ChangeEvent{
+		Type: mvcc.ChangePrewrite, Key: key, Op: lock.Op, Value: lock.Value, StartTS: lock.StartTS,
+	})
 }
 
 func (wb *writeBatch) Commit(key []byte, lock *mvcc.MvccLock) {
@@ -255,6 +266,9 @@ func (wb *writeBatch) Commit(key []byte, lock *mvcc.MvccLock) {
 		wb.dbBatch.set(opLockKey, nil, userMeta)
 	}
 	wb.lockBatch.delete(key)
+	wb.changes = append(wb.changes, mvcc.ChangeEvent{
+		Type: mvcc.ChangeCommit, Key: key, Op: lock.Op, Value: lock.Value, StartTS: wb.startTS, CommitTS: wb.commitTS,
+	})
 }
 
 func (wb *writeBatch) Rollback(key []byte, deleteLock bool) {
@@ -263,6 +277,7 @@ func (wb *writeBatch) Rollback(key []byte, deleteLock bool) {
 	wb.dbBatch.set(rollbackKey, nil, userMeta)
 	if deleteLock {
 		wb.lockBatch.delete(key)
+		wb.changes = append(wb.changes, mvcc.ChangeEvent{Type: mvcc.ChangeRollback, Key: key, StartTS: wb.startTS})
 	}
 }
 
@@ -274,6 +289,34 @@ func (wb *writeBatch) PessimisticRollback(key []byte) {
 	wb.lockBatch.delete(key)
 }
 
+func (wb *writeBatch) RawPut(key, value []byte, version uint64) {
+	wb.dbBatch.set(y.KeyWithTs(mvcc.EncodeRawKey(key), version), value, mvcc.NewRawUserMeta(version))
+}
+
+func (wb *writeBatch) RawDelete(key []byte, version uint64) {
+	wb.dbBatch.delete(y.KeyWithTs(mvcc.EncodeRawKey(key), version))
+}
+
+func (wb *writeBatch) VerPut(key, value []byte, version uint64) {
+	wb.dbBatch.set(y.KeyWithTs(mvcc.EncodeVerKey(key, version), version), value, mvcc.NewVerUserMeta(version))
+}
+
+func (wb *writeBatch) VerDelete(key []byte, version uint64) {
+	wb.dbBatch.delete(y.KeyWithTs(mvcc.EncodeVerKey(key, version), version))
+}
+
+func (wb *writeBatch) ImportPut(key, value []byte, commitTS uint64) {
+	wb.dbBatch.set(y.KeyWithTs(key, commitTS), value, mvcc.NewDBUserMeta(commitTS, commitTS))
+}
+
+func (wb *writeBatch) ImportDelete(key []byte, commitTS uint64) {
+	wb.dbBatch.delete(y.KeyWithTs(key, commitTS))
+}
+
+func (wb *writeBatch) DeleteVersion(key []byte, version uint64) {
+	wb.dbBatch.delete(y.KeyWithTs(key, version))
+}
+
 func (writer *dbWriter) NewWriteBatch(startTS, commitTS uint64, ctx *kvrpcpb.Context) mvcc.WriteBatch {
 	if commitTS > 0 {
 		writer.updateLatestTS(commitTS)
@@ -283,6 +326,7 @@ func (writer *dbWriter) NewWriteBatch(startTS, commitTS uint64, ctx *kvrpcpb.Con
 	return &writeBatch{
 		startTS:  startTS,
 		commitTS: commitTS,
+		bundle:   writer.bundle,
 	}
 }
 
@@ -309,6 +353,47 @@ func (writer *dbWriter) DeleteRange(startKey, endKey []byte, latchHandle mvcc.La
 	return writer.deleteKeysInBatch(latchHandle, keys, delRangeBatchSize)
 }
 
+const ingestBatchSize = 4096
+
+func (writer *dbWriter) IngestSST(ctx *kvrpcpb.Context, meta *import_sstpb.SSTMeta, path string,
+	latchHandle mvcc.LatchHandle) error {
+	dbBatch := newWriteDBBatch()
+	err := raftstore.IterateImportSST(path, meta, func(key y.Key, value, userMeta []byte) error {
+		if userMeta == nil {
+			dbBatch.delete(key)
+		} else {
+			dbBatch.set(key, safeCopy(value), userMeta)
+		}
+		if len(dbBatch.entries) < ingestBatchSize {
+			return nil
+		}
+		err := writer.writeDBBatchWithLatches(latchHandle, dbBatch)
+		dbBatch = newWriteDBBatch()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writer.writeDBBatchWithLatches(latchHandle, dbBatch)
+}
+
+func (writer *dbWriter) writeDBBatchWithLatches(latchHandle mvcc.LatchHandle, dbBatch *writeDBBatch) error {
+	if len(dbBatch.entries) == 0 {
+		return nil
+	}
+	keys := make([]y.Key, len(dbBatch.entries))
+	for i, e := range dbBatch.entries {
+		keys[i] = e.Key
+	}
+	hashVals := userKeysToHashVals(keys...)
+	latchHandle.AcquireLatches(hashVals)
+	dbBatch.wg.Add(1)
+	writer.dbCh <- dbBatch
+	dbBatch.wg.Wait()
+	latchHandle.ReleaseLatches(hashVals)
+	return dbBatch.err
+}
+
 func (writer *dbWriter) collectRangeKeys(it *badger.Iterator, startKey, endKey []byte, keys []y.Key) []y.Key {
 	if len(endKey) == 0 {
 		panic("invalid end key")
@@ -329,19 +414,13 @@ func (writer *dbWriter) deleteKeysInBatch(latchHandle mvcc.LatchHandle, keys []y
 		batchSize := mathutil.Min(len(keys), batchSize)
 		batchKeys := keys[:batchSize]
 		keys = keys[batchSize:]
-		hashVals := userKeysToHashVals(batchKeys...)
 		dbBatch := newWriteDBBatch()
 		for _, key := range batchKeys {
 			key.Version++
 			dbBatch.delete(key)
 		}
-		latchHandle.AcquireLatches(hashVals)
-		dbBatch.wg.Add(1)
-		writer.dbCh <- dbBatch
-		dbBatch.wg.Wait()
-		latchHandle.ReleaseLatches(hashVals)
-		if dbBatch.err != nil {
-			return dbBatch.err
+		if err := writer.writeDBBatchWithLatches(latchHandle, dbBatch); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -14,6 +14,7 @@
 package lockwaiter
 
 import (
+	"fmt"
 	"sort"
 	"sync"
 	"time"
@@ -95,6 +96,22 @@ type WaitResult struct {
 	WakeupSleepTime WakeupWaitTime
 	CommitTS        uint64
 	DeadlockResp    *deadlock.DeadlockResponse
+	// WaitChain is the wait chain of the deadlock, it is nil if the deadlock is detected by a remote detector,
+	// since the DeadlockResponse doesn't carry it.
+	WaitChain []*WaitChainEntry
+}
+
+// WaitChainEntry is an edge of the wait chain of a deadlock, the Key is nil if the edge is registered by a
+// remote DeadlockRequest, since the request doesn't carry the key.
+type WaitChainEntry struct {
+	Txn        uint64
+	WaitForTxn uint64
+	KeyHash    uint64
+	Key        []byte
+}
+
+func (e *WaitChainEntry) String() string {
+	return fmt.Sprintf("txn %d waits for txn %d on key %q hash %d", e.Txn, e.WaitForTxn, e.Key, e.KeyHash)
 }
 
 const WaitTimeout WakeupWaitTime = -1
@@ -212,8 +229,19 @@ func (lw *Manager) CleanUp(w *Waiter) {
 	w.DrainCh()
 }
 
+// WaitingCount returns the number of the waiters in the waiting queues.
+func (lw *Manager) WaitingCount() int {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	cnt := 0
+	for _, q := range lw.waitingQueues {
+		cnt += len(q.waiters)
+	}
+	return cnt
+}
+
 // WakeUpDetection wakes up waiters waiting for deadlock detection results
-func (lw *Manager) WakeUpForDeadlock(resp *deadlock.DeadlockResponse) {
+func (lw *Manager) WakeUpForDeadlock(resp *deadlock.DeadlockResponse, waitChain []*WaitChainEntry) {
 	var (
 		waiter         *Waiter
 		waitForKeyHash uint64
@@ -237,7 +265,7 @@ func (lw *Manager) WakeUpForDeadlock(resp *deadlock.DeadlockResponse) {
 	}
 	lw.mu.Unlock()
 	if waiter != nil {
-		waiter.ch <- WaitResult{DeadlockResp: resp}
+		waiter.ch <- WaitResult{DeadlockResp: resp, WaitChain: waitChain}
 		log.S().Infof("wakeup txn=%v blocked by txn=%v because of deadlock, keyHash=%v, deadlockKeyHash=%v",
 			resp.Entry.Txn, resp.Entry.WaitForTxn, resp.Entry.KeyHash, resp.DeadlockKeyHash)
 	}
@@ -56,6 +56,7 @@ func (t *testLockwaiter) TestLockwaiterBasic(c *C) {
 
 	// basic wake up test
 	waiter = mgr.NewWaiter(3, 2, keyHash, 10)
+	c.Assert(mgr.WaitingCount(), Equals, 1)
 	mgr.WakeUp(2, 222, keysHash)
 	res := <-waiter.ch
 	c.Assert(res.CommitTS, Equals, uint64(222))
@@ -71,7 +72,7 @@ func (t *testLockwaiter) TestLockwaiterBasic(c *C) {
 	resp.Entry.WaitForTxn = 4
 	resp.Entry.KeyHash = keyHash
 	resp.DeadlockKeyHash = 30192
-	mgr.WakeUpForDeadlock(resp)
+	mgr.WakeUpForDeadlock(resp, nil)
 	res = <-waiter.ch
 	c.Assert(res.DeadlockResp, NotNil)
 	c.Assert(res.DeadlockResp.Entry.Txn, Equals, uint64(3))
@@ -133,7 +134,7 @@ func (t *testLockwaiter) TestLockwaiterConcurrent(c *C) {
 			resp.Entry.Txn = i
 			resp.Entry.WaitForTxn = waitForTxn
 			resp.Entry.KeyHash = i * 10
-			mgr.WakeUpForDeadlock(resp)
+			mgr.WakeUpForDeadlock(resp, nil)
 		}
 	}
 	endWg.Wait()